@@ -111,7 +111,23 @@ func EnsureDirExists(dir string) error {
 	return nil
 }
 
-// CleanOldDirs removes old directories based on a maximum count or age
+// PinnedFileName is the name of the marker file that protects a directory
+// from being removed by CleanOldDirs and by nigiri's cleanup commands
+const PinnedFileName = ".pinned"
+
+// IsPinned reports whether dir contains the pinned marker file
+//
+// Parameters:
+//   - dir: The directory to check
+//
+// Returns:
+//   - bool: True if the directory is pinned, false otherwise
+func IsPinned(dir string) bool {
+	return Exists(filepath.Join(dir, PinnedFileName))
+}
+
+// CleanOldDirs removes old directories based on a maximum count or age.
+// Pinned directories are never removed, but still count towards maxDirs.
 func CleanOldDirs(parentDir string, maxDirs int, maxAge time.Duration) error {
 	entries, err := os.ReadDir(parentDir)
 	if err != nil {
@@ -140,6 +156,9 @@ func CleanOldDirs(parentDir string, maxDirs int, maxAge time.Duration) error {
 	if maxDirs > 0 && len(dirs) > maxDirs {
 		for i := 0; i < len(dirs)-maxDirs; i++ {
 			dirToRemove := filepath.Join(parentDir, dirs[i].Name)
+			if IsPinned(dirToRemove) {
+				continue
+			}
 			if err := os.RemoveAll(dirToRemove); err != nil {
 				return logger.CreateErrorf("failed to remove directory %s: %w", dirToRemove, err)
 			}
@@ -152,6 +171,9 @@ func CleanOldDirs(parentDir string, maxDirs int, maxAge time.Duration) error {
 		for _, dir := range dirs {
 			if now.Sub(dir.ModTime) > maxAge {
 				dirToRemove := filepath.Join(parentDir, dir.Name)
+				if IsPinned(dirToRemove) {
+					continue
+				}
 				if err := os.RemoveAll(dirToRemove); err != nil {
 					return logger.CreateErrorf("failed to remove directory %s: %w", dirToRemove, err)
 				}
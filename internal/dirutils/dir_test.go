@@ -357,3 +357,36 @@ func TestCleanOldDirs(t *testing.T) {
 		t.Error("CleanOldDirs() incorrectly removed dir3")
 	}
 }
+
+func TestCleanOldDirsKeepsPinned(t *testing.T) {
+	testDir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"dir1", "dir2", "dir3"} {
+		dirPath := filepath.Join(testDir, name)
+		if err := os.MkdirAll(dirPath, 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if name == "dir1" {
+			if err := os.WriteFile(filepath.Join(dirPath, PinnedFileName), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		modTime := now.Add(time.Duration(i-2) * 24 * time.Hour)
+		if err := os.Chtimes(dirPath, modTime, modTime); err != nil {
+			t.Fatalf("Failed to set directory time: %v", err)
+		}
+	}
+	if !IsPinned(filepath.Join(testDir, "dir1")) || IsPinned(filepath.Join(testDir, "dir2")) {
+		t.Fatalf("IsPinned() does not match the marker files")
+	}
+
+	// dir1 is both the oldest and older than the age limit, but pinned
+	if err := CleanOldDirs(testDir, 1, 36*time.Hour); err != nil {
+		t.Fatalf("CleanOldDirs() error = %v", err)
+	}
+	for name, want := range map[string]bool{"dir1": true, "dir2": false, "dir3": true} {
+		if got := Exists(filepath.Join(testDir, name)); got != want {
+			t.Errorf("after CleanOldDirs() %s exists = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Package exec provides an abstraction over running external commands so that
+// code spawning processes can be tested without starting real ones
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	osexec "os/exec"
+	"time"
+)
+
+// processGroupWaitDelay is how long Run waits for the output of a process
+// group to be closed after the group is killed
+const processGroupWaitDelay = 5 * time.Second
+
+// Options represents the settings of a single command invocation
+//
+// Fields:
+//   - Dir: The working directory of the command (empty for the current directory)
+//   - Env: The full environment of the command (nil inherits the current environment)
+//   - Stdin: The standard input of the command (nil for no input)
+//   - Stdout: Where standard output is streamed; when nil it is captured and returned
+//   - Stderr: Where standard error is streamed; when nil it is captured and returned
+//   - ProcessGroup: Whether the command runs in its own process group, so that
+//     the processes it starts are killed with it when the context is done and
+//     receive the interrupt and termination signals nigiri receives
+//   - ForwardSignals: Whether the termination signals nigiri receives are
+//     forwarded to the command, which keeps nigiri's process group, and nigiri
+//     waits for it to exit instead of being ended by the signal first
+type Options struct {
+	Dir            string
+	Env            []string
+	Stdin          io.Reader
+	Stdout         io.Writer
+	Stderr         io.Writer
+	ProcessGroup   bool
+	ForwardSignals bool
+}
+
+// Runner runs external commands
+type Runner interface {
+	// Run runs argv[0] with the remaining elements as arguments and waits for
+	// it to finish. Output that is not streamed through opts is returned.
+	// A command that exits with a non-zero status returns an *ExitError.
+	Run(ctx context.Context, argv []string, opts Options) (stdout, stderr []byte, err error)
+}
+
+// ExitError reports that a command ran but exited with a non-zero status
+//
+// Fields:
+//   - Code: The exit status of the command
+//   - Err: The underlying error, if any
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit status carried by err
+//
+// Parameters:
+//   - err: The error returned by a Runner
+//
+// Returns:
+//   - int: The exit status of the command
+//   - bool: True if err reports a non-zero exit status, false otherwise
+func ExitCode(err error) (int, bool) {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code, true
+	}
+	return 0, false
+}
+
+// OSRunner is a Runner that starts real processes through os/exec
+type OSRunner struct{}
+
+// NewOSRunner creates a Runner that starts real processes
+//
+// Returns:
+//   - *OSRunner: A new OSRunner instance
+func NewOSRunner() *OSRunner {
+	return &OSRunner{}
+}
+
+// Run runs the command described by argv and opts. The process is killed if
+// ctx is done before it exits, together with the processes it started when
+// opts.ProcessGroup is set.
+func (r *OSRunner) Run(ctx context.Context, argv []string, opts Options) ([]byte, []byte, error) {
+	if len(argv) == 0 {
+		return nil, nil, errors.New("no command specified")
+	}
+	cmd := osexec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = opts.Dir
+	cmd.Env = opts.Env
+	cmd.Stdin = opts.Stdin
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	if opts.Stdout != nil {
+		cmd.Stdout = opts.Stdout
+	}
+	cmd.Stderr = &stderr
+	if opts.Stderr != nil {
+		cmd.Stderr = opts.Stderr
+	}
+
+	var err error
+	switch {
+	case opts.ProcessGroup:
+		// Processes left in the group may keep the output open after a kill
+		cmd.WaitDelay = processGroupWaitDelay
+		err = runForwardingSignals(cmd, true)
+	case opts.ForwardSignals:
+		err = runForwardingSignals(cmd, false)
+	default:
+		err = cmd.Run()
+	}
+	var exitErr *osexec.ExitError
+	if errors.As(err, &exitErr) {
+		err = &ExitError{Code: exitErr.ExitCode(), Err: exitErr}
+	}
+	return captured(&stdout, opts.Stdout), captured(&stderr, opts.Stderr), err
+}
+
+// captured returns the contents of buf unless the output was streamed elsewhere
+func captured(buf *bytes.Buffer, streamed io.Writer) []byte {
+	if streamed != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,118 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestOSRunner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("tests use /bin/sh")
+	}
+	r := NewOSRunner()
+	ctx := context.Background()
+
+	stdout, stderr, err := r.Run(ctx, []string{"/bin/sh", "-c", "echo out; echo err >&2"}, Options{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if string(stdout) != "out\n" || string(stderr) != "err\n" {
+		t.Errorf("Run() = (%q, %q), want (\"out\\n\", \"err\\n\")", stdout, stderr)
+	}
+
+	var streamed bytes.Buffer
+	stdout, _, err = r.Run(ctx, []string{"/bin/sh", "-c", "pwd; echo $FOO"}, Options{
+		Dir:    "/",
+		Env:    []string{"FOO=bar"},
+		Stdout: &streamed,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if stdout != nil {
+		t.Errorf("Run() returned streamed stdout %q", stdout)
+	}
+	if got := streamed.String(); got != "/\nbar\n" {
+		t.Errorf("streamed output = %q, want %q", got, "/\nbar\n")
+	}
+
+	_, _, err = r.Run(ctx, []string{"/bin/sh", "-c", "exit 3"}, Options{})
+	if code, ok := ExitCode(err); !ok || code != 3 {
+		t.Errorf("ExitCode() = (%d, %v), want (3, true)", code, ok)
+	}
+
+	if _, _, err := r.Run(ctx, nil, Options{}); err == nil {
+		t.Errorf("Run() without a command should fail")
+	}
+}
+
+func TestFake(t *testing.T) {
+	f := &Fake{}
+	if _, _, err := f.Run(context.Background(), []string{"true"}, Options{}); err != nil {
+		t.Errorf("Run() without handler error = %v", err)
+	}
+
+	f.Handler = func(call Call) ([]byte, []byte, error) {
+		if call.Argv[0] == "fail" {
+			return nil, []byte("boom"), &ExitError{Code: 2}
+		}
+		return []byte("ok"), nil, nil
+	}
+	var out bytes.Buffer
+	if _, _, err := f.Run(context.Background(), []string{"succeed", "-v"}, Options{Stdout: &out}); err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+	if out.String() != "ok" {
+		t.Errorf("streamed output = %q, want %q", out.String(), "ok")
+	}
+	_, stderr, err := f.Run(context.Background(), []string{"fail"}, Options{})
+	if code, ok := ExitCode(err); !ok || code != 2 {
+		t.Errorf("ExitCode() = (%d, %v), want (2, true)", code, ok)
+	}
+	if string(stderr) != "boom" {
+		t.Errorf("stderr = %q, want %q", stderr, "boom")
+	}
+
+	calls := f.Calls()
+	if len(calls) != 3 || calls[1].Argv[1] != "-v" {
+		t.Errorf("Calls() = %+v", calls)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if _, ok := ExitCode(nil); ok {
+		t.Errorf("ExitCode(nil) reported an exit status")
+	}
+	if _, ok := ExitCode(errors.New("not found")); ok {
+		t.Errorf("ExitCode() of a non-exit error reported an exit status")
+	}
+}
+
+func TestOSRunnerProcessGroup(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("tests use /bin/sh")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	// The background sleep keeps the output open unless the whole group is killed
+	var out bytes.Buffer
+	start := time.Now()
+	_, _, err := NewOSRunner().Run(ctx, []string{"/bin/sh", "-c", "sleep 30 & echo started; wait"}, Options{
+		Stdout:       &out,
+		ProcessGroup: true,
+	})
+	if err == nil {
+		t.Fatalf("Run() should fail when the context is done")
+	}
+	if elapsed := time.Since(start); elapsed >= processGroupWaitDelay {
+		t.Errorf("Run() took %s, want the process group killed when the context is done", elapsed)
+	}
+	if out.String() != "started\n" {
+		t.Errorf("output = %q, want %q", out.String(), "started\n")
+	}
+}
@@ -0,0 +1,67 @@
+package exec
+
+import (
+	"context"
+	"sync"
+)
+
+// Call records a single invocation of a Fake runner
+//
+// Fields:
+//   - Argv: The command and its arguments
+//   - Opts: The options the command was run with
+type Call struct {
+	Argv []string
+	Opts Options
+}
+
+// Fake is a Runner for tests that records every call instead of starting a
+// process and returns the result produced by its Handler
+type Fake struct {
+	// Handler produces the output and error of each call; when nil every
+	// call succeeds without output
+	Handler func(call Call) (stdout, stderr []byte, err error)
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// Run records the call and returns the result of the Handler. Output is
+// written to the streams in opts when they are set, as a real process would.
+func (f *Fake) Run(ctx context.Context, argv []string, opts Options) ([]byte, []byte, error) {
+	call := Call{Argv: append([]string(nil), argv...), Opts: opts}
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	if f.Handler == nil {
+		return nil, nil, nil
+	}
+	stdout, stderr, err := f.Handler(call)
+	if opts.Stdout != nil {
+		if _, writeErr := opts.Stdout.Write(stdout); writeErr != nil {
+			return nil, nil, writeErr
+		}
+		stdout = nil
+	}
+	if opts.Stderr != nil {
+		if _, writeErr := opts.Stderr.Write(stderr); writeErr != nil {
+			return nil, nil, writeErr
+		}
+		stderr = nil
+	}
+	return stdout, stderr, err
+}
+
+// Calls returns the calls made so far, in order
+//
+// Returns:
+//   - []Call: The recorded calls
+func (f *Fake) Calls() []Call {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Call(nil), f.calls...)
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package exec
+
+import "errors"
+
+// ReplaceSupported reports whether Replace can replace the current process
+const ReplaceSupported = false
+
+// Replace is not supported on this platform and always returns an error
+//
+// Parameters:
+//   - argv: The program to run followed by its arguments
+//   - opts: The working directory and environment of the program
+//
+// Returns:
+//   - error: An error reporting that replacing the process is unsupported
+func Replace(argv []string, opts Options) error {
+	return errors.New("replacing the current process is not supported on this platform")
+}
@@ -0,0 +1,43 @@
+//go:build unix
+
+package exec
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ReplaceSupported reports whether Replace can replace the current process
+const ReplaceSupported = true
+
+// Replace replaces the current process with argv[0], passing the remaining
+// elements as arguments. Only opts.Dir and opts.Env are used; the standard
+// streams of the current process are inherited. On success it does not return.
+//
+// Parameters:
+//   - argv: The program to run followed by its arguments
+//   - opts: The working directory and environment of the program
+//
+// Returns:
+//   - error: Any error that prevented replacing the process
+func Replace(argv []string, opts Options) error {
+	if len(argv) == 0 {
+		return fmt.Errorf("no command to run")
+	}
+	path, err := filepath.Abs(argv[0])
+	if err != nil {
+		return err
+	}
+	if opts.Dir != "" {
+		if err := os.Chdir(opts.Dir); err != nil {
+			return err
+		}
+	}
+	env := opts.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	return syscall.Exec(path, argv, env)
+}
@@ -0,0 +1,19 @@
+//go:build !unix
+
+package exec
+
+import osexec "os/exec"
+
+// runForwardingSignals runs cmd. Signals cannot be forwarded and process
+// groups are not supported on this platform, so only the command itself is
+// killed when its context is done.
+//
+// Parameters:
+//   - cmd: The command to run
+//   - group: Whether cmd would run in its own process group (ignored)
+//
+// Returns:
+//   - error: Any error encountered while running the command
+func runForwardingSignals(cmd *osexec.Cmd, group bool) error {
+	return cmd.Run()
+}
@@ -0,0 +1,70 @@
+//go:build unix
+
+package exec
+
+import (
+	"os"
+	osexec "os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// runForwardingSignals runs cmd, relaying the interrupt and termination
+// signals received while it runs instead of letting them end nigiri first.
+// In its own process group, cmd no longer receives the signals of the
+// terminal, so both are forwarded to the group, which is also killed as a
+// whole when the context of cmd is done. Otherwise cmd shares the terminal
+// and receives its interrupts directly, and only termination signals, which
+// are sent to nigiri alone, are forwarded. Once cmd has exited, nigiri is
+// ended by the signal as well if it ended cmd.
+//
+// Parameters:
+//   - cmd: The command to run
+//   - group: Whether cmd runs in its own process group
+//
+// Returns:
+//   - error: Any error encountered while starting or waiting for the command
+func runForwardingSignals(cmd *osexec.Cmd, group bool) error {
+	if group {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.Cancel = func() error {
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	received := make(chan syscall.Signal, 1)
+	go func() {
+		var last syscall.Signal
+		defer func() { received <- last }()
+		for {
+			select {
+			case sig := <-signals:
+				last = sig.(syscall.Signal)
+				if group {
+					_ = syscall.Kill(-cmd.Process.Pid, last)
+				} else if last == syscall.SIGTERM {
+					_ = cmd.Process.Signal(last)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+	sig := <-received
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && sig != 0 && status.Signaled() && status.Signal() == sig {
+		signal.Stop(signals)
+		_ = syscall.Kill(os.Getpid(), sig)
+	}
+	return err
+}
@@ -0,0 +1,57 @@
+//go:build unix
+
+package exec
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestOSRunnerForwardsSignals(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "process group", opts: Options{ProcessGroup: true}},
+		{name: "shared process group", opts: Options{ForwardSignals: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w := io.Pipe()
+			opts := tt.opts
+			opts.Stdout = w
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				_, _, err := NewOSRunner().Run(ctx, []string{"/bin/sh", "-c", `trap 'echo stopping; exit 3' TERM; echo ready; while :; do sleep 0.05; done`}, opts)
+				_ = w.Close()
+				done <- err
+			}()
+
+			lines := bufio.NewScanner(r)
+			if !lines.Scan() || lines.Text() != "ready" {
+				t.Fatalf("command did not start: %q", lines.Text())
+			}
+			// The signal reaches nigiri, which must pass it on rather than end
+			if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+				t.Fatal(err)
+			}
+			if !lines.Scan() || lines.Text() != "stopping" {
+				t.Errorf("command output = %q, want the signal to reach it", lines.Text())
+			}
+			go func() { _, _ = io.Copy(io.Discard, r) }()
+
+			err := <-done
+			if code, ok := ExitCode(err); !ok || code != 3 {
+				t.Errorf("Run() error = %v, want exit status 3 from the trap", err)
+			}
+		})
+	}
+}
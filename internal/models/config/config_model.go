@@ -1,6 +1,12 @@
 // Package config defines the configuration models for the nigiri CLI
 package config
 
+import "time"
+
+// DefaultShortHashLength is the number of characters of the short hashes
+// builds are stored under when a target does not set short-hash-length
+const DefaultShortHashLength = 7
+
 // Config represents the configuration for the nigiri CLI
 //
 // Fields:
@@ -23,13 +29,43 @@ type Config struct {
 //   - DefaultBranch: The default branch of the repository
 //   - WorkingDirectory: The directory within the repository to run the build command
 //   - BinaryOnly: Whether to keep only the binary and remove source code after build
+//   - DepsFiles: Dependency lock files to hash when recording dependencies (overrides detection)
+//   - WarningPattern: Regular expression matching build output lines that count as warnings
+//   - Platforms: Operating systems the target can be built on (empty allows all)
+//   - KeepRunning: Whether cleanup keeps builds that are currently being run
+//   - BuildTimeoutValue: The build timeout as a duration string ("0" disables the timeout)
+//   - PostProcess: Commands run against the built binary before the build is finalized
+//   - Reproducible: Whether builds set the standard reproducible-build environment variables
+//   - ReproducibleExclude: Reproducible-build environment variables not to set
+//   - Metadata: How much build metadata is recorded (full, minimal or none; empty means full)
+//   - Shell: The shell and its arguments that build commands run through, e.g. "bash -c" (empty uses the OS default)
+//   - PruneFailed: Whether failed builds are pruned down to their logs and metadata
+//   - ExpectFiles: Files that must exist in the checkout before the build command runs
+//   - Toolchain: The directory of a toolchain whose bin directory is put first in PATH for builds
+//   - ShortHashLengthValue: The number of characters of the short hashes builds are stored under (0 uses the default)
+//   - Submodules: Whether the git submodules of the source are cloned before the build command runs
 type Target struct {
-	BuildCommand     BuildCommand `yaml:"build_command"`
-	DefaultBranch    string       `yaml:"default_branch"`
-	Sources          string       `yaml:"sources"`
-	WorkingDirectory string       `yaml:"working_directory"`
-	Env              []string     `yaml:"env"`
-	BinaryOnly       bool         `yaml:"binary_only"`
+	BuildCommand         BuildCommand `yaml:"build_command"`
+	PostProcess          PostProcess  `yaml:"post_process"`
+	DefaultBranch        string       `yaml:"default_branch"`
+	Sources              string       `yaml:"sources"`
+	WorkingDirectory     string       `yaml:"working_directory"`
+	Env                  []string     `yaml:"env"`
+	DepsFiles            []string     `yaml:"deps_files"`
+	WarningPattern       string       `yaml:"warning_pattern"`
+	BuildTimeoutValue    string       `yaml:"build_timeout"`
+	Metadata             string       `yaml:"metadata"`
+	Shell                string       `yaml:"shell"`
+	Toolchain            string       `yaml:"toolchain"`
+	Platforms            []string     `yaml:"platforms"`
+	ReproducibleExclude  []string     `yaml:"reproducible_exclude"`
+	ExpectFiles          []string     `yaml:"expect_files"`
+	ShortHashLengthValue int          `yaml:"short_hash_length"`
+	BinaryOnly           bool         `yaml:"binary_only"`
+	KeepRunning          bool         `yaml:"keep_running"`
+	Reproducible         bool         `yaml:"reproducible"`
+	PruneFailed          bool         `yaml:"prune_failed"`
+	Submodules           bool         `yaml:"submodules"`
 }
 
 // BuildCommand represents the build command configuration for a target
@@ -46,6 +82,57 @@ type BuildCommand struct {
 	BinaryPathValue string `mapstructure:"binary-path"`
 }
 
+// PostProcess represents the commands run against the built binary for each OS.
+// The commands are templates in which {{.Binary}} expands to the binary path.
+//
+// Fields:
+//   - Linux: The post-process commands for Linux
+//   - Windows: The post-process commands for Windows
+//   - Darwin: The post-process commands for macOS
+type PostProcess struct {
+	Linux   []string `mapstructure:"linux"`
+	Windows []string `mapstructure:"windows"`
+	Darwin  []string `mapstructure:"darwin"`
+}
+
+// Commands returns the post-process commands for the given OS
+//
+// Parameters:
+//   - goos: The operating system, as reported by runtime.GOOS
+//
+// Returns:
+//   - []string: The commands to run, in order (nil if there are none)
+func (p PostProcess) Commands(goos string) []string {
+	switch goos {
+	case "linux":
+		return p.Linux
+	case "windows":
+		return p.Windows
+	case "darwin":
+		return p.Darwin
+	}
+	return nil
+}
+
+// Command returns the build command for the given OS
+//
+// Parameters:
+//   - goos: The operating system, as reported by runtime.GOOS
+//
+// Returns:
+//   - string: The build command (empty if there is none)
+func (bc BuildCommand) Command(goos string) string {
+	switch goos {
+	case "linux":
+		return bc.Linux
+	case "windows":
+		return bc.Windows
+	case "darwin":
+		return bc.Darwin
+	}
+	return ""
+}
+
 // BinaryPath returns the configured binary path if set, otherwise false
 //
 // Returns:
@@ -58,6 +145,58 @@ func (bc BuildCommand) BinaryPath() (string, bool) {
 	return bc.BinaryPathValue, true
 }
 
+// WithDefaults returns the build command with the OS-specific commands it
+// does not set taken from defaults. Commands set on the target always win,
+// and the binary path is never inherited.
+//
+// Parameters:
+//   - defaults: The default build commands of the configuration
+//
+// Returns:
+//   - BuildCommand: The build command with the defaults applied
+func (bc BuildCommand) WithDefaults(defaults BuildCommand) BuildCommand {
+	if bc.Linux == "" {
+		bc.Linux = defaults.Linux
+	}
+	if bc.Windows == "" {
+		bc.Windows = defaults.Windows
+	}
+	if bc.Darwin == "" {
+		bc.Darwin = defaults.Darwin
+	}
+	return bc
+}
+
+// BuildTimeout returns the configured build timeout if set, otherwise false.
+// A zero duration means the build has no timeout.
+//
+// Returns:
+//   - time.Duration: The build timeout
+//   - bool: True if the build timeout is set, false otherwise
+func (t Target) BuildTimeout() (time.Duration, bool) {
+	if t.BuildTimeoutValue == "" {
+		return 0, false
+	}
+	d, err := time.ParseDuration(t.BuildTimeoutValue)
+	if err != nil {
+		return 0, false
+	}
+	return d, true
+}
+
+// ShortHashLength returns the number of characters of the short hashes the
+// builds of the target are stored under, which is also the minimum length of
+// the commit prefixes that select them
+//
+// Returns:
+//   - int: The configured short hash length, or DefaultShortHashLength if unset
+func (t Target) ShortHashLength() int {
+	if t.ShortHashLengthValue == 0 {
+		return DefaultShortHashLength
+	}
+	return t.ShortHashLengthValue
+}
+
 // GetCfgDir returns the configuration directory
 //
 // Returns:
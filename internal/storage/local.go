@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+)
+
+// Local implements Storage
+var _ Storage = (*Local)(nil)
+
+// Local stores builds on the local filesystem, in a directory per target
+// below the nigiri root and a directory per build below it. It is the
+// default storage.
+type Local struct {
+	root string
+}
+
+// NewLocal creates a storage keeping builds below root
+//
+// Parameters:
+//   - root: The nigiri root directory; relative paths are made absolute
+//
+// Returns:
+//   - *Local: The storage
+func NewLocal(root string) *Local {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return &Local{root: root}
+}
+
+// Dir returns the directory of a build
+//
+// Parameters:
+//   - target: The name of the target
+//   - build: The name of the build
+//
+// Returns:
+//   - string: The absolute path of the build directory
+func (l *Local) Dir(target, build string) string {
+	return filepath.Join(l.root, target, build)
+}
+
+// CreateCommitDir creates the directory of a new build, and the directory of
+// its target if needed
+func (l *Local) CreateCommitDir(target, build string) (string, error) {
+	if err := validateBuild(target, build); err != nil {
+		return "", err
+	}
+	dir := l.Dir(target, build)
+	if dirutils.Exists(dir) {
+		return "", fmt.Errorf("commit directory already exists: %s", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// WriteArtifact writes an artifact file into the directory of a build
+func (l *Local) WriteArtifact(target, build, name string, r io.Reader) (err error) {
+	if err := validateArtifact(target, build, name); err != nil {
+		return err
+	}
+	dir := l.Dir(target, build)
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	_, err = io.Copy(file, r)
+	return err
+}
+
+// ReadArtifact opens an artifact file in the directory of a build
+func (l *Local) ReadArtifact(target, build, name string) (io.ReadCloser, error) {
+	if err := validateArtifact(target, build, name); err != nil {
+		return nil, err
+	}
+	return os.Open(filepath.Join(l.Dir(target, build), filepath.FromSlash(name)))
+}
+
+// List returns the build directories of a target, newest first. Hidden
+// directories are not builds and are skipped.
+func (l *Local) List(target string) ([]Build, error) {
+	if err := targets.ValidateTargetName(target); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(l.root, target))
+	if err != nil {
+		return nil, err
+	}
+	var builds []Build
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		builds = append(builds, Build{Name: entry.Name(), ModTime: info.ModTime()})
+	}
+	sort.SliceStable(builds, func(i, j int) bool {
+		return builds[i].ModTime.After(builds[j].ModTime)
+	})
+	return builds, nil
+}
+
+// Remove removes the directory of a build
+func (l *Local) Remove(target, build string) error {
+	if err := validateBuild(target, build); err != nil {
+		return err
+	}
+	return os.RemoveAll(l.Dir(target, build))
+}
+
+// Size returns the total size of the files in the directory of a build
+func (l *Local) Size(target, build string) (int64, error) {
+	if err := validateBuild(target, build); err != nil {
+		return 0, err
+	}
+	dir := l.Dir(target, build)
+	if _, err := os.Stat(dir); err != nil {
+		return 0, err
+	}
+	return dirutils.GetDirSize(dir)
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+)
+
+// Memory implements Storage
+var _ Storage = (*Memory)(nil)
+
+// Memory stores builds in memory. It is meant for tests of code written
+// against Storage, and shows what a storage that is not on the local
+// filesystem has to provide.
+type Memory struct {
+	mu      sync.Mutex
+	targets map[string]map[string]*memoryBuild
+}
+
+// memoryBuild is a build held by Memory
+type memoryBuild struct {
+	modTime   time.Time
+	artifacts map[string][]byte
+}
+
+// NewMemory creates an empty in-memory storage
+//
+// Returns:
+//   - *Memory: The storage
+func NewMemory() *Memory {
+	return &Memory{targets: make(map[string]map[string]*memoryBuild)}
+}
+
+// notExist returns an error for a missing target, build or artifact that
+// satisfies os.IsNotExist
+func notExist(op string, elem ...string) error {
+	return &fs.PathError{Op: op, Path: path.Join(elem...), Err: fs.ErrNotExist}
+}
+
+// build returns a build, or nil if it does not exist. m.mu must be held.
+func (m *Memory) build(target, build string) *memoryBuild {
+	return m.targets[target][build]
+}
+
+// CreateCommitDir creates an empty build. There is no local directory, so
+// the returned path is always empty.
+func (m *Memory) CreateCommitDir(target, build string) (string, error) {
+	if err := validateBuild(target, build); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.build(target, build) != nil {
+		return "", fmt.Errorf("commit directory already exists: %s", path.Join(target, build))
+	}
+	if m.targets[target] == nil {
+		m.targets[target] = make(map[string]*memoryBuild)
+	}
+	m.targets[target][build] = &memoryBuild{modTime: time.Now(), artifacts: make(map[string][]byte)}
+	return "", nil
+}
+
+// WriteArtifact stores a copy of the contents of r
+func (m *Memory) WriteArtifact(target, build, name string, r io.Reader) error {
+	if err := validateArtifact(target, build, name); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.build(target, build)
+	if b == nil {
+		return notExist("write", target, build)
+	}
+	b.artifacts[name] = data
+	return nil
+}
+
+// ReadArtifact returns a reader over a copy of an artifact
+func (m *Memory) ReadArtifact(target, build, name string) (io.ReadCloser, error) {
+	if err := validateArtifact(target, build, name); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.build(target, build)
+	if b == nil {
+		return nil, notExist("open", target, build, name)
+	}
+	data, ok := b.artifacts[name]
+	if !ok {
+		return nil, notExist("open", target, build, name)
+	}
+	return io.NopCloser(bytes.NewReader(bytes.Clone(data))), nil
+}
+
+// List returns the builds of a target, newest first
+func (m *Memory) List(target string) ([]Build, error) {
+	if err := targets.ValidateTargetName(target); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.targets[target]
+	if !ok {
+		return nil, notExist("open", target)
+	}
+	builds := make([]Build, 0, len(stored))
+	for name, b := range stored {
+		builds = append(builds, Build{Name: name, ModTime: b.modTime})
+	}
+	// Builds made at the same time are ordered by name, as map order is random
+	sort.Slice(builds, func(i, j int) bool {
+		if !builds[i].ModTime.Equal(builds[j].ModTime) {
+			return builds[i].ModTime.After(builds[j].ModTime)
+		}
+		return builds[i].Name < builds[j].Name
+	})
+	return builds, nil
+}
+
+// Remove removes a build and its artifacts
+func (m *Memory) Remove(target, build string) error {
+	if err := validateBuild(target, build); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.targets[target], build)
+	return nil
+}
+
+// Size returns the total length of the artifacts of a build
+func (m *Memory) Size(target, build string) (int64, error) {
+	if err := validateBuild(target, build); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.build(target, build)
+	if b == nil {
+		return 0, notExist("stat", target, build)
+	}
+	var size int64
+	for _, data := range b.artifacts {
+		size += int64(len(data))
+	}
+	return size, nil
+}
+
+// SetModTime changes when a build was last modified, which orders List
+//
+// Parameters:
+//   - target: The name of the target
+//   - build: The name of the build
+//   - modTime: The new modification time
+//
+// Returns:
+//   - error: An error if the build does not exist
+func (m *Memory) SetModTime(target, build string, modTime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := m.build(target, build)
+	if b == nil {
+		return notExist("chtimes", target, build)
+	}
+	b.modTime = modTime
+	return nil
+}
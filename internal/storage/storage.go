@@ -0,0 +1,107 @@
+// Package storage abstracts where the builds of targets and their artifacts
+// are kept, so that builds can live somewhere other than the local nigiri root.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+)
+
+// Build describes a stored build of a target
+//
+// Fields:
+//   - Name: The name of the build, usually the short commit hash
+//   - ModTime: When the build was last modified
+type Build struct {
+	Name    string
+	ModTime time.Time
+}
+
+// Storage stores the builds of targets and their artifacts. Artifacts are
+// named by slash-separated paths relative to their build, such as
+// "build-info.json" or "logs/build.log". Errors about missing targets,
+// builds or artifacts satisfy os.IsNotExist.
+type Storage interface {
+	// CreateCommitDir creates an empty build of target and returns the local
+	// directory its files are written to, or "" if the storage is not on the
+	// local filesystem. It fails if the build already exists.
+	CreateCommitDir(target, build string) (string, error)
+	// WriteArtifact stores the contents of r as the artifact name of an
+	// existing build, replacing any artifact of that name
+	WriteArtifact(target, build, name string, r io.Reader) error
+	// ReadArtifact opens the artifact name of a build; the caller must close it
+	ReadArtifact(target, build, name string) (io.ReadCloser, error)
+	// List returns the builds of target, newest first
+	List(target string) ([]Build, error)
+	// Remove removes a build and all of its artifacts. Removing a build that
+	// does not exist is not an error.
+	Remove(target, build string) error
+	// Size returns the total size of the artifacts of a build in bytes
+	Size(target, build string) (int64, error)
+}
+
+// ReadBuildInfo reads the build metadata of a stored build, falling back to
+// build-info.txt like targets.ReadBuildInfo
+//
+// Parameters:
+//   - s: The storage holding the build
+//   - target: The name of the target
+//   - build: The name of the build
+//
+// Returns:
+//   - *targets.BuildInfo: The parsed build metadata
+//   - error: Any error encountered while reading or parsing the metadata
+func ReadBuildInfo(s Storage, target, build string) (*targets.BuildInfo, error) {
+	return targets.ReadBuildInfoWith(func(name string) ([]byte, error) {
+		r, err := s.ReadArtifact(target, build, name)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = r.Close() }()
+		return io.ReadAll(r)
+	})
+}
+
+// validateBuild checks that the names of a target and a build are safe to
+// use as single path elements
+//
+// Parameters:
+//   - target: The name of the target
+//   - build: The name of the build
+//
+// Returns:
+//   - error: An error describing why a name is invalid, or nil if both are valid
+func validateBuild(target, build string) error {
+	if err := targets.ValidateTargetName(target); err != nil {
+		return err
+	}
+	if build == "" || build == "." || build == ".." || strings.ContainsAny(build, `/\`) {
+		return fmt.Errorf("invalid build name %q: must be a single local path element", build)
+	}
+	return nil
+}
+
+// validateArtifact checks the names of a target, a build and an artifact.
+// Artifact names must be slash-separated paths that stay within the build.
+//
+// Parameters:
+//   - target: The name of the target
+//   - build: The name of the build
+//   - name: The name of the artifact
+//
+// Returns:
+//   - error: An error describing why a name is invalid, or nil if all are valid
+func validateArtifact(target, build, name string) error {
+	if err := validateBuild(target, build); err != nil {
+		return err
+	}
+	if !fs.ValidPath(name) || name == "." || strings.Contains(name, `\`) {
+		return fmt.Errorf("invalid artifact name %q: must be a relative slash-separated path", name)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testStorages returns the storages the contract tests run against
+func testStorages(t *testing.T) map[string]Storage {
+	return map[string]Storage{
+		"local":  NewLocal(t.TempDir()),
+		"memory": NewMemory(),
+	}
+}
+
+// readArtifact reads an artifact as a string
+func readArtifact(t *testing.T, s Storage, target, build, name string) string {
+	t.Helper()
+	r, err := s.ReadArtifact(target, build, name)
+	if err != nil {
+		t.Fatalf("ReadArtifact(%s) failed: %v", name, err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read artifact %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestStorageArtifacts(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.CreateCommitDir("tool", "abc1234"); err != nil {
+				t.Fatalf("CreateCommitDir failed: %v", err)
+			}
+			if _, err := s.CreateCommitDir("tool", "abc1234"); err == nil {
+				t.Errorf("Expected creating an existing build to fail")
+			}
+
+			if err := s.WriteArtifact("tool", "abc1234", "bin", strings.NewReader("binary")); err != nil {
+				t.Fatalf("WriteArtifact failed: %v", err)
+			}
+			if err := s.WriteArtifact("tool", "abc1234", "logs/build.log", strings.NewReader("log")); err != nil {
+				t.Fatalf("WriteArtifact in a subdirectory failed: %v", err)
+			}
+			if err := s.WriteArtifact("tool", "abc1234", "bin", strings.NewReader("binary v2")); err != nil {
+				t.Fatalf("Replacing an artifact failed: %v", err)
+			}
+			if got := readArtifact(t, s, "tool", "abc1234", "bin"); got != "binary v2" {
+				t.Errorf("ReadArtifact(bin) = %q, want %q", got, "binary v2")
+			}
+			if got := readArtifact(t, s, "tool", "abc1234", "logs/build.log"); got != "log" {
+				t.Errorf("ReadArtifact(logs/build.log) = %q, want %q", got, "log")
+			}
+
+			size, err := s.Size("tool", "abc1234")
+			if err != nil {
+				t.Fatalf("Size failed: %v", err)
+			}
+			if size != int64(len("binary v2")+len("log")) {
+				t.Errorf("Size = %d, want %d", size, len("binary v2")+len("log"))
+			}
+
+			if _, err := s.ReadArtifact("tool", "abc1234", "missing"); !os.IsNotExist(err) {
+				t.Errorf("Expected a not-exist error for a missing artifact, got %v", err)
+			}
+			if err := s.WriteArtifact("tool", "def5678", "bin", strings.NewReader("x")); !os.IsNotExist(err) {
+				t.Errorf("Expected a not-exist error writing to a missing build, got %v", err)
+			}
+			if _, err := s.Size("tool", "def5678"); !os.IsNotExist(err) {
+				t.Errorf("Expected a not-exist error for the size of a missing build, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStorageListAndRemove(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.List("tool"); !os.IsNotExist(err) {
+				t.Errorf("Expected a not-exist error listing a missing target, got %v", err)
+			}
+
+			now := time.Now()
+			for i, build := range []string{"aaa1111", "bbb2222", "ccc3333"} {
+				if _, err := s.CreateCommitDir("tool", build); err != nil {
+					t.Fatalf("CreateCommitDir failed: %v", err)
+				}
+				setModTime(t, s, "tool", build, now.Add(time.Duration(i-3)*time.Hour))
+			}
+			// bbb2222 is the newest
+			setModTime(t, s, "tool", "bbb2222", now)
+
+			assertBuilds(t, s, "bbb2222", "ccc3333", "aaa1111")
+
+			if err := s.Remove("tool", "ccc3333"); err != nil {
+				t.Fatalf("Remove failed: %v", err)
+			}
+			if err := s.Remove("tool", "ccc3333"); err != nil {
+				t.Errorf("Removing a missing build failed: %v", err)
+			}
+			assertBuilds(t, s, "bbb2222", "aaa1111")
+		})
+	}
+}
+
+func TestStorageRejectsInvalidNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		build    string
+		artifact string
+	}{
+		{name: "target with separator", target: "../tool", build: "abc1234", artifact: "bin"},
+		{name: "build with separator", target: "tool", build: "abc/1234", artifact: "bin"},
+		{name: "parent build", target: "tool", build: "..", artifact: "bin"},
+		{name: "artifact escaping the build", target: "tool", build: "abc1234", artifact: "../bin"},
+		{name: "absolute artifact", target: "tool", build: "abc1234", artifact: "/bin"},
+		{name: "empty artifact", target: "tool", build: "abc1234", artifact: ""},
+	}
+	for name, s := range testStorages(t) {
+		if _, err := s.CreateCommitDir("tool", "abc1234"); err != nil {
+			t.Fatalf("CreateCommitDir failed: %v", err)
+		}
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				if err := s.WriteArtifact(tt.target, tt.build, tt.artifact, strings.NewReader("x")); err == nil || os.IsNotExist(err) {
+					t.Errorf("Expected WriteArtifact to reject the name, got %v", err)
+				}
+				if _, err := s.ReadArtifact(tt.target, tt.build, tt.artifact); err == nil || os.IsNotExist(err) {
+					t.Errorf("Expected ReadArtifact to reject the name, got %v", err)
+				}
+			})
+		}
+	}
+}
+
+func TestStorageReadBuildInfo(t *testing.T) {
+	for name, s := range testStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := s.CreateCommitDir("tool", "abc1234"); err != nil {
+				t.Fatalf("CreateCommitDir failed: %v", err)
+			}
+			if _, err := ReadBuildInfo(s, "tool", "abc1234"); !os.IsNotExist(err) {
+				t.Errorf("Expected a not-exist error without metadata, got %v", err)
+			}
+
+			text := "Target: tool\nCommit: abc1234def5678\nStatus: success\n"
+			if err := s.WriteArtifact("tool", "abc1234", "build-info.txt", strings.NewReader(text)); err != nil {
+				t.Fatalf("WriteArtifact failed: %v", err)
+			}
+			info, err := ReadBuildInfo(s, "tool", "abc1234")
+			if err != nil {
+				t.Fatalf("ReadBuildInfo failed: %v", err)
+			}
+			if info.Commit != "abc1234def5678" || info.Status != "success" {
+				t.Errorf("ReadBuildInfo = %+v, want the commit and status of build-info.txt", info)
+			}
+
+			data := `{"target": "tool", "commit": "abc1234def5678", "status": "archived"}`
+			if err := s.WriteArtifact("tool", "abc1234", "build-info.json", strings.NewReader(data)); err != nil {
+				t.Fatalf("WriteArtifact failed: %v", err)
+			}
+			if info, err = ReadBuildInfo(s, "tool", "abc1234"); err != nil {
+				t.Fatalf("ReadBuildInfo failed: %v", err)
+			}
+			if info.Status != "archived" {
+				t.Errorf("Expected build-info.json to take precedence, got status %q", info.Status)
+			}
+		})
+	}
+}
+
+// setModTime changes the modification time of a build in either storage
+func setModTime(t *testing.T, s Storage, target, build string, modTime time.Time) {
+	t.Helper()
+	var err error
+	switch s := s.(type) {
+	case *Local:
+		err = os.Chtimes(s.Dir(target, build), modTime, modTime)
+	case *Memory:
+		err = s.SetModTime(target, build, modTime)
+	}
+	if err != nil {
+		t.Fatalf("Failed to set modification time: %v", err)
+	}
+}
+
+// assertBuilds checks the names List returns, in order
+func assertBuilds(t *testing.T, s Storage, want ...string) {
+	t.Helper()
+	builds, err := s.List("tool")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	var got []string
+	for _, build := range builds {
+		got = append(got, build.Name)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("List = %v, want %v", got, want)
+	}
+}
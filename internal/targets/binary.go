@@ -0,0 +1,97 @@
+package targets
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+)
+
+// ResolveBinary locates the binary of a build. The bin file of the commit
+// directory is used when it exists. Otherwise the binary is looked up in the
+// source of the build, extracting source.tar.gz into src first unless a
+// previous run already did: at the binary path of the target, or without one
+// at the target name in the working directory, then in its bin/ and build/
+// directories. Nothing is printed; callers report progress themselves, see
+// SourceNeedsExtraction.
+//
+// Parameters:
+//   - runDir: The commit directory of the build
+//   - cfg: The configuration of the target
+//   - targetName: The name of the target
+//
+// Returns:
+//   - string: The path of the binary
+//   - error: An error if the source cannot be extracted or the binary is not found
+func ResolveBinary(runDir string, cfg config.Target, targetName string) (string, error) {
+	// Look for the binary in the commit directory first
+	binaryPath := filepath.Join(runDir, "bin")
+	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
+		// Check for compressed source
+		srcArchive := filepath.Join(runDir, "source.tar.gz")
+		srcDir := filepath.Join(runDir, "src")
+
+		// Extract the source archive unless a previous run already did
+		if _, err := os.Stat(srcArchive); err == nil {
+			upToDate, err := sourceExtracted(srcArchive, runDir)
+			if err != nil {
+				return "", logger.CreateErrorf("failed to check extracted source: %w", err)
+			}
+			if !upToDate {
+				if err := extractSource(srcArchive, runDir); err != nil {
+					return "", logger.CreateErrorf("failed to extract source archive: %w", err)
+				}
+			}
+		}
+
+		// At this point, we should have a src directory (either it was there or we extracted it)
+		if _, err := os.Stat(srcDir); os.IsNotExist(err) {
+			return "", logger.CreateErrorf("source directory not found: %s", srcDir)
+		}
+
+		// Apply working directory if specified
+		workDir := srcDir
+		if cfg.WorkingDirectory != "" {
+			workDir = filepath.Join(srcDir, cfg.WorkingDirectory)
+			if _, err := os.Stat(workDir); os.IsNotExist(err) {
+				return "", logger.CreateErrorf("working directory '%s' not found in source", cfg.WorkingDirectory)
+			}
+		}
+
+		// Get binary path from config
+		if binPath, ok := cfg.BuildCommand.BinaryPath(); ok {
+			binaryPath = filepath.Join(workDir, binPath)
+		} else {
+			binaryPath = fallbackBinaryPath(workDir, targetName)
+		}
+	}
+
+	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
+		return "", logger.CreateErrorf("binary not found at %s", binaryPath)
+	}
+	return binaryPath, nil
+}
+
+// fallbackBinaryPath returns the first of the common binary locations in the
+// working directory that exists: the target name itself, then bin/ and build/
+//
+// Parameters:
+//   - workDir: The working directory of the source
+//   - targetName: The name of the target, used as the binary name
+//
+// Returns:
+//   - string: The path of the binary, or workDir/targetName if none exists
+func fallbackBinaryPath(workDir, targetName string) string {
+	binaryPath := filepath.Join(workDir, targetName)
+	if _, err := os.Stat(binaryPath); err == nil {
+		return binaryPath
+	}
+	for _, dir := range []string{"bin", "build"} {
+		altPath := filepath.Join(workDir, dir, targetName)
+		if _, err := os.Stat(altPath); err == nil {
+			return altPath
+		}
+	}
+	return binaryPath
+}
@@ -0,0 +1,191 @@
+package targets
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/models/config"
+)
+
+// writeFile creates path with its parent directories
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeSourceArchive writes a source.tar.gz holding files into commitDir
+func writeSourceArchive(t *testing.T, commitDir string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(filepath.Join(commitDir, "source.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gw, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResolveBinary(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		archive map[string]string
+		cfg     config.Target
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "bin in the commit directory",
+			files: map[string]string{"bin": "", "src/tool": ""},
+			want:  "bin",
+		},
+		{
+			name:    "binary path in the extracted archive",
+			archive: map[string]string{"out/app": "#!/bin/sh\n"},
+			cfg:     config.Target{BuildCommand: config.BuildCommand{BinaryPathValue: "out/app"}},
+			want:    filepath.Join("src", "out", "app"),
+		},
+		{
+			name:  "binary path in the working directory",
+			files: map[string]string{"src/cmd/app": ""},
+			cfg:   config.Target{WorkingDirectory: "cmd", BuildCommand: config.BuildCommand{BinaryPathValue: "app"}},
+			want:  filepath.Join("src", "cmd", "app"),
+		},
+		{
+			name:  "target name in the source",
+			files: map[string]string{"src/tool": "", "src/bin/tool": ""},
+			want:  filepath.Join("src", "tool"),
+		},
+		{
+			name:  "bin fallback",
+			files: map[string]string{"src/bin/tool": "", "src/build/tool": ""},
+			want:  filepath.Join("src", "bin", "tool"),
+		},
+		{
+			name:  "build fallback",
+			files: map[string]string{"src/build/tool": ""},
+			want:  filepath.Join("src", "build", "tool"),
+		},
+		{
+			name:    "no binary",
+			files:   map[string]string{"src/README": ""},
+			wantErr: "binary not found at",
+		},
+		{
+			name:    "no source",
+			wantErr: "source directory not found",
+		},
+		{
+			name:    "missing working directory",
+			files:   map[string]string{"src/tool": ""},
+			cfg:     config.Target{WorkingDirectory: "cmd"},
+			wantErr: "working directory 'cmd' not found in source",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runDir := t.TempDir()
+			for name, content := range tt.files {
+				writeFile(t, filepath.Join(runDir, name), content)
+			}
+			if tt.archive != nil {
+				writeSourceArchive(t, runDir, tt.archive)
+			}
+
+			got, err := ResolveBinary(runDir, tt.cfg, "tool")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolveBinary() error = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveBinary() error = %v", err)
+			}
+			if want := filepath.Join(runDir, tt.want); got != want {
+				t.Errorf("ResolveBinary() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestResolveBinaryExtractsChangedArchive(t *testing.T) {
+	runDir := t.TempDir()
+	cfg := config.Target{BuildCommand: config.BuildCommand{BinaryPathValue: "app"}}
+
+	writeSourceArchive(t, runDir, map[string]string{"app": "v1"})
+	if _, err := ResolveBinary(runDir, cfg, "tool"); err != nil {
+		t.Fatalf("ResolveBinary() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(runDir, extractedMarkerName)); err != nil {
+		t.Errorf("extraction marker not written: %v", err)
+	}
+
+	// A file added to the extracted source survives while the archive is unchanged
+	writeFile(t, filepath.Join(runDir, "src", "extra"), "")
+	if _, err := ResolveBinary(runDir, cfg, "tool"); err != nil {
+		t.Fatalf("ResolveBinary() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(runDir, "src", "extra")); err != nil {
+		t.Errorf("unchanged archive was extracted again: %v", err)
+	}
+
+	writeSourceArchive(t, runDir, map[string]string{"app": "v2"})
+	got, err := ResolveBinary(runDir, cfg, "tool")
+	if err != nil {
+		t.Fatalf("ResolveBinary() error = %v", err)
+	}
+	content, err := os.ReadFile(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "v2" {
+		t.Errorf("binary content = %q, want %q after the archive changed", content, "v2")
+	}
+}
+
+func TestSourceNeedsExtraction(t *testing.T) {
+	runDir := t.TempDir()
+	if SourceNeedsExtraction(runDir) {
+		t.Error("SourceNeedsExtraction() = true without a source archive")
+	}
+
+	writeSourceArchive(t, runDir, map[string]string{"app": "v1"})
+	if !SourceNeedsExtraction(runDir) {
+		t.Error("SourceNeedsExtraction() = false for an archive that was never extracted")
+	}
+	cfg := config.Target{BuildCommand: config.BuildCommand{BinaryPathValue: "app"}}
+	if _, err := ResolveBinary(runDir, cfg, "tool"); err != nil {
+		t.Fatalf("ResolveBinary() error = %v", err)
+	}
+	if SourceNeedsExtraction(runDir) {
+		t.Error("SourceNeedsExtraction() = true for an archive that was already extracted")
+	}
+
+	writeFile(t, filepath.Join(runDir, "bin"), "")
+	writeSourceArchive(t, runDir, map[string]string{"app": "v2"})
+	if SourceNeedsExtraction(runDir) {
+		t.Error("SourceNeedsExtraction() = true for a build with a bin file")
+	}
+}
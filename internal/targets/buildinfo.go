@@ -0,0 +1,319 @@
+package targets
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BuildInfoFileName is the name of the JSON metadata file written to each commit directory
+const BuildInfoFileName = "build-info.json"
+
+// BuildInfoTextFileName is the name of the human-readable metadata file
+// written next to BuildInfoFileName. Builds made before the JSON file was
+// introduced only have this file.
+const BuildInfoTextFileName = "build-info.txt"
+
+// Build statuses recorded in the build metadata
+const (
+	// BuildStatusSuccess marks a build whose build command completed successfully
+	BuildStatusSuccess = "success"
+	// BuildStatusFailed marks a failed build; its FailedStage tells whether
+	// the build command ran
+	BuildStatusFailed = "failed"
+	// BuildStatusArchived marks a source snapshot made with --archive-only,
+	// for which no build command was run
+	BuildStatusArchived = "archived"
+	// BuildStatusUnknown marks a build whose legacy text metadata does not
+	// record the outcome
+	BuildStatusUnknown = "unknown"
+)
+
+// Stages of a build recorded with failed builds, so that a build that never
+// ran its build command can be told apart from one whose build command failed
+const (
+	// BuildStageSetup covers everything before the build command runs, such
+	// as the clone, the checkout and the clone cache lock
+	BuildStageSetup = "setup"
+	// BuildStageBuild covers the build command and the steps after it
+	BuildStageBuild = "build"
+)
+
+// Metadata levels controlling how much build metadata is written
+const (
+	// MetadataFull records everything known about the build
+	MetadataFull = "full"
+	// MetadataMinimal records only the commit, the build status and labels
+	MetadataMinimal = "minimal"
+	// MetadataNone records no build metadata at all
+	MetadataNone = "none"
+)
+
+// MetadataLevels lists the supported metadata levels
+var MetadataLevels = []string{MetadataFull, MetadataMinimal, MetadataNone}
+
+// IsMetadataLevel reports whether level is one of MetadataLevels
+//
+// Parameters:
+//   - level: The metadata level to check
+//
+// Returns:
+//   - bool: True if the level is supported, false otherwise
+func IsMetadataLevel(level string) bool {
+	for _, l := range MetadataLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildInfo represents the metadata recorded for a single build
+//
+// Fields:
+//   - BuildDate: The time the build finished
+//   - Target: The name of the target
+//   - Commit: The full commit hash that was built
+//   - ShortHash: The short commit hash used as the commit directory name
+//   - Ref: The branch or commit that was requested
+//   - PullRequest: The GitHub pull request built with --from-pr (0 otherwise)
+//   - Tag: The tag built with --tag (empty otherwise)
+//   - Status: The outcome of the build (success, failed or archived)
+//   - FailedStage: The stage a failed build failed at (setup or build); empty
+//     for builds recorded before stages were, whose build command failed
+//   - OS: The operating system the build ran on
+//   - Arch: The architecture the build ran on
+//   - CloneDuration: The time spent cloning the repository
+//   - BuildDuration: The time spent running the build command
+//   - DependencyFiles: Hashes of the dependency lock files found in the source
+//   - Warnings: Build output lines that matched the warning pattern
+//   - Labels: User-supplied key=value labels given with --label
+type BuildInfo struct {
+	// Fields other than the commit and status are left empty in minimal metadata
+	BuildDate     time.Time     `json:"build_date,omitzero"`
+	Target        string        `json:"target,omitempty"`
+	Commit        string        `json:"commit"`
+	ShortHash     string        `json:"short_hash"`
+	Ref           string        `json:"ref,omitempty"`
+	PullRequest   int           `json:"pull_request,omitempty"`
+	Tag           string        `json:"tag,omitempty"`
+	Status        string        `json:"status"`
+	FailedStage   string        `json:"failed_stage,omitempty"`
+	OS            string        `json:"os,omitempty"`
+	Arch          string        `json:"arch,omitempty"`
+	CloneDuration time.Duration `json:"clone_duration,omitzero"`
+	BuildDuration time.Duration `json:"build_duration,omitzero"`
+	// DependencyFiles is only populated when dependency recording is enabled
+	DependencyFiles []DependencyFile `json:"dependency_files,omitempty"`
+	// Warnings is only populated when builds fail on warnings
+	Warnings []string `json:"warnings,omitempty"`
+	// BinarySHA256 is the checksum of the binary copied into the commit
+	// directory, taken after post-processing
+	BinarySHA256 string `json:"binary_sha256,omitempty"`
+	// SourceArchiveSHA256 is the checksum of source.tar.gz, when the source
+	// was archived
+	SourceArchiveSHA256 string `json:"source_archive_sha256,omitempty"`
+	// Size is the disk usage of the commit directory in bytes, measured
+	// before the metadata files were written
+	Size int64 `json:"size,omitempty"`
+	// Labels are kept in minimal metadata since they are chosen by the user
+	Labels map[string]string `json:"labels,omitempty"`
+	// KeptClone records that the cloned source, including .git, was left in
+	// the src directory instead of being archived or removed
+	KeptClone bool `json:"kept_clone,omitempty"`
+}
+
+// DependencyFile represents a dependency lock file recorded for a build
+//
+// Fields:
+//   - Path: The path of the file relative to the repository root
+//   - SHA256: The hex-encoded SHA-256 checksum of the file
+type DependencyFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// BuildCommandFailed reports whether the build failed in or after its build
+// command, as opposed to failing before the build command ran
+//
+// Returns:
+//   - bool: True if the build is recorded as failed at the build stage
+func (b *BuildInfo) BuildCommandFailed() bool {
+	return b.Status == BuildStatusFailed && b.FailedStage != BuildStageSetup
+}
+
+// Succeeded reports whether the build completed successfully
+//
+// Returns:
+//   - bool: True if the recorded status is success, false otherwise
+func (b *BuildInfo) Succeeded() bool {
+	return b.Status == BuildStatusSuccess
+}
+
+// Minimal returns a copy of the metadata holding only the commit, the build
+// status, the user's labels and whether the clone was kept, leaving out
+// details about the build machine and the build
+//
+// Returns:
+//   - *BuildInfo: The minimal metadata
+func (b *BuildInfo) Minimal() *BuildInfo {
+	return &BuildInfo{
+		Commit:      b.Commit,
+		ShortHash:   b.ShortHash,
+		Status:      b.Status,
+		FailedStage: b.FailedStage,
+		Labels:      b.Labels,
+		KeptClone:   b.KeptClone,
+	}
+}
+
+// ReadBuildInfo reads the build metadata from the specified commit directory.
+// Builds without build-info.json fall back to parsing build-info.txt; when
+// neither file exists the returned error satisfies os.IsNotExist.
+//
+// Parameters:
+//   - commitDir: The commit directory containing the metadata file
+//
+// Returns:
+//   - *BuildInfo: The parsed build metadata
+//   - error: Any error encountered while reading or parsing the metadata
+func ReadBuildInfo(commitDir string) (*BuildInfo, error) {
+	return ReadBuildInfoWith(func(name string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(commitDir, name))
+	})
+}
+
+// ReadBuildInfoWith reads build metadata like ReadBuildInfo, with the files
+// of the build read by readFile, so that builds that are not in a local
+// directory can be read too
+//
+// Parameters:
+//   - readFile: The function reading a metadata file of the build by name; a
+//     missing file must be reported with an error satisfying os.IsNotExist
+//
+// Returns:
+//   - *BuildInfo: The parsed build metadata
+//   - error: Any error encountered while reading or parsing the metadata
+func ReadBuildInfoWith(readFile func(name string) ([]byte, error)) (*BuildInfo, error) {
+	data, err := readFile(BuildInfoFileName)
+	if os.IsNotExist(err) {
+		text, textErr := readFile(BuildInfoTextFileName)
+		if textErr != nil {
+			// Report the missing JSON file rather than the fallback
+			return nil, err
+		}
+		return parseBuildInfoText(text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var info BuildInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse build metadata: %w", err)
+	}
+	return &info, nil
+}
+
+// parseBuildInfoText parses the "Key: value" lines of build-info.txt. Lines
+// that are not recognised are ignored so that text written by other versions
+// can still be read. The text of older builds does not record the outcome of
+// the build, in which case the status is BuildStatusUnknown.
+//
+// Parameters:
+//   - data: The contents of build-info.txt
+//
+// Returns:
+//   - *BuildInfo: The parsed build metadata
+//   - error: An error if a recognised value is malformed or no commit is recorded
+func parseBuildInfoText(data []byte) (*BuildInfo, error) {
+	info := &BuildInfo{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ": ")
+		if !ok {
+			continue
+		}
+		var err error
+		switch key {
+		case "Target":
+			info.Target = value
+		case "Commit":
+			info.Commit = value
+		case "Short hash":
+			info.ShortHash = value
+		case "Status":
+			info.Status, _, _ = strings.Cut(value, ",")
+		case "Build date":
+			info.BuildDate, err = time.Parse(time.RFC3339, value)
+		case "Clone duration":
+			info.CloneDuration, err = time.ParseDuration(value)
+		case "Build duration":
+			info.BuildDuration, err = time.ParseDuration(value)
+		case "OS":
+			info.OS = value
+		case "Architecture":
+			info.Arch = value
+		case "Pull request":
+			info.PullRequest, err = strconv.Atoi(strings.TrimPrefix(value, "#"))
+		case "Tag":
+			info.Tag = value
+		case "Dependency file":
+			path, sum, found := strings.Cut(value, " sha256:")
+			if !found {
+				err = fmt.Errorf("missing checksum")
+			}
+			info.DependencyFiles = append(info.DependencyFiles, DependencyFile{Path: path, SHA256: sum})
+		case "Binary sha256":
+			info.BinarySHA256 = value
+		case "Source archive sha256":
+			info.SourceArchiveSHA256 = value
+		case "Size":
+			info.Size, err = strconv.ParseInt(strings.TrimSuffix(value, " bytes"), 10, 64)
+		case "Warning":
+			info.Warnings = append(info.Warnings, value)
+		case "Labels":
+			info.Labels = map[string]string{}
+			for _, pair := range strings.Split(value, ",") {
+				k, v, _ := strings.Cut(pair, "=")
+				info.Labels[k] = v
+			}
+		case "Source":
+			info.KeptClone = strings.HasPrefix(value, "kept clone")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s in build metadata: %w", strings.ToLower(key), err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read build metadata: %w", err)
+	}
+	if info.Commit == "" {
+		return nil, fmt.Errorf("failed to parse build metadata: no commit recorded")
+	}
+	if info.Status == "" {
+		info.Status = BuildStatusUnknown
+	}
+	return info, nil
+}
+
+// WriteBuildInfo writes the build metadata to the specified commit directory
+//
+// Parameters:
+//   - commitDir: The commit directory to write the metadata file into
+//   - info: The build metadata to write
+//
+// Returns:
+//   - error: Any error encountered while encoding or writing the metadata
+func WriteBuildInfo(commitDir string, info *BuildInfo) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode build metadata: %w", err)
+	}
+	return os.WriteFile(filepath.Join(commitDir, BuildInfoFileName), append(data, '\n'), 0644)
+}
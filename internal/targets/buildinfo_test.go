@@ -0,0 +1,232 @@
+package targets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBuildInfoRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := &BuildInfo{
+		BuildDate:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Target:        "tool",
+		Commit:        "1234567890abcdef1234567890abcdef12345678",
+		ShortHash:     "1234567",
+		Ref:           "main",
+		Status:        BuildStatusSuccess,
+		OS:            "linux",
+		Arch:          "amd64",
+		CloneDuration: 2 * time.Second,
+		BuildDuration: 3 * time.Second,
+		DependencyFiles: []DependencyFile{
+			{Path: "go.sum", SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		},
+		Warnings: []string{"warning: unused variable"},
+	}
+	if err := WriteBuildInfo(dir, want); err != nil {
+		t.Fatalf("WriteBuildInfo() error = %v", err)
+	}
+
+	got, err := ReadBuildInfo(dir)
+	if err != nil {
+		t.Fatalf("ReadBuildInfo() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadBuildInfo() = %+v, want %+v", got, want)
+	}
+	if !got.Succeeded() {
+		t.Errorf("Succeeded() = false, want true")
+	}
+}
+
+func TestReadBuildInfoMissing(t *testing.T) {
+	_, err := ReadBuildInfo(t.TempDir())
+	if err == nil {
+		t.Fatalf("ReadBuildInfo() on directory without metadata should fail")
+	}
+	if !os.IsNotExist(err) {
+		t.Errorf("ReadBuildInfo() error = %v, want a not-exist error", err)
+	}
+}
+
+func TestBuildInfoMinimal(t *testing.T) {
+	dir := t.TempDir()
+	full := &BuildInfo{
+		BuildDate:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Target:        "tool",
+		Commit:        "1234567890abcdef1234567890abcdef12345678",
+		ShortHash:     "1234567",
+		Ref:           "main",
+		Status:        BuildStatusFailed,
+		OS:            "linux",
+		Arch:          "amd64",
+		CloneDuration: 2 * time.Second,
+		BuildDuration: 3 * time.Second,
+	}
+	if err := WriteBuildInfo(dir, full.Minimal()); err != nil {
+		t.Fatalf("WriteBuildInfo() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, BuildInfoFileName))
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("Failed to parse metadata: %v", err)
+	}
+	want := map[string]interface{}{
+		"commit":     full.Commit,
+		"short_hash": full.ShortHash,
+		"status":     BuildStatusFailed,
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("minimal metadata = %v, want %v", fields, want)
+	}
+
+	got, err := ReadBuildInfo(dir)
+	if err != nil {
+		t.Fatalf("ReadBuildInfo() error = %v", err)
+	}
+	if got.Succeeded() {
+		t.Errorf("Succeeded() = true, want false")
+	}
+}
+
+func TestIsMetadataLevel(t *testing.T) {
+	for _, level := range []string{MetadataFull, MetadataMinimal, MetadataNone} {
+		if !IsMetadataLevel(level) {
+			t.Errorf("IsMetadataLevel(%q) = false, want true", level)
+		}
+	}
+	for _, level := range []string{"", "verbose", "FULL"} {
+		if IsMetadataLevel(level) {
+			t.Errorf("IsMetadataLevel(%q) = true, want false", level)
+		}
+	}
+}
+
+func TestReadBuildInfoFallsBackToText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want *BuildInfo
+	}{
+		{
+			name: "legacy build",
+			text: "Target: tool\n" +
+				"Commit: 1234567890abcdef1234567890abcdef12345678\n" +
+				"Short hash: 1234567\n" +
+				"Build date: 2024-01-02T03:04:05Z\n" +
+				"Clone duration: 2s\n" +
+				"Build duration: 1m3.5s\n" +
+				"OS: linux\n" +
+				"Architecture: amd64\n",
+			want: &BuildInfo{
+				BuildDate:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				Target:        "tool",
+				Commit:        "1234567890abcdef1234567890abcdef12345678",
+				ShortHash:     "1234567",
+				Status:        BuildStatusUnknown,
+				OS:            "linux",
+				Arch:          "amd64",
+				CloneDuration: 2 * time.Second,
+				BuildDuration: time.Minute + 3500*time.Millisecond,
+			},
+		},
+		{
+			name: "archived build with details",
+			text: "Commit: 1234567890abcdef1234567890abcdef12345678\n" +
+				"Short hash: 1234567\n" +
+				"Pull request: #42\n" +
+				"Dependency file: go.sum sha256:abc123\n" +
+				"Source archive sha256: def456\n" +
+				"Size: 2048 bytes\n" +
+				"Warning: warning: unused variable\n" +
+				"Labels: env=ci,team=core\n" +
+				"Status: archived, not built\n",
+			want: &BuildInfo{
+				Commit:              "1234567890abcdef1234567890abcdef12345678",
+				ShortHash:           "1234567",
+				PullRequest:         42,
+				Status:              BuildStatusArchived,
+				DependencyFiles:     []DependencyFile{{Path: "go.sum", SHA256: "abc123"}},
+				SourceArchiveSHA256: "def456",
+				Size:                2048,
+				Warnings:            []string{"warning: unused variable"},
+				Labels:              map[string]string{"env": "ci", "team": "core"},
+			},
+		},
+		{
+			name: "minimal metadata",
+			text: "Commit: 1234567890abcdef1234567890abcdef12345678\n" +
+				"Short hash: 1234567\n" +
+				"Status: failed\n" +
+				"Source: kept clone (src)\n",
+			want: &BuildInfo{
+				Commit:    "1234567890abcdef1234567890abcdef12345678",
+				ShortHash: "1234567",
+				Status:    BuildStatusFailed,
+				KeptClone: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, BuildInfoTextFileName), []byte(tt.text), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadBuildInfo(dir)
+			if err != nil {
+				t.Fatalf("ReadBuildInfo() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadBuildInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBuildInfoPrefersJSON(t *testing.T) {
+	dir := t.TempDir()
+	if err := WriteBuildInfo(dir, &BuildInfo{Commit: "abcdef1", ShortHash: "abcdef1", Status: BuildStatusSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, BuildInfoTextFileName), []byte("Commit: 1234567\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadBuildInfo(dir)
+	if err != nil {
+		t.Fatalf("ReadBuildInfo() error = %v", err)
+	}
+	if got.Commit != "abcdef1" || !got.Succeeded() {
+		t.Errorf("ReadBuildInfo() = %+v, want the JSON metadata", got)
+	}
+}
+
+func TestReadBuildInfoTextErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{name: "no commit", text: "Target: tool\n"},
+		{name: "malformed date", text: "Commit: 1234567\nBuild date: yesterday\n"},
+		{name: "malformed size", text: "Commit: 1234567\nSize: large\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, BuildInfoTextFileName), []byte(tt.text), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ReadBuildInfo(dir); err == nil {
+				t.Errorf("ReadBuildInfo() should fail")
+			}
+		})
+	}
+}
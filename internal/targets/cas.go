@@ -0,0 +1,235 @@
+package targets
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oota-sushikuitee/nigiri/pkg/fsutils"
+)
+
+// CASDirName is the name of the directory in the nigiri root holding the
+// content-addressed store shared by builds made with --cas
+const CASDirName = ".cas"
+
+// casExecutableSuffix marks blobs of files with 0755 permissions. Hard links
+// share their permissions, so copies of the same content with different
+// permissions are stored as separate blobs. Blobs of files with 0644
+// permissions have no suffix, and blobs of files with any other permissions
+// are suffixed with them in octal, e.g. "-600".
+const casExecutableSuffix = "-x"
+
+// CASStats summarizes how a directory was stored in the content-addressed store
+//
+// Fields:
+//   - Files: The number of regular files stored
+//   - Shared: The number of files linked to content another build already stored
+//   - SharedBytes: The disk space saved by the shared files in bytes
+type CASStats struct {
+	Files       int
+	Shared      int
+	SharedBytes int64
+}
+
+// CASBlob is a file in the content-addressed store
+//
+// Fields:
+//   - Path: The path of the blob
+//   - Size: The size of the blob in bytes
+type CASBlob struct {
+	Path string
+	Size int64
+}
+
+// StoreInCAS stores every regular file under dir in the content-addressed
+// store at casDir, keyed by its SHA-256 checksum. A file whose content is not
+// in the store yet becomes the stored blob; any other file is replaced by a
+// hard link to the stored blob. Files keep their permissions. Where hard
+// links are not supported, files are left in place as copies.
+//
+// Parameters:
+//   - casDir: The directory of the content-addressed store
+//   - dir: The directory whose files to store
+//
+// Returns:
+//   - CASStats: How many files were stored and shared
+//   - error: Any error encountered while hashing, linking or copying files
+func StoreInCAS(casDir, dir string) (CASStats, error) {
+	var stats CASStats
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		shared, err := storeFileInCAS(casDir, path, info)
+		if err != nil {
+			return fmt.Errorf("failed to store %s: %w", path, err)
+		}
+		stats.Files++
+		if shared {
+			stats.Shared++
+			stats.SharedBytes += info.Size()
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// storeFileInCAS stores a single file and reports whether it now shares its
+// content with a blob stored earlier
+func storeFileInCAS(casDir, path string, info os.FileInfo) (bool, error) {
+	sum, err := fsutils.SHA256File(path)
+	if err != nil {
+		return false, err
+	}
+	mode := info.Mode().Perm()
+	blob := filepath.Join(casDir, sum[:2], casBlobName(sum, mode))
+
+	blobInfo, err := os.Stat(blob)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+			return false, err
+		}
+		// The first copy of the content becomes the blob
+		if err := os.Link(path, blob); err != nil {
+			if !linkUnsupported(err) {
+				return false, err
+			}
+			return false, copyCASBlob(path, blob, mode)
+		}
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if os.SameFile(blobInfo, info) {
+		return true, nil
+	}
+
+	// Swap the file for a link through a temporary name so that it is never
+	// missing; keep the copy where linking is not supported
+	tmp := path + ".cas-tmp"
+	if err := os.Link(blob, tmp); err != nil {
+		if linkUnsupported(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
+
+// casBlobName returns the name of the blob of content with checksum sum
+// stored for files with permissions mode
+func casBlobName(sum string, mode os.FileMode) string {
+	switch mode {
+	case 0644:
+		return sum
+	case 0755:
+		return sum + casExecutableSuffix
+	default:
+		return fmt.Sprintf("%s-%03o", sum, mode)
+	}
+}
+
+// copyCASBlob copies src to the blob path through a temporary file so that
+// a partially written blob is never visible
+func copyCASBlob(src, blob string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	tmp, err := os.CreateTemp(filepath.Dir(blob), ".blob-")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := io.Copy(tmp, in); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), blob)
+}
+
+// UnreferencedCASBlobs lists the blobs in the content-addressed store that no
+// build links to once the directories in removing are gone. Blobs are only
+// reported on platforms that expose hard link counts; elsewhere every blob is
+// treated as referenced.
+//
+// Parameters:
+//   - casDir: The directory of the content-addressed store
+//   - removing: Directories about to be removed whose links do not count
+//
+// Returns:
+//   - []CASBlob: The unreferenced blobs
+//   - error: Any error encountered while reading the store
+func UnreferencedCASBlobs(casDir string, removing []string) ([]CASBlob, error) {
+	if _, err := os.Stat(casDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	// Links inside directories that are about to be removed do not keep
+	// a blob alive. The directories may be nested, so each path counts once.
+	dropped := make(map[fileID]uint64)
+	seen := make(map[string]bool)
+	for _, dir := range removing {
+		_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() || seen[path] {
+				return nil
+			}
+			seen[path] = true
+			if info, err := d.Info(); err == nil {
+				if id, _, ok := linkInfo(info); ok {
+					dropped[id]++
+				}
+			}
+			return nil
+		})
+	}
+
+	var blobs []CASBlob
+	err := filepath.WalkDir(casDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		id, links, ok := linkInfo(info)
+		if !ok {
+			return nil
+		}
+		// The store itself holds one of the links
+		if links-1 <= dropped[id] {
+			blobs = append(blobs, CASBlob{Path: path, Size: info.Size()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content-addressed store: %w", err)
+	}
+	return blobs, nil
+}
@@ -0,0 +1,32 @@
+//go:build !unix
+
+package targets
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// fileID identifies a file independently of the paths linking to it
+type fileID struct{}
+
+// linkInfo is not supported on this platform and always reports false
+//
+// Parameters:
+//   - info: The file info of the file
+//
+// Returns:
+//   - fileID: The zero file identity
+//   - uint64: Always 0
+//   - bool: Always false
+func linkInfo(info os.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
+
+// linkUnsupported reports whether err, returned by os.Link, means that the
+// file cannot be hard linked where it is. The reasons are not told apart on
+// this platform, so only a missing file counts as a failure.
+func linkUnsupported(err error) bool {
+	return !errors.Is(err, fs.ErrNotExist)
+}
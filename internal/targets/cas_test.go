@@ -0,0 +1,222 @@
+package targets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// requireHardLinks skips the test unless dir supports hard links and the
+// platform reports link counts
+func requireHardLinks(t *testing.T, dir string) {
+	t.Helper()
+	src := filepath.Join(dir, "link-probe")
+	if err := os.WriteFile(src, nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	defer func() { _ = os.Remove(src) }()
+	if err := os.Link(src, src+"-link"); err != nil {
+		t.Skipf("hard links are not supported: %v", err)
+	}
+	defer func() { _ = os.Remove(src + "-link") }()
+	info, err := os.Stat(src)
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if _, _, ok := linkInfo(info); !ok {
+		t.Skip("hard link counts are not reported on this platform")
+	}
+}
+
+// writeCASTestFiles writes files, keyed by name, into dir
+func writeCASTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+}
+
+// sameFile reports whether the paths refer to the same underlying file
+func sameFile(t *testing.T, a, b string) bool {
+	t.Helper()
+	infoA, err := os.Stat(a)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", a, err)
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", b, err)
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+func TestStoreInCAS(t *testing.T) {
+	root := t.TempDir()
+	requireHardLinks(t, root)
+	casDir := filepath.Join(root, CASDirName)
+	first := filepath.Join(root, "first")
+	second := filepath.Join(root, "second")
+	writeCASTestFiles(t, first, map[string]string{"README": "shared\n", "main.go": "package main\n"})
+	writeCASTestFiles(t, second, map[string]string{"docs/README": "shared\n", "main.go": "package other\n"})
+	if err := os.Chmod(filepath.Join(first, "README"), 0755); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+
+	stats, err := StoreInCAS(casDir, first)
+	if err != nil {
+		t.Fatalf("StoreInCAS() error = %v", err)
+	}
+	if stats != (CASStats{Files: 2}) {
+		t.Errorf("StoreInCAS() first stats = %+v, want 2 files and nothing shared", stats)
+	}
+	writeCASTestFiles(t, second, map[string]string{"copy/README": "shared\n"})
+	if err := os.Chmod(filepath.Join(second, "copy", "README"), 0755); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+	stats, err = StoreInCAS(casDir, second)
+	if err != nil {
+		t.Fatalf("StoreInCAS() error = %v", err)
+	}
+	want := CASStats{Files: 3, Shared: 1, SharedBytes: int64(len("shared\n"))}
+	if stats != want {
+		t.Errorf("StoreInCAS() second stats = %+v, want %+v", stats, want)
+	}
+
+	// Identical executable content shares an inode; the same content with
+	// other permissions and different content do not
+	if !sameFile(t, filepath.Join(first, "README"), filepath.Join(second, "copy", "README")) {
+		t.Errorf("identical executable files do not share an inode")
+	}
+	if sameFile(t, filepath.Join(first, "README"), filepath.Join(second, "docs", "README")) {
+		t.Errorf("files with different permissions share an inode")
+	}
+	if sameFile(t, filepath.Join(first, "main.go"), filepath.Join(second, "main.go")) {
+		t.Errorf("files with different content share an inode")
+	}
+
+	// Storing a directory again changes nothing
+	stats, err = StoreInCAS(casDir, second)
+	if err != nil {
+		t.Fatalf("StoreInCAS() error = %v", err)
+	}
+	if stats.Files != 3 || stats.Shared != 3 {
+		t.Errorf("StoreInCAS() again stats = %+v, want 3 files all shared", stats)
+	}
+	data, err := os.ReadFile(filepath.Join(second, "docs", "README"))
+	if err != nil || string(data) != "shared\n" {
+		t.Errorf("stored file content = %q, %v, want %q", data, err, "shared\n")
+	}
+}
+
+func TestStoreInCASKeepsPermissions(t *testing.T) {
+	root := t.TempDir()
+	requireHardLinks(t, root)
+	casDir := filepath.Join(root, CASDirName)
+	dir := filepath.Join(root, "build")
+	files := map[string]os.FileMode{"secret": 0600, "script": 0700, "tool": 0755, "README": 0644}
+	for name, mode := range files {
+		writeCASTestFiles(t, dir, map[string]string{name: "same\n"})
+		if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+			t.Fatalf("Failed to chmod: %v", err)
+		}
+	}
+
+	stats, err := StoreInCAS(casDir, dir)
+	if err != nil {
+		t.Fatalf("StoreInCAS() error = %v", err)
+	}
+	if stats.Shared != 0 {
+		t.Errorf("StoreInCAS() shared %d files with different permissions, want 0", stats.Shared)
+	}
+	for name, mode := range files {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		if got := info.Mode().Perm(); got != mode {
+			t.Errorf("%s permissions = %o, want %o", name, got, mode)
+		}
+	}
+}
+
+func TestStoreInCASReturnsLinkErrors(t *testing.T) {
+	root := t.TempDir()
+	requireHardLinks(t, root)
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	casDir := filepath.Join(root, CASDirName)
+	first := filepath.Join(root, "first")
+	second := filepath.Join(root, "second")
+	writeCASTestFiles(t, first, map[string]string{"README": "shared\n"})
+	writeCASTestFiles(t, second, map[string]string{"README": "shared\n"})
+	if _, err := StoreInCAS(casDir, first); err != nil {
+		t.Fatalf("StoreInCAS() error = %v", err)
+	}
+
+	// The link cannot be created next to the file
+	if err := os.Chmod(second, 0555); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+	defer func() { _ = os.Chmod(second, 0755) }()
+	if _, err := StoreInCAS(casDir, second); err == nil {
+		t.Errorf("StoreInCAS() error = nil, want the link error")
+	}
+}
+
+func TestUnreferencedCASBlobs(t *testing.T) {
+	root := t.TempDir()
+	requireHardLinks(t, root)
+	casDir := filepath.Join(root, CASDirName)
+	first := filepath.Join(root, "first")
+	second := filepath.Join(root, "second")
+	writeCASTestFiles(t, first, map[string]string{"README": "shared\n", "only-first": "first\n"})
+	writeCASTestFiles(t, second, map[string]string{"README": "shared\n"})
+	for _, dir := range []string{first, second} {
+		if _, err := StoreInCAS(casDir, dir); err != nil {
+			t.Fatalf("StoreInCAS() error = %v", err)
+		}
+	}
+
+	blobs, err := UnreferencedCASBlobs(casDir, nil)
+	if err != nil {
+		t.Fatalf("UnreferencedCASBlobs() error = %v", err)
+	}
+	if len(blobs) != 0 {
+		t.Errorf("UnreferencedCASBlobs() = %v, want none while builds link every blob", blobs)
+	}
+
+	// Removing the first build only releases the blob it alone links to;
+	// nested directories are counted once
+	blobs, err = UnreferencedCASBlobs(casDir, []string{first, filepath.Join(first, "README")})
+	if err != nil {
+		t.Fatalf("UnreferencedCASBlobs() error = %v", err)
+	}
+	if len(blobs) != 1 || blobs[0].Size != int64(len("first\n")) {
+		t.Errorf("UnreferencedCASBlobs() = %v, want the blob of only-first", blobs)
+	}
+
+	for _, dir := range []string{first, second} {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatalf("Failed to remove %s: %v", dir, err)
+		}
+	}
+	blobs, err = UnreferencedCASBlobs(casDir, nil)
+	if err != nil {
+		t.Fatalf("UnreferencedCASBlobs() error = %v", err)
+	}
+	if len(blobs) != 2 {
+		t.Errorf("UnreferencedCASBlobs() = %v, want both blobs once no build links them", blobs)
+	}
+
+	blobs, err = UnreferencedCASBlobs(filepath.Join(root, "missing"), nil)
+	if err != nil || blobs != nil {
+		t.Errorf("UnreferencedCASBlobs() without a store = %v, %v, want nil, nil", blobs, err)
+	}
+}
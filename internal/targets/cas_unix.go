@@ -0,0 +1,40 @@
+//go:build unix
+
+package targets
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file independently of the paths linking to it
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// linkInfo returns the identity and hard link count of a file
+//
+// Parameters:
+//   - info: The file info of the file
+//
+// Returns:
+//   - fileID: The device and inode of the file
+//   - uint64: The number of hard links to the file
+//   - bool: True if the platform reported them, false otherwise
+func linkInfo(info os.FileInfo) (fileID, uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, uint64(st.Nlink), true
+}
+
+// linkUnsupported reports whether err, returned by os.Link, means that the
+// file cannot be hard linked where it is, e.g. across filesystems, rather
+// than that linking failed
+func linkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.EMLINK) ||
+		errors.Is(err, syscall.EPERM) || errors.Is(err, errors.ErrUnsupported)
+}
@@ -0,0 +1,213 @@
+package targets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CloneCacheDirName is the name of the directory in the nigiri root holding
+// one cached clone for each source repository
+const CloneCacheDirName = ".clone-cache"
+
+// CloneCacheLockFileName is the name of the marker file written to a cache
+// entry while a clone or fetch is using it
+const CloneCacheLockFileName = ".cache.lock"
+
+// cloneCacheFetchFileName is the name of the file in a cache entry recording
+// the source it caches. Its modification time is the time of the last fetch.
+const cloneCacheFetchFileName = ".fetch"
+
+// cloneCacheKeyHashLength is the number of hex digits of the hash that ends a
+// cache key
+const cloneCacheKeyHashLength = 16
+
+// CloneCacheEntry is a cached clone of a source repository
+//
+// Fields:
+//   - Key: The cache key of the source, which is the name of the entry directory
+//   - Source: The source URL the entry caches (empty if it was not recorded)
+//   - Path: The directory of the entry
+//   - LastFetch: When the entry was last fetched (zero if it was not recorded)
+//   - Locked: Whether a clone or fetch is using the entry
+type CloneCacheEntry struct {
+	Key       string
+	Source    string
+	Path      string
+	LastFetch time.Time
+	Locked    bool
+}
+
+// CloneCacheKey normalizes a source URL into the cache key of its entry, so
+// that the different ways of writing the same repository share one entry:
+// the scheme, credentials, a trailing ".git" and the case of the host are
+// ignored, and scp-like SSH sources ("git@host:owner/repo") map to the same
+// key as their HTTPS form. The key is the readable host and path followed by
+// a hash of them, so that sources that only differ in where their path has
+// separators and underscores, such as foo_bar/baz and foo/bar_baz, never
+// share an entry.
+//
+// Parameters:
+//   - source: The source URL of the repository
+//
+// Returns:
+//   - string: The cache key, usable as a directory name
+func CloneCacheKey(source string) string {
+	s := strings.TrimSpace(source)
+	host, path := "", s
+	if u, err := url.Parse(s); err == nil && strings.Contains(s, "://") {
+		host, path = u.Hostname(), u.Path
+	} else if at, colon := strings.Index(s, "@"), strings.Index(s, ":"); colon > 0 && !strings.Contains(s[:colon], "/") {
+		host, path = s[at+1:colon], s[colon+1:]
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+
+	key := strings.ToLower(host)
+	if path != "" {
+		if key != "" {
+			key += "/"
+		}
+		key += path
+	}
+	readable := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':':
+			return '_'
+		}
+		return r
+	}, key)
+	sum := sha256.Sum256([]byte(key))
+	return readable + "-" + hex.EncodeToString(sum[:])[:cloneCacheKeyHashLength]
+}
+
+// RecordCloneCacheFetch records that the entry in entryDir caching source has
+// just been fetched
+//
+// Parameters:
+//   - entryDir: The directory of the cache entry
+//   - source: The source URL the entry caches
+//
+// Returns:
+//   - error: Any error encountered while writing the fetch record
+func RecordCloneCacheFetch(entryDir, source string) error {
+	if err := os.WriteFile(filepath.Join(entryDir, cloneCacheFetchFileName), []byte(source), 0644); err != nil {
+		return fmt.Errorf("failed to record fetch: %w", err)
+	}
+	return nil
+}
+
+// AcquireCloneCacheLock marks the cache entry in entryDir as in use,
+// waiting while another clone or fetch uses it. The returned release
+// function removes the marker and should be deferred by the caller.
+//
+// Parameters:
+//   - entryDir: The directory of the cache entry
+//
+// Returns:
+//   - func(): A function that releases the lock
+//   - error: Any error encountered while writing the lock file
+func AcquireCloneCacheLock(entryDir string) (func(), error) {
+	release, err := acquireLock(filepath.Join(entryDir, CloneCacheLockFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write cache lock: %w", err)
+	}
+	return release, nil
+}
+
+// TryAcquireCloneCacheLock marks the cache entry in entryDir as in use
+// unless a clone or fetch is using it
+//
+// Parameters:
+//   - entryDir: The directory of the cache entry
+//
+// Returns:
+//   - func(): A function that releases the lock (nil if the entry is in use)
+//   - bool: True if the lock was taken, false if the entry is in use
+//   - error: Any error encountered while writing the lock file
+func TryAcquireCloneCacheLock(entryDir string) (func(), bool, error) {
+	release, err := tryLock(filepath.Join(entryDir, CloneCacheLockFileName))
+	if errors.Is(err, errLockHeld) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to write cache lock: %w", err)
+	}
+	return release, true, nil
+}
+
+// IsCloneCacheLocked reports whether a clone or fetch is using the cache
+// entry. A lock left behind by a process that no longer exists is ignored.
+//
+// Parameters:
+//   - entryDir: The directory of the cache entry
+//
+// Returns:
+//   - bool: True if a live process holds the cache lock, false otherwise
+func IsCloneCacheLocked(entryDir string) bool {
+	return lockHeld(filepath.Join(entryDir, CloneCacheLockFileName))
+}
+
+// ReadCloneCacheEntry reads the cache entry in entryDir
+//
+// Parameters:
+//   - entryDir: The directory of the cache entry
+//
+// Returns:
+//   - CloneCacheEntry: The cache entry
+//   - error: Any error encountered while reading the entry
+func ReadCloneCacheEntry(entryDir string) (CloneCacheEntry, error) {
+	if _, err := os.Stat(entryDir); err != nil {
+		return CloneCacheEntry{}, err
+	}
+	entry := CloneCacheEntry{
+		Key:    filepath.Base(entryDir),
+		Path:   entryDir,
+		Locked: IsCloneCacheLocked(entryDir),
+	}
+	fetchPath := filepath.Join(entryDir, cloneCacheFetchFileName)
+	if data, err := os.ReadFile(fetchPath); err == nil {
+		entry.Source = strings.TrimSpace(string(data))
+	}
+	if info, err := os.Stat(fetchPath); err == nil {
+		entry.LastFetch = info.ModTime()
+	}
+	return entry, nil
+}
+
+// ListCloneCache lists the entries of the clone cache, sorted by key
+//
+// Parameters:
+//   - cacheDir: The directory of the clone cache
+//
+// Returns:
+//   - []CloneCacheEntry: The cache entries (none if the cache does not exist)
+//   - error: Any error encountered while reading the cache
+func ListCloneCache(cacheDir string) ([]CloneCacheEntry, error) {
+	dirEntries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read clone cache: %w", err)
+	}
+	var entries []CloneCacheEntry
+	for _, d := range dirEntries {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		entry, err := ReadCloneCacheEntry(filepath.Join(cacheDir, d.Name()))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
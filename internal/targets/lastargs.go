@@ -0,0 +1,80 @@
+package targets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LastArgsFileName is the name of the file in a commit directory that records
+// the arguments the build was last run with
+const LastArgsFileName = "last-args.json"
+
+// ReadLastArgs reads the arguments a build was last run with
+//
+// Parameters:
+//   - commitDir: The commit directory of the build
+//
+// Returns:
+//   - []string: The recorded arguments (empty if the last run had none)
+//   - bool: True if arguments were recorded, false if the build was never run
+//   - error: Any error encountered while reading or parsing the file
+func ReadLastArgs(commitDir string) ([]string, bool, error) {
+	data, err := os.ReadFile(filepath.Join(commitDir, LastArgsFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	var args []string
+	if err := json.Unmarshal(data, &args); err != nil {
+		return nil, false, fmt.Errorf("failed to parse last arguments: %w", err)
+	}
+	return args, true, nil
+}
+
+// WriteLastArgs records the arguments a build is run with, replacing those of
+// its previous run
+//
+// Parameters:
+//   - commitDir: The commit directory of the build
+//   - args: The arguments passed to the binary
+//
+// Returns:
+//   - error: Any error encountered while writing the file
+func WriteLastArgs(commitDir string, args []string) error {
+	if args == nil {
+		args = []string{}
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("failed to encode last arguments: %w", err)
+	}
+	info, err := os.Stat(commitDir)
+	if err != nil {
+		return err
+	}
+	// Write through a temporary file so concurrent runs never see a partial file
+	tmp, err := os.CreateTemp(commitDir, LastArgsFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write last arguments: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write last arguments: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write last arguments: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(commitDir, LastArgsFileName)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write last arguments: %w", err)
+	}
+	// Builds are ordered by modification time, which running a build must not change
+	_ = os.Chtimes(commitDir, info.ModTime(), info.ModTime())
+	return nil
+}
@@ -0,0 +1,66 @@
+package targets
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLastArgs(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(dir, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	args, ok, err := ReadLastArgs(dir)
+	if err != nil || ok || args != nil {
+		t.Errorf("ReadLastArgs() without a run = %v, %v, %v, want nil, false, nil", args, ok, err)
+	}
+
+	if err := WriteLastArgs(dir, []string{"-v", "--flag=a b"}); err != nil {
+		t.Fatalf("WriteLastArgs() error = %v", err)
+	}
+	if err := WriteLastArgs(dir, nil); err != nil {
+		t.Fatalf("WriteLastArgs() error = %v", err)
+	}
+	args, ok, err = ReadLastArgs(dir)
+	if err != nil || !ok || len(args) != 0 {
+		t.Errorf("ReadLastArgs() after a run without arguments = %v, %v, %v, want [], true, nil", args, ok, err)
+	}
+
+	if err := WriteLastArgs(dir, []string{"-v", "--flag=a b"}); err != nil {
+		t.Fatalf("WriteLastArgs() error = %v", err)
+	}
+	args, ok, err = ReadLastArgs(dir)
+	if err != nil || !ok || !reflect.DeepEqual(args, []string{"-v", "--flag=a b"}) {
+		t.Errorf("ReadLastArgs() = %v, %v, %v", args, ok, err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("WriteLastArgs() changed the commit directory modification time to %v, want %v", info.ModTime(), modTime)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != LastArgsFileName {
+		t.Errorf("commit directory contains %v, want only %s", entries, LastArgsFileName)
+	}
+}
+
+func TestReadLastArgsCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, LastArgsFileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadLastArgs(dir); err == nil {
+		t.Errorf("ReadLastArgs() of a corrupt file should fail")
+	}
+}
@@ -0,0 +1,189 @@
+package targets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BuildLockFileName is the name of the marker file written to a target root
+// directory while a build of that target is in progress
+const BuildLockFileName = ".build.lock"
+
+// lockPollInterval is how often a held lock is checked while waiting for it
+var lockPollInterval = 100 * time.Millisecond
+
+// errLockHeld is returned by tryLock when another holder has the lock
+var errLockHeld = errors.New("the lock is held by another process")
+
+// tryLock takes an exclusive file lock on the lock file at lockPath and
+// writes the PID of the process to it. The lock is held on an open file, so
+// it fails while another holder has it, including another goroutine of this
+// process, and the operating system releases it when its process exits. A
+// lock file left behind by a process that crashed is therefore not held and
+// is reused as it is, without being removed first.
+//
+// Parameters:
+//   - lockPath: The path of the lock file
+//
+// Returns:
+//   - func(): A function that releases the lock; calling it again has no effect
+//   - error: errLockHeld if the lock is held, or any error encountered while creating the lock file
+func tryLock(lockPath string) (func(), error) {
+	for {
+		file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(file); err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		// The previous holder removes the file when it releases the lock, so
+		// the file locked here may no longer be the one at lockPath
+		if !isCurrentFile(file, lockPath) {
+			_ = unlockFile(file)
+			_ = file.Close()
+			continue
+		}
+		if err := writePID(file); err != nil {
+			releaseLockFile(file, lockPath)
+			return nil, err
+		}
+		var once sync.Once
+		return func() {
+			once.Do(func() { releaseLockFile(file, lockPath) })
+		}, nil
+	}
+}
+
+// isCurrentFile reports whether file is still the file at path
+//
+// Parameters:
+//   - file: The open file
+//   - path: The path the file was opened at
+//
+// Returns:
+//   - bool: True if path names file, false if it was removed or replaced
+func isCurrentFile(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
+
+// writePID replaces the content of the lock file with the PID of the process
+//
+// Parameters:
+//   - file: The locked lock file
+//
+// Returns:
+//   - error: Any error encountered while writing the file
+func writePID(file *os.File) error {
+	if err := file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := file.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	return err
+}
+
+// releaseLockFile removes the lock file and releases its lock. Elsewhere the
+// file is removed while it is still locked, so that nobody locks a file that
+// is about to be removed; Windows cannot remove open files, and the removal
+// after closing the file fails while another process has it open.
+//
+// Parameters:
+//   - file: The locked lock file
+//   - lockPath: The path of the lock file
+func releaseLockFile(file *os.File, lockPath string) {
+	if runtime.GOOS != "windows" {
+		_ = os.Remove(lockPath)
+	}
+	_ = unlockFile(file)
+	_ = file.Close()
+	if runtime.GOOS == "windows" {
+		_ = os.Remove(lockPath)
+	}
+}
+
+// acquireLock takes the lock file at lockPath like tryLock, waiting while
+// another holder has it
+//
+// Parameters:
+//   - lockPath: The path of the lock file
+//
+// Returns:
+//   - func(): A function that releases the lock; calling it again has no effect
+//   - error: Any error encountered while creating the lock file
+func acquireLock(lockPath string) (func(), error) {
+	for {
+		release, err := tryLock(lockPath)
+		if !errors.Is(err, errLockHeld) {
+			return release, err
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// lockHeld reports whether the lock file at lockPath is locked. A lock file
+// left behind by a process that no longer exists is not.
+//
+// Parameters:
+//   - lockPath: The path of the lock file
+//
+// Returns:
+//   - bool: True if the lock is held, false if it is missing or stale
+func lockHeld(lockPath string) bool {
+	file, err := os.Open(lockPath)
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	defer file.Close()
+	if err := lockFile(file); err != nil {
+		return true
+	}
+	_ = unlockFile(file)
+	return false
+}
+
+// AcquireBuildLock marks the target root directory as having a build in
+// progress, waiting while another build of the target holds the lock. The
+// returned release function removes the marker and should be deferred by
+// the caller.
+//
+// Parameters:
+//   - targetRoot: The root directory for the target
+//
+// Returns:
+//   - func(): A function that releases the lock
+//   - error: Any error encountered while writing the lock file
+func AcquireBuildLock(targetRoot string) (func(), error) {
+	release, err := acquireLock(filepath.Join(targetRoot, BuildLockFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write build lock: %w", err)
+	}
+	return release, nil
+}
+
+// IsBuildLocked reports whether a build of the target is currently in
+// progress. A lock left behind by a build whose process no longer exists,
+// e.g. after a crash, is ignored.
+//
+// Parameters:
+//   - targetRoot: The root directory for the target
+//
+// Returns:
+//   - bool: True if a live process holds the build lock, false otherwise
+func IsBuildLocked(targetRoot string) bool {
+	return lockHeld(filepath.Join(targetRoot, BuildLockFileName))
+}
@@ -0,0 +1,28 @@
+//go:build !unix && !windows
+
+package targets
+
+import "os"
+
+// lockFile is not supported on this platform and always succeeds, so locks
+// do not keep processes apart
+//
+// Parameters:
+//   - file: The open lock file
+//
+// Returns:
+//   - error: Always nil
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile is not supported on this platform and always succeeds
+//
+// Parameters:
+//   - file: The locked lock file
+//
+// Returns:
+//   - error: Always nil
+func unlockFile(file *os.File) error {
+	return nil
+}
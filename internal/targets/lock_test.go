@@ -0,0 +1,116 @@
+package targets
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBuildLock(t *testing.T) {
+	targetRoot := t.TempDir()
+	release, err := AcquireBuildLock(targetRoot)
+	if err != nil {
+		t.Fatalf("AcquireBuildLock() error = %v", err)
+	}
+	if !IsBuildLocked(targetRoot) {
+		t.Error("IsBuildLocked() = false while the lock is held")
+	}
+	if _, err := tryLock(filepath.Join(targetRoot, BuildLockFileName)); err != errLockHeld {
+		t.Errorf("tryLock() of a held build lock error = %v, want %v", err, errLockHeld)
+	}
+	release()
+	release()
+	if IsBuildLocked(targetRoot) {
+		t.Error("IsBuildLocked() = true after the lock was released")
+	}
+}
+
+func TestBuildLockLeftByDeadProcess(t *testing.T) {
+	targetRoot := t.TempDir()
+	lockPath := filepath.Join(targetRoot, BuildLockFileName)
+	// A lock file nobody has locked, as after a build that crashed
+	if err := os.WriteFile(lockPath, []byte("1073741824"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if IsBuildLocked(targetRoot) {
+		t.Error("IsBuildLocked() = true for the lock of a process that no longer exists")
+	}
+	release, err := AcquireBuildLock(targetRoot)
+	if err != nil {
+		t.Fatalf("AcquireBuildLock() over a stale lock error = %v", err)
+	}
+	defer release()
+	if !IsBuildLocked(targetRoot) {
+		t.Error("IsBuildLocked() = false after the stale lock was replaced")
+	}
+}
+
+func TestStaleLockTakenOverByOneHolder(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), BuildLockFileName)
+	if err := os.WriteFile(lockPath, []byte("1073741824"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	originalInterval := lockPollInterval
+	lockPollInterval = time.Millisecond
+	defer func() { lockPollInterval = originalInterval }()
+
+	var holders, maxHolders, acquired atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				release, err := acquireLock(lockPath)
+				if err != nil {
+					t.Errorf("acquireLock() error = %v", err)
+					return
+				}
+				n := holders.Add(1)
+				for {
+					m := maxHolders.Load()
+					if n <= m || maxHolders.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				acquired.Add(1)
+				time.Sleep(100 * time.Microsecond)
+				holders.Add(-1)
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+	if got := maxHolders.Load(); got != 1 {
+		t.Errorf("%d holders had the lock at once, want 1", got)
+	}
+	if got := acquired.Load(); got != 160 {
+		t.Errorf("the lock was acquired %d times, want 160", got)
+	}
+}
+
+func TestLockFileHoldsPID(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), BuildLockFileName)
+	if err := os.WriteFile(lockPath, []byte("1073741824"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	release, err := tryLock(lockPath)
+	if err != nil {
+		t.Fatalf("tryLock() error = %v", err)
+	}
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.Itoa(os.Getpid()); string(data) != want {
+		t.Errorf("lock file content = %q, want %q", data, want)
+	}
+	release()
+	if _, err := os.Stat(lockPath); !os.IsNotExist(err) {
+		t.Errorf("lock file still exists after release: %v", err)
+	}
+}
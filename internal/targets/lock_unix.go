@@ -0,0 +1,35 @@
+//go:build unix
+
+package targets
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on file without waiting
+//
+// Parameters:
+//   - file: The open lock file
+//
+// Returns:
+//   - error: errLockHeld if another open file holds the lock, or any other error encountered while locking
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLockHeld
+	}
+	return err
+}
+
+// unlockFile releases the lock lockFile took on file
+//
+// Parameters:
+//   - file: The locked lock file
+//
+// Returns:
+//   - error: Any error encountered while unlocking
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
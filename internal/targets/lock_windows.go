@@ -0,0 +1,37 @@
+//go:build windows
+
+package targets
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes an exclusive lock on file without waiting
+//
+// Parameters:
+//   - file: The open lock file
+//
+// Returns:
+//   - error: errLockHeld if another open file holds the lock, or any other error encountered while locking
+func lockFile(file *os.File) error {
+	err := windows.LockFileEx(windows.Handle(file.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY,
+		0, 1, 0, &windows.Overlapped{})
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLockHeld
+	}
+	return err
+}
+
+// unlockFile releases the lock lockFile took on file
+//
+// Parameters:
+//   - file: The locked lock file
+//
+// Returns:
+//   - error: Any error encountered while unlocking
+func unlockFile(file *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, &windows.Overlapped{})
+}
@@ -0,0 +1,57 @@
+package targets
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+)
+
+// Pin marks the build in commitDir as pinned, so that cleanup never removes
+// it. The modification time of the commit directory, which cleanup orders
+// builds by, is left unchanged.
+//
+// Parameters:
+//   - commitDir: The commit directory of the build
+//
+// Returns:
+//   - bool: True if the build was pinned, false if it already was
+//   - error: Any error encountered while writing the marker file
+func Pin(commitDir string) (bool, error) {
+	if dirutils.IsPinned(commitDir) {
+		return false, nil
+	}
+	info, err := os.Stat(commitDir)
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(filepath.Join(commitDir, dirutils.PinnedFileName), nil, 0644); err != nil {
+		return false, err
+	}
+	_ = os.Chtimes(commitDir, info.ModTime(), info.ModTime())
+	return true, nil
+}
+
+// Unpin removes the pinned marker of the build in commitDir, leaving the
+// modification time of the commit directory unchanged
+//
+// Parameters:
+//   - commitDir: The commit directory of the build
+//
+// Returns:
+//   - bool: True if the build was unpinned, false if it was not pinned
+//   - error: Any error encountered while removing the marker file
+func Unpin(commitDir string) (bool, error) {
+	if !dirutils.IsPinned(commitDir) {
+		return false, nil
+	}
+	info, err := os.Stat(commitDir)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Remove(filepath.Join(commitDir, dirutils.PinnedFileName)); err != nil {
+		return false, err
+	}
+	_ = os.Chtimes(commitDir, info.ModTime(), info.ModTime())
+	return true, nil
+}
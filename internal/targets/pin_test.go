@@ -0,0 +1,48 @@
+package targets
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+)
+
+func TestPin(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(dir, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name       string
+		do         func(string) (bool, error)
+		wantChange bool
+		wantPinned bool
+	}{
+		{name: "pin", do: Pin, wantChange: true, wantPinned: true},
+		{name: "pin again", do: Pin, wantChange: false, wantPinned: true},
+		{name: "unpin", do: Unpin, wantChange: true, wantPinned: false},
+		{name: "unpin again", do: Unpin, wantChange: false, wantPinned: false},
+	}
+	for _, step := range steps {
+		changed, err := step.do(dir)
+		if err != nil {
+			t.Fatalf("%s: error = %v", step.name, err)
+		}
+		if changed != step.wantChange {
+			t.Errorf("%s: changed = %v, want %v", step.name, changed, step.wantChange)
+		}
+		if pinned := dirutils.IsPinned(dir); pinned != step.wantPinned {
+			t.Errorf("%s: pinned = %v, want %v", step.name, pinned, step.wantPinned)
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(modTime) {
+			t.Errorf("%s: changed the commit directory modification time to %v, want %v", step.name, info.ModTime(), modTime)
+		}
+	}
+}
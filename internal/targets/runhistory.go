@@ -0,0 +1,98 @@
+package targets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RunHistoryFileName is the name of the file in a target root directory that
+// records when each build of the target was last run
+const RunHistoryFileName = ".run-history.json"
+
+// runHistoryLockFileName is the name of the lock file in a target root
+// directory held while the run history is updated
+const runHistoryLockFileName = ".run-history.lock"
+
+// RunRecord describes the last run of a build
+//
+// Fields:
+//   - LastRun: When the build was last run
+//   - Stdout: The file the program's standard output was captured to, if any
+//   - Stderr: The file the program's standard error was captured to, if any
+type RunRecord struct {
+	LastRun time.Time `json:"last_run"`
+	Stdout  string    `json:"stdout,omitempty"`
+	Stderr  string    `json:"stderr,omitempty"`
+}
+
+// ReadRunHistory reads the last run of each build of a target
+//
+// Parameters:
+//   - targetRoot: The root directory for the target
+//
+// Returns:
+//   - map[string]RunRecord: The last run keyed by commit directory name, empty if nothing was recorded
+//   - error: Any error encountered while reading or parsing the history
+func ReadRunHistory(targetRoot string) (map[string]RunRecord, error) {
+	history := make(map[string]RunRecord)
+	data, err := os.ReadFile(filepath.Join(targetRoot, RunHistoryFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return history, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &history); err != nil {
+		return nil, fmt.Errorf("failed to parse run history: %w", err)
+	}
+	return history, nil
+}
+
+// RecordRun records the run of a build of a target, replacing its previous
+// record. The history is updated under a lock, so that runs of the same
+// target finishing at the same time do not lose each other's records.
+//
+// Parameters:
+//   - targetRoot: The root directory for the target
+//   - commitDirName: The name of the commit directory of the build that was run
+//   - record: The details of the run
+//
+// Returns:
+//   - error: Any error encountered while updating the history
+func RecordRun(targetRoot, commitDirName string, record RunRecord) error {
+	release, err := acquireLock(filepath.Join(targetRoot, runHistoryLockFileName))
+	if err != nil {
+		return fmt.Errorf("failed to lock run history: %w", err)
+	}
+	defer release()
+
+	history, err := ReadRunHistory(targetRoot)
+	if err != nil {
+		// A corrupt history only loses retention hints, so start over
+		history = make(map[string]RunRecord)
+	}
+	history[commitDirName] = record
+
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run history: %w", err)
+	}
+	// Write through a temporary file so concurrent runs never see a partial file
+	tmp, err := os.CreateTemp(targetRoot, RunHistoryFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write run history: %w", err)
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write run history: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write run history: %w", err)
+	}
+	return os.Rename(tmp.Name(), filepath.Join(targetRoot, RunHistoryFileName))
+}
@@ -0,0 +1,85 @@
+package targets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunHistory(t *testing.T) {
+	dir := t.TempDir()
+
+	history, err := ReadRunHistory(dir)
+	if err != nil {
+		t.Fatalf("ReadRunHistory() without history error = %v", err)
+	}
+	if len(history) != 0 {
+		t.Errorf("ReadRunHistory() without history = %v, want empty", history)
+	}
+
+	first := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	second := first.Add(time.Hour)
+	if err := RecordRun(dir, "abc1234", RunRecord{LastRun: first}); err != nil {
+		t.Fatalf("RecordRun() error = %v", err)
+	}
+	if err := RecordRun(dir, "def5678", RunRecord{LastRun: first}); err != nil {
+		t.Fatalf("RecordRun() error = %v", err)
+	}
+	if err := RecordRun(dir, "abc1234", RunRecord{LastRun: second, Stdout: "out.log"}); err != nil {
+		t.Fatalf("RecordRun() error = %v", err)
+	}
+
+	history, err = ReadRunHistory(dir)
+	if err != nil {
+		t.Fatalf("ReadRunHistory() error = %v", err)
+	}
+	if !history["abc1234"].LastRun.Equal(second) || history["abc1234"].Stdout != "out.log" || !history["def5678"].LastRun.Equal(first) || len(history) != 2 {
+		t.Errorf("ReadRunHistory() = %v", history)
+	}
+}
+
+func TestRecordRunReplacesCorruptHistory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, RunHistoryFileName), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadRunHistory(dir); err == nil {
+		t.Errorf("ReadRunHistory() of corrupt history should fail")
+	}
+	if err := RecordRun(dir, "abc1234", RunRecord{LastRun: time.Now()}); err != nil {
+		t.Fatalf("RecordRun() error = %v", err)
+	}
+	history, err := ReadRunHistory(dir)
+	if err != nil || len(history) != 1 {
+		t.Errorf("ReadRunHistory() = %v, %v", history, err)
+	}
+}
+
+func TestRecordRunConcurrently(t *testing.T) {
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := RecordRun(dir, fmt.Sprintf("build%d", i), RunRecord{LastRun: time.Now()}); err != nil {
+				t.Errorf("RecordRun() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	history, err := ReadRunHistory(dir)
+	if err != nil {
+		t.Fatalf("ReadRunHistory() error = %v", err)
+	}
+	if len(history) != 10 {
+		t.Errorf("ReadRunHistory() has %d records, want 10", len(history))
+	}
+	if _, err := os.Stat(filepath.Join(dir, runHistoryLockFileName)); !os.IsNotExist(err) {
+		t.Errorf("run history lock left behind: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package targets
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+// RunningDirName is the name of the directory in a commit directory holding
+// one PID file for each process currently running the build
+const RunningDirName = ".running"
+
+// MarkRunning records that the process with the given PID is running the build
+// in commitDir. The returned release function removes the PID file and should
+// be deferred by the caller.
+//
+// Parameters:
+//   - commitDir: The commit directory of the build being run
+//   - pid: The PID of the process running the build
+//
+// Returns:
+//   - func(): A function that removes the PID file
+//   - error: Any error encountered while writing the PID file
+func MarkRunning(commitDir string, pid int) (func(), error) {
+	runningDir := filepath.Join(commitDir, RunningDirName)
+	if _, err := os.Stat(runningDir); os.IsNotExist(err) {
+		info, err := os.Stat(commitDir)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Mkdir(runningDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create running directory: %w", err)
+		}
+		// Cleanup orders builds by modification time, which running a
+		// build must not change
+		_ = os.Chtimes(commitDir, info.ModTime(), info.ModTime())
+	}
+	pidPath := filepath.Join(runningDir, strconv.Itoa(pid))
+	if err := os.WriteFile(pidPath, nil, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write PID file: %w", err)
+	}
+	return func() {
+		_ = os.Remove(pidPath)
+	}, nil
+}
+
+// IsRunning reports whether a live process is running the build in commitDir.
+// PID files left behind by processes that no longer exist are ignored.
+//
+// Parameters:
+//   - commitDir: The commit directory of the build
+//
+// Returns:
+//   - bool: True if at least one recorded process is still alive
+func IsRunning(commitDir string) bool {
+	entries, err := os.ReadDir(filepath.Join(commitDir, RunningDirName))
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if processAlive(pid) {
+			return true
+		}
+	}
+	return false
+}
+
+// processAlive reports whether a process with the given PID exists
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// FindProcess only succeeds for existing processes on Windows, which
+	// does not support probing with signal 0
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	// EPERM means the process exists but belongs to another user
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
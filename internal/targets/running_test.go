@@ -0,0 +1,42 @@
+package targets
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+)
+
+func TestMarkRunning(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process liveness is not probed on Windows")
+	}
+	dir := t.TempDir()
+	if IsRunning(dir) {
+		t.Errorf("IsRunning() without PID files = true, want false")
+	}
+
+	// A PID file left behind by an exited process does not count
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("cannot start a process: %v", err)
+	}
+	if _, err := MarkRunning(dir, exited.Process.Pid); err != nil {
+		t.Fatalf("MarkRunning() error = %v", err)
+	}
+	if IsRunning(dir) {
+		t.Errorf("IsRunning() with a stale PID file = true, want false")
+	}
+
+	release, err := MarkRunning(dir, os.Getpid())
+	if err != nil {
+		t.Fatalf("MarkRunning() error = %v", err)
+	}
+	if !IsRunning(dir) {
+		t.Errorf("IsRunning() while running = false, want true")
+	}
+	release()
+	if IsRunning(dir) {
+		t.Errorf("IsRunning() after release = true, want false")
+	}
+}
@@ -0,0 +1,255 @@
+package targets
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oota-sushikuitee/nigiri/pkg/fsutils"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+)
+
+// extractedMarkerName is the file recording the checksum of the source archive
+// that the src directory of a commit was extracted from
+const extractedMarkerName = ".extracted"
+
+// sourceExtracted reports whether the src directory of commitDir was fully
+// extracted from the archive at srcArchive as it is now
+//
+// Parameters:
+//   - srcArchive: The path to the source archive
+//   - commitDir: The commit directory containing src and the marker
+//
+// Returns:
+//   - bool: True if src matches the archive and need not be extracted again
+//   - error: Any error encountered while hashing the archive
+func sourceExtracted(srcArchive, commitDir string) (bool, error) {
+	marker, err := os.ReadFile(filepath.Join(commitDir, extractedMarkerName))
+	if err != nil {
+		return false, nil
+	}
+	if _, err := os.Stat(filepath.Join(commitDir, "src")); err != nil {
+		return false, nil
+	}
+	sum, err := fsutils.SHA256File(srcArchive)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(marker)) == sum, nil
+}
+
+// SourceNeedsExtraction reports whether resolving the binary of the build in
+// commitDir extracts its source archive, because the commit directory has no
+// bin file and src was not extracted from the archive as it is now
+//
+// Parameters:
+//   - commitDir: The commit directory of the build
+//
+// Returns:
+//   - bool: True if ResolveBinary extracts source.tar.gz, false otherwise
+func SourceNeedsExtraction(commitDir string) bool {
+	if _, err := os.Stat(filepath.Join(commitDir, "bin")); !os.IsNotExist(err) {
+		return false
+	}
+	srcArchive := filepath.Join(commitDir, "source.tar.gz")
+	if _, err := os.Stat(srcArchive); err != nil {
+		return false
+	}
+	upToDate, err := sourceExtracted(srcArchive, commitDir)
+	return err == nil && !upToDate
+}
+
+// extractSource replaces the src directory of commitDir with the contents of
+// srcArchive. The marker is written last, so an interrupted extraction is
+// detected and redone on the next run.
+//
+// Parameters:
+//   - srcArchive: The path to the source archive
+//   - commitDir: The commit directory to extract into
+//
+// Returns:
+//   - error: Any error encountered during extraction
+func extractSource(srcArchive, commitDir string) error {
+	markerPath := filepath.Join(commitDir, extractedMarkerName)
+	srcDir := filepath.Join(commitDir, "src")
+	if err := os.Remove(markerPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove extraction marker: %w", err)
+	}
+	if err := os.RemoveAll(srcDir); err != nil {
+		return fmt.Errorf("failed to remove stale source directory: %w", err)
+	}
+	sum, err := fsutils.SHA256File(srcArchive)
+	if err != nil {
+		return fmt.Errorf("failed to hash archive: %w", err)
+	}
+	// The archive is rooted at the source directory itself
+	if err := os.MkdirAll(srcDir, 0755); err != nil {
+		return fmt.Errorf("failed to create source directory: %w", err)
+	}
+	if err := ExtractTarGz(srcArchive, srcDir); err != nil {
+		return err
+	}
+	if err := os.WriteFile(markerPath, []byte(sum+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write extraction marker: %w", err)
+	}
+	return nil
+}
+
+// maxFileSizeForExtract is the maximum file size allowed when extracting archives (1GB)
+const maxFileSizeForExtract = 1 << 30
+
+// ExtractTarGz extracts a tar.gz archive into a directory. Entries, symlinks
+// and hard links that would escape the directory are rejected.
+//
+// Parameters:
+//   - tarGzPath: The path to the archive
+//   - destDir: The directory to extract into
+//
+// Returns:
+//   - error: Any error encountered during extraction
+func ExtractTarGz(tarGzPath, destDir string) error {
+	// Open the tar.gz file
+	file, err := os.Open(tarGzPath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Warnf("failed to close archive file: %v", err)
+		}
+	}()
+
+	// Create gzip reader
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to create gzip reader: %w", err)
+	}
+	defer func() {
+		if err := gzipReader.Close(); err != nil {
+			logger.Warnf("failed to close gzip reader: %v", err)
+		}
+	}()
+
+	// Create tar reader
+	tarReader := tar.NewReader(gzipReader)
+
+	// Extract each file
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("tar reading error: %w", err)
+		}
+
+		// Resolve the target path and ensure it stays within destDir. Using
+		// filepath.Rel-based containment avoids the separator-unsafe prefix
+		// pitfall (e.g. "/root-evil" is not contained by "/root").
+		filePath := filepath.Join(destDir, filepath.Clean(header.Name))
+		if !isWithinDir(destDir, filePath) {
+			return fmt.Errorf("attempted path traversal in archive: %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filePath, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+		case tar.TypeSymlink:
+			if err := extractSymlink(destDir, filePath, header.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			// Hard link: the target is relative to the extraction root.
+			target := filepath.Join(destDir, filepath.Clean(header.Linkname))
+			if !isWithinDir(destDir, target) {
+				return fmt.Errorf("hard link target escapes extraction root: %s -> %s", header.Name, header.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+				return fmt.Errorf("failed to create parent directory: %w", err)
+			}
+			if err := os.Link(target, filePath); err != nil {
+				return fmt.Errorf("failed to create hard link: %w", err)
+			}
+		default:
+			// Make sure parent directory exists
+			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+				return fmt.Errorf("failed to create parent directory: %w", err)
+			}
+			// Extract file using helper function for proper resource management
+			if err := extractFileFromTar(tarReader, filePath, header.Mode); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isWithinDir reports whether target is contained within root (or equal to it),
+// using path-component-aware comparison rather than a raw string prefix.
+func isWithinDir(root, target string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(target))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// extractSymlink writes a symlink at linkPath pointing to linkname, rejecting
+// any link whose resolved target would escape the extraction root.
+func extractSymlink(destDir, linkPath, linkname string) error {
+	var resolved string
+	if filepath.IsAbs(linkname) {
+		resolved = filepath.Clean(linkname)
+	} else {
+		resolved = filepath.Clean(filepath.Join(filepath.Dir(linkPath), linkname))
+	}
+	if !isWithinDir(destDir, resolved) {
+		return fmt.Errorf("symlink target escapes extraction root: %s -> %s", linkPath, linkname)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	// Remove any pre-existing entry so a stale target cannot be followed.
+	if err := os.Remove(linkPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace existing path: %w", err)
+	}
+	if err := os.Symlink(linkname, linkPath); err != nil {
+		return fmt.Errorf("failed to create symlink: %w", err)
+	}
+	return nil
+}
+
+// extractFileFromTar extracts a single file from the tar reader with proper resource cleanup
+// and size limits to prevent resource exhaustion
+func extractFileFromTar(tarReader *tar.Reader, filePath string, mode int64) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Warnf("failed to close file %s: %v", filePath, err)
+		}
+	}()
+
+	// Use LimitReader to prevent extracting extremely large files
+	limitedReader := io.LimitReader(tarReader, maxFileSizeForExtract)
+	if _, err := io.Copy(file, limitedReader); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	// Set file permissions
+	if err := os.Chmod(filePath, os.FileMode(mode)); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+
+	return nil
+}
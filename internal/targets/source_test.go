@@ -0,0 +1,20 @@
+package targets
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestExtractTarGz_PrefixSiblingNotEscaped guards against the separator-unsafe
+// prefix check: a destination like ".../root" must not be considered to contain
+// a sibling like ".../root-evil".
+func TestIsWithinDir_PrefixSibling(t *testing.T) {
+	root := filepath.Join("tmp", "root")
+	sibling := filepath.Join("tmp", "root-evil", "x")
+	if isWithinDir(root, sibling) {
+		t.Errorf("isWithinDir(%q, %q) = true, want false", root, sibling)
+	}
+	if !isWithinDir(root, filepath.Join(root, "sub", "file")) {
+		t.Errorf("isWithinDir did not contain a genuine child path")
+	}
+}
@@ -133,16 +133,16 @@ func TestGetTargetHeadDir(t *testing.T) {
 			Commits: commits.Commits{
 				Commits: []commits.Commit{
 					{
-						Hash:      "commit1",
-						ShortHash: "commit1",
+						Hash:      "c0ffee1",
+						ShortHash: "c0ffee1",
 					},
 					{
-						Hash:      "commit2",
-						ShortHash: "commit2",
+						Hash:      "c0ffee2",
+						ShortHash: "c0ffee2",
 					},
 				},
 			}}
-		testDir := filepath.Join(tmpNigiriRoot, "test", "commit2")
+		testDir := filepath.Join(tmpNigiriRoot, "test", "c0ffee2")
 		os.MkdirAll(testDir, 0755)
 		defer os.RemoveAll(filepath.Join(tmpNigiriRoot, "test"))
 
@@ -0,0 +1,271 @@
+package commands
+
+import (
+	"context"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/commits"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
+	"github.com/spf13/cobra"
+)
+
+// bisectCommand represents the structure for the bisect command
+type bisectCommand struct {
+	cmd *cobra.Command
+	// runner runs the builds and the test command
+	runner exec.Runner
+	// good is the last known good revision
+	good string
+	// bad is the first known bad revision
+	bad string
+	// test is the shell command deciding whether a build is good or bad
+	test string
+	// useToken enables GitHub token authentication
+	useToken bool
+	// tokens resolves the GitHub token once for the clone and every build
+	tokens *vcsutils.TokenCache
+	// verbose enables verbose output for clones and builds
+	verbose bool
+	// timeout is the build timeout (0 = no timeout)
+	timeout time.Duration
+}
+
+// newBisectCommand creates a new bisect command instance which finds the first
+// commit of a target that fails a user supplied test, building each candidate
+// commit the same way the build command does.
+//
+// Returns:
+//   - *bisectCommand: A configured bisect command instance
+func newBisectCommand() *bisectCommand {
+	c := &bisectCommand{runner: exec.NewOSRunner(), tokens: &vcsutils.TokenCache{}}
+	cmd := &cobra.Command{
+		Use:   "bisect target --good <commit> --bad <commit> --test <command>",
+		Short: "Find the first bad commit of a target",
+		Long: `Find the first commit between a good and a bad commit that fails a test.
+The commits after --good up to --bad are bisected along the first-parent history.
+Each candidate is built like 'nigiri build <target> <commit>' and the --test command
+is run through the shell of the target with NIGIRI_BIN set to the built binary and
+NIGIRI_COMMIT set to the commit hash. A zero exit status marks the commit good; any
+other status, or a failed build command, marks it bad. A build that fails before its
+build command runs aborts the bisection. Builds are kept, so repeated bisections
+reuse them.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return cmd.Help()
+			}
+			return c.executeBisect(args[0])
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// Offer tab completion for targets if no arguments provided yet
+			if len(args) == 0 {
+				return getConfiguredTargets(toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&c.good, "good", "", "Last known good commit")
+	flags.StringVar(&c.bad, "bad", "", "Known bad commit")
+	flags.StringVar(&c.test, "test", "", "Shell command that exits 0 for a good build")
+	flags.BoolVarP(&c.useToken, "use-token", "t", false, "Use GitHub token for authentication (required for private repositories)")
+	flags.BoolVarP(&c.verbose, "verbose", "v", false, "Enable verbose output")
+	c.timeout = defaultBuildTimeout
+	flags.Var((*timeoutValue)(&c.timeout), "timeout", "Build timeout such as 10m or 1h30m; a bare number is minutes (0 = no timeout)")
+	_ = cmd.MarkFlagRequired("good")
+	_ = cmd.MarkFlagRequired("bad")
+	_ = cmd.MarkFlagRequired("test")
+
+	c.cmd = cmd
+	return c
+}
+
+// executeBisect resolves the commit range of the target and bisects it
+//
+// Parameters:
+//   - target: The name of the target to bisect as specified in the config file
+//
+// Returns:
+//   - error: Any error encountered during the bisection
+func (c *bisectCommand) executeBisect(target string) error {
+	cm := newConfigManager()
+	if err := cm.LoadCfgFile(); err != nil {
+		return logger.CreateErrorf("failed to load configuration: %w", err)
+	}
+	targetCfg, exists := cm.Config.Targets[target]
+	if !exists {
+		return logger.CreateErrorf("target '%s' not found in configuration", target)
+	}
+
+	// A full clone is needed to walk the history between the two commits
+	cloneDir, err := os.MkdirTemp("", "nigiri-bisect-")
+	if err != nil {
+		return logger.CreateErrorf("failed to create temporary directory: %w", err)
+	}
+	defer func() {
+		if err := os.RemoveAll(cloneDir); err != nil {
+			logger.Warnf("Failed to remove temporary clone: %v", err)
+		}
+	}()
+
+	git := vcsutils.Git{Source: targetCfg.Sources, Tokens: c.tokens}
+	authMethod := vcsutils.AuthNone
+	if c.useToken {
+		authMethod = vcsutils.AuthToken
+	}
+	c.cmd.Printf("Cloning %s to resolve the commit range...\n", vcsutils.ScrubCredentials(targetCfg.Sources))
+	if err := git.Clone(cloneDir, vcsutils.Options{Depth: 0, Verbose: c.verbose, AuthMethod: authMethod}); err != nil {
+		return logger.CreateErrorf("failed to clone repository: %w", err)
+	}
+	candidates, err := git.CommitRange(cloneDir, c.good, c.bad)
+	if err != nil {
+		return logger.CreateErrorf("failed to resolve commit range: %w", err)
+	}
+	c.cmd.Printf("Bisecting %d commits (about %d steps)\n", len(candidates), bisectSteps(len(candidates)))
+
+	firstBad, err := findFirstBad(candidates, func(hash string) (bool, error) {
+		return c.isBad(target, hash, targetCfg.ShortHashLength(), targetCfg.Shell)
+	})
+	if err != nil {
+		return err
+	}
+
+	c.cmd.Printf("\nFirst bad commit: %s\n", firstBad)
+	return nil
+}
+
+// isBad builds the target at hash, or reuses an existing build, and runs the
+// test command against it
+//
+// Parameters:
+//   - target: The name of the target
+//   - hash: The full commit hash to test
+//   - shortHashLength: The short hash length of the target
+//   - shell: The shell of the target the test command runs through
+//
+// Returns:
+//   - bool: True if the commit is bad, false if it is good
+//   - error: Any error that prevents deciding, such as a failed clone
+func (c *bisectCommand) isBad(target, hash string, shortHashLength int, shell string) (bool, error) {
+	commit := commits.Commit{Hash: hash}
+	if err := commit.CalculateShortHashLength(shortHashLength); err != nil {
+		return false, logger.CreateErrorf("failed to calculate short hash: %w", err)
+	}
+	c.cmd.Printf("\nTesting commit %s\n", commit.ShortHash)
+
+	b := newBuildCommand()
+	b.runner = c.runner
+	b.commit = hash
+	b.useToken = c.useToken
+	b.tokens = c.tokens
+	b.verbose = c.verbose
+	if c.cmd.Flags().Changed("timeout") {
+		// Only an explicit timeout overrides the target's build-timeout
+		_ = b.cmd.Flags().Set("timeout", c.timeout.String())
+	}
+	b.cmd.SetOut(c.cmd.OutOrStdout())
+	buildErr := b.executeBuild(target)
+	// The build may have lengthened the short hash to keep it unique
+	commitDir := b.builtDir
+	if commitDir == "" {
+		commitDir = filepath.Join(nigiriRoot, target, commit.ShortHash)
+	}
+
+	// A recorded build failure is a bad commit; anything else that stops
+	// the build from completing aborts the bisection
+	if buildCommandFailed(commitDir, buildErr) {
+		c.cmd.Printf("Commit %s is bad (build failed)\n", commit.ShortHash)
+		return true, nil
+	}
+	if buildErr != nil {
+		return false, logger.CreateErrorf("failed to build commit %s: %w", commit.ShortHash, buildErr)
+	}
+
+	_, _, err := c.runner.Run(context.Background(), shellCommand(shell, runtime.GOOS, c.test), exec.Options{
+		Stdout: c.cmd.OutOrStdout(),
+		Stderr: c.cmd.ErrOrStderr(),
+		Env: append(os.Environ(),
+			"NIGIRI_BIN="+filepath.Join(commitDir, "bin"),
+			"NIGIRI_COMMIT="+hash,
+		),
+	})
+	if _, exited := exec.ExitCode(err); err != nil && !exited {
+		return false, logger.CreateErrorf("failed to run test command: %w", err)
+	}
+	bad := err != nil
+	if bad {
+		c.cmd.Printf("Commit %s is bad\n", commit.ShortHash)
+	} else {
+		c.cmd.Printf("Commit %s is good\n", commit.ShortHash)
+	}
+	return bad, nil
+}
+
+// findFirstBad bisects candidates, ordered oldest first, for the first bad
+// commit. The commit before candidates[0] is assumed good and the last
+// candidate is assumed bad, so it is never tested.
+//
+// Parameters:
+//   - candidates: The commits to bisect, oldest first
+//   - isBad: Reports whether a commit is bad
+//
+// Returns:
+//   - string: The first bad commit
+//   - error: Any error returned by isBad, or an error if candidates is empty
+func findFirstBad(candidates []string, isBad func(string) (bool, error)) (string, error) {
+	if len(candidates) == 0 {
+		return "", logger.CreateErrorf("no commits to bisect")
+	}
+	lo, hi := 0, len(candidates)-1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		bad, err := isBad(candidates[mid])
+		if err != nil {
+			return "", err
+		}
+		if bad {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	return candidates[hi], nil
+}
+
+// bisectSteps returns the maximum number of commits findFirstBad tests for n candidates
+func bisectSteps(n int) int {
+	if n <= 1 {
+		return 0
+	}
+	return bits.Len(uint(n - 1))
+}
+
+// buildCommandFailed reports whether the build in commitDir failed in its
+// build command rather than before it ran. Builds made without metadata are
+// judged by their build log, which is only created right before the build
+// command runs.
+//
+// Parameters:
+//   - commitDir: The commit directory of the build
+//   - buildErr: The error returned by the build
+//
+// Returns:
+//   - bool: True if the build command ran and the build failed
+func buildCommandFailed(commitDir string, buildErr error) bool {
+	info, err := targets.ReadBuildInfo(commitDir)
+	if err == nil {
+		return info.BuildCommandFailed()
+	}
+	if buildErr == nil || !os.IsNotExist(err) {
+		return false
+	}
+	_, statErr := os.Stat(filepath.Join(commitDir, "logs", "build.log"))
+	return statErr == nil
+}
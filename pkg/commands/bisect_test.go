@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindFirstBad(t *testing.T) {
+	t.Parallel()
+	candidates := []string{"c1", "c2", "c3", "c4", "c5", "c6", "c7", "c8"}
+	for firstBad := range candidates {
+		t.Run(fmt.Sprintf("first bad at %d", firstBad), func(t *testing.T) {
+			t.Parallel()
+			var tested []string
+			got, err := findFirstBad(candidates, func(hash string) (bool, error) {
+				tested = append(tested, hash)
+				for i, c := range candidates {
+					if c == hash {
+						return i >= firstBad, nil
+					}
+				}
+				return false, fmt.Errorf("unknown commit %s", hash)
+			})
+			require.NoError(t, err)
+			assert.Equal(t, candidates[firstBad], got)
+			assert.LessOrEqual(t, len(tested), bisectSteps(len(candidates)))
+			assert.NotContains(t, tested, candidates[len(candidates)-1], "the known bad commit should not be tested")
+		})
+	}
+}
+
+func TestFindFirstBadSingleCandidate(t *testing.T) {
+	got, err := findFirstBad([]string{"c1"}, func(string) (bool, error) {
+		t.Fatal("a single candidate should not be tested")
+		return false, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "c1", got)
+}
+
+func TestFindFirstBadErrors(t *testing.T) {
+	_, err := findFirstBad(nil, func(string) (bool, error) { return false, nil })
+	assert.Error(t, err)
+
+	testErr := errors.New("clone failed")
+	_, err = findFirstBad([]string{"c1", "c2", "c3"}, func(string) (bool, error) { return false, testErr })
+	assert.ErrorIs(t, err, testErr)
+}
+
+func TestBisectSteps(t *testing.T) {
+	t.Parallel()
+	tests := []struct{ n, want int }{{0, 0}, {1, 0}, {2, 1}, {3, 2}, {4, 2}, {5, 3}, {8, 3}, {9, 4}}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, bisectSteps(tt.n), "bisectSteps(%d)", tt.n)
+	}
+}
+
+func TestBuildCommandFailed(t *testing.T) {
+	buildErr := errors.New("build failed")
+	tests := []struct {
+		name     string
+		status   string
+		stage    string
+		buildLog bool
+		buildErr error
+		want     bool
+	}{
+		{name: "recorded failure", status: targets.BuildStatusFailed, buildErr: buildErr, want: true},
+		{name: "recorded build command failure", status: targets.BuildStatusFailed, stage: targets.BuildStageBuild, buildErr: buildErr, want: true},
+		{name: "recorded setup failure", status: targets.BuildStatusFailed, stage: targets.BuildStageSetup, buildErr: buildErr},
+		{name: "recorded success", status: targets.BuildStatusSuccess},
+		{name: "no metadata, build command ran", buildLog: true, buildErr: buildErr, want: true},
+		{name: "no metadata, failed before building", buildErr: buildErr},
+		{name: "no metadata, succeeded", buildLog: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commitDir := t.TempDir()
+			if tt.status != "" {
+				require.NoError(t, targets.WriteBuildInfo(commitDir, &targets.BuildInfo{Status: tt.status, FailedStage: tt.stage}))
+			}
+			if tt.buildLog {
+				require.NoError(t, os.MkdirAll(filepath.Join(commitDir, "logs"), 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(commitDir, "logs", "build.log"), nil, 0644))
+			}
+			assert.Equal(t, tt.want, buildCommandFailed(commitDir, tt.buildErr))
+		})
+	}
+}
+
+func TestBisectRunsTestThroughTargetShell(t *testing.T) {
+	useTestNigiriRoot(t)
+	repoDir, hash := createTestSourceRepo(t)
+	useTestBuildConfig(t, repoDir, "make app", "shell: bash -eu -c")
+
+	fake := &exec.Fake{Handler: func(call exec.Call) ([]byte, []byte, error) {
+		if call.Argv[len(call.Argv)-1] == "make app" {
+			binDir := filepath.Join(call.Opts.Dir, "bin")
+			require.NoError(t, os.MkdirAll(binDir, 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(binDir, "app"), []byte("app"), 0755))
+		}
+		return nil, nil, nil
+	}}
+	c := newBisectCommand()
+	c.runner = fake
+	c.test = "./check"
+	c.cmd.SetOut(&bytes.Buffer{})
+
+	bad, err := c.isBad("tool", hash, 7, "bash -eu -c")
+	require.NoError(t, err)
+	assert.False(t, bad)
+	calls := fake.Calls()
+	require.NotEmpty(t, calls)
+	assert.Equal(t, []string{"bash", "-eu", "-c", "./check"}, calls[len(calls)-1].Argv)
+}
+
+func TestBisectAbortsOnSetupFailure(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	missing := filepath.Join(t.TempDir(), "missing")
+	useTestBuildConfig(t, missing, "make app", "")
+	hash := "0123456789abcdef0123456789abcdef01234567"
+
+	fake := &exec.Fake{}
+	c := newBisectCommand()
+	c.runner = fake
+	c.test = "true"
+	c.cmd.SetOut(&bytes.Buffer{})
+
+	bad, err := c.isBad("tool", hash, 7, "")
+	require.Error(t, err)
+	assert.False(t, bad)
+	assert.Empty(t, fake.Calls())
+	info, err := targets.ReadBuildInfo(filepath.Join(root, "tool", hash[:7]))
+	require.NoError(t, err)
+	assert.Equal(t, targets.BuildStageSetup, info.FailedStage)
+}
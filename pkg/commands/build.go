@@ -2,18 +2,33 @@ package commands
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/internal/storage"
 	"github.com/oota-sushikuitee/nigiri/internal/targets"
 	"github.com/oota-sushikuitee/nigiri/pkg/commits"
+	"github.com/oota-sushikuitee/nigiri/pkg/config"
+	"github.com/oota-sushikuitee/nigiri/pkg/fsutils"
 	"github.com/oota-sushikuitee/nigiri/pkg/logger"
 	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
 	"github.com/spf13/cobra"
@@ -23,18 +38,127 @@ import (
 type buildCommand struct {
 	// cmd is the cobra command instance
 	cmd *cobra.Command
+	// runner runs the build command
+	runner exec.Runner
 	// commit specifies a particular commit to build
 	commit string
+	// fromPR is the number of the GitHub pull request to build (0 = none)
+	fromPR int
+	// tag is the tag whose commit is built (empty = none)
+	tag string
 	// depth is the git clone depth
 	depth int
+	// retries is the number of times transient clone failures are retried
+	retries int
 	// verbose enables verbose output
 	verbose bool
 	// forceBuild forces rebuilding even if already built
 	forceBuild bool
 	// useToken enables GitHub token authentication
 	useToken bool
-	// timeout is the build timeout in minutes (0 = no timeout)
-	timeout int
+	// tokens resolves the GitHub token once for all git operations of the
+	// invocation
+	tokens *vcsutils.TokenCache
+	// useSSH authenticates with an SSH key instead of a token
+	useSSH bool
+	// sshKey is the SSH private key used with --ssh
+	sshKey string
+	// lsRemote lists the remote branches and tags offered by completion
+	lsRemote remoteLister
+	// timeout is the build timeout (0 = no timeout)
+	timeout time.Duration
+	// recordDeps records hashes of dependency lock files in the build metadata
+	recordDeps bool
+	// failOnWarning fails the build when its output matches the warning pattern
+	failOnWarning bool
+	// prefixOutput prefixes verbose build output lines with the target name
+	prefixOutput bool
+	// forcePlatform builds even if the host OS is not in the target's platforms
+	forcePlatform bool
+	// refSpecs restricts the clone to the given refspecs
+	refSpecs []string
+	// allBranches fetches every branch when building the HEAD of a branch
+	// instead of only that branch
+	allBranches bool
+	// buildInTemp builds in a temporary directory and moves the artifacts
+	// into the commit directory only when the build succeeds
+	buildInTemp bool
+	// stdinConfig reads the configuration from stdin instead of the config file
+	stdinConfig bool
+	// reproducible sets the standard reproducible-build environment variables
+	reproducible bool
+	// reproducibleExclude lists reproducible-build variables not to set
+	reproducibleExclude []string
+	// noMetadata skips writing build metadata regardless of the target's setting
+	noMetadata bool
+	// labels are key=value pairs recorded in the build metadata
+	labels []string
+	// cas stores the source files in the content-addressed store instead of
+	// archiving them, sharing identical files between builds
+	cas bool
+	// printPlan prints what the build would do instead of building
+	printPlan bool
+	// output is the output format of --print-plan ("text" or "json")
+	output string
+	// warnings collects the warnings of the current build for the summary
+	// printed when it ends
+	warnings []string
+	// noCache clones directly from the source instead of through the clone
+	// cache
+	noCache bool
+	// keepClone leaves the cloned source, including .git, in the commit
+	// directory instead of archiving or removing it
+	keepClone bool
+	// archiveOnly stores the source as source.tar.gz without running the
+	// build command
+	archiveOnly bool
+	// pruneFailed removes everything but the logs and metadata from the
+	// commit directory when the build command fails
+	pruneFailed bool
+	// submodules clones the git submodules of the source
+	submodules bool
+	// pruneAfter is the number of builds of the target kept after a
+	// successful build, removing the oldest ones (0 keeps all)
+	pruneAfter int
+	// watch rebuilds a target with a local source whenever the source changes
+	watch bool
+	// workingTree is the local source directory whose working tree,
+	// uncommitted changes included, replaces the checkout (set by --watch)
+	workingTree string
+	// all builds every configured target
+	all bool
+	// jobs is the number of targets built at the same time
+	jobs int
+	// loadedConfig is the configuration loaded once for a multi-target
+	// build and shared by every target
+	loadedConfig *config.ConfigManager
+	// root is the directory the build is written to instead of the nigiri
+	// root, e.g. to rebuild a commit without touching its stored build
+	root string
+	// builtDir is the commit directory of the last build, once it is known
+	builtDir string
+	// upToDate reports that the last build was skipped because the commit
+	// had already been built
+	upToDate bool
+}
+
+// sshKeyPassphraseEnv is the environment variable holding the passphrase of
+// the SSH key used with --ssh, kept off the command line
+const sshKeyPassphraseEnv = "NIGIRI_SSH_KEY_PASSPHRASE"
+
+// defaultDepsFiles lists the dependency lock files detected by --record-deps
+// when a target does not configure its own deps-files
+var defaultDepsFiles = []string{
+	"go.mod",
+	"go.sum",
+	"Cargo.lock",
+	"package-lock.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"Gemfile.lock",
+	"poetry.lock",
+	"Pipfile.lock",
+	"composer.lock",
 }
 
 // newBuildCommand creates a new build command instance which is responsible for
@@ -44,22 +168,69 @@ type buildCommand struct {
 // Returns:
 //   - *buildCommand: A configured build command instance
 func newBuildCommand() *buildCommand {
-	c := &buildCommand{}
+	c := &buildCommand{runner: exec.NewOSRunner(), tokens: &vcsutils.TokenCache{}, lsRemote: lsRemote}
 	cmd := &cobra.Command{
-		Use:   "build target [commit]",
+		Use:   "build target [commit] | build target... | build --all",
 		Short: "Build a target",
 		Long: `Build a target from a source repository.
 If commit is not specified, the latest commit on the default branch will be built.
-If the target has already been built at the specified commit, the build will be skipped unless --force is specified.`,
+If the target has already been built at the specified commit, the build will be skipped unless --force is specified.
+With several targets, or --all for every configured target, the latest commits are
+built concurrently, at most --jobs at a time, and a summary is printed at the end.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) < 1 {
+			if len(args) < 1 && !c.all {
 				return cmd.Help()
 			}
+			if c.jobs < 1 {
+				return fmt.Errorf("--jobs must be at least 1")
+			}
+			if c.pruneAfter < 0 {
+				return fmt.Errorf("--prune-after must not be negative")
+			}
+			if c.watch && (c.all || len(args) > 1) {
+				return fmt.Errorf("--watch builds a single target and cannot be combined with a commit, more targets or --all")
+			}
+			if c.output != "text" && c.output != "json" {
+				return fmt.Errorf("unsupported output format '%s': expected text or json", c.output)
+			}
+			if c.fromPR < 0 {
+				return fmt.Errorf("invalid pull request number %d", c.fromPR)
+			}
+			if c.useSSH && c.useToken {
+				return fmt.Errorf("--ssh cannot be combined with --use-token")
+			}
+			if c.archiveOnly && (c.keepClone || c.cas) {
+				return fmt.Errorf("--archive-only cannot be combined with --keep-clone or --cas")
+			}
+			if c.all || len(args) > 1 {
+				names, err := c.selectTargets(args)
+				if err != nil {
+					return err
+				}
+				if names != nil {
+					return c.executeBuilds(names)
+				}
+			}
 			target := args[0]
 			// Optional commit hash argument
 			if len(args) > 1 {
 				c.commit = args[1]
 			}
+			if c.output != "text" && !c.printPlan {
+				return fmt.Errorf("--output is only supported with --print-plan or when building several targets")
+			}
+			if c.fromPR > 0 && c.commit != "" {
+				return fmt.Errorf("--from-pr cannot be combined with a commit")
+			}
+			if c.tag != "" && (c.commit != "" || c.fromPR > 0) {
+				return fmt.Errorf("--tag cannot be combined with a commit or --from-pr")
+			}
+			if c.watch {
+				if c.fromPR > 0 || c.tag != "" || c.printPlan {
+					return fmt.Errorf("--watch cannot be combined with --from-pr, --tag or --print-plan")
+				}
+				return c.executeWatch(target)
+			}
 			return c.executeBuild(target)
 		},
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
@@ -67,16 +238,51 @@ If the target has already been built at the specified commit, the build will be
 			if len(args) == 0 {
 				return c.getCompletionTargets(toComplete), cobra.ShellCompDirectiveNoFileComp
 			}
-			return nil, cobra.ShellCompDirectiveNoFileComp
+			// Then offer built commits and the remote branches and tags
+			if len(args) == 1 {
+				return c.getCompletionCommits(args[0], toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			// Further arguments name more targets to build
+			return c.getCompletionTargets(toComplete), cobra.ShellCompDirectiveNoFileComp
 		},
 	}
 	// Add flags
 	flags := cmd.Flags()
 	flags.BoolVarP(&c.verbose, "verbose", "v", false, "Enable verbose output")
 	flags.IntVarP(&c.depth, "depth", "d", 1, "Git clone depth (use 0 for full history)")
+	flags.IntVar(&c.retries, "retries", 2, "Number of times a clone is retried after a transient network failure such as a timeout (0 disables retries)")
 	flags.BoolVarP(&c.forceBuild, "force", "f", false, "Force rebuild even if the target has already been built at the specified commit")
 	flags.BoolVarP(&c.useToken, "use-token", "t", false, "Use GitHub token for authentication (required for private repositories)")
-	flags.IntVar(&c.timeout, "timeout", 30, "Build timeout in minutes (0 = no timeout)")
+	flags.BoolVar(&c.useSSH, "ssh", false, "Authenticate with an SSH key (requires an ssh:// or git@host:owner/repo source)")
+	flags.StringVar(&c.sshKey, "ssh-key", "", "SSH private key to use with --ssh (default ~/.ssh/id_rsa, then the SSH agent)")
+	c.timeout = defaultBuildTimeout
+	flags.Var((*timeoutValue)(&c.timeout), "timeout", "Build timeout such as 10m or 1h30m; a bare number is minutes (0 = no timeout)")
+	flags.BoolVar(&c.recordDeps, "record-deps", false, "Record hashes of dependency lock files in the build metadata")
+	flags.BoolVar(&c.failOnWarning, "fail-on-warning", false, "Fail the build if its output matches the target's warning pattern")
+	flags.BoolVar(&c.prefixOutput, "prefix-output", false, "Prefix each verbose build output line with the target name")
+	flags.BoolVar(&c.forcePlatform, "force-platform", false, "Build even if the target does not list the current OS in its platforms")
+	flags.IntVar(&c.fromPR, "from-pr", 0, "Build the head commit of the given GitHub pull request")
+	flags.StringVar(&c.tag, "tag", "", "Build the commit the given tag points to")
+	flags.StringArrayVar(&c.refSpecs, "ref-spec", nil, "Fetch only the given refspec instead of cloning (can be repeated)")
+	flags.BoolVar(&c.submodules, "submodules", false, "Clone the git submodules of the source recursively (overrides the target's submodules)")
+	flags.BoolVar(&c.allBranches, "all-branches", false, "Fetch every branch when building the HEAD of the default branch instead of only that branch")
+	flags.BoolVar(&c.buildInTemp, "build-in-temp", false, "Build in a temporary directory and move the artifacts into place only on success")
+	flags.BoolVar(&c.stdinConfig, "stdin-config", false, "Read the configuration from stdin instead of the configuration file")
+	flags.BoolVar(&c.reproducible, "reproducible", false, "Set reproducible-build environment variables such as SOURCE_DATE_EPOCH for the build")
+	flags.StringArrayVar(&c.labels, "label", nil, "Label to record in the build metadata as key=value (can be repeated)")
+	flags.BoolVar(&c.noMetadata, "no-metadata", false, "Do not write build metadata (build-info.json and build-info.txt)")
+	flags.BoolVar(&c.printPlan, "print-plan", false, "Print what the build would do without cloning or writing anything")
+	flags.StringVarP(&c.output, "output", "o", "text", "Output format for --print-plan and the summary of a multi-target build: text or json")
+	flags.BoolVar(&c.cas, "cas", false, "Experimental: store source files once in a content-addressed store shared by builds instead of archiving them")
+	flags.BoolVar(&c.noCache, "no-cache", false, "Clone directly from the source instead of through the clone cache")
+	flags.BoolVar(&c.keepClone, "keep-clone", false, "Keep the cloned source including .git in the commit directory (overrides binary-only)")
+	flags.BoolVar(&c.archiveOnly, "archive-only", false, "Store the source as source.tar.gz without running the build command")
+	flags.BoolVar(&c.pruneFailed, "prune-failed", false, "On build failure, remove the source and other artifacts but keep the logs and metadata (overrides the target's prune-failed)")
+	flags.IntVar(&c.pruneAfter, "prune-after", 0, "After a successful build, remove the oldest builds of the target so that at most this many remain (0 keeps all)")
+	flags.BoolVar(&c.watch, "watch", false, "Watch the local source directory of the target and rebuild whenever it changes (keeps only the latest build unless --prune-after is given)")
+	flags.StringArrayVar(&c.reproducibleExclude, "reproducible-exclude", nil, "Reproducible-build environment variable not to set (can be repeated)")
+	flags.BoolVarP(&c.all, "all", "A", false, "Build every configured target")
+	flags.IntVarP(&c.jobs, "jobs", "j", runtime.NumCPU(), "Number of targets to build at the same time")
 
 	c.cmd = cmd
 	return c
@@ -87,17 +293,486 @@ func (c *buildCommand) getCompletionTargets(prefix string) []string {
 	return getConfiguredTargets(prefix)
 }
 
-// resolveCloneDepth determines the clone depth to use. A shallow clone only
-// contains the default branch HEAD, so it cannot resolve an arbitrary commit;
-// when a commit is requested, fall back to a full clone (depth 0).
-func resolveCloneDepth(depth int, commit string) int {
-	if commit != "" {
-		return 0
+// getCompletionCommits returns the built commit hashes of the specified target
+// followed by the branches and tags of its remote repository
+func (c *buildCommand) getCompletionCommits(target, prefix string) []string {
+	completions := getTargetCommits(target, prefix)
+	seen := make(map[string]bool, len(completions))
+	for _, commit := range completions {
+		seen[commit] = true
+	}
+	for _, name := range getRemoteRefNames(target, prefix, c.lsRemote) {
+		if !seen[name] {
+			seen[name] = true
+			completions = append(completions, name)
+		}
+	}
+	return completions
+}
+
+// validateCloneOptions checks the clone options against the requested commit
+// and adjusts them where they cannot work. A shallow clone only contains the
+// tip of the fetched branch, so an arbitrary commit may be missing from it;
+// in that case the clone stays shallow and checking out the commit deepens
+// it step by step, up to the full history if needed.
+//
+// Parameters:
+//   - opts: The clone options selected by the command flags
+//   - commitRequested: The commit or ref requested by the user (empty for the default branch HEAD)
+//
+// Returns:
+//   - vcsutils.Options: The options to clone with
+//   - []string: Warnings to show the user about the chosen options
+//   - error: An error if the options are invalid
+func validateCloneOptions(opts vcsutils.Options, commitRequested string) (vcsutils.Options, []string, error) {
+	if opts.Depth < 0 {
+		return opts, nil, fmt.Errorf("invalid --depth: depth %d is negative; use 0 for full history or a positive depth", opts.Depth)
+	}
+	if opts.Retries < 0 {
+		return opts, nil, fmt.Errorf("invalid --retries: retries %d is negative; use 0 to disable retries", opts.Retries)
+	}
+
+	var warnings []string
+	specificCommit := commitRequested != "" && commitRequested != "HEAD"
+	switch {
+	case specificCommit && opts.Depth > 0:
+		opts.UnshallowIfNeeded = true
+	case !specificCommit && opts.Depth == 0:
+		warnings = append(warnings, "--depth 0 clones the full history, which can be slow and large for big repositories; it is only needed to build older commits")
+	}
+	return opts, warnings, nil
+}
+
+// singleBranchOptions restricts the clone of a branch build to the branch
+// being built, so that the other branches are not fetched. Builds of a
+// specific commit keep cloning every branch since the commit may be on any
+// of them, and explicit refspecs already select what is fetched.
+//
+// Parameters:
+//   - opts: The clone options selected by the command flags
+//   - branch: The branch whose HEAD is built (empty when building a commit)
+//   - allBranches: Whether every branch was requested with --all-branches
+//
+// Returns:
+//   - vcsutils.Options: The options to clone with
+func singleBranchOptions(opts vcsutils.Options, branch string, allBranches bool) vcsutils.Options {
+	if branch == "" || allBranches || len(opts.RefSpecs) > 0 {
+		return opts
+	}
+	opts.Branch = branch
+	opts.SingleBranch = true
+	return opts
+}
+
+// checkPlatform reports whether a target may be built on goos
+//
+// Parameters:
+//   - target: The name of the target
+//   - platforms: The platforms the target supports (empty allows all)
+//   - goos: The operating system of the host
+//
+// Returns:
+//   - error: An error naming the unsupported platform, or nil if building is allowed
+func checkPlatform(target string, platforms []string, goos string) error {
+	if len(platforms) == 0 {
+		return nil
+	}
+	for _, p := range platforms {
+		if p == goos {
+			return nil
+		}
+	}
+	return fmt.Errorf("target '%s' does not support %s (supported platforms: %s)", target, goos, strings.Join(platforms, ", "))
+}
+
+// cloneOptions returns the clone options selected by the command flags
+//
+// Returns:
+//   - vcsutils.Options: The options to clone the target repository with
+func (c *buildCommand) cloneOptions() vcsutils.Options {
+	authMethod := vcsutils.AuthNone
+	if c.useToken {
+		authMethod = vcsutils.AuthToken
+	} else if c.useSSH {
+		authMethod = vcsutils.AuthSSH
+	}
+	return vcsutils.Options{
+		Depth:            c.depth,
+		Retries:          c.retries,
+		Verbose:          c.verbose,
+		AuthMethod:       authMethod,
+		RefSpecs:         c.refSpecs,
+		SSHKeyPath:       c.sshKey,
+		SSHKeyPassphrase: os.Getenv(sshKeyPassphraseEnv),
+	}
+}
+
+// buildTimeout returns the timeout for building target. An explicit --timeout
+// takes precedence over the target's build-timeout, which takes precedence
+// over the --timeout default.
+//
+// Parameters:
+//   - targetCfg: The configuration of the target being built
+//
+// Returns:
+//   - time.Duration: The build timeout (0 = no timeout)
+func (c *buildCommand) buildTimeout(targetCfg internalconfig.Target) time.Duration {
+	if !c.cmd.Flags().Changed("timeout") {
+		if timeout, ok := targetCfg.BuildTimeout(); ok {
+			return timeout
+		}
+	}
+	return c.timeout
+}
+
+// defaultBuildTimeout is the build timeout used when neither --timeout nor the
+// target's build-timeout is given
+const defaultBuildTimeout = 30 * time.Minute
+
+// timeoutValue is the value of a --timeout flag. It accepts a duration such
+// as 10m, or a bare number of minutes as earlier versions did.
+type timeoutValue time.Duration
+
+// String returns the timeout as a duration
+func (v *timeoutValue) String() string {
+	return time.Duration(*v).String()
+}
+
+// Set parses a duration or a number of minutes
+//
+// Parameters:
+//   - s: The value given on the command line
+//
+// Returns:
+//   - error: An error if s is neither a duration nor a number, or is negative
+func (v *timeoutValue) Set(s string) error {
+	timeout, err := time.ParseDuration(s)
+	if minutes, atoiErr := strconv.Atoi(s); atoiErr == nil {
+		timeout, err = time.Duration(minutes)*time.Minute, nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid timeout %q: use a duration such as 10m or a number of minutes", s)
+	}
+	if timeout < 0 {
+		return fmt.Errorf("invalid timeout %q: must not be negative", s)
+	}
+	*v = timeoutValue(timeout)
+	return nil
+}
+
+// Type returns the type name shown in the help
+func (v *timeoutValue) Type() string {
+	return "duration"
+}
+
+// loadConfig loads the configuration from the configuration file, or from
+// stdin when --stdin-config is set. A configuration already loaded for a
+// multi-target build is reused.
+//
+// Returns:
+//   - *config.ConfigManager: The loaded configuration
+//   - error: Any error encountered while reading, parsing or validating it
+func (c *buildCommand) loadConfig() (*config.ConfigManager, error) {
+	if c.loadedConfig != nil {
+		return c.loadedConfig, nil
+	}
+	cm := newConfigManager()
+	if !c.stdinConfig {
+		if err := cm.LoadCfgFile(); err != nil {
+			return nil, logger.CreateErrorf("failed to load configuration: %w", err)
+		}
+		return cm, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.cmd.InOrStdin(), maxImportSize+1))
+	if err != nil {
+		return nil, logger.CreateErrorf("failed to read configuration from stdin: %w", err)
+	}
+	if len(data) > maxImportSize {
+		return nil, logger.CreateErrorf("configuration on stdin exceeds %d bytes", maxImportSize)
+	}
+	if err := cm.LoadCfgData(data, "stdin"); err != nil {
+		return nil, logger.CreateErrorf("failed to load configuration from stdin: %w", err)
+	}
+	for name, target := range cm.Config.Targets {
+		target.BuildCommand = target.BuildCommand.WithDefaults(cm.Config.Defaults)
+		if err := config.ValidateTarget(name, target); err != nil {
+			return nil, logger.CreateErrorf("invalid configuration on stdin: %w", err)
+		}
+	}
+	return cm, nil
+}
+
+// rootDir returns the directory builds are written to
+//
+// Returns:
+//   - string: c.root when set, otherwise the nigiri root
+func (c *buildCommand) rootDir() string {
+	if c.root != "" {
+		return c.root
+	}
+	return nigiriRoot
+}
+
+// storage returns the storage builds are kept in, which is the local
+// filesystem below the nigiri root
+//
+// Returns:
+//   - *storage.Local: The storage of the builds
+func (c *buildCommand) storage() *storage.Local {
+	return storage.NewLocal(c.rootDir())
+}
+
+// executeBuild builds the specified target and ends with a summary of the
+// warnings collected along the way, which are easy to miss in the build output.
+// With --print-plan --output json, the warnings are part of the plan instead.
+//
+// Parameters:
+//   - target: The name of the target to build as specified in the config file
+//
+// Returns:
+//   - error: Any error encountered during the build process
+func (c *buildCommand) executeBuild(target string) error {
+	c.warnings = nil
+	c.builtDir, c.upToDate = "", false
+	err := diskFullError(c.runBuild(target), c.rootDir())
+	if len(c.warnings) > 0 && (!c.printPlan || c.output != "json") {
+		c.cmd.Printf("\nCompleted with %d warnings:\n", len(c.warnings))
+		for _, warning := range c.warnings {
+			c.cmd.Printf("  - %s\n", warning)
+		}
+	}
+	return err
+}
+
+// warnf logs a warning and collects it for the summary printed when the
+// build ends
+//
+// Parameters:
+//   - format: The format of the warning
+//   - v: The values to format
+func (c *buildCommand) warnf(format string, v ...interface{}) {
+	logger.Warnf(format, v...)
+	c.warnings = append(c.warnings, fmt.Sprintf(format, v...))
+}
+
+// selectTargets decides whether the arguments name several targets to build.
+// Two arguments are a target and a commit unless the second one is also a
+// configured target.
+//
+// Parameters:
+//   - args: The command line arguments
+//
+// Returns:
+//   - []string: The targets to build, or nil if args are a target and a commit
+//   - error: Any error encountered loading the configuration or in the arguments
+func (c *buildCommand) selectTargets(args []string) ([]string, error) {
+	cm, err := c.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	c.loadedConfig = cm
+
+	var names []string
+	switch {
+	case c.all:
+		if len(args) > 0 {
+			return nil, fmt.Errorf("--all cannot be combined with target names")
+		}
+		for name := range cm.Config.Targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	case len(args) == 2:
+		if _, isTarget := cm.Config.Targets[args[1]]; !isTarget {
+			return nil, nil
+		}
+		names = args
+	default:
+		names = args
+	}
+
+	if c.fromPR > 0 || c.tag != "" || c.printPlan {
+		return nil, fmt.Errorf("--from-pr, --tag and --print-plan build a single target")
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("target '%s' is given more than once", name)
+		}
+		seen[name] = true
+	}
+	return names, nil
+}
+
+// Statuses of a target in the summary of a multi-target build
+const (
+	// BuildResultSuccess means the target was built
+	BuildResultSuccess = "success"
+	// BuildResultFailed means the build of the target failed
+	BuildResultFailed = "failed"
+	// BuildResultUpToDate means the commit had already been built
+	BuildResultUpToDate = "up-to-date"
+)
+
+// BuildResult is the outcome of building one target of a multi-target build
+//
+// Fields:
+//   - Target: The name of the target
+//   - Status: One of BuildResultSuccess, BuildResultFailed or BuildResultUpToDate
+//   - Commit: The commit directory name of the build (empty if the build
+//     failed before the commit was resolved)
+//   - Duration: How long the build took
+//   - Size: The size of the built binary in bytes (0 if there is none)
+//   - Error: Why the build failed (empty unless it failed)
+type BuildResult struct {
+	Target   string        `json:"target"`
+	Status   string        `json:"status"`
+	Commit   string        `json:"commit,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Size     int64         `json:"size,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// executeBuilds builds the latest commit of several targets concurrently, at
+// most --jobs at a time, and prints a summary once every build has ended.
+// The output of each build is prefixed with its target name. With --output
+// json, the summary is a JSON array of BuildResult and the build output goes
+// to stderr so that stdout holds only the summary.
+//
+// Parameters:
+//   - names: The names of the targets to build
+//
+// Returns:
+//   - error: An error naming the targets that failed to build
+func (c *buildCommand) executeBuilds(names []string) error {
+	// Both streams share one lock so lines of different builds never interleave
+	var mu sync.Mutex
+	stdout := &syncWriter{mu: &mu, w: c.cmd.OutOrStdout()}
+	stderr := &syncWriter{mu: &mu, w: c.cmd.ErrOrStderr()}
+	if c.output == "json" {
+		stdout.w = c.cmd.ErrOrStderr()
+		// A failed build is not a usage error, and the usage would follow the JSON
+		c.cmd.SilenceUsage = true
+	}
+
+	results := make([]BuildResult, len(names))
+	slots := make(chan struct{}, c.jobs)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			b, flush := c.forTarget(name, stdout, stderr)
+			start := time.Now()
+			err := b.executeBuild(name)
+			flush()
+			results[i] = b.buildResult(name, err, time.Since(start))
+		}()
+	}
+	wg.Wait()
+
+	if c.output == "json" {
+		enc := json.NewEncoder(c.cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return logger.CreateErrorf("failed to write build summary: %w", err)
+		}
+	} else {
+		c.cmd.Println("\nBuild summary:")
+		renderBuildSummary(c.cmd.OutOrStdout(), results, logger.UseColor(c.cmd.OutOrStdout()))
+	}
+	var failed []string
+	for _, result := range results {
+		if result.Status == BuildResultFailed {
+			failed = append(failed, result.Target)
+		}
+	}
+	if len(failed) > 0 {
+		return logger.CreateErrorf("failed to build %d of %d targets: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// buildResult describes the outcome of the last build of c for the summary
+// of a multi-target build
+//
+// Parameters:
+//   - target: The name of the target
+//   - err: The error returned by the build
+//   - duration: How long the build took
+//
+// Returns:
+//   - BuildResult: The outcome of the build
+func (c *buildCommand) buildResult(target string, err error, duration time.Duration) BuildResult {
+	result := BuildResult{Target: target, Status: BuildResultSuccess, Duration: duration}
+	if c.builtDir != "" {
+		result.Commit = filepath.Base(c.builtDir)
+	}
+	switch {
+	case err != nil:
+		result.Status = BuildResultFailed
+		result.Error = err.Error()
+		return result
+	case c.upToDate:
+		result.Status = BuildResultUpToDate
+	}
+	if info, statErr := os.Stat(filepath.Join(c.builtDir, "bin")); statErr == nil && !info.IsDir() {
+		result.Size = info.Size()
+	}
+	return result
+}
+
+// forTarget returns a copy of the build command for building one target of
+// a multi-target build, whose output is prefixed with the target name. The
+// copy shares the flags of c, so explicitly set flags keep taking precedence
+// over the target configuration.
+//
+// Parameters:
+//   - target: The name of the target
+//   - stdout: The shared standard output
+//   - stderr: The shared standard error
+//
+// Returns:
+//   - *buildCommand: The build command for the target
+//   - func(): A function flushing a pending partial output line
+func (c *buildCommand) forTarget(target string, stdout, stderr io.Writer) (*buildCommand, func()) {
+	b := *c
+	b.warnings = nil
+	// The output is already prefixed as a whole
+	b.prefixOutput = false
+
+	prefix := fmt.Sprintf("[%s] ", target)
+	out, errOut := newPrefixWriter(stdout, prefix), newPrefixWriter(stderr, prefix)
+	cmd := &cobra.Command{Use: c.cmd.Use}
+	cmd.Flags().AddFlagSet(c.cmd.Flags())
+	cmd.SetOut(out)
+	cmd.SetErr(errOut)
+	cmd.SetIn(c.cmd.InOrStdin())
+	b.cmd = cmd
+	return &b, func() {
+		_ = out.Flush()
+		_ = errOut.Flush()
 	}
-	return depth
 }
 
-// executeBuild handles the build process for the specified target.
+// syncWriter serializes writes from concurrent builds to a shared writer
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// Write writes p to the underlying writer while holding the lock
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// runBuild handles the build process for the specified target.
 // It loads configuration, clones the repository at the default branch's HEAD,
 // and executes the appropriate OS-specific build command.
 //
@@ -106,12 +781,11 @@ func resolveCloneDepth(depth int, commit string) int {
 //
 // Returns:
 //   - error: Any error encountered during the build process
-func (c *buildCommand) executeBuild(target string) error {
+func (c *buildCommand) runBuild(target string) error {
 	// Load configuration
-	cm := newConfigManager()
-	err := cm.LoadCfgFile()
+	cm, err := c.loadConfig()
 	if err != nil {
-		return logger.CreateErrorf("failed to load configuration: %w", err)
+		return err
 	}
 
 	// Check if target exists in config
@@ -119,6 +793,89 @@ func (c *buildCommand) executeBuild(target string) error {
 	if !exists {
 		return logger.CreateErrorf("target '%s' not found in configuration", target)
 	}
+	// Targets without their own build command for an OS use the defaults
+	targetCfg.BuildCommand = targetCfg.BuildCommand.WithDefaults(cm.Config.Defaults)
+
+	if platformErr := checkPlatform(target, targetCfg.Platforms, runtime.GOOS); platformErr != nil {
+		if !c.forcePlatform {
+			return logger.CreateErrorf("%w; use --force-platform to build anyway", platformErr)
+		}
+		c.warnf("%v; building anyway because --force-platform is set", platformErr)
+	}
+
+	// A pinned toolchain must exist before anything is cloned
+	var toolchainBin string
+	if targetCfg.Toolchain != "" {
+		toolchain, binDir, toolchainErr := resolveToolchain(targetCfg.Toolchain)
+		if toolchainErr != nil {
+			return logger.CreateErrorf("invalid toolchain of target '%s': %w", target, toolchainErr)
+		}
+		targetCfg.Toolchain, toolchainBin = toolchain, binDir
+	}
+
+	labels, labelErr := parseLabels(c.labels)
+	if labelErr != nil {
+		return logger.CreateErrorf("invalid --label: %w", labelErr)
+	}
+	if len(labels) > 0 && c.metadataLevel(targetCfg) == targets.MetadataNone {
+		c.warnf("Labels are not recorded because build metadata is disabled")
+	}
+
+	for _, name := range c.reproducibleExclude {
+		if !config.IsReproducibleEnvVar(name) {
+			return logger.CreateErrorf("invalid --reproducible-exclude %q: expected one of %s", name, strings.Join(config.ReproducibleEnvVars, ", "))
+		}
+	}
+
+	// Reject malformed refspecs before anything is fetched
+	if _, refSpecErr := vcsutils.ParseRefSpecs(c.refSpecs); refSpecErr != nil {
+		return logger.CreateErrorf("invalid --ref-spec: %w", refSpecErr)
+	}
+	cloneOptions, cloneWarnings, optsErr := validateCloneOptions(c.cloneOptions(), c.commit)
+	if optsErr != nil {
+		return optsErr
+	}
+	for _, warning := range cloneWarnings {
+		c.warnf("%s", warning)
+	}
+	cloneOptions.RecurseSubmodules = c.cloneSubmodules(targetCfg)
+	if c.fromPR > 0 {
+		// Pull request heads are not fetched by a regular clone
+		cloneOptions.RefSpecs = append(cloneOptions.RefSpecs, vcsutils.PullRequestRefSpec(c.fromPR))
+	}
+
+	// Resolve the commit and decide what to do before anything is written
+	progress := c.cmd.OutOrStdout()
+	if c.printPlan {
+		// Keep stdout for the plan itself
+		progress = c.cmd.ErrOrStderr()
+	}
+	git := &vcsutils.Git{
+		Source: targetCfg.Sources,
+		Tokens: c.tokens,
+	}
+	git.UseRetries(cloneOptions)
+	if c.useSSH {
+		// Resolving the commit to build already needs the SSH key
+		if sshErr := git.UseSSH(cloneOptions); sshErr != nil {
+			return logger.CreateErrorf("failed to set up SSH authentication: %w", sshErr)
+		}
+	}
+	plan, err := c.planBuild(target, targetCfg, git, progress)
+	if err != nil {
+		return err
+	}
+	if c.printPlan {
+		return c.printBuildPlan(plan)
+	}
+	if c.tag != "" && len(cloneOptions.RefSpecs) == 0 {
+		// Only the tag is fetched, and its commit is checked out by the clone
+		cloneOptions.Tag = strings.TrimPrefix(c.tag, "refs/tags/")
+		cloneOptions.SingleBranch = true
+	} else if c.commit == "" && c.fromPR == 0 {
+		// The HEAD of the branch was resolved above, so only that branch is needed
+		cloneOptions = singleBranchOptions(cloneOptions, plan.Ref, c.allBranches)
+	}
 
 	// Create target directory if it doesn't exist
 	fsTarget := targets.Target{
@@ -126,135 +883,249 @@ func (c *buildCommand) executeBuild(target string) error {
 		Commits: commits.Commits{},
 	}
 
-	if _, createErr := fsTarget.CreateTargetRootDirIfNotExist(nigiriRoot); createErr != nil {
+	if _, createErr := fsTarget.CreateTargetRootDirIfNotExist(c.rootDir()); createErr != nil {
 		return logger.CreateErrorf("failed to create target directory: %w", createErr)
 	}
 
-	targetRootDir, err := fsTarget.GetTargetRootDir(nigiriRoot)
+	targetRootDir, err := fsTarget.GetTargetRootDir(c.rootDir())
 	if err != nil {
 		return logger.CreateErrorf("failed to get target directory: %w", err)
 	}
-
-	// Initialize git utility
-	git := vcsutils.Git{
-		Source: targetCfg.Sources,
+	// Every path of the build is absolute, so it does not depend on the
+	// working directory of the process
+	if targetRootDir, err = filepath.Abs(targetRootDir); err != nil {
+		return logger.CreateErrorf("failed to resolve target directory: %w", err)
 	}
 
-	// Determine the commit to build
-	var headCommit commits.Commit
-	if c.commit == "" {
-		// Get the HEAD of the default branch
-		defaultBranch := targetCfg.DefaultBranch
-		if defaultBranch == "" {
-			defaultBranch = "main" // Default to 'main' if not specified
-		}
-		c.cmd.Printf("Getting HEAD of branch '%s' from %s...\n", defaultBranch, targetCfg.Sources)
-		if gitErr := git.GetDefaultBranchRemoteHead(defaultBranch); gitErr != nil {
-			return logger.CreateErrorf("failed to get HEAD of branch '%s': %w", defaultBranch, gitErr)
-		}
-		headCommit = commits.Commit{
-			Hash: git.HEAD,
-		}
-	} else {
-		// Use the specified commit
-		c.cmd.Printf("Using specified commit: %s\n", c.commit)
-		headCommit = commits.Commit{
-			Hash: c.commit,
-		}
+	// Mark the target as being built so cleanup does not treat it as empty.
+	// Builds of the same target take turns.
+	if targets.IsBuildLocked(targetRootDir) {
+		c.cmd.Printf("Waiting for another build of target %s to finish...\n", target)
 	}
-
-	if hashErr := headCommit.CalculateShortHash(); hashErr != nil {
-		return logger.CreateErrorf("failed to calculate short hash: %w", hashErr)
+	releaseLock, err := targets.AcquireBuildLock(targetRootDir)
+	if err != nil {
+		return logger.CreateErrorf("failed to lock target directory: %w", err)
 	}
+	defer releaseLock()
 
-	if validateErr := headCommit.Validate(); validateErr != nil {
-		return logger.CreateErrorf("invalid commit: %w", validateErr)
-	}
+	headCommit := commits.Commit{Hash: plan.Commit, ShortHash: plan.ShortHash}
+	ref := plan.Ref
+	// The commit directory is named after the short hash, and the tag for
+	// tag builds
+	buildDir := filepath.Base(plan.CommitDir)
+	dirCommit := commits.Commit{Hash: headCommit.Hash, ShortHash: buildDir}
+	c.builtDir = filepath.Join(targetRootDir, buildDir)
 
-	// Check if commit has already been built
-	isExistCommitDir := targets.IsExistTargetCommitDir(targetRootDir, headCommit)
-	if isExistCommitDir && !c.forceBuild {
-		c.cmd.Printf("Commit %s has already been built. Use --force to rebuild.\n", headCommit.ShortHash)
+	// Check if commit has already been built. The check is repeated under
+	// the lock, since another build may have finished in the meantime.
+	isExistCommitDir := targets.IsExistTargetCommitDir(targetRootDir, dirCommit)
+	rebuild := c.forceBuild
+	if isExistCommitDir && !rebuild {
+		if status, unusable := unusableBuild(c.builtDir); unusable {
+			c.cmd.Printf("Commit %s has a %s build; building it again\n", buildDir, status)
+			rebuild = true
+		}
+	}
+	if isExistCommitDir && !rebuild {
+		c.cmd.Printf("Commit %s has already been built. Use --force to rebuild.\n", buildDir)
+		c.upToDate = true
 		return nil
 	}
 
 	// Create commit directory
 	var commitDir string
 	var createErr error
-	if isExistCommitDir {
-		// If force rebuild, use the existing directory
-		commitDir = filepath.Join(targetRootDir, headCommit.ShortHash)
-		c.cmd.Printf("Force rebuilding commit %s\n", headCommit.ShortHash)
+	finalCommitDir := filepath.Join(targetRootDir, buildDir)
+	if c.buildInTemp {
+		// Stage the whole build outside the nigiri root; the commit directory
+		// is only created once the build has succeeded
+		commitDir, createErr = os.MkdirTemp("", "nigiri-build-")
+		if createErr != nil {
+			return logger.CreateErrorf("failed to create temporary build directory: %w", createErr)
+		}
+		c.cmd.Printf("Building in temporary directory %s\n", commitDir)
+		// Once moved into place the staging directory no longer exists;
+		// otherwise the build failed and leaves nothing behind
+		stagingDir := commitDir
+		defer func() {
+			if rmErr := os.RemoveAll(stagingDir); rmErr != nil {
+				c.warnf("Failed to remove temporary build directory %s: %v", stagingDir, rmErr)
+			}
+		}()
+	} else if isExistCommitDir {
+		// When rebuilding, use the existing directory
+		commitDir = finalCommitDir
+		c.cmd.Printf("Rebuilding commit %s\n", buildDir)
 		// Clean up the src directory
 		srcDir := filepath.Join(commitDir, "src")
 		if cleanErr := os.RemoveAll(srcDir); cleanErr != nil {
 			return logger.CreateErrorf("failed to clean src directory: %w", cleanErr)
 		}
 	} else {
-		// Create a new commit directory
-		commitDir, createErr = targets.CreateTargetCommitDir(targetRootDir, headCommit)
+		// Create a new commit directory. Its name may include a tag, so the
+		// commit it is named after is validated
+		if createErr = headCommit.Validate(); createErr == nil {
+			commitDir, createErr = c.storage().CreateCommitDir(target, buildDir)
+		}
 		if createErr != nil {
 			return logger.CreateErrorf("failed to create commit directory: %w", createErr)
 		}
 	}
 
-	// Record current working directory
-	cwd, err := os.Getwd()
-	if err != nil {
-		return logger.CreateErrorf("failed to get current working directory: %w", err)
-	}
-	defer func() {
-		if dirErr := os.Chdir(cwd); dirErr != nil {
-			logger.Warnf("Failed to change back to original directory: %v", dirErr)
-		}
-	}()
-
-	// Change to the commit directory
-	if chErr := os.Chdir(commitDir); chErr != nil {
-		return logger.CreateErrorf("failed to change to commit directory: %w", chErr)
-	}
-
 	// Create log directory for build logs
 	logDir := filepath.Join(commitDir, "logs")
 	if mkErr := os.MkdirAll(logDir, 0755); mkErr != nil {
 		return logger.CreateErrorf("failed to create log directory: %w", mkErr)
 	}
 
+	// Builds that fail before their metadata is written, e.g. because the
+	// clone fails, are recorded as failed so that they are not mistaken for
+	// usable builds. The stage tells whether the build command had run.
+	metadataWritten := false
+	failedStage := targets.BuildStageSetup
+	defer func() {
+		if metadataWritten {
+			return
+		}
+		c.writeBuildMetadata(commitDir, c.metadataLevel(targetCfg), &targets.BuildInfo{
+			BuildDate:   time.Now(),
+			Target:      target,
+			Commit:      headCommit.Hash,
+			ShortHash:   headCommit.ShortHash,
+			Ref:         ref,
+			PullRequest: c.fromPR,
+			Tag:         plan.Tag,
+			Status:      targets.BuildStatusFailed,
+			FailedStage: failedStage,
+			OS:          runtime.GOOS,
+			Arch:        runtime.GOARCH,
+			Labels:      labels,
+		})
+	}()
+
 	// Clone the repository with specified options
 	cloneStartTime := time.Now()
 	cloneDir := filepath.Join(commitDir, "src")
 	c.cmd.Printf("Cloning repository to %s...\n", cloneDir)
-	authMethod := vcsutils.AuthNone
-	if c.useToken {
-		authMethod = vcsutils.AuthToken
+	if len(cloneOptions.RefSpecs) > 0 {
+		c.cmd.Printf("Fetching refspecs: %s\n", strings.Join(cloneOptions.RefSpecs, ", "))
 	}
-	cloneDepth := resolveCloneDepth(c.depth, c.commit)
-	if c.commit != "" && cloneDepth != c.depth {
-		c.cmd.Printf("Commit specified; cloning full history to resolve %s\n", c.commit)
-	}
-	cloneOptions := vcsutils.Options{
-		Depth:      cloneDepth,
-		Verbose:    c.verbose,
-		AuthMethod: authMethod,
+	// Clones of whole branches go through the clone cache, so that repeated
+	// builds of the same repository only fetch what changed
+	cacheEntryDir := ""
+	releaseCache := func() {}
+	if !c.noCache && len(cloneOptions.RefSpecs) == 0 {
+		cacheEntryDir = filepath.Join(cloneCacheDir(), targets.CloneCacheKey(targetCfg.Sources))
+		if mkErr := os.MkdirAll(cacheEntryDir, 0755); mkErr != nil {
+			return logger.CreateErrorf("failed to create clone cache entry: %w", mkErr)
+		}
+		if targets.IsCloneCacheLocked(cacheEntryDir) {
+			c.cmd.Printf("Waiting for another clone using the clone cache %s...\n", cacheEntryDir)
+		}
+		release, lockErr := targets.AcquireCloneCacheLock(cacheEntryDir)
+		if lockErr != nil {
+			return logger.CreateErrorf("failed to lock clone cache entry: %w", lockErr)
+		}
+		// The entry is only in use until the clone is done
+		releaseCache = release
+		defer releaseCache()
+		cloneOptions.CacheDir = cacheEntryDir
+		c.cmd.Printf("Using clone cache %s\n", cacheEntryDir)
 	}
 	if cloneErr := git.Clone(cloneDir, cloneOptions); cloneErr != nil {
 		return logger.CreateErrorf("failed to clone repository: %w", cloneErr)
 	}
+	if cacheEntryDir != "" {
+		if recordErr := targets.RecordCloneCacheFetch(cacheEntryDir, targetCfg.Sources); recordErr != nil {
+			c.warnf("Failed to record clone cache fetch: %v", recordErr)
+		}
+		releaseCache()
+	}
 
 	// If a specific commit was requested, always check it out so the build
-	// never silently uses the default branch HEAD instead
-	if c.commit != "" {
-		c.cmd.Printf("Checking out commit %s...\n", c.commit)
-		if checkoutErr := git.Checkout(cloneDir, c.commit); checkoutErr != nil {
-			return logger.CreateErrorf("failed to checkout commit %s: %w", c.commit, checkoutErr)
+	// never silently uses the default branch HEAD instead. Fetching refspecs
+	// leaves the worktree empty, so the resolved HEAD is checked out as well.
+	checkoutRef := c.commit
+	if checkoutRef == "" && len(cloneOptions.RefSpecs) > 0 {
+		checkoutRef = headCommit.Hash
+	}
+	if checkoutRef != "" {
+		c.cmd.Printf("Checking out commit %s...\n", checkoutRef)
+		if checkoutErr := git.Checkout(cloneDir, checkoutRef); checkoutErr != nil {
+			return logger.CreateErrorf("failed to checkout commit %s: %w", checkoutRef, checkoutErr)
 		}
 	}
 
 	cloneDuration := time.Since(cloneStartTime)
 	c.cmd.Printf("Repository cloned in %s\n", cloneDuration)
 
-	// Change to the source directory for building
-	// If working directory is specified, change to that directory
+	// The branch may have moved after its HEAD was resolved. The commit
+	// directory is named after the resolved commit, so that commit is checked
+	// out instead; the build fails if it cannot be.
+	checkedOutHash, err := git.CurrentCommitHash(cloneDir)
+	if err != nil {
+		return logger.CreateErrorf("failed to read checked out commit: %w", err)
+	}
+	if checkoutRef == "" && !strings.HasPrefix(checkedOutHash, strings.ToLower(headCommit.Hash)) {
+		c.cmd.Printf("The branch moved to %s; checking out commit %s...\n", checkedOutHash, headCommit.Hash)
+		if checkoutErr := git.Checkout(cloneDir, headCommit.Hash); checkoutErr != nil {
+			return logger.CreateErrorf("the branch moved to %s after commit %s was resolved and checking out %s failed: %w (build again to build the new commit)",
+				checkedOutHash, headCommit.Hash, headCommit.ShortHash, checkoutErr)
+		}
+		if checkedOutHash, err = git.CurrentCommitHash(cloneDir); err != nil {
+			return logger.CreateErrorf("failed to read checked out commit: %w", err)
+		}
+	}
+	if !strings.HasPrefix(checkedOutHash, strings.ToLower(headCommit.Hash)) {
+		return logger.CreateErrorf("checked out commit %s differs from requested commit %s", checkedOutHash, headCommit.Hash)
+	}
+	// A requested commit may be abbreviated
+	headCommit.Hash = checkedOutHash
+
+	if c.workingTree != "" {
+		c.cmd.Printf("Copying the working tree of %s...\n", c.workingTree)
+		if copyErr := copyWorkingTree(c.workingTree, cloneDir); copyErr != nil {
+			return logger.CreateErrorf("failed to copy the working tree: %w", copyErr)
+		}
+	}
+
+	// Fail fast when the checkout does not look like the expected repository
+	if missing := missingExpectedFiles(cloneDir, targetCfg.ExpectFiles); len(missing) > 0 {
+		return logger.CreateErrorf("expected files missing from the checkout of target '%s' at %s: %s (check its source and branch)",
+			target, headCommit.ShortHash, strings.Join(missing, ", "))
+	}
+
+	if c.archiveOnly {
+		info := &targets.BuildInfo{
+			BuildDate:     time.Now(),
+			Target:        target,
+			Commit:        headCommit.Hash,
+			ShortHash:     headCommit.ShortHash,
+			Ref:           ref,
+			PullRequest:   c.fromPR,
+			Tag:           plan.Tag,
+			Status:        targets.BuildStatusArchived,
+			OS:            runtime.GOOS,
+			Arch:          runtime.GOARCH,
+			CloneDuration: cloneDuration,
+			Labels:        labels,
+		}
+		if err := c.archiveSource(commitDir, cloneDir, c.metadataLevel(targetCfg), info); err != nil {
+			return err
+		}
+		metadataWritten = true
+		if c.buildInTemp {
+			if err := c.moveIntoPlace(commitDir, finalCommitDir); err != nil {
+				return err
+			}
+		}
+		c.cmd.Printf("Archived source of target '%s' at commit %s (not built)\n", target, headCommit.ShortHash)
+		return nil
+	}
+
+	// The build runs in the source directory, or in the configured working
+	// directory within it. The process working directory is never changed,
+	// so that several targets can be built at the same time.
 	workDir := cloneDir
 	if targetCfg.WorkingDirectory != "" {
 		workDir = filepath.Join(cloneDir, targetCfg.WorkingDirectory)
@@ -262,26 +1133,47 @@ func (c *buildCommand) executeBuild(target string) error {
 			return logger.CreateErrorf("working directory '%s' not found in source", targetCfg.WorkingDirectory)
 		}
 	}
-	if chdirErr := os.Chdir(workDir); chdirErr != nil {
-		return logger.CreateErrorf("failed to change to working directory: %w", chdirErr)
+
+	// Hash dependency lock files before the build command can modify them
+	var depsFiles []targets.DependencyFile
+	if c.recordDeps {
+		names := targetCfg.DepsFiles
+		if len(names) == 0 {
+			names = defaultDepsFiles
+		}
+		var depsErr error
+		depsFiles, depsErr = hashDependencyFiles(cloneDir, workDir, names)
+		if depsErr != nil {
+			return logger.CreateErrorf("failed to record dependency files: %w", depsErr)
+		}
+		c.cmd.Printf("Recorded %d dependency files\n", len(depsFiles))
 	}
 
 	// Select the appropriate build command based on the OS
-	buildCmd := targetCfg.BuildCommand
-	var cmd string
-	switch os := runtime.GOOS; os {
-	case "linux":
-		cmd = buildCmd.Linux
-	case "windows":
-		cmd = buildCmd.Windows
-	case "darwin":
-		cmd = buildCmd.Darwin
-	default:
-		return logger.CreateErrorf("unsupported OS: %s", runtime.GOOS)
+	cmd, cmdErr := osBuildCommand(targetCfg.BuildCommand, runtime.GOOS)
+	if cmdErr != nil {
+		return cmdErr
+	}
+	if targetCfg.Toolchain != "" {
+		// Only targets with a toolchain treat their build command as a template,
+		// so that other commands may contain {{ literally
+		if cmd, cmdErr = renderCommand("build-command", cmd, commandData{Toolchain: targetCfg.Toolchain}); cmdErr != nil {
+			return logger.CreateErrorf("invalid build command %q: %w", cmd, cmdErr)
+		}
+		c.cmd.Printf("Using toolchain %s\n", targetCfg.Toolchain)
 	}
 
-	if cmd == "" {
-		return logger.CreateErrorf("no build command specified for OS: %s", runtime.GOOS)
+	var warnings *warningScanner
+	if c.failOnWarning {
+		pattern := targetCfg.WarningPattern
+		if pattern == "" {
+			pattern = defaultWarningPattern
+		}
+		re, reErr := regexp.Compile(pattern)
+		if reErr != nil {
+			return logger.CreateErrorf("invalid warning-pattern %q: %w", pattern, reErr)
+		}
+		warnings = newWarningScanner(re)
 	}
 
 	// Build log file path
@@ -290,137 +1182,1336 @@ func (c *buildCommand) executeBuild(target string) error {
 	if err != nil {
 		return logger.CreateErrorf("failed to create build log file: %w", err)
 	}
-	defer func() {
-		if err := buildLogFile.Close(); err != nil {
-			logger.Warnf("failed to close build log file: %v", err)
-		}
-	}()
 
 	// Run the build command
 	c.cmd.Printf("Building target '%s' with command: %s\n", target, cmd)
-	if c.timeout > 0 {
-		c.cmd.Printf("Build timeout: %d minutes\n", c.timeout)
+	timeout := c.buildTimeout(targetCfg)
+	if timeout > 0 {
+		c.cmd.Printf("Build timeout: %s\n", timeout)
 	}
 	buildStartTime := time.Now()
 
 	// Create context with timeout if specified
 	var ctx context.Context
 	var cancel context.CancelFunc
-	if c.timeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(c.timeout)*time.Minute)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
 		defer cancel()
 	} else {
 		ctx = context.Background()
 	}
 
-	execCmd := exec.CommandContext(ctx, "/bin/sh", "-c", cmd)
-	execCmd.Stdout = buildLogFile
-	execCmd.Stderr = buildLogFile
+	// The build runs in its own process group so that a timeout also kills
+	// the processes the build command started
+	runOpts := exec.Options{
+		Dir:          workDir,
+		Stdout:       buildLogFile,
+		Stderr:       buildLogFile,
+		ProcessGroup: true,
+	}
 
+	var prefixed []*prefixWriter
 	if c.verbose {
 		// If verbose, show output in terminal too
-		execCmd.Stdout = io.MultiWriter(os.Stdout, buildLogFile)
-		execCmd.Stderr = io.MultiWriter(os.Stderr, buildLogFile)
+		var stdout, stderr io.Writer = c.cmd.OutOrStdout(), c.cmd.ErrOrStderr()
+		if c.prefixOutput {
+			// Keep concurrent builds attributable; the log file stays unprefixed
+			prefix := fmt.Sprintf("[%s] ", target)
+			prefixedStdout, prefixedStderr := newPrefixWriter(stdout, prefix), newPrefixWriter(stderr, prefix)
+			prefixed = append(prefixed, prefixedStdout, prefixedStderr)
+			stdout, stderr = prefixedStdout, prefixedStderr
+		}
+		runOpts.Stdout = io.MultiWriter(stdout, buildLogFile)
+		runOpts.Stderr = io.MultiWriter(stderr, buildLogFile)
+	}
+	if warnings != nil {
+		// Scan the output for warnings while it is being captured
+		runOpts.Stdout = io.MultiWriter(runOpts.Stdout, warnings)
+		runOpts.Stderr = io.MultiWriter(runOpts.Stderr, warnings)
 	}
 
-	// Set environment variables if specified
-	if len(targetCfg.Env) > 0 {
-		execCmd.Env = append(os.Environ(), targetCfg.Env...)
+	// Set environment variables if specified; the target's own env is
+	// appended last so it overrides the reproducible-build variables
+	var buildEnv []string
+	if c.reproducible || targetCfg.Reproducible {
+		commitTime, timeErr := git.CommitTime(cloneDir)
+		if timeErr != nil {
+			return logger.CreateErrorf("failed to read commit date: %w", timeErr)
+		}
+		exclude := append(append([]string{}, targetCfg.ReproducibleExclude...), c.reproducibleExclude...)
+		buildEnv = reproducibleEnv(commitTime, os.Getenv("GOFLAGS"), exclude)
+		if len(buildEnv) > 0 {
+			c.cmd.Printf("Reproducible build environment: %s\n", strings.Join(buildEnv, " "))
+		}
+	}
+	buildEnv = append(buildEnv, targetCfg.Env...)
+	if len(buildEnv) > 0 || toolchainBin != "" {
+		runOpts.Env = append(os.Environ(), buildEnv...)
+	}
+	if toolchainBin != "" {
+		// The last PATH entry wins, so the toolchain also precedes a PATH set in env
+		runOpts.Env = append(runOpts.Env, prependPathEnv(runOpts.Env, toolchainBin, runtime.GOOS))
 	}
 
-	buildErr := execCmd.Run()
+	failedStage = targets.BuildStageBuild
+	_, _, buildErr := c.runner.Run(ctx, shellCommand(targetCfg.Shell, runtime.GOOS, cmd), runOpts)
+	for _, w := range prefixed {
+		if err := w.Flush(); err != nil {
+			c.warnf("failed to flush build output: %v", err)
+		}
+	}
+	if err := buildLogFile.Close(); err != nil {
+		c.warnf("failed to close build log file: %v", err)
+	}
 
 	// Check if the build was killed due to timeout
 	if ctx.Err() == context.DeadlineExceeded {
-		buildErr = logger.CreateErrorf("build timed out after %d minutes", c.timeout)
+		buildErr = logger.CreateErrorf("build timed out after %s", timeout)
 	}
 	buildDuration := time.Since(buildStartTime)
 
-	// Create a build metadata file
-	metadataPath := filepath.Join(commitDir, "build-info.txt")
-	metaFile, err := os.Create(metadataPath)
-	if err == nil {
-		defer func() {
-			if err := metaFile.Close(); err != nil {
-				logger.Warnf("failed to close metadata file: %v", err)
-			}
-		}()
-		if _, err := metaFile.WriteString(fmt.Sprintf("Target: %s\n", target)); err != nil {
-			logger.Warnf("Failed to write target info: %v", err)
-		}
-		if _, err := metaFile.WriteString(fmt.Sprintf("Commit: %s\n", headCommit.Hash)); err != nil {
-			logger.Warnf("Failed to write commit info: %v", err)
-		}
-		if _, err := metaFile.WriteString(fmt.Sprintf("Short hash: %s\n", headCommit.ShortHash)); err != nil {
-			logger.Warnf("Failed to write short hash info: %v", err)
-		}
-		if _, err := metaFile.WriteString(fmt.Sprintf("Build date: %s\n", time.Now().Format(time.RFC3339))); err != nil {
-			logger.Warnf("Failed to write build date info: %v", err)
-		}
-		if _, err := metaFile.WriteString(fmt.Sprintf("Clone duration: %s\n", cloneDuration)); err != nil {
-			logger.Warnf("Failed to write clone duration info: %v", err)
-		}
-		if _, err := metaFile.WriteString(fmt.Sprintf("Build duration: %s\n", buildDuration)); err != nil {
-			logger.Warnf("Failed to write build duration info: %v", err)
-		}
-		if _, err := metaFile.WriteString(fmt.Sprintf("OS: %s\n", runtime.GOOS)); err != nil {
-			logger.Warnf("Failed to write OS info: %v", err)
-		}
-		if _, err := metaFile.WriteString(fmt.Sprintf("Architecture: %s\n", runtime.GOARCH)); err != nil {
-			logger.Warnf("Failed to write architecture info: %v", err)
+	var warningLines []string
+	if warnings != nil {
+		warningLines = warnings.Matches()
+		if buildErr == nil && len(warningLines) > 0 {
+			buildErr = logger.CreateErrorf("build emitted %d warnings", len(warningLines))
 		}
 	}
 
-	// Process source files based on binary_only option or always compress them
+	// Copy the built binary and post-process it before its checksum is recorded
+	var binary string
 	if buildErr == nil {
-		// Copy built binary if binary path is specified
-		binaryPath, hasBinaryPath := buildCmd.BinaryPath()
-		if hasBinaryPath {
-			// If binary path is specified, copy it to the commit directory
-			sourceFile := filepath.Join(workDir, binaryPath)
-			destFile := filepath.Join(commitDir, "bin")
-
-			// Create bin directory if it doesn't exist
-			if err := os.MkdirAll(filepath.Dir(destFile), 0755); err != nil {
-				logger.Warnf("Failed to create bin directory: %v", err)
-			} else {
-				// Copy the binary
-				if copyErr := copyFile(sourceFile, destFile); copyErr != nil {
-					logger.Warnf("Failed to copy binary: %v", copyErr)
-				}
-			}
-		}
+		binary, buildErr = c.finalizeBinary(targetCfg, workDir, commitDir, buildLogPath)
 	}
 
-	// Handle binary_only option or compress source
-	if targetCfg.BinaryOnly {
+	// Handle keep-clone, binary_only and cas options or compress source
+	var archive string
+	if c.keepClone {
+		c.cmd.Printf("Keeping cloned source at %s\n", filepath.Join(finalCommitDir, "src"))
+	} else if targetCfg.BinaryOnly {
 		// If binary_only is set, remove source directory
 		if err := os.RemoveAll(cloneDir); err != nil {
-			logger.Warnf("Failed to remove source directory: %v", err)
+			c.warnf("Failed to remove source directory: %v", err)
+		}
+	} else if c.cas {
+		// Keep the source extracted, with files shared between builds. A
+		// staged build is stored once it is in place, since moving it from
+		// another filesystem would copy the linked files.
+		if !c.buildInTemp {
+			c.storeSourceInCAS(cloneDir)
 		}
 	} else {
 		// Compress source directory
 		srcTarGzPath := filepath.Join(commitDir, "source.tar.gz")
 		if err := compressDirectory(cloneDir, srcTarGzPath); err != nil {
-			logger.Warnf("Failed to compress source directory: %v", err)
+			// The source is kept, so a partial archive only takes up space
+			if removeErr := os.Remove(srcTarGzPath); removeErr != nil && !os.IsNotExist(removeErr) {
+				c.warnf("Failed to remove partial source archive: %v", removeErr)
+			}
+			c.warnf("Failed to compress source directory: %v", diskFullError(err, c.rootDir()))
 		} else {
+			archive = srcTarGzPath
 			// If compression successful, remove source directory
 			if err := os.RemoveAll(cloneDir); err != nil {
-				logger.Warnf("Failed to remove source directory after compression: %v", err)
+				c.warnf("Failed to remove source directory after compression: %v", err)
 			}
 		}
 	}
 
+	stats, statsErr := computeArtifactStats(binary, archive, commitDir)
+	if statsErr != nil {
+		c.warnf("Failed to compute artifact checksums and size: %v", statsErr)
+	}
+
+	// Record the build metadata read back by other commands
+	buildStatus, buildFailedStage := targets.BuildStatusSuccess, ""
+	if buildErr != nil {
+		buildStatus, buildFailedStage = targets.BuildStatusFailed, targets.BuildStageBuild
+	}
+	buildInfo := &targets.BuildInfo{
+		BuildDate:           time.Now(),
+		Target:              target,
+		Commit:              headCommit.Hash,
+		ShortHash:           headCommit.ShortHash,
+		Ref:                 ref,
+		PullRequest:         c.fromPR,
+		Tag:                 plan.Tag,
+		Status:              buildStatus,
+		FailedStage:         buildFailedStage,
+		OS:                  runtime.GOOS,
+		Arch:                runtime.GOARCH,
+		CloneDuration:       cloneDuration,
+		BuildDuration:       buildDuration,
+		DependencyFiles:     depsFiles,
+		Warnings:            warningLines,
+		BinarySHA256:        stats.binarySum,
+		SourceArchiveSHA256: stats.archiveSum,
+		Size:                stats.size,
+		Labels:              labels,
+		KeptClone:           c.keepClone,
+	}
+	metadataWritten = true
+	c.writeBuildMetadata(commitDir, c.metadataLevel(targetCfg), buildInfo)
+
 	// Check if build was successful
 	if buildErr != nil {
+		if buildStatus == targets.BuildStatusFailed && c.pruneFailedBuilds(targetCfg) {
+			if err := pruneFailedBuild(commitDir); err != nil {
+				c.warnf("Failed to prune failed build: %v", err)
+			} else {
+				c.cmd.Printf("Pruned failed build, keeping logs and metadata in %s\n", commitDir)
+			}
+		}
+		if c.buildInTemp {
+			return logger.CreateErrorf("build failed: %w\nThe temporary build directory is removed; use --verbose to see the build output", buildErr)
+		}
 		return logger.CreateErrorf("build failed: %w\nSee build log at %s", buildErr, buildLogPath)
 	}
 
+	if c.buildInTemp {
+		if err := c.moveIntoPlace(commitDir, finalCommitDir); err != nil {
+			return err
+		}
+		if c.cas && !c.keepClone && !targetCfg.BinaryOnly {
+			c.storeSourceInCAS(filepath.Join(finalCommitDir, "src"))
+		}
+	}
+
 	c.cmd.Printf("Target '%s' built at commit %s\n", target, headCommit.ShortHash)
-	c.cmd.Printf("Run with: nigiri run %s %s\n", target, headCommit.ShortHash)
+	c.cmd.Printf("Run with: nigiri run %s %s\n", target, buildDir)
+
+	if c.pruneAfter > 0 {
+		protected := func(build string) bool {
+			buildPath := filepath.Join(targetRootDir, build)
+			return targets.IsRunning(buildPath) || dirutils.IsPinned(buildPath)
+		}
+		removed, err := pruneOldBuilds(c.storage(), target, c.pruneAfter, buildDir, protected)
+		for _, name := range removed {
+			c.cmd.Printf("Removed old build %s\n", name)
+		}
+		if err != nil {
+			c.warnf("Failed to remove old builds: %v", err)
+		}
+	}
 	return nil
 }
 
+// pruneOldBuilds removes the oldest builds of a target so that at most keep
+// builds remain, counting the build that just finished, which is never
+// removed. Builds that are protected, such as builds being run or pinned
+// builds, are kept but still count towards keep.
+//
+// Parameters:
+//   - store: The storage holding the builds
+//   - target: The name of the target
+//   - keep: The number of builds to keep
+//   - current: The name of the build that just finished
+//   - protected: Reports whether a build must not be removed
+//
+// Returns:
+//   - []string: The names of the removed builds
+//   - error: Any error encountered while listing or removing the builds
+func pruneOldBuilds(store storage.Storage, target string, keep int, current string, protected func(build string) bool) ([]string, error) {
+	stored, err := store.List(target)
+	if err != nil {
+		return nil, err
+	}
+	var builds []storage.Build
+	for _, build := range stored {
+		if build.Name != current {
+			builds = append(builds, build)
+		}
+	}
+	// The current build takes one of the kept places
+	if len(builds) <= keep-1 {
+		return nil, nil
+	}
+
+	var removed []string
+	var errs []error
+	for _, build := range builds[keep-1:] {
+		if protected(build.Name) {
+			continue
+		}
+		if err := store.Remove(target, build.Name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, build.Name)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// cloneSubmodules reports whether the submodules of the target are cloned.
+// --submodules, including --submodules=false, overrides the target's
+// submodules setting.
+//
+// Parameters:
+//   - targetCfg: The configuration of the target
+//
+// Returns:
+//   - bool: True if the submodules are cloned
+func (c *buildCommand) cloneSubmodules(targetCfg internalconfig.Target) bool {
+	if c.cmd.Flags().Changed("submodules") {
+		return c.submodules
+	}
+	return targetCfg.Submodules
+}
+
+// pruneFailedBuilds reports whether a failed build of the target is pruned.
+// --prune-failed, including --prune-failed=false, overrides the target's
+// prune-failed setting.
+//
+// Parameters:
+//   - targetCfg: The configuration of the target
+//
+// Returns:
+//   - bool: True if failed builds are pruned
+func (c *buildCommand) pruneFailedBuilds(targetCfg internalconfig.Target) bool {
+	if c.cmd.Flags().Changed("prune-failed") {
+		return c.pruneFailed
+	}
+	return targetCfg.PruneFailed
+}
+
+// pruneFailedBuild removes the transient artifacts of a failed build, such as
+// the source directory, the source archive and a partially processed binary,
+// from its commit directory. The logs and the build metadata recording the
+// failure are kept for debugging.
+//
+// Parameters:
+//   - commitDir: The commit directory of the failed build
+//
+// Returns:
+//   - error: Any error encountered while removing the artifacts
+func pruneFailedBuild(commitDir string) error {
+	entries, err := os.ReadDir(commitDir)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, entry := range entries {
+		switch entry.Name() {
+		case "logs", targets.BuildInfoFileName, targets.BuildInfoTextFileName:
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(commitDir, entry.Name())); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// moveIntoPlace moves a build staged with --build-in-temp into its commit
+// directory. The build is first moved next to the commit directory, which
+// may copy it across file systems, and then renamed into place, so that an
+// existing build of the same commit is only removed once it is replaced.
+//
+// Parameters:
+//   - commitDir: The staging directory
+//   - finalCommitDir: The commit directory in the nigiri root
+//
+// Returns:
+//   - error: Any error encountered while moving or replacing the directory
+func (c *buildCommand) moveIntoPlace(commitDir, finalCommitDir string) error {
+	parent, base := filepath.Split(finalCommitDir)
+	stagedDir, err := siblingTempPath(parent, "."+base+".new-")
+	if err != nil {
+		return logger.CreateErrorf("failed to move build artifacts into %s: %w", finalCommitDir, err)
+	}
+	if mvErr := moveDir(commitDir, stagedDir); mvErr != nil {
+		return logger.CreateErrorf("failed to move build artifacts into %s: %w", finalCommitDir, mvErr)
+	}
+
+	oldDir := ""
+	if _, statErr := os.Lstat(finalCommitDir); statErr == nil {
+		if oldDir, err = siblingTempPath(parent, "."+base+".old-"); err == nil {
+			err = os.Rename(finalCommitDir, oldDir)
+		}
+		if err != nil {
+			_ = os.RemoveAll(stagedDir)
+			return logger.CreateErrorf("failed to replace existing commit directory: %w", err)
+		}
+	}
+	if err := os.Rename(stagedDir, finalCommitDir); err != nil {
+		if oldDir != "" {
+			_ = os.Rename(oldDir, finalCommitDir)
+		}
+		_ = os.RemoveAll(stagedDir)
+		return logger.CreateErrorf("failed to move build artifacts into %s: %w", finalCommitDir, err)
+	}
+	if oldDir != "" {
+		if rmErr := os.RemoveAll(oldDir); rmErr != nil {
+			c.warnf("Failed to remove the replaced build %s: %v", oldDir, rmErr)
+		}
+	}
+	c.cmd.Printf("Moved build artifacts to %s\n", finalCommitDir)
+	return nil
+}
+
+// storeSourceInCAS stores the source of a --cas build in the content-addressed
+// store. Failing to store it is only a warning, since the source is kept.
+//
+// Parameters:
+//   - srcDir: The source directory of the build
+func (c *buildCommand) storeSourceInCAS(srcDir string) {
+	stats, err := targets.StoreInCAS(filepath.Join(c.rootDir(), targets.CASDirName), srcDir)
+	if err != nil {
+		c.warnf("Failed to store source in the content-addressed store: %v", err)
+		return
+	}
+	c.cmd.Printf("Stored %d source files in the content-addressed store (%d shared, %.2f MB saved)\n",
+		stats.Files, stats.Shared, float64(stats.SharedBytes)/(1024*1024))
+}
+
+// siblingTempPath returns an unused path in dir whose name starts with
+// prefix. Names starting with a dot are skipped when builds are listed, and
+// gc removes the ones left behind by an interrupted swap.
+//
+// Parameters:
+//   - dir: The directory the path is in
+//   - prefix: The prefix of the name
+//
+// Returns:
+//   - string: The path, which does not exist
+//   - error: Any error encountered while reserving the name
+func siblingTempPath(dir, prefix string) (string, error) {
+	path, err := os.MkdirTemp(dir, prefix)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// archiveSource finishes an --archive-only build: the checked out source is
+// compressed into source.tar.gz and removed, and the metadata records that
+// nothing was built. Unlike a regular build, failing to archive is an error.
+//
+// Parameters:
+//   - commitDir: The commit directory to write the archive into
+//   - cloneDir: The directory holding the checked out source
+//   - level: The metadata level
+//   - info: The metadata of the archive
+//
+// Returns:
+//   - error: Any error encountered while archiving the source
+func (c *buildCommand) archiveSource(commitDir, cloneDir, level string, info *targets.BuildInfo) error {
+	srcTarGzPath := filepath.Join(commitDir, "source.tar.gz")
+	if err := compressDirectory(cloneDir, srcTarGzPath); err != nil {
+		if removeErr := os.Remove(srcTarGzPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			c.warnf("Failed to remove partial source archive: %v", removeErr)
+		}
+		return logger.CreateErrorf("failed to archive source: %w", err)
+	}
+	if err := os.RemoveAll(cloneDir); err != nil {
+		c.warnf("Failed to remove source directory after compression: %v", err)
+	}
+	stats, err := computeArtifactStats("", srcTarGzPath, commitDir)
+	if err != nil {
+		c.warnf("Failed to compute artifact checksums and size: %v", err)
+	}
+	info.SourceArchiveSHA256 = stats.archiveSum
+	info.Size = stats.size
+	c.writeBuildMetadata(commitDir, level, info)
+	return nil
+}
+
+// Build plan actions
+const (
+	// buildActionBuild builds a commit that has not been built yet
+	buildActionBuild = "build"
+	// buildActionRebuild rebuilds an existing build because of --force, or
+	// because it failed or is a source archive
+	buildActionRebuild = "rebuild"
+	// buildActionSkip leaves an existing build untouched
+	buildActionSkip = "skip"
+)
+
+// unusableBuild reports whether the existing build in commitDir is recorded
+// as failed or archived, so that building its commit builds it again. A
+// build without metadata cannot be told apart from a successful one and
+// counts as usable.
+//
+// Parameters:
+//   - commitDir: The commit directory of the existing build
+//
+// Returns:
+//   - string: The recorded status of the build
+//   - bool: True if the build should be built again, false otherwise
+func unusableBuild(commitDir string) (string, bool) {
+	info, err := targets.ReadBuildInfo(commitDir)
+	if err != nil {
+		return "", false
+	}
+	return info.Status, !info.Succeeded()
+}
+
+// buildPlan describes what a build will do, as resolved before anything is
+// cloned or written
+type buildPlan struct {
+	Target           string `json:"target"`
+	Source           string `json:"source"`
+	Ref              string `json:"ref"`
+	Tag              string `json:"tag,omitempty"`
+	Commit           string `json:"commit"`
+	ShortHash        string `json:"short_hash"`
+	Action           string `json:"action"`
+	Command          string `json:"command"`
+	WorkingDirectory string `json:"working_directory,omitempty"`
+	TargetDir        string `json:"target_dir"`
+	CommitDir        string `json:"commit_dir"`
+	BinaryPath       string `json:"binary_path,omitempty"`
+	// Warnings are the warnings collected while planning
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// planBuild resolves the commit to build and decides whether it will be
+// built, rebuilt or skipped. Only the remote is queried; nothing is written.
+//
+// Parameters:
+//   - target: The name of the target
+//   - targetCfg: The configuration of the target
+//   - git: The repository of the target, shared with the build
+//   - progress: Where to report the resolution progress
+//
+// Returns:
+//   - *buildPlan: The plan of the build
+//   - error: Any error encountered while resolving the commit
+func (c *buildCommand) planBuild(target string, targetCfg internalconfig.Target, git *vcsutils.Git, progress io.Writer) (*buildPlan, error) {
+	// Determine the commit to build
+	var headCommit commits.Commit
+	var tag string
+	ref := c.commit
+	if c.fromPR > 0 {
+		fmt.Fprintf(progress, "Getting head of pull request #%d from %s...\n", c.fromPR, vcsutils.ScrubCredentials(targetCfg.Sources))
+		if gitErr := git.GetPullRequestHead(c.fromPR); gitErr != nil {
+			return nil, logger.CreateErrorf("failed to get head of pull request #%d: %w", c.fromPR, gitErr)
+		}
+		headCommit = commits.Commit{
+			Hash: git.HEAD,
+		}
+		ref = vcsutils.PullRequestRef(c.fromPR)
+	} else if c.tag != "" {
+		tag = strings.TrimPrefix(c.tag, "refs/tags/")
+		fmt.Fprintf(progress, "Getting commit of tag '%s' from %s...\n", tag, vcsutils.ScrubCredentials(targetCfg.Sources))
+		if gitErr := git.GetTagRemoteHead(tag); gitErr != nil {
+			return nil, logger.CreateErrorf("failed to get commit of tag '%s': %w", tag, gitErr)
+		}
+		headCommit = commits.Commit{
+			Hash: git.HEAD,
+		}
+		ref = "refs/tags/" + tag
+	} else if c.commit == "" {
+		// Get the HEAD of the default branch
+		defaultBranch := targetCfg.DefaultBranch
+		if defaultBranch == "" {
+			// Ask the remote for its default branch rather than assuming one
+			detected, detectErr := git.DetectDefaultBranch()
+			if detectErr != nil {
+				return nil, logger.CreateErrorf("failed to detect default branch (set 'default-branch' in the configuration): %w", detectErr)
+			}
+			defaultBranch = detected
+			fmt.Fprintf(progress, "Detected default branch '%s'\n", defaultBranch)
+		}
+		fmt.Fprintf(progress, "Getting HEAD of branch '%s' from %s...\n", defaultBranch, vcsutils.ScrubCredentials(targetCfg.Sources))
+		if gitErr := git.GetDefaultBranchRemoteHead(defaultBranch); gitErr != nil {
+			return nil, logger.CreateErrorf("failed to get HEAD of branch '%s': %w", defaultBranch, gitErr)
+		}
+		headCommit = commits.Commit{
+			Hash: git.HEAD,
+		}
+		ref = defaultBranch
+	} else {
+		// Use the specified commit
+		fmt.Fprintf(progress, "Using specified commit: %s\n", c.commit)
+		headCommit = commits.Commit{
+			Hash: strings.ToLower(c.commit),
+		}
+	}
+
+	if hashErr := headCommit.CalculateShortHashLength(targetCfg.ShortHashLength()); hashErr != nil {
+		return nil, logger.CreateErrorf("failed to calculate short hash: %w", hashErr)
+	}
+
+	if validateErr := headCommit.Validate(); validateErr != nil {
+		return nil, logger.CreateErrorf("invalid commit: %w", validateErr)
+	}
+
+	targetRootDir := filepath.Join(c.rootDir(), target)
+	headCommit.ShortHash = uniqueShortHash(targetRootDir, headCommit, tag)
+	dirCommit := commits.Commit{Hash: headCommit.Hash, ShortHash: buildDirName(headCommit.ShortHash, tag)}
+	action := buildActionBuild
+	if targets.IsExistTargetCommitDir(targetRootDir, dirCommit) {
+		action = buildActionSkip
+		if _, unusable := unusableBuild(filepath.Join(targetRootDir, dirCommit.ShortHash)); c.forceBuild || unusable {
+			action = buildActionRebuild
+		}
+	}
+	// A missing build command is reported when the build runs, so that
+	// skipped builds do not need one
+	command, _ := osBuildCommand(targetCfg.BuildCommand, runtime.GOOS)
+	binaryPath, _ := targetCfg.BuildCommand.BinaryPath()
+
+	return &buildPlan{
+		Target:           target,
+		Source:           vcsutils.ScrubCredentials(targetCfg.Sources),
+		Ref:              ref,
+		Tag:              tag,
+		Commit:           headCommit.Hash,
+		ShortHash:        headCommit.ShortHash,
+		Action:           action,
+		Command:          command,
+		WorkingDirectory: targetCfg.WorkingDirectory,
+		TargetDir:        targetRootDir,
+		CommitDir:        filepath.Join(targetRootDir, dirCommit.ShortHash),
+		BinaryPath:       binaryPath,
+	}, nil
+}
+
+// uniqueShortHash returns the short hash the build of a commit is stored
+// under. Like git's abbreviations, the short hash is lengthened one character
+// at a time while a build of a different commit already uses it, so that two
+// commits whose hashes share a prefix do not end up in the same directory.
+// Builds without a recorded commit are assumed to be of the same commit.
+//
+// Parameters:
+//   - targetRootDir: The root directory of the target
+//   - commit: The commit, with its short hash at the configured length
+//   - tag: The tag that is built (empty for other builds)
+//
+// Returns:
+//   - string: The short hash to store the build under
+func uniqueShortHash(targetRootDir string, commit commits.Commit, tag string) string {
+	for n := len(commit.ShortHash); n < len(commit.Hash); n++ {
+		short := commit.Hash[:n]
+		info, err := targets.ReadBuildInfo(filepath.Join(targetRootDir, buildDirName(short, tag)))
+		if err != nil || info.Commit == "" || commits.HasHashPrefix(info.Commit, commit.Hash) || commits.HasHashPrefix(commit.Hash, info.Commit) {
+			return short
+		}
+	}
+	return commit.Hash
+}
+
+// buildDirName returns the name of the commit directory of a build: the
+// short hash, followed by the tag for tag builds. Characters of the tag that
+// are not safe in a directory name, such as the slashes of "release/1.0",
+// are replaced with underscores.
+//
+// Parameters:
+//   - shortHash: The short hash of the commit
+//   - tag: The tag that was built (empty for other builds)
+//
+// Returns:
+//   - string: The name of the commit directory
+func buildDirName(shortHash, tag string) string {
+	if tag == "" {
+		return shortHash
+	}
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, tag)
+	return shortHash + "-" + safe
+}
+
+// printBuildPlan writes the plan in the format selected by --output
+//
+// Parameters:
+//   - plan: The plan to print
+//
+// Returns:
+//   - error: Any error encountered while writing the plan
+func (c *buildCommand) printBuildPlan(plan *buildPlan) error {
+	if c.output == "json" {
+		plan.Warnings = c.warnings
+		enc := json.NewEncoder(c.cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	}
+
+	c.cmd.Printf("Target:     %s\n", plan.Target)
+	c.cmd.Printf("Source:     %s\n", plan.Source)
+	c.cmd.Printf("Ref:        %s\n", plan.Ref)
+	c.cmd.Printf("Commit:     %s\n", plan.Commit)
+	c.cmd.Printf("Action:     %s\n", plan.Action)
+	c.cmd.Printf("Command:    %s\n", plan.Command)
+	if plan.WorkingDirectory != "" {
+		c.cmd.Printf("Workdir:    %s\n", plan.WorkingDirectory)
+	}
+	c.cmd.Printf("Commit dir: %s\n", plan.CommitDir)
+	if plan.BinaryPath != "" {
+		c.cmd.Printf("Binary:     %s\n", plan.BinaryPath)
+	}
+	return nil
+}
+
+// shellCommand returns the argument list that runs command through shell, or
+// through the default shell of goos when no shell is configured: "cmd /C" on
+// Windows and "/bin/sh -c" elsewhere
+//
+// Parameters:
+//   - shell: The shell and its arguments, e.g. "bash -c" (empty uses the default)
+//   - goos: The operating system, as reported by runtime.GOOS
+//   - command: The command to run
+//
+// Returns:
+//   - []string: The shell, its arguments and the command
+func shellCommand(shell, goos, command string) []string {
+	args := strings.Fields(shell)
+	if len(args) == 0 {
+		if goos == "windows" {
+			args = []string{"cmd", "/C"}
+		} else {
+			args = []string{"/bin/sh", "-c"}
+		}
+	}
+	return append(args, command)
+}
+
+// shellQuote quotes s as a single argument for the shell commands run through
+// (see shellCommand): in double quotes for cmd, and in single quotes for
+// PowerShell and POSIX shells
+//
+// Parameters:
+//   - shell: The shell and its arguments (empty uses the default of goos)
+//   - goos: The operating system, as reported by runtime.GOOS
+//   - s: The argument to quote
+//
+// Returns:
+//   - string: The quoted argument
+func shellQuote(shell, goos, s string) string {
+	program := strings.ToLower(shellCommand(shell, goos, "")[0])
+	program = strings.TrimSuffix(filepath.Base(strings.ReplaceAll(program, `\`, "/")), ".exe")
+	switch program {
+	case "cmd":
+		// Paths cannot contain double quotes on Windows
+		return `"` + s + `"`
+	case "pwsh", "powershell":
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	default:
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+}
+
+// osBuildCommand returns the build command configured for goos
+//
+// Parameters:
+//   - buildCmd: The build command configuration of the target
+//   - goos: The operating system, as reported by runtime.GOOS
+//
+// Returns:
+//   - string: The build command
+//   - error: An error if goos is unsupported or has no build command
+func osBuildCommand(buildCmd internalconfig.BuildCommand, goos string) (string, error) {
+	var cmd string
+	switch goos {
+	case "linux":
+		cmd = buildCmd.Linux
+	case "windows":
+		cmd = buildCmd.Windows
+	case "darwin":
+		cmd = buildCmd.Darwin
+	default:
+		return "", logger.CreateErrorf("unsupported OS: %s", goos)
+	}
+	if cmd == "" {
+		return "", logger.CreateErrorf("no build command specified for OS: %s", goos)
+	}
+	return cmd, nil
+}
+
+// metadataLevel returns how much build metadata to record for a target.
+// --no-metadata overrides the target's metadata setting, which defaults to full.
+//
+// Parameters:
+//   - targetCfg: The configuration of the target being built
+//
+// Returns:
+//   - string: The metadata level
+func (c *buildCommand) metadataLevel(targetCfg internalconfig.Target) string {
+	if c.noMetadata {
+		return targets.MetadataNone
+	}
+	if targetCfg.Metadata == "" {
+		return targets.MetadataFull
+	}
+	return targetCfg.Metadata
+}
+
+// writeBuildMetadata writes build-info.txt and build-info.json to the commit
+// directory at the given metadata level. Minimal metadata only records the
+// commit and the build status, and nothing is written at level none.
+// Failures are collected as warnings since the build itself has completed.
+//
+// Parameters:
+//   - commitDir: The commit directory to write the metadata into
+//   - level: The metadata level
+//   - info: The full build metadata
+func (c *buildCommand) writeBuildMetadata(commitDir, level string, info *targets.BuildInfo) {
+	switch level {
+	case targets.MetadataNone:
+		return
+	case targets.MetadataMinimal:
+		info = info.Minimal()
+	}
+
+	var text strings.Builder
+	if info.Target != "" {
+		fmt.Fprintf(&text, "Target: %s\n", info.Target)
+	}
+	fmt.Fprintf(&text, "Commit: %s\n", info.Commit)
+	fmt.Fprintf(&text, "Short hash: %s\n", info.ShortHash)
+	if level == targets.MetadataMinimal {
+		fmt.Fprintf(&text, "Status: %s\n", info.Status)
+	} else {
+		fmt.Fprintf(&text, "Build date: %s\n", info.BuildDate.Format(time.RFC3339))
+		fmt.Fprintf(&text, "Clone duration: %s\n", info.CloneDuration)
+		fmt.Fprintf(&text, "Build duration: %s\n", info.BuildDuration)
+		fmt.Fprintf(&text, "OS: %s\n", info.OS)
+		fmt.Fprintf(&text, "Architecture: %s\n", info.Arch)
+	}
+	if info.PullRequest > 0 {
+		fmt.Fprintf(&text, "Pull request: #%d\n", info.PullRequest)
+	}
+	if info.Tag != "" {
+		fmt.Fprintf(&text, "Tag: %s\n", info.Tag)
+	}
+	for _, dep := range info.DependencyFiles {
+		fmt.Fprintf(&text, "Dependency file: %s sha256:%s\n", dep.Path, dep.SHA256)
+	}
+	if info.BinarySHA256 != "" {
+		fmt.Fprintf(&text, "Binary sha256: %s\n", info.BinarySHA256)
+	}
+	if info.SourceArchiveSHA256 != "" {
+		fmt.Fprintf(&text, "Source archive sha256: %s\n", info.SourceArchiveSHA256)
+	}
+	if info.Size > 0 {
+		fmt.Fprintf(&text, "Size: %d bytes\n", info.Size)
+	}
+	for _, line := range info.Warnings {
+		fmt.Fprintf(&text, "Warning: %s\n", line)
+	}
+	if len(info.Labels) > 0 {
+		fmt.Fprintf(&text, "Labels: %s\n", formatLabels(info.Labels))
+	}
+	if info.FailedStage != "" {
+		fmt.Fprintf(&text, "Failed stage: %s\n", info.FailedStage)
+	}
+	if info.KeptClone {
+		text.WriteString("Source: kept clone (src)\n")
+	}
+	if info.Status == targets.BuildStatusArchived && level != targets.MetadataMinimal {
+		text.WriteString("Status: archived, not built\n")
+	}
+	if err := os.WriteFile(filepath.Join(commitDir, targets.BuildInfoTextFileName), []byte(text.String()), 0644); err != nil {
+		c.warnf("Failed to write build info: %v", err)
+	}
+
+	if err := targets.WriteBuildInfo(commitDir, info); err != nil {
+		c.warnf("Failed to write build metadata: %v", err)
+	}
+}
+
+// finalizeBinary copies the built binary into the commit directory, runs the
+// target's post-process commands against the copy and returns its path.
+// Targets without a binary path have nothing to finalize.
+//
+// Parameters:
+//   - targetCfg: The configuration of the target being built
+//   - workDir: The directory the build command ran in
+//   - commitDir: The commit directory to copy the binary into
+//   - buildLogPath: The build log that post-process output is appended to
+//
+// Returns:
+//   - string: The path of the finalized binary (empty if there is none)
+//   - error: An error if a post-process command fails or the binary it needs is missing
+func (c *buildCommand) finalizeBinary(targetCfg internalconfig.Target, workDir, commitDir, buildLogPath string) (string, error) {
+	binaryPath, hasBinaryPath := targetCfg.BuildCommand.BinaryPath()
+	if !hasBinaryPath {
+		return "", nil
+	}
+	postProcess := targetCfg.PostProcess.Commands(runtime.GOOS)
+
+	// Copy the binary into the commit directory
+	sourceFile := filepath.Join(workDir, binaryPath)
+	destFile := filepath.Join(commitDir, "bin")
+	copyErr := os.MkdirAll(filepath.Dir(destFile), 0755)
+	if copyErr == nil {
+		copyErr = copyFile(sourceFile, destFile)
+	}
+	if copyErr != nil {
+		if len(postProcess) > 0 {
+			return "", logger.CreateErrorf("failed to copy binary for post-processing: %w", copyErr)
+		}
+		c.warnf("Failed to copy binary: %v", copyErr)
+		return "", nil
+	}
+
+	if len(postProcess) > 0 {
+		if err := c.postProcessBinary(postProcess, targetCfg.Shell, targetCfg.Toolchain, destFile, commitDir, buildLogPath); err != nil {
+			return "", err
+		}
+	}
+	return destFile, nil
+}
+
+// artifactStats holds the checksums and size recorded for a build's artifacts
+type artifactStats struct {
+	binarySum  string
+	archiveSum string
+	size       int64
+}
+
+// computeArtifactStats computes the checksum of the binary, the checksum of
+// the source archive and the size of the commit directory concurrently, since
+// each reads large files. Artifacts given as an empty path are skipped.
+//
+// Parameters:
+//   - binary: The path of the finalized binary (empty if there is none)
+//   - archive: The path of the source archive (empty if there is none)
+//   - commitDir: The commit directory whose size to compute
+//
+// Returns:
+//   - artifactStats: The values that could be computed
+//   - error: The errors of every computation that failed, joined
+func computeArtifactStats(binary, archive, commitDir string) (artifactStats, error) {
+	var stats artifactStats
+	var binaryErr, archiveErr, sizeErr error
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		if binary != "" {
+			if stats.binarySum, binaryErr = fsutils.SHA256File(binary); binaryErr != nil {
+				binaryErr = fmt.Errorf("binary checksum: %w", binaryErr)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if archive != "" {
+			if stats.archiveSum, archiveErr = fsutils.SHA256File(archive); archiveErr != nil {
+				archiveErr = fmt.Errorf("source archive checksum: %w", archiveErr)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if stats.size, sizeErr = dirutils.GetDirSize(commitDir); sizeErr != nil {
+			sizeErr = fmt.Errorf("build size: %w", sizeErr)
+		}
+	}()
+	wg.Wait()
+	return stats, errors.Join(binaryErr, archiveErr, sizeErr)
+}
+
+// postProcessBinary runs the post-process commands against binary, stopping
+// at the first command that fails
+//
+// Parameters:
+//   - commands: The post-process command templates
+//   - shell: The shell configured for the target (empty uses the OS default)
+//   - toolchain: The toolchain directory of the target (empty if none)
+//   - binary: The path of the binary to process
+//   - commitDir: The directory the commands run in
+//   - buildLogPath: The build log that command output is appended to
+//
+// Returns:
+//   - error: An error if a command cannot be rendered or fails
+func (c *buildCommand) postProcessBinary(commands []string, shell, toolchain, binary, commitDir, buildLogPath string) error {
+	absBinary, err := filepath.Abs(binary)
+	if err != nil {
+		return logger.CreateErrorf("failed to resolve binary path: %w", err)
+	}
+	logFile, err := os.OpenFile(buildLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return logger.CreateErrorf("failed to open build log: %w", err)
+	}
+	defer func() {
+		if err := logFile.Close(); err != nil {
+			c.warnf("failed to close build log file: %v", err)
+		}
+	}()
+
+	for _, command := range commands {
+		rendered, err := renderCommand("post-process", command, commandData{Binary: shellQuote(shell, runtime.GOOS, absBinary), Toolchain: toolchain})
+		if err != nil {
+			return logger.CreateErrorf("invalid post-process command %q: %w", command, err)
+		}
+		c.cmd.Printf("Post-processing binary: %s\n", rendered)
+		runOpts := exec.Options{Dir: commitDir, Stdout: logFile, Stderr: logFile}
+		if c.verbose {
+			runOpts.Stdout = io.MultiWriter(c.cmd.OutOrStdout(), logFile)
+			runOpts.Stderr = io.MultiWriter(c.cmd.ErrOrStderr(), logFile)
+		}
+		if _, _, err := c.runner.Run(context.Background(), shellCommand(shell, runtime.GOOS, rendered), runOpts); err != nil {
+			return logger.CreateErrorf("post-process command %q failed: %w", rendered, err)
+		}
+	}
+	return nil
+}
+
+// commandData holds the values available to command templates
+//
+// Fields:
+//   - Binary: The path of the binary being processed, quoted for the shell (post-process commands only)
+//   - Toolchain: The toolchain directory of the target (empty if none)
+type commandData struct {
+	Binary    string
+	Toolchain string
+}
+
+// renderCommand expands the placeholders of a command template, such as
+// {{.Binary}} in post-process commands and {{.Toolchain}}
+//
+// Parameters:
+//   - name: The name of the template, used in error messages
+//   - command: The command template
+//   - data: The values of the placeholders
+//
+// Returns:
+//   - string: The command to run
+//   - error: Any error encountered while parsing or executing the template
+func renderCommand(name, command string, data commandData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(command)
+	if err != nil {
+		return "", err
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+	return rendered.String(), nil
+}
+
+// resolveToolchain checks that the toolchain directory of a target exists and
+// returns the directory put first in PATH: its bin subdirectory when there is
+// one, otherwise the toolchain directory itself
+//
+// Parameters:
+//   - toolchain: The configured toolchain directory
+//
+// Returns:
+//   - string: The absolute toolchain directory
+//   - string: The directory to prepend to PATH
+//   - error: An error if the toolchain directory does not exist
+func resolveToolchain(toolchain string) (string, string, error) {
+	dir, err := filepath.Abs(toolchain)
+	if err != nil {
+		return "", "", err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", "", fmt.Errorf("toolchain directory %s does not exist", dir)
+	}
+	if !info.IsDir() {
+		return "", "", fmt.Errorf("toolchain %s is not a directory", dir)
+	}
+	binDir := filepath.Join(dir, "bin")
+	if info, err := os.Stat(binDir); err == nil && info.IsDir() {
+		return dir, binDir, nil
+	}
+	return dir, dir, nil
+}
+
+// prependPathEnv returns the PATH entry that puts dir before the PATH of env
+//
+// Parameters:
+//   - env: The environment as KEY=value pairs, where the last PATH entry applies
+//   - dir: The directory to put first in PATH
+//   - goos: The operating system, which decides whether keys are case-insensitive
+//
+// Returns:
+//   - string: The PATH entry as KEY=value
+func prependPathEnv(env []string, dir, goos string) string {
+	key, current := "PATH", ""
+	for _, kv := range env {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && (k == "PATH" || (goos == "windows" && strings.EqualFold(k, "PATH"))) {
+			key, current = k, v
+		}
+	}
+	if current == "" {
+		return key + "=" + dir
+	}
+	return key + "=" + dir + string(os.PathListSeparator) + current
+}
+
+// hashDependencyFiles computes checksums of the named dependency lock files.
+// Each name is looked up in the working directory and, when it differs, in the
+// repository root; files that do not exist are skipped.
+//
+// Parameters:
+//   - srcDir: The repository root
+//   - workDir: The directory the build command runs in
+//   - names: The dependency file paths to look for
+//
+// Returns:
+//   - []targets.DependencyFile: The files found with their paths relative to srcDir
+//   - error: Any error encountered while hashing an existing file
+func hashDependencyFiles(srcDir, workDir string, names []string) ([]targets.DependencyFile, error) {
+	dirs := []string{workDir}
+	if filepath.Clean(workDir) != filepath.Clean(srcDir) {
+		dirs = append(dirs, srcDir)
+	}
+
+	var deps []targets.DependencyFile
+	for _, dir := range dirs {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			info, err := os.Stat(path)
+			if err != nil || info.IsDir() {
+				continue
+			}
+			sum, err := fsutils.SHA256File(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to hash %s: %w", path, err)
+			}
+			relPath, err := filepath.Rel(srcDir, path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get relative path: %w", err)
+			}
+			deps = append(deps, targets.DependencyFile{
+				Path:   filepath.ToSlash(relPath),
+				SHA256: sum,
+			})
+		}
+	}
+	return deps, nil
+}
+
+// missingExpectedFiles returns the expected files that do not exist in the
+// checkout
+//
+// Parameters:
+//   - srcDir: The root directory of the checked out source
+//   - names: The expected paths, relative to srcDir
+//
+// Returns:
+//   - []string: The expected paths that are missing, in the order given
+func missingExpectedFiles(srcDir string, names []string) []string {
+	var missing []string
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(srcDir, filepath.FromSlash(name))); err != nil {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// reproducibleEnv returns the environment variables set for a reproducible
+// build of a commit, in the order of config.ReproducibleEnvVars
+//
+// Parameters:
+//   - commitTime: The committer date of the commit being built
+//   - goflags: The inherited GOFLAGS value, which -trimpath is added to
+//   - exclude: The variables not to set
+//
+// Returns:
+//   - []string: The variables as KEY=value pairs
+func reproducibleEnv(commitTime time.Time, goflags string, exclude []string) []string {
+	excluded := make(map[string]bool, len(exclude))
+	for _, name := range exclude {
+		excluded[name] = true
+	}
+	if !strings.Contains(" "+goflags+" ", " -trimpath ") {
+		goflags = strings.TrimSpace(goflags + " -trimpath")
+	}
+	values := map[string]string{
+		"SOURCE_DATE_EPOCH": strconv.FormatInt(commitTime.Unix(), 10),
+		"GOFLAGS":           goflags,
+		"TZ":                "UTC",
+		"LC_ALL":            "C",
+	}
+
+	var env []string
+	for _, name := range config.ReproducibleEnvVars {
+		if !excluded[name] {
+			env = append(env, name+"="+values[name])
+		}
+	}
+	return env
+}
+
+// defaultWarningPattern matches build output lines treated as warnings when
+// the target does not configure its own warning-pattern
+const defaultWarningPattern = `(?i)warning`
+
+// warningScanner is a writer that splits the build output into lines and
+// collects those matching a pattern. It is safe for concurrent use so that
+// stdout and stderr can both be written to it.
+type warningScanner struct {
+	mu      sync.Mutex
+	pattern *regexp.Regexp
+	partial []byte
+	matches []string
+}
+
+// newWarningScanner creates a warningScanner matching lines against pattern
+//
+// Parameters:
+//   - pattern: The regular expression identifying warning lines
+//
+// Returns:
+//   - *warningScanner: The new scanner
+func newWarningScanner(pattern *regexp.Regexp) *warningScanner {
+	return &warningScanner{pattern: pattern}
+}
+
+// Write scans the complete lines in p, keeping any trailing partial line
+// until the rest of it is written
+func (w *warningScanner) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.scanLine(w.partial[:i])
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// scanLine records line if it matches the pattern
+func (w *warningScanner) scanLine(line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if w.pattern.Match(line) {
+		w.matches = append(w.matches, string(line))
+	}
+}
+
+// Matches returns the lines that matched the pattern, including an
+// unterminated final line
+//
+// Returns:
+//   - []string: The matching lines in output order
+func (w *warningScanner) Matches() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.scanLine(w.partial)
+		w.partial = nil
+	}
+	return w.matches
+}
+
+// prefixWriter is a writer that buffers output into lines and writes each
+// complete line to the underlying writer with a prefix. Each line is written
+// with a single call so that lines from several writers do not interleave.
+type prefixWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	prefix  []byte
+	partial []byte
+}
+
+// newPrefixWriter creates a prefixWriter writing to w
+//
+// Parameters:
+//   - w: The underlying writer
+//   - prefix: The prefix written before every line
+//
+// Returns:
+//   - *prefixWriter: The new writer
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+// Write writes the complete lines in p with the prefix, keeping any trailing
+// partial line until it is completed or flushed
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.partial[:i+1]); err != nil {
+			return 0, err
+		}
+		p.partial = p.partial[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes a pending partial line, terminated by a newline
+//
+// Returns:
+//   - error: Any error returned by the underlying writer
+func (p *prefixWriter) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.partial) == 0 {
+		return nil
+	}
+	line := append(p.partial, '\n')
+	p.partial = nil
+	return p.writeLine(line)
+}
+
+// writeLine writes a single newline-terminated line with the prefix
+func (p *prefixWriter) writeLine(line []byte) error {
+	out := make([]byte, 0, len(p.prefix)+len(line))
+	out = append(append(out, p.prefix...), line...)
+	_, err := p.w.Write(out)
+	return err
+}
+
+// moveDir moves the directory src to dst. A rename is attempted first; when
+// src and dst are on different devices the tree is copied and src removed.
+func moveDir(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	if err := copyDir(src, dst); err != nil {
+		_ = os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyDir recursively copies the directory tree at src to dst, preserving
+// file permissions and symlinks
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		target := filepath.Join(dst, relPath)
+
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink: %w", err)
+			}
+			return os.Symlink(linkTarget, target)
+		default:
+			return copyFile(path, target)
+		}
+	})
+}
+
 // copyFile copies a file from src to dst
 func copyFile(src, dst string) error {
 	// Open source file
@@ -468,32 +2559,34 @@ func copyFile(src, dst string) error {
 // maxFileSizeForArchive is the maximum file size allowed in archives (1GB)
 const maxFileSizeForArchive = 1 << 30
 
-// compressDirectory compresses a directory into a tar.gz file
-func compressDirectory(srcDir, tarGzPath string) error {
+// compressDirectory compresses a directory into a tar.gz file. Closing the
+// writers flushes the end of the archive, so failing to close them is an
+// error too: the archive would be truncated.
+func compressDirectory(srcDir, tarGzPath string) (err error) {
 	// Create tar.gz file
 	tarGzFile, err := os.Create(tarGzPath)
 	if err != nil {
 		return fmt.Errorf("failed to create tar.gz file: %w", err)
 	}
 	defer func() {
-		if err := tarGzFile.Close(); err != nil {
-			logger.Warnf("failed to close tar.gz file: %v", err)
+		if closeErr := tarGzFile.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close tar.gz file: %w", closeErr)
 		}
 	}()
 
 	// Create gzip writer
 	gzipWriter := gzip.NewWriter(tarGzFile)
 	defer func() {
-		if err := gzipWriter.Close(); err != nil {
-			logger.Warnf("failed to close gzip writer: %v", err)
+		if closeErr := gzipWriter.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close gzip writer: %w", closeErr)
 		}
 	}()
 
 	// Create tar writer
 	tarWriter := tar.NewWriter(gzipWriter)
 	defer func() {
-		if err := tarWriter.Close(); err != nil {
-			logger.Warnf("failed to close tar writer: %v", err)
+		if closeErr := tarWriter.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close tar writer: %w", closeErr)
 		}
 	}()
 
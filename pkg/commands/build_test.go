@@ -1,9 +1,34 @@
 package commands
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/internal/storage"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/fsutils"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewBuildCommand(t *testing.T) {
@@ -18,25 +43,2252 @@ func TestExecuteBuild(t *testing.T) {
 	assert.Error(t, err) // Expecting error due to missing config and other dependencies
 }
 
-func TestResolveCloneDepth(t *testing.T) {
+func TestValidateCloneOptions(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
-		name   string
-		depth  int
-		commit string
-		want   int
+		name      string
+		depth     int
+		retries   int
+		commit    string
+		wantDepth int
+		wantWarn  string
+		wantErr   bool
+		// wantUnshallow is whether the checkout may fetch the full history
+		wantUnshallow bool
 	}{
-		{name: "no commit keeps default shallow depth", depth: 1, commit: "", want: 1},
-		{name: "no commit keeps custom depth", depth: 5, commit: "", want: 5},
-		{name: "no commit keeps full history depth", depth: 0, commit: "", want: 0},
-		{name: "commit with default shallow depth forces full clone", depth: 1, commit: "abc1234", want: 0},
-		{name: "commit with custom depth forces full clone", depth: 5, commit: "abc1234", want: 0},
-		{name: "commit with full history depth stays full", depth: 0, commit: "abc1234", want: 0},
+		{name: "no commit keeps default shallow depth", depth: 1, commit: "", wantDepth: 1},
+		{name: "no commit keeps custom depth", depth: 5, commit: "", wantDepth: 5},
+		{name: "no commit with full history warns", depth: 0, commit: "", wantDepth: 0, wantWarn: "--depth 0 clones the full history"},
+		{name: "HEAD keeps shallow depth", depth: 1, commit: "HEAD", wantDepth: 1},
+		{name: "HEAD with full history warns", depth: 0, commit: "HEAD", wantDepth: 0, wantWarn: "--depth 0 clones the full history"},
+		{name: "commit with default shallow depth deepens on checkout", depth: 1, commit: "abc1234", wantDepth: 1, wantUnshallow: true},
+		{name: "commit with custom depth deepens on checkout", depth: 5, commit: "abc1234", wantDepth: 5, wantUnshallow: true},
+		{name: "commit with full history stays full", depth: 0, commit: "abc1234", wantDepth: 0},
+		{name: "negative depth is rejected", depth: -1, commit: "", wantErr: true},
+		{name: "negative retries are rejected", depth: 1, retries: -1, commit: "", wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			assert.Equal(t, tt.want, resolveCloneDepth(tt.depth, tt.commit))
+			opts, warnings, err := validateCloneOptions(vcsutils.Options{Depth: tt.depth, Retries: tt.retries}, tt.commit)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantDepth, opts.Depth)
+			assert.Equal(t, tt.wantUnshallow, opts.UnshallowIfNeeded)
+			if tt.wantWarn == "" {
+				assert.Empty(t, warnings)
+			} else {
+				require.Len(t, warnings, 1)
+				assert.Contains(t, warnings[0], tt.wantWarn)
+			}
+		})
+	}
+}
+
+func TestHashDependencyFiles(t *testing.T) {
+	srcDir := t.TempDir()
+	workDir := filepath.Join(srcDir, "cmd", "app")
+	require.NoError(t, os.MkdirAll(workDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "go.sum"), []byte("hello"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workDir, "package-lock.json"), []byte("{}"), 0644))
+
+	deps, err := hashDependencyFiles(srcDir, workDir, defaultDepsFiles)
+	require.NoError(t, err)
+	assert.Equal(t, []targets.DependencyFile{
+		{Path: "cmd/app/package-lock.json", SHA256: "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"},
+		{Path: "go.sum", SHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+	}, deps)
+
+	// A configured deps-files list replaces the detection set
+	deps, err = hashDependencyFiles(srcDir, srcDir, []string{"go.sum", "missing.lock"})
+	require.NoError(t, err)
+	require.Len(t, deps, 1)
+	assert.Equal(t, "go.sum", deps[0].Path)
+
+	// The recorded hashes are persisted in the build metadata
+	commitDir := t.TempDir()
+	require.NoError(t, targets.WriteBuildInfo(commitDir, &targets.BuildInfo{Target: "tool", DependencyFiles: deps}))
+	info, err := targets.ReadBuildInfo(commitDir)
+	require.NoError(t, err)
+	assert.Equal(t, deps, info.DependencyFiles)
+}
+
+func TestBuildInTemp(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build commands use /bin/sh")
+	}
+	root := useTestNigiriRoot(t)
+	tempDir := t.TempDir()
+	t.Setenv("TMPDIR", tempDir)
+	repoDir, hash := createTestSourceRepo(t)
+	commitDir := filepath.Join(root, "tool", hash[:7])
+
+	tests := []struct {
+		name     string
+		command  string
+		wantErr  bool
+		wantDirs bool
+	}{
+		{
+			name: "failed build leaves no commit directory",
+			// The commit directory must not exist while the build runs
+			command: fmt.Sprintf("test ! -e '%s' && exit 1", commitDir),
+			wantErr: true,
+		},
+		{
+			name:     "successful build is moved into place",
+			command:  fmt.Sprintf("test ! -e '%s' && mkdir -p bin && echo ok > bin/app", commitDir),
+			wantDirs: true,
+		},
+		{
+			name:     "rebuild replaces the existing build",
+			command:  fmt.Sprintf("test -e '%s/bin' && mkdir -p bin && echo rebuilt > bin/app", commitDir),
+			wantDirs: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestBuildConfig(t, repoDir, tt.command, "")
+
+			c := newBuildCommand()
+			c.buildInTemp = true
+			c.forceBuild = true
+			c.cmd.SetOut(&bytes.Buffer{})
+			err := c.executeBuild("tool")
+			// The staging directory is removed whether or not the build succeeds
+			leftovers, globErr := filepath.Glob(filepath.Join(tempDir, "nigiri-build-*"))
+			require.NoError(t, globErr)
+			assert.Empty(t, leftovers)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.NoDirExists(t, commitDir)
+				return
+			}
+			require.NoError(t, err)
+			entries, readErr := os.ReadDir(filepath.Join(root, "tool"))
+			require.NoError(t, readErr)
+			for _, entry := range entries {
+				assert.False(t, strings.HasPrefix(entry.Name(), "."+hash[:7]), "leftover %s", entry.Name())
+			}
+			assert.FileExists(t, filepath.Join(commitDir, "bin"))
+			assert.FileExists(t, filepath.Join(commitDir, targets.BuildInfoFileName))
+			assert.FileExists(t, filepath.Join(commitDir, "logs", "build.log"))
+		})
+	}
+}
+
+func TestMoveIntoPlaceKeepsExistingBuildOnFailure(t *testing.T) {
+	targetDir := t.TempDir()
+	finalDir := filepath.Join(targetDir, "abc1234")
+	require.NoError(t, os.MkdirAll(finalDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(finalDir, "bin"), []byte("old"), 0755))
+
+	c := newBuildCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	err := c.moveIntoPlace(filepath.Join(t.TempDir(), "missing"), finalDir)
+	require.Error(t, err)
+	content, err := os.ReadFile(filepath.Join(finalDir, "bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(content))
+	entries, err := os.ReadDir(targetDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestWarningScanner(t *testing.T) {
+	w := newWarningScanner(regexp.MustCompile(defaultWarningPattern))
+	for _, chunk := range []string{"compiling\nmain.go:3: WARN", "ING: unused\r\nok\n", "warning: trailing"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, []string{"main.go:3: WARNING: unused", "warning: trailing"}, w.Matches())
+}
+
+func TestBuildFailOnWarning(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build commands use /bin/sh")
+	}
+	repoDir, hash := createTestSourceRepo(t)
+
+	tests := []struct {
+		name         string
+		command      string
+		extra        string
+		wantErr      bool
+		wantWarnings []string
+	}{
+		{
+			name:    "output without warnings succeeds",
+			command: "mkdir -p bin && echo ok > bin/app && echo all good",
+		},
+		{
+			name:         "warning on stderr fails the build",
+			command:      "mkdir -p bin && echo ok > bin/app && echo 'Warning: deprecated API' >&2",
+			wantErr:      true,
+			wantWarnings: []string{"Warning: deprecated API"},
+		},
+		{
+			name:         "custom warning pattern",
+			command:      "mkdir -p bin && echo ok > bin/app && echo 'warning: ignored' && echo 'lint: W123'",
+			extra:        "warning-pattern: '^lint: W'",
+			wantErr:      true,
+			wantWarnings: []string{"lint: W123"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, tt.command, tt.extra)
+
+			c := newBuildCommand()
+			c.failOnWarning = true
+			c.cmd.SetOut(&bytes.Buffer{})
+			err := c.executeBuild("tool")
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			info, err := targets.ReadBuildInfo(filepath.Join(root, "tool", hash[:7]))
+			require.NoError(t, err)
+			assert.Equal(t, !tt.wantErr, info.Succeeded())
+			assert.Equal(t, tt.wantWarnings, info.Warnings)
+		})
+	}
+}
+
+func TestBuildCloneOptionsFromFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want vcsutils.Options
+	}{
+		{
+			name: "defaults",
+			want: vcsutils.Options{Depth: 1, Retries: 2, AuthMethod: vcsutils.AuthNone},
+		},
+		{
+			name: "ref specs and token",
+			args: []string{"--ref-spec", "+refs/heads/main:refs/remotes/origin/main", "--ref-spec", "refs/tags/v1.0:refs/tags/v1.0", "--use-token", "--depth", "3", "--retries", "0"},
+			want: vcsutils.Options{
+				Depth:      3,
+				AuthMethod: vcsutils.AuthToken,
+				RefSpecs:   []string{"+refs/heads/main:refs/remotes/origin/main", "refs/tags/v1.0:refs/tags/v1.0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newBuildCommand()
+			require.NoError(t, c.cmd.ParseFlags(tt.args))
+			assert.Equal(t, tt.want, c.cloneOptions())
+		})
+	}
+}
+
+func TestSingleBranchOptions(t *testing.T) {
+	refSpecs := []string{"+refs/heads/main:refs/remotes/origin/main"}
+	tests := []struct {
+		name        string
+		opts        vcsutils.Options
+		branch      string
+		allBranches bool
+		want        vcsutils.Options
+	}{
+		{
+			name:   "branch build fetches only the branch",
+			opts:   vcsutils.Options{Depth: 1},
+			branch: "main",
+			want:   vcsutils.Options{Depth: 1, Branch: "main", SingleBranch: true},
+		},
+		{
+			name: "commit build fetches every branch",
+			opts: vcsutils.Options{Depth: 0},
+			want: vcsutils.Options{Depth: 0},
+		},
+		{
+			name:        "all branches requested",
+			opts:        vcsutils.Options{Depth: 1},
+			branch:      "main",
+			allBranches: true,
+			want:        vcsutils.Options{Depth: 1},
+		},
+		{
+			name:   "refspecs are left alone",
+			opts:   vcsutils.Options{Depth: 1, RefSpecs: refSpecs},
+			branch: "main",
+			want:   vcsutils.Options{Depth: 1, RefSpecs: refSpecs},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, singleBranchOptions(tt.opts, tt.branch, tt.allBranches))
+		})
+	}
+}
+
+func TestBuildClonesSingleBranch(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	r, err := git.PlainOpen(repoDir)
+	require.NoError(t, err)
+	other := plumbing.NewHashReference(plumbing.NewBranchReferenceName("other"), plumbing.NewHash(hash))
+	require.NoError(t, r.Storer.SetReference(other))
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	remoteBranches := func(t *testing.T) []string {
+		t.Helper()
+		clone, err := git.PlainOpen(filepath.Join(root, "tool", hash[:7], "src"))
+		require.NoError(t, err)
+		refs, err := clone.References()
+		require.NoError(t, err)
+		var branches []string
+		require.NoError(t, refs.ForEach(func(ref *plumbing.Reference) error {
+			if ref.Name().IsRemote() {
+				branches = append(branches, ref.Name().Short())
+			}
+			return nil
+		}))
+		return branches
+	}
+
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--keep-clone"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Equal(t, []string{"origin/master"}, remoteBranches(t))
+
+	c = newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--keep-clone", "--all-branches", "--force"})
+	require.NoError(t, c.cmd.Execute())
+	assert.ElementsMatch(t, []string{"origin/master", "origin/other"}, remoteBranches(t))
+}
+
+func TestBuildRejectsInvalidRefSpec(t *testing.T) {
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, t.TempDir(), "true", "")
+	c := newBuildCommand()
+	c.refSpecs = []string{"refs/heads/*:refs/remotes/origin/main"}
+	err := c.executeBuild("tool")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid --ref-spec")
+}
+
+func TestBuildWithRefSpec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build commands use /bin/sh")
+	}
+	root := useTestNigiriRoot(t)
+	repoDir, hash := createTestSourceRepo(t)
+	useTestBuildConfig(t, repoDir, "mkdir -p bin && cp README bin/app", "")
+
+	c := newBuildCommand()
+	c.refSpecs = []string{"+refs/heads/master:refs/remotes/origin/master"}
+	c.cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, c.executeBuild("tool"))
+
+	info, err := targets.ReadBuildInfo(filepath.Join(root, "tool", hash[:7]))
+	require.NoError(t, err)
+	assert.Equal(t, hash, info.Commit)
+	assert.FileExists(t, filepath.Join(root, "tool", hash[:7], "bin"))
+}
+
+func TestBuildWithFakeRunner(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus string
+	}{
+		{name: "successful build", wantStatus: targets.BuildStatusSuccess},
+		{name: "failed build", err: &exec.ExitError{Code: 2}, wantStatus: targets.BuildStatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make app", "")
+			fake := &exec.Fake{Handler: func(call exec.Call) ([]byte, []byte, error) {
+				if tt.err == nil {
+					binDir := filepath.Join(call.Opts.Dir, "bin")
+					require.NoError(t, os.MkdirAll(binDir, 0755))
+					require.NoError(t, os.WriteFile(filepath.Join(binDir, "app"), []byte("app"), 0755))
+				}
+				return []byte("building\n"), nil, tt.err
+			}}
+
+			c := newBuildCommand()
+			c.runner = fake
+			c.cmd.SetOut(&bytes.Buffer{})
+			err := c.executeBuild("tool")
+			if tt.err != nil {
+				require.Error(t, err)
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			calls := fake.Calls()
+			require.Len(t, calls, 1)
+			assert.Equal(t, []string{"/bin/sh", "-c", "make app"}, calls[0].Argv)
+
+			commitDir := filepath.Join(root, "tool", hash[:7])
+			info, err := targets.ReadBuildInfo(commitDir)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, info.Status)
+			buildLog, err := os.ReadFile(filepath.Join(commitDir, "logs", "build.log"))
+			require.NoError(t, err)
+			assert.Equal(t, "building\n", string(buildLog))
+			if tt.err == nil {
+				assert.FileExists(t, filepath.Join(commitDir, "bin"))
+			}
+		})
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := newPrefixWriter(&out, "[tool] ")
+	for _, chunk := range []string{"first\nsec", "ond\n", "\npartial"} {
+		n, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.Equal(t, "[tool] first\n[tool] second\n[tool] \n", out.String())
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "[tool] first\n[tool] second\n[tool] \n[tool] partial\n", out.String())
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "[tool] first\n[tool] second\n[tool] \n[tool] partial\n", out.String())
+}
+
+func TestBuildPrefixOutput(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	repoDir, hash := createTestSourceRepo(t)
+	useTestBuildConfig(t, repoDir, "make app", "")
+
+	c := newBuildCommand()
+	c.runner = &exec.Fake{Handler: func(exec.Call) ([]byte, []byte, error) {
+		return []byte("compiling\nlinked"), []byte("note: cached\n"), nil
+	}}
+	c.verbose = true
+	c.prefixOutput = true
+	var out, errOut bytes.Buffer
+	c.cmd.SetOut(&out)
+	c.cmd.SetErr(&errOut)
+	require.NoError(t, c.executeBuild("tool"))
+
+	assert.Contains(t, out.String(), "[tool] compiling\n[tool] linked\n")
+	assert.Equal(t, "[tool] note: cached\n", errOut.String())
+	buildLog, err := os.ReadFile(filepath.Join(root, "tool", hash[:7], "logs", "build.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(buildLog), "compiling\nlinked")
+	assert.NotContains(t, string(buildLog), "[tool]")
+}
+
+func TestCheckPlatform(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		platforms []string
+		goos      string
+		wantErr   bool
+	}{
+		{name: "no platforms allows any OS", goos: "darwin"},
+		{name: "listed OS is allowed", platforms: []string{"linux", "darwin"}, goos: "darwin"},
+		{name: "linux-only target on darwin", platforms: []string{"linux"}, goos: "darwin", wantErr: true},
+		{name: "linux-only target on windows", platforms: []string{"linux"}, goos: "windows", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			err := checkPlatform("tool", tt.platforms, tt.goos)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "target 'tool' does not support "+tt.goos)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name  string
+		shell string
+		goos  string
+		want  []string
+	}{
+		{name: "linux default", goos: "linux", want: []string{"/bin/sh", "-c", "make"}},
+		{name: "darwin default", goos: "darwin", want: []string{"/bin/sh", "-c", "make"}},
+		{name: "windows default", goos: "windows", want: []string{"cmd", "/C", "make"}},
+		{name: "configured shell", shell: "bash -eu -c", goos: "linux", want: []string{"bash", "-eu", "-c", "make"}},
+		{name: "configured shell on windows", shell: "pwsh -NoProfile -Command", goos: "windows", want: []string{"pwsh", "-NoProfile", "-Command", "make"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, shellCommand(tt.shell, tt.goos, "make"))
+		})
+	}
+}
+
+func TestBuildUsesConfiguredShell(t *testing.T) {
+	repoDir, _ := createTestSourceRepo(t)
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make app", "shell: bash -eu -c")
+
+	fake := &exec.Fake{}
+	c := newBuildCommand()
+	c.runner = fake
+	c.cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, c.executeBuild("tool"))
+
+	calls := fake.Calls()
+	require.NotEmpty(t, calls)
+	assert.Equal(t, []string{"bash", "-eu", "-c", "make app"}, calls[0].Argv)
+}
+
+func TestBuildRefusesUnsupportedPlatform(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	// Declare a platform other than the host so the build runs on an unsupported OS
+	otherOS := "windows"
+	if runtime.GOOS == "windows" {
+		otherOS = "linux"
+	}
+
+	t.Run("refused without override", func(t *testing.T) {
+		root := useTestNigiriRoot(t)
+		useTestBuildConfig(t, repoDir, "make", "platforms: ["+otherOS+"]")
+		fake := &exec.Fake{}
+		c := newBuildCommand()
+		c.runner = fake
+		c.cmd.SetOut(&bytes.Buffer{})
+
+		err := c.executeBuild("tool")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "target 'tool' does not support "+runtime.GOOS)
+		assert.Empty(t, fake.Calls())
+		assert.NoDirExists(t, filepath.Join(root, "tool", hash[:7]))
+	})
+
+	t.Run("built with --force-platform", func(t *testing.T) {
+		useTestNigiriRoot(t)
+		useTestBuildConfig(t, repoDir, "make", "platforms: ["+otherOS+"]")
+		fake := &exec.Fake{}
+		c := newBuildCommand()
+		c.runner = fake
+		c.forcePlatform = true
+		c.cmd.SetOut(&bytes.Buffer{})
+
+		require.NoError(t, c.executeBuild("tool"))
+		assert.Len(t, fake.Calls(), 1)
+	})
+}
+
+func TestBuildStdinConfig(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	// The on-disk configuration must be ignored
+	useTestBuildConfig(t, repoDir, "make from-file", "")
+	stdinConfig := fmt.Sprintf(`targets:
+  tool:
+    source: %s
+    default-branch: master
+    build-command:
+      linux: make from-stdin
+      darwin: make from-stdin
+`, repoDir)
+
+	fake := &exec.Fake{}
+	c := newBuildCommand()
+	c.runner = fake
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetIn(strings.NewReader(stdinConfig))
+	c.cmd.SetArgs([]string{"--stdin-config", "tool"})
+	require.NoError(t, c.cmd.Execute())
+
+	calls := fake.Calls()
+	require.Len(t, calls, 1)
+	assert.Equal(t, []string{"/bin/sh", "-c", "make from-stdin"}, calls[0].Argv)
+	assert.DirExists(t, filepath.Join(root, "tool", hash[:7]))
+}
+
+func TestBuildUsesDefaultBuildCommand(t *testing.T) {
+	repoDir, _ := createTestSourceRepo(t)
+	tests := []struct {
+		name        string
+		target      string
+		wantCommand string
+	}{
+		{name: "target without build command", target: "plain", wantCommand: "make default"},
+		{name: "target with its own build command", target: "custom", wantCommand: "make custom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestNigiriRoot(t)
+			useTestConfig(t, fmt.Sprintf(`defaults:
+  linux: make default
+  darwin: make default
+targets:
+  plain:
+    source: %[1]s
+    default-branch: master
+  custom:
+    source: %[1]s
+    default-branch: master
+    build-command:
+      linux: make custom
+      darwin: make custom
+`, repoDir))
+
+			fake := &exec.Fake{}
+			c := newBuildCommand()
+			c.runner = fake
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs([]string{tt.target})
+			require.NoError(t, c.cmd.Execute())
+
+			calls := fake.Calls()
+			require.Len(t, calls, 1)
+			assert.Equal(t, []string{"/bin/sh", "-c", tt.wantCommand}, calls[0].Argv)
+		})
+	}
+}
+
+func TestBuildToolchain(t *testing.T) {
+	useTestNigiriRoot(t)
+	repoDir, _ := createTestSourceRepo(t)
+	toolchain := t.TempDir()
+	binDir := filepath.Join(toolchain, "bin")
+	require.NoError(t, os.MkdirAll(binDir, 0755))
+	t.Setenv("PATH", "/usr/bin")
+	useTestBuildConfig(t, repoDir, "{{.Toolchain}}/bin/go build", "toolchain: "+toolchain+"\nenv: [PATH=/custom/bin]")
+
+	fake := &exec.Fake{}
+	c := newBuildCommand()
+	c.runner = fake
+	out := &bytes.Buffer{}
+	c.cmd.SetOut(out)
+	c.cmd.SetArgs([]string{"tool"})
+	require.NoError(t, c.cmd.Execute())
+
+	calls := fake.Calls()
+	require.Len(t, calls, 1)
+	assert.Equal(t, []string{"/bin/sh", "-c", toolchain + "/bin/go build"}, calls[0].Argv)
+	env := calls[0].Opts.Env
+	require.NotEmpty(t, env)
+	// The toolchain goes before the PATH set in env, which overrides the inherited one
+	assert.Equal(t, "PATH="+binDir+string(os.PathListSeparator)+"/custom/bin", env[len(env)-1])
+	assert.Contains(t, out.String(), "Using toolchain "+toolchain)
+}
+
+func TestBuildToolchainMissing(t *testing.T) {
+	useTestNigiriRoot(t)
+	missing := filepath.Join(t.TempDir(), "go1.22")
+	useTestBuildConfig(t, "https://github.com/octocat/Hello-World", "make", "toolchain: "+missing)
+
+	fake := &exec.Fake{}
+	c := newBuildCommand()
+	c.runner = fake
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool"})
+	err := c.cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid toolchain of target 'tool'")
+	assert.Contains(t, err.Error(), missing)
+	assert.Empty(t, fake.Calls())
+}
+
+func TestPrependPathEnv(t *testing.T) {
+	sep := string(os.PathListSeparator)
+	assert.Equal(t, "PATH=/tc/bin"+sep+"/b", prependPathEnv([]string{"PATH=/a", "HOME=/h", "PATH=/b"}, "/tc/bin", "linux"))
+	assert.Equal(t, "PATH=/tc/bin", prependPathEnv([]string{"HOME=/h"}, "/tc/bin", "linux"))
+	assert.Equal(t, "Path=/tc/bin"+sep+"/w", prependPathEnv([]string{"Path=/w"}, "/tc/bin", "windows"))
+	assert.Equal(t, "PATH=/tc/bin", prependPathEnv([]string{"Path=/w"}, "/tc/bin", "linux"))
+}
+
+func TestBuildStdinConfigInvalid(t *testing.T) {
+	useTestNigiriRoot(t)
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "empty", input: ""},
+		{name: "missing build command", input: "targets:\n  tool:\n    source: https://example.com/tool\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &exec.Fake{}
+			c := newBuildCommand()
+			c.runner = fake
+			c.stdinConfig = true
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetIn(strings.NewReader(tt.input))
+			err := c.executeBuild("tool")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "stdin")
+			assert.Empty(t, fake.Calls())
+		})
+	}
+}
+
+func TestBuildTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		config string
+		want   time.Duration
+	}{
+		{name: "flag default", want: 30 * time.Minute},
+		{name: "config timeout", config: "45m", want: 45 * time.Minute},
+		{name: "config disables timeout", config: "0", want: 0},
+		{name: "flag overrides config", args: []string{"--timeout", "5"}, config: "45m", want: 5 * time.Minute},
+		{name: "explicit default flag overrides config", args: []string{"--timeout", "30"}, config: "45m", want: 30 * time.Minute},
+		{name: "duration flag", args: []string{"--timeout", "10m"}, want: 10 * time.Minute},
+		{name: "compound duration flag", args: []string{"--timeout", "1h30m"}, config: "45m", want: 90 * time.Minute},
+		{name: "flag disables timeout", args: []string{"--timeout", "0"}, config: "45m", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newBuildCommand()
+			require.NoError(t, c.cmd.ParseFlags(tt.args))
+			assert.Equal(t, tt.want, c.buildTimeout(internalconfig.Target{BuildTimeoutValue: tt.config}))
+		})
+	}
+}
+
+func TestBuildTimeoutInvalid(t *testing.T) {
+	for _, value := range []string{"soon", "-5", "-1m"} {
+		t.Run(value, func(t *testing.T) {
+			c := newBuildCommand()
+			err := c.cmd.ParseFlags([]string{"--timeout", value})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid timeout")
+		})
+	}
+}
+
+func TestBuildTimesOut(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make app", "")
+
+	fake := &exec.Fake{Handler: func(exec.Call) ([]byte, []byte, error) {
+		time.Sleep(100 * time.Millisecond)
+		return []byte("partial output\n"), nil, &exec.ExitError{Code: -1}
+	}}
+	c := newBuildCommand()
+	c.runner = fake
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--timeout", "50ms"})
+	err := c.cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "build timed out after 50ms")
+
+	require.Len(t, fake.Calls(), 1)
+	assert.True(t, fake.Calls()[0].Opts.ProcessGroup)
+	buildLog, err := os.ReadFile(filepath.Join(root, "tool", hash[:7], "logs", "build.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "partial output\n", string(buildLog))
+}
+
+func TestBuildUsesConfigTimeout(t *testing.T) {
+	repoDir, _ := createTestSourceRepo(t)
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make app", "build-timeout: 45m")
+
+	var out bytes.Buffer
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "Build timeout: 45m0s")
+}
+
+func TestBuildPostProcess(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	postProcess := `post-process:
+  linux: ["strip {{.Binary}}", "upx {{.Binary}}"]
+  darwin: ["strip {{.Binary}}", "upx {{.Binary}}"]`
+
+	tests := []struct {
+		name       string
+		failUpx    bool
+		wantStatus string
+	}{
+		{name: "processed binary is recorded", wantStatus: targets.BuildStatusSuccess},
+		{name: "failing command aborts the build", failUpx: true, wantStatus: targets.BuildStatusFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make app", postProcess)
+			commitDir, err := filepath.Abs(filepath.Join(root, "tool", hash[:7]))
+			require.NoError(t, err)
+			binary := filepath.Join(commitDir, "bin")
+
+			fake := &exec.Fake{Handler: func(call exec.Call) ([]byte, []byte, error) {
+				command := call.Argv[2]
+				switch {
+				case command == "make app":
+					binDir := filepath.Join(call.Opts.Dir, "bin")
+					require.NoError(t, os.MkdirAll(binDir, 0755))
+					require.NoError(t, os.WriteFile(filepath.Join(binDir, "app"), []byte("unprocessed"), 0755))
+				case strings.HasPrefix(command, "strip "):
+					require.NoError(t, os.WriteFile(binary, []byte("stripped"), 0755))
+				case tt.failUpx:
+					return nil, nil, &exec.ExitError{Code: 1}
+				}
+				return nil, nil, nil
+			}}
+
+			c := newBuildCommand()
+			c.runner = fake
+			c.cmd.SetOut(&bytes.Buffer{})
+			err = c.executeBuild("tool")
+
+			var commands []string
+			for _, call := range fake.Calls() {
+				commands = append(commands, call.Argv[2])
+			}
+			quoted := shellQuote("", runtime.GOOS, binary)
+			assert.Equal(t, []string{"make app", "strip " + quoted, "upx " + quoted}, commands)
+
+			info, infoErr := targets.ReadBuildInfo(commitDir)
+			require.NoError(t, infoErr)
+			assert.Equal(t, tt.wantStatus, info.Status)
+			if tt.failUpx {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "post-process command")
+				assert.Empty(t, info.BinarySHA256)
+				return
+			}
+			require.NoError(t, err)
+			sum := sha256.Sum256([]byte("stripped"))
+			assert.Equal(t, hex.EncodeToString(sum[:]), info.BinarySHA256)
+			archiveSum, sumErr := fsutils.SHA256File(filepath.Join(commitDir, "source.tar.gz"))
+			require.NoError(t, sumErr)
+			assert.Equal(t, archiveSum, info.SourceArchiveSHA256)
+			assert.Positive(t, info.Size)
+		})
+	}
+}
+
+func TestComputeArtifactStats(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "bin")
+	archive := filepath.Join(dir, "source.tar.gz")
+	require.NoError(t, os.WriteFile(binary, []byte("binary"), 0755))
+	require.NoError(t, os.WriteFile(archive, []byte("archive"), 0644))
+
+	stats, err := computeArtifactStats(binary, archive, dir)
+	require.NoError(t, err)
+	wantBinary, err := fsutils.SHA256File(binary)
+	require.NoError(t, err)
+	wantArchive, err := fsutils.SHA256File(archive)
+	require.NoError(t, err)
+	wantSize, err := dirutils.GetDirSize(dir)
+	require.NoError(t, err)
+	assert.Equal(t, artifactStats{binarySum: wantBinary, archiveSum: wantArchive, size: wantSize}, stats)
+
+	// Skipped artifacts are left empty
+	stats, err = computeArtifactStats("", "", dir)
+	require.NoError(t, err)
+	assert.Equal(t, artifactStats{size: wantSize}, stats)
+
+	// Every failure is reported, and the values that could be computed are kept
+	stats, err = computeArtifactStats(filepath.Join(dir, "missing"), filepath.Join(dir, "missing.tar.gz"), dir)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "binary checksum")
+	assert.Contains(t, err.Error(), "source archive checksum")
+	assert.Equal(t, wantSize, stats.size)
+}
+
+func TestRenderCommand(t *testing.T) {
+	got, err := renderCommand("post-process", "strip --strip-all {{.Binary}}", commandData{Binary: "/tmp/bin"})
+	require.NoError(t, err)
+	assert.Equal(t, "strip --strip-all /tmp/bin", got)
+
+	got, err = renderCommand("build-command", "{{.Toolchain}}/bin/go build", commandData{Toolchain: "/opt/go"})
+	require.NoError(t, err)
+	assert.Equal(t, "/opt/go/bin/go build", got)
+
+	_, err = renderCommand("post-process", "strip {{.Missing}}", commandData{Binary: "/tmp/bin"})
+	assert.Error(t, err)
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		shell string
+		goos  string
+		want  string
+	}{
+		{goos: "linux", want: `'/tmp/it'\''s here/bin'`},
+		{shell: "bash -eu -c", goos: "linux", want: `'/tmp/it'\''s here/bin'`},
+		{goos: "windows", want: `"/tmp/it's here/bin"`},
+		{shell: `C:\Windows\System32\cmd.exe /C`, goos: "windows", want: `"/tmp/it's here/bin"`},
+		{shell: "pwsh -NoProfile -Command", goos: "windows", want: `'/tmp/it''s here/bin'`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, shellQuote(tt.shell, tt.goos, "/tmp/it's here/bin"), "shell %q on %s", tt.shell, tt.goos)
+	}
+}
+
+func TestBuildPostProcessQuotesBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("build commands use /bin/sh")
+	}
+	repoDir, hash := createTestSourceRepo(t)
+	useTestNigiriRoot(t)
+	nigiriRoot = filepath.Join(nigiriRoot, "it's a root")
+	useTestBuildConfig(t, repoDir, "mkdir -p bin && echo raw > bin/app", `post-process:
+  linux: ["echo processed > {{.Binary}}"]
+  darwin: ["echo processed > {{.Binary}}"]`)
+
+	c := newBuildCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, c.executeBuild("tool"))
+	content, err := os.ReadFile(filepath.Join(nigiriRoot, "tool", hash[:7], "bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "processed\n", string(content))
+}
+
+func TestReproducibleEnv(t *testing.T) {
+	commitTime := time.Unix(1700000000, 0)
+	tests := []struct {
+		name    string
+		goflags string
+		exclude []string
+		want    []string
+	}{
+		{
+			name: "all variables",
+			want: []string{"SOURCE_DATE_EPOCH=1700000000", "GOFLAGS=-trimpath", "TZ=UTC", "LC_ALL=C"},
+		},
+		{
+			name:    "inherited GOFLAGS are kept",
+			goflags: "-mod=vendor",
+			want:    []string{"SOURCE_DATE_EPOCH=1700000000", "GOFLAGS=-mod=vendor -trimpath", "TZ=UTC", "LC_ALL=C"},
+		},
+		{
+			name:    "trimpath is not repeated",
+			goflags: "-trimpath -mod=vendor",
+			want:    []string{"SOURCE_DATE_EPOCH=1700000000", "GOFLAGS=-trimpath -mod=vendor", "TZ=UTC", "LC_ALL=C"},
+		},
+		{
+			name:    "excluded variables are not set",
+			exclude: []string{"GOFLAGS", "LC_ALL"},
+			want:    []string{"SOURCE_DATE_EPOCH=1700000000", "TZ=UTC"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, reproducibleEnv(commitTime, tt.goflags, tt.exclude))
+		})
+	}
+}
+
+func TestBuildReproducible(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	commitTime, err := (&vcsutils.Git{}).CommitTime(repoDir)
+	require.NoError(t, err)
+	epoch := fmt.Sprintf("SOURCE_DATE_EPOCH=%d", commitTime.Unix())
+
+	tests := []struct {
+		name    string
+		extra   string
+		args    []string
+		want    []string
+		notWant []string
+	}{
+		{name: "flag", args: []string{"--reproducible"}, want: []string{epoch, "TZ=UTC"}},
+		{
+			name:    "config with exclusions",
+			extra:   "reproducible: true\nreproducible-exclude: [TZ]",
+			args:    []string{"--reproducible-exclude", "LC_ALL"},
+			want:    []string{epoch},
+			notWant: []string{"TZ=UTC", "LC_ALL=C"},
+		},
+		{
+			name:  "target env overrides",
+			extra: "reproducible: true\nenv: [\"TZ=Asia/Tokyo\"]",
+			want:  []string{epoch, "TZ=Asia/Tokyo"},
+		},
+		{name: "disabled", notWant: []string{epoch}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make", tt.extra)
+
+			var env []string
+			fake := &exec.Fake{Handler: func(call exec.Call) ([]byte, []byte, error) {
+				env = call.Opts.Env
+				return nil, nil, nil
+			}}
+			c := newBuildCommand()
+			c.runner = fake
+			c.cmd.SetOut(&bytes.Buffer{})
+			require.NoError(t, c.cmd.ParseFlags(tt.args))
+			require.NoError(t, c.executeBuild("tool"))
+			require.DirExists(t, filepath.Join(root, "tool", hash[:7]))
+
+			// Later entries win, as they do in the build process
+			effective := map[string]string{}
+			for _, kv := range env {
+				key, _, _ := strings.Cut(kv, "=")
+				effective[key] = kv
+			}
+			for _, kv := range tt.want {
+				key, _, _ := strings.Cut(kv, "=")
+				assert.Equal(t, kv, effective[key])
+			}
+			for _, kv := range tt.notWant {
+				key, _, _ := strings.Cut(kv, "=")
+				assert.NotEqual(t, kv, effective[key])
+			}
+		})
+	}
+}
+
+func TestBuildRejectsUnknownReproducibleExclude(t *testing.T) {
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, t.TempDir(), "make", "")
+	c := newBuildCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, c.cmd.ParseFlags([]string{"--reproducible-exclude", "PATH"}))
+	err := c.executeBuild("tool")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid --reproducible-exclude")
+}
+
+func TestBuildNamesCommitDirLowerCase(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", strings.ToUpper(hash)})
+	require.NoError(t, c.cmd.Execute())
+	assert.DirExists(t, filepath.Join(root, "tool", hash[:7]))
+}
+
+func TestBuildMetadataLevels(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	tests := []struct {
+		name      string
+		extra     string
+		args      []string
+		wantFiles bool
+		wantOS    string
+	}{
+		{name: "full by default", wantFiles: true, wantOS: runtime.GOOS},
+		{name: "minimal", extra: "metadata: minimal", wantFiles: true},
+		{name: "none", extra: "metadata: none"},
+		{name: "no-metadata flag overrides config", extra: "metadata: full", args: []string{"--no-metadata"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make app", tt.extra)
+			commitDir, err := filepath.Abs(filepath.Join(root, "tool", hash[:7]))
+			require.NoError(t, err)
+
+			c := newBuildCommand()
+			c.runner = &exec.Fake{Handler: func(call exec.Call) ([]byte, []byte, error) {
+				binDir := filepath.Join(call.Opts.Dir, "bin")
+				require.NoError(t, os.MkdirAll(binDir, 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(binDir, "app"), []byte("binary"), 0755))
+				return nil, nil, nil
+			}}
+			c.cmd.SetOut(&bytes.Buffer{})
+			require.NoError(t, c.cmd.ParseFlags(tt.args))
+			require.NoError(t, c.executeBuild("tool"))
+
+			if !tt.wantFiles {
+				assert.NoFileExists(t, filepath.Join(commitDir, targets.BuildInfoFileName))
+				assert.NoFileExists(t, filepath.Join(commitDir, "build-info.txt"))
+
+				// The build is still runnable without its metadata
+				fake := &exec.Fake{}
+				var out bytes.Buffer
+				r := newRunCommand()
+				r.runner = fake
+				r.attachLogs = true
+				r.cmd.SetOut(&out)
+				_, err := r.executeRun("tool", "", nil)
+				require.NoError(t, err)
+				calls := fake.Calls()
+				require.Len(t, calls, 1)
+				assert.Equal(t, filepath.Join(commitDir, "bin"), calls[0].Argv[0])
+				assert.Contains(t, out.String(), "Commit:     "+hash[:7])
+				assert.Contains(t, out.String(), "no build metadata recorded")
+				return
+			}
+
+			info, err := targets.ReadBuildInfo(commitDir)
+			require.NoError(t, err)
+			assert.Equal(t, hash, info.Commit)
+			assert.Equal(t, targets.BuildStatusSuccess, info.Status)
+			assert.Equal(t, tt.wantOS, info.OS)
+			text, err := os.ReadFile(filepath.Join(commitDir, "build-info.txt"))
+			require.NoError(t, err)
+			assert.Contains(t, string(text), "Commit: "+hash)
+			if tt.wantOS == "" {
+				assert.NotContains(t, string(text), "OS:")
+				assert.NotContains(t, string(text), "Architecture:")
+			}
+		})
+	}
+}
+
+func TestBuildLabels(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--label", "env=staging", "--label", "ticket=JIRA-123"})
+	require.NoError(t, c.cmd.Execute())
+
+	commitDir := filepath.Join(root, "tool", hash[:7])
+	info, err := targets.ReadBuildInfo(commitDir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"env": "staging", "ticket": "JIRA-123"}, info.Labels)
+	text, err := os.ReadFile(filepath.Join(commitDir, "build-info.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(text), "Labels: env=staging,ticket=JIRA-123\n")
+
+	c = newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetErr(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--force", "--label", "env"})
+	err = c.cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid --label")
+}
+
+func TestBuildKeepClone(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "binary-only: true")
+
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--keep-clone"})
+	require.NoError(t, c.cmd.Execute())
+
+	commitDir := filepath.Join(root, "tool", hash[:7])
+	assert.DirExists(t, filepath.Join(commitDir, "src", ".git"))
+	assert.NoFileExists(t, filepath.Join(commitDir, "source.tar.gz"))
+	info, err := targets.ReadBuildInfo(commitDir)
+	require.NoError(t, err)
+	assert.True(t, info.KeptClone)
+	text, err := os.ReadFile(filepath.Join(commitDir, "build-info.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(text), "Source: kept clone (src)\n")
+
+	// Without --keep-clone, binary-only removes the source again
+	c = newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--force"})
+	require.NoError(t, c.cmd.Execute())
+	assert.NoDirExists(t, filepath.Join(commitDir, "src"))
+	info, err = targets.ReadBuildInfo(commitDir)
+	require.NoError(t, err)
+	assert.False(t, info.KeptClone)
+}
+
+func TestBuildCloneCache(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+	entryDir := filepath.Join(root, targets.CloneCacheDirName, targets.CloneCacheKey(repoDir))
+
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--no-cache"})
+	require.NoError(t, c.cmd.Execute())
+	assert.NoDirExists(t, entryDir)
+
+	var out bytes.Buffer
+	c = newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", "--force"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "Using clone cache "+entryDir)
+	assert.DirExists(t, filepath.Join(entryDir, "objects"))
+	assert.NoFileExists(t, filepath.Join(entryDir, targets.CloneCacheLockFileName))
+
+	entry, err := targets.ReadCloneCacheEntry(entryDir)
+	require.NoError(t, err)
+	assert.Equal(t, repoDir, entry.Source)
+	assert.False(t, entry.LastFetch.IsZero())
+
+	info, err := targets.ReadBuildInfo(filepath.Join(root, "tool", hash[:7]))
+	require.NoError(t, err)
+	assert.Equal(t, hash, info.Commit)
+}
+
+func TestBuildPruneFailed(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+
+	tests := []struct {
+		name       string
+		extra      string
+		args       []string
+		wantPruned bool
+	}{
+		{name: "failed build is kept by default"},
+		{name: "flag prunes failed build", args: []string{"--prune-failed"}, wantPruned: true},
+		{name: "config prunes failed build", extra: "prune-failed: true", wantPruned: true},
+		{name: "flag overrides config", extra: "prune-failed: true", args: []string{"--prune-failed=false"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make", tt.extra)
+
+			c := newBuildCommand()
+			c.runner = &exec.Fake{Handler: func(exec.Call) ([]byte, []byte, error) {
+				return []byte("compiling\n"), []byte("make: *** [all] Error 2\n"), &exec.ExitError{Code: 2}
+			}}
+			var out bytes.Buffer
+			c.cmd.SetOut(&out)
+			c.cmd.SetArgs(append([]string{"tool"}, tt.args...))
+			require.Error(t, c.cmd.Execute())
+
+			commitDir := filepath.Join(root, "tool", hash[:7])
+			if tt.wantPruned {
+				assert.NoFileExists(t, filepath.Join(commitDir, "source.tar.gz"))
+				assert.NoDirExists(t, filepath.Join(commitDir, "src"))
+				assert.Contains(t, out.String(), "Pruned failed build")
+			} else {
+				assert.FileExists(t, filepath.Join(commitDir, "source.tar.gz"))
+			}
+			log, err := os.ReadFile(filepath.Join(commitDir, "logs", "build.log"))
+			require.NoError(t, err)
+			assert.Contains(t, string(log), "Error 2")
+			info, err := targets.ReadBuildInfo(commitDir)
+			require.NoError(t, err)
+			assert.Equal(t, targets.BuildStatusFailed, info.Status)
+			assert.FileExists(t, filepath.Join(commitDir, targets.BuildInfoTextFileName))
+		})
+	}
+
+	t.Run("successful build is not pruned", func(t *testing.T) {
+		root := useTestNigiriRoot(t)
+		useTestBuildConfig(t, repoDir, "make", "")
+
+		c := newBuildCommand()
+		c.runner = &exec.Fake{}
+		c.cmd.SetOut(&bytes.Buffer{})
+		c.cmd.SetArgs([]string{"tool", "--prune-failed"})
+		require.NoError(t, c.cmd.Execute())
+		assert.FileExists(t, filepath.Join(root, "tool", hash[:7], "source.tar.gz"))
+	})
+}
+
+func TestBuildExpectFiles(t *testing.T) {
+	repoDir, hash := createTestSourceRepoWithFiles(t, map[string]string{
+		"Makefile":        "all:\n",
+		"cmd/app/main.go": "package main\n",
+	})
+
+	tests := []struct {
+		name    string
+		extra   string
+		wantErr string
+	}{
+		{name: "no expected files"},
+		{name: "expected files present", extra: "expect-files: [Makefile, cmd/app/main.go]"},
+		{name: "expected files missing", extra: "expect-files: [Makefile, go.mod, cmd/other]", wantErr: "expected files missing from the checkout of target 'tool' at " + hash[:7] + ": go.mod, cmd/other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make", tt.extra)
+
+			fake := &exec.Fake{}
+			c := newBuildCommand()
+			c.runner = fake
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs([]string{"tool"})
+			err := c.cmd.Execute()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				assert.Len(t, fake.Calls(), 1)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			// The build command never runs for a checkout missing expected files
+			assert.Empty(t, fake.Calls())
+			// The build is still recorded as failed
+			info, err := targets.ReadBuildInfo(filepath.Join(root, "tool", hash[:7]))
+			require.NoError(t, err)
+			assert.Equal(t, targets.BuildStatusFailed, info.Status)
+			assert.Equal(t, targets.BuildStageSetup, info.FailedStage)
+			assert.Equal(t, hash, info.Commit)
+		})
+	}
+}
+
+func TestBuildRebuildsFailedBuild(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	repoDir, hash := createTestSourceRepo(t)
+	useTestBuildConfig(t, repoDir, "make app", "")
+	commitDir := filepath.Join(root, "tool", hash[:7])
+
+	failing := &exec.Fake{Handler: func(exec.Call) ([]byte, []byte, error) {
+		return nil, nil, fmt.Errorf("exit status 2")
+	}}
+	c := newBuildCommand()
+	c.runner = failing
+	c.cmd.SetOut(&bytes.Buffer{})
+	require.Error(t, c.executeBuild("tool"))
+	info, err := targets.ReadBuildInfo(commitDir)
+	require.NoError(t, err)
+	assert.Equal(t, targets.BuildStatusFailed, info.Status)
+	assert.Equal(t, targets.BuildStageBuild, info.FailedStage)
+
+	// The failed build is built again without --force
+	fake := &exec.Fake{Handler: func(call exec.Call) ([]byte, []byte, error) {
+		binDir := filepath.Join(call.Opts.Dir, "bin")
+		require.NoError(t, os.MkdirAll(binDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(binDir, "app"), []byte("app"), 0755))
+		return nil, nil, nil
+	}}
+	c = newBuildCommand()
+	c.runner = fake
+	var out bytes.Buffer
+	c.cmd.SetOut(&out)
+	require.NoError(t, c.executeBuild("tool"))
+	assert.False(t, c.upToDate)
+	assert.Len(t, fake.Calls(), 1)
+	assert.Contains(t, out.String(), "has a failed build; building it again")
+	info, err = targets.ReadBuildInfo(commitDir)
+	require.NoError(t, err)
+	assert.True(t, info.Succeeded())
+	assert.Empty(t, info.FailedStage)
+
+	// A successful build is kept
+	c = newBuildCommand()
+	c.runner = fake
+	c.cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, c.executeBuild("tool"))
+	assert.True(t, c.upToDate)
+	assert.Len(t, fake.Calls(), 1)
+}
+
+func TestBuildPruneAfter(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	// Older builds of the target, oldest first
+	old := []string{"1111111", "2222222", "3333333"}
+	for i, name := range old {
+		dir := filepath.Join(root, "tool", name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		modTime := time.Now().Add(-time.Duration(len(old)-i) * time.Hour)
+		require.NoError(t, os.Chtimes(dir, modTime, modTime))
+	}
+
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	var out bytes.Buffer
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", "--prune-after", "2"})
+	require.NoError(t, c.cmd.Execute())
+
+	assert.DirExists(t, filepath.Join(root, "tool", hash[:7]))
+	assert.DirExists(t, filepath.Join(root, "tool", "3333333"))
+	assert.NoDirExists(t, filepath.Join(root, "tool", "2222222"))
+	assert.NoDirExists(t, filepath.Join(root, "tool", "1111111"))
+	assert.Contains(t, out.String(), "Removed old build 2222222")
+	assert.Contains(t, out.String(), "Removed old build 1111111")
+}
+
+func TestBuildArchiveOnly(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "binary-only: true")
+
+	fake := &exec.Fake{}
+	c := newBuildCommand()
+	c.runner = fake
+	var out bytes.Buffer
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", "--archive-only"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "Archived source of target 'tool' at commit "+hash[:7]+" (not built)")
+	assert.Empty(t, fake.Calls(), "the build command must not run")
+
+	commitDir := filepath.Join(root, "tool", hash[:7])
+	assert.FileExists(t, filepath.Join(commitDir, "source.tar.gz"))
+	assert.NoDirExists(t, filepath.Join(commitDir, "src"))
+	assert.NoFileExists(t, filepath.Join(commitDir, "bin"))
+	info, err := targets.ReadBuildInfo(commitDir)
+	require.NoError(t, err)
+	assert.Equal(t, targets.BuildStatusArchived, info.Status)
+	assert.Equal(t, hash, info.Commit)
+	text, err := os.ReadFile(filepath.Join(commitDir, "build-info.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(text), "Status: archived, not built\n")
+
+	// run refuses the archive, list labels it and gc keeps it
+	r := newRunCommand()
+	r.cmd.SetOut(&bytes.Buffer{})
+	_, err = r.executeRun("tool", "", nil)
+	assert.ErrorContains(t, err, "--archive-only")
+
+	out.Reset()
+	l := newListCommand()
+	l.cmd.SetOut(&out)
+	l.cmd.SetArgs([]string{"tool"})
+	require.NoError(t, l.cmd.Execute())
+	assert.Contains(t, out.String(), hash[:7]+" (archived on ")
+
+	items, _, err := planGC(root, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	c = newBuildCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetErr(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--archive-only", "--keep-clone"})
+	assert.EqualError(t, c.cmd.Execute(), "--archive-only cannot be combined with --keep-clone or --cas")
+}
+
+func TestBuildCASSharesSourceFiles(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+
+	for _, extra := range [][]string{nil, {"--build-in-temp"}} {
+		t.Run(strings.Join(append([]string{"cas"}, extra...), " "), func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			t.Setenv("TMPDIR", t.TempDir())
+			useTestConfig(t, fmt.Sprintf(`targets:
+  tool:
+    source: %[1]s
+    default-branch: master
+    build-command:
+      linux: make
+      darwin: make
+  tool-copy:
+    source: %[1]s
+    default-branch: master
+    build-command:
+      linux: make
+      darwin: make
+`, repoDir))
+
+			for _, target := range []string{"tool", "tool-copy"} {
+				c := newBuildCommand()
+				c.runner = &exec.Fake{}
+				c.cmd.SetOut(&bytes.Buffer{})
+				c.cmd.SetArgs(append([]string{target, "--cas"}, extra...))
+				require.NoError(t, c.cmd.Execute())
+				assert.NoFileExists(t, filepath.Join(root, target, hash[:7], "source.tar.gz"))
+			}
+
+			first, err := os.Stat(filepath.Join(root, "tool", hash[:7], "src", "README"))
+			require.NoError(t, err)
+			second, err := os.Stat(filepath.Join(root, "tool-copy", hash[:7], "src", "README"))
+			require.NoError(t, err)
+			if runtime.GOOS == "windows" && !os.SameFile(first, second) {
+				t.Skip("hard links are not supported")
+			}
+			assert.True(t, os.SameFile(first, second), "identical source files of both builds should share an inode")
+			assert.DirExists(t, filepath.Join(root, targets.CASDirName))
+		})
+	}
+}
+
+func TestBuildPrintPlan(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	runPlan := func(args ...string) buildPlan {
+		t.Helper()
+		fake := &exec.Fake{}
+		c := newBuildCommand()
+		c.runner = fake
+		var out bytes.Buffer
+		c.cmd.SetOut(&out)
+		c.cmd.SetErr(&bytes.Buffer{})
+		c.cmd.SetArgs(append([]string{"tool", "--print-plan", "--output", "json"}, args...))
+		require.NoError(t, c.cmd.Execute())
+		assert.Empty(t, fake.Calls())
+		var plan buildPlan
+		require.NoError(t, json.Unmarshal(out.Bytes(), &plan), out.String())
+		return plan
+	}
+
+	// Planning writes nothing, not even the target directory
+	plan := runPlan()
+	assert.Equal(t, buildActionBuild, plan.Action)
+	assert.Equal(t, hash, plan.Commit)
+	assert.Equal(t, "master", plan.Ref)
+	assert.Equal(t, "make", plan.Command)
+	assert.Equal(t, filepath.Join(root, "tool", hash[:7]), plan.CommitDir)
+	assert.Equal(t, "bin/app", plan.BinaryPath)
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", hash[:7]), 0755))
+	plan = runPlan()
+	assert.Equal(t, buildActionSkip, plan.Action)
+	assert.Equal(t, hash[:7], plan.ShortHash)
+	plan = runPlan("--force")
+	assert.Equal(t, buildActionRebuild, plan.Action)
+	require.NoError(t, targets.WriteBuildInfo(filepath.Join(root, "tool", hash[:7]), &targets.BuildInfo{Status: targets.BuildStatusFailed}))
+	plan = runPlan()
+	assert.Equal(t, buildActionRebuild, plan.Action)
+
+	c := newBuildCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetErr(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--output", "json"})
+	err = c.cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "--output is only supported with --print-plan")
+}
+
+func TestBuildResolvesTokenOnce(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	calls := 0
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.tokens = &vcsutils.TokenCache{Resolve: func() (string, error) {
+		calls++
+		return "secret", nil
+	}}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--use-token"})
+	require.NoError(t, c.cmd.Execute())
+	assert.DirExists(t, filepath.Join(root, "tool", hash[:7]))
+	assert.Equal(t, 1, calls)
+}
+
+func TestBuildWarningSummary(t *testing.T) {
+	repoDir, _ := createTestSourceRepo(t)
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	// The fake build produces no binary to copy, and labels are not
+	// recorded without metadata
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	var out bytes.Buffer
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", "--no-metadata", "--label", "env=ci"})
+	require.NoError(t, c.cmd.Execute())
+
+	summary := out.String()[strings.Index(out.String(), "Completed with"):]
+	assert.Contains(t, summary, "Completed with 2 warnings:\n")
+	assert.Contains(t, summary, "  - Labels are not recorded because build metadata is disabled\n")
+	assert.Contains(t, summary, "  - Failed to copy binary: ")
+
+	// The plan carries the warnings instead of a summary
+	c = newBuildCommand()
+	c.runner = &exec.Fake{}
+	out.Reset()
+	c.cmd.SetOut(&out)
+	c.cmd.SetErr(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "--print-plan", "--output", "json", "--no-metadata", "--label", "env=ci"})
+	require.NoError(t, c.cmd.Execute())
+	var plan buildPlan
+	require.NoError(t, json.Unmarshal(out.Bytes(), &plan), out.String())
+	assert.Equal(t, []string{"Labels are not recorded because build metadata is disabled"}, plan.Warnings)
+	assert.NotContains(t, out.String(), "Completed with")
+}
+
+func TestBuildCompletionOffersRemoteRefs(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, "https://example.com/tool.git", "make", "")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", "abc1234"), 0755))
+
+	calls := 0
+	c := newBuildCommand()
+	c.lsRemote = func(source string) ([]vcsutils.RemoteRef, error) {
+		calls++
+		assert.Equal(t, "https://example.com/tool.git", source)
+		return []vcsutils.RemoteRef{
+			{Name: "main", Kind: "branch", Hash: "abc1234def5678abc1234def5678abc1234def56"},
+			{Name: "v1.0.0", Kind: "tag", Hash: "1234567890abcdef1234567890abcdef12345678"},
+		}, nil
+	}
+
+	completions, directive := c.cmd.ValidArgsFunction(c.cmd, []string{"tool"}, "")
+	assert.Equal(t, []string{"abc1234", "main", "v1.0.0"}, completions)
+	assert.Equal(t, cobra.ShellCompDirectiveNoFileComp, directive)
+	assert.Equal(t, []string{"v1.0.0"}, c.getCompletionCommits("tool", "v"))
+	assert.Equal(t, 1, calls, "remote refs should be listed once and then read from the cache")
+
+	// Without a fresh cache, a failing listing falls back to local builds
+	require.NoError(t, os.RemoveAll(filepath.Join(root, refCacheDirName)))
+	c.lsRemote = func(string) ([]vcsutils.RemoteRef, error) {
+		return nil, fmt.Errorf("network unreachable")
+	}
+	assert.Equal(t, []string{"abc1234"}, c.getCompletionCommits("tool", ""))
+
+	// A remote that does not answer in time offers no references either
+	originalTimeout := refListTimeout
+	t.Cleanup(func() { refListTimeout = originalTimeout })
+	refListTimeout = 50 * time.Millisecond
+	unblock := make(chan struct{})
+	defer close(unblock)
+	c.lsRemote = func(string) ([]vcsutils.RemoteRef, error) {
+		<-unblock
+		return []vcsutils.RemoteRef{{Name: "late", Kind: "branch"}}, nil
+	}
+	start := time.Now()
+	assert.Equal(t, []string{"abc1234"}, c.getCompletionCommits("tool", ""))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestBuildFromPR(t *testing.T) {
+	t.Run("non-GitHub source is rejected", func(t *testing.T) {
+		repoDir, _ := createTestSourceRepo(t)
+		useTestNigiriRoot(t)
+		useTestBuildConfig(t, repoDir, "make", "")
+
+		fake := &exec.Fake{}
+		c := newBuildCommand()
+		c.runner = fake
+		c.cmd.SetOut(&bytes.Buffer{})
+		c.cmd.SetArgs([]string{"tool", "--from-pr", "123"})
+		err := c.cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "pull request #123")
+		assert.Contains(t, err.Error(), "only be built from GitHub sources")
+		assert.Empty(t, fake.Calls())
+	})
+
+	t.Run("commit argument is rejected", func(t *testing.T) {
+		useTestNigiriRoot(t)
+		useTestBuildConfig(t, t.TempDir(), "make", "")
+		c := newBuildCommand()
+		c.cmd.SetOut(&bytes.Buffer{})
+		c.cmd.SetArgs([]string{"tool", "abc1234", "--from-pr", "123"})
+		err := c.cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--from-pr cannot be combined with a commit")
+	})
+
+	t.Run("pull request number is recorded", func(t *testing.T) {
+		commitDir := t.TempDir()
+		c := newBuildCommand()
+		c.cmd.SetOut(&bytes.Buffer{})
+		c.writeBuildMetadata(commitDir, targets.MetadataFull, &targets.BuildInfo{
+			Commit:      "1234567890abcdef1234567890abcdef12345678",
+			ShortHash:   "1234567",
+			Ref:         vcsutils.PullRequestRef(123),
+			PullRequest: 123,
+			Status:      targets.BuildStatusSuccess,
+		})
+
+		info, err := targets.ReadBuildInfo(commitDir)
+		require.NoError(t, err)
+		assert.Equal(t, 123, info.PullRequest)
+		assert.Equal(t, "refs/pull/123/head", info.Ref)
+		text, err := os.ReadFile(filepath.Join(commitDir, "build-info.txt"))
+		require.NoError(t, err)
+		assert.Contains(t, string(text), "Pull request: #123\n")
+	})
+}
+
+func TestBuildDirName(t *testing.T) {
+	tests := []struct {
+		shortHash string
+		tag       string
+		want      string
+	}{
+		{shortHash: "abc1234", want: "abc1234"},
+		{shortHash: "abc1234", tag: "v1.2.3", want: "abc1234-v1.2.3"},
+		{shortHash: "abc1234", tag: "release/1.0", want: "abc1234-release_1.0"},
+		{shortHash: "abc1234", tag: "v1+build:2", want: "abc1234-v1_build_2"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, buildDirName(tt.shortHash, tt.tag), "buildDirName(%q, %q)", tt.shortHash, tt.tag)
+	}
+}
+
+func TestBuildTag(t *testing.T) {
+	repoDir, tagged := createTestSourceRepo(t)
+	r, err := git.PlainOpen(repoDir)
+	require.NoError(t, err)
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	_, err = r.CreateTag("release/1.0", plumbing.NewHash(tagged), &git.CreateTagOptions{Tagger: sig, Message: "release 1.0"})
+	require.NoError(t, err)
+	// Move master past the tagged commit
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "CHANGES"), []byte("next\n"), 0644))
+	_, err = w.Add("CHANGES")
+	require.NoError(t, err)
+	_, err = w.Commit("next", &git.CommitOptions{Author: sig})
+	require.NoError(t, err)
+
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	var out bytes.Buffer
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", "--tag", "release/1.0"})
+	require.NoError(t, c.cmd.Execute())
+
+	commitDir := filepath.Join(root, "tool", tagged[:7]+"-release_1.0")
+	info, err := targets.ReadBuildInfo(commitDir)
+	require.NoError(t, err)
+	assert.Equal(t, tagged, info.Commit)
+	assert.Equal(t, tagged[:7], info.ShortHash)
+	assert.Equal(t, "release/1.0", info.Tag)
+	assert.Equal(t, "refs/tags/release/1.0", info.Ref)
+	assert.NoDirExists(t, filepath.Join(root, "tool", tagged[:7]))
+	assert.Contains(t, out.String(), "Run with: nigiri run tool "+tagged[:7]+"-release_1.0\n")
+	text, err := os.ReadFile(filepath.Join(commitDir, targets.BuildInfoTextFileName))
+	require.NoError(t, err)
+	assert.Contains(t, string(text), "Tag: release/1.0\n")
+
+	// The tag build is found again by the tag
+	out.Reset()
+	c = newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", "--tag", "refs/tags/release/1.0"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "has already been built")
+
+	t.Run("missing tag", func(t *testing.T) {
+		c := newBuildCommand()
+		c.runner = &exec.Fake{}
+		c.cmd.SetOut(&bytes.Buffer{})
+		c.cmd.SetArgs([]string{"tool", "--tag", "v9.9.9"})
+		err := c.cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "tag 'v9.9.9' not found")
+	})
+
+	for _, args := range [][]string{
+		{"tool", "abc1234", "--tag", "v1.0"},
+		{"tool", "--from-pr", "1", "--tag", "v1.0"},
+	} {
+		c := newBuildCommand()
+		c.cmd.SetOut(&bytes.Buffer{})
+		c.cmd.SetArgs(args)
+		err := c.cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--tag cannot be combined with a commit or --from-pr")
+	}
+}
+
+func TestBuildOlderCommitFromShallowClone(t *testing.T) {
+	repoDir, older := createTestSourceRepo(t)
+	r, err := git.PlainOpen(repoDir)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	for i := 0; i < 3; i++ {
+		_, err = w.Commit(fmt.Sprintf("next %d", i), &git.CommitOptions{Author: sig, AllowEmptyCommits: true})
+		require.NoError(t, err)
+	}
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	// The default depth of 1 is kept and the checkout deepens the clone
+	var out bytes.Buffer
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", older[:7], "--no-cache", "--keep-clone"})
+	require.NoError(t, c.cmd.Execute())
+	assert.NotContains(t, out.String(), "cloning full history")
+
+	commitDir := filepath.Join(root, "tool", older[:7])
+	info, err := targets.ReadBuildInfo(commitDir)
+	require.NoError(t, err)
+	assert.Equal(t, older, info.Commit)
+	clone, err := git.PlainOpen(filepath.Join(commitDir, "src"))
+	require.NoError(t, err)
+	head, err := clone.Head()
+	require.NoError(t, err)
+	assert.Equal(t, older, head.Hash().String())
+}
+
+func TestBuildSubmodules(t *testing.T) {
+	subDir, subHash := createTestSourceRepo(t)
+	repoDir, _ := createTestSourceRepoWithFiles(t, map[string]string{
+		".gitmodules": "[submodule \"sub\"]\n\tpath = sub\n\turl = " + subDir + "\n",
+	})
+	// go-git cannot add a submodule, so its gitlink is written to the index
+	r, err := git.PlainOpen(repoDir)
+	require.NoError(t, err)
+	idx, err := r.Storer.Index()
+	require.NoError(t, err)
+	idx.Entries = append(idx.Entries, &index.Entry{Name: "sub", Hash: plumbing.NewHash(subHash), Mode: filemode.Submodule})
+	require.NoError(t, r.Storer.SetIndex(idx))
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	_, err = w.Commit("add submodule", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		extra   string
+		args    []string
+		wantSub bool
+	}{
+		{name: "not cloned by default"},
+		{name: "flag", args: []string{"--submodules"}, wantSub: true},
+		{name: "config", extra: "submodules: true", wantSub: true},
+		{name: "flag overrides config", extra: "submodules: true", args: []string{"--submodules=false"}},
+		{name: "without the clone cache", args: []string{"--submodules", "--no-cache"}, wantSub: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make", tt.extra)
+
+			c := newBuildCommand()
+			c.runner = &exec.Fake{}
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs(append([]string{"tool", "--keep-clone"}, tt.args...))
+			require.NoError(t, c.cmd.Execute())
+
+			builds, err := storage.NewLocal(root).List("tool")
+			require.NoError(t, err)
+			require.Len(t, builds, 1)
+			subFile := filepath.Join(root, "tool", builds[0].Name, "src", "sub", "README")
+			if tt.wantSub {
+				assert.FileExists(t, subFile)
+			} else {
+				assert.NoFileExists(t, subFile)
+			}
+		})
+	}
+}
+
+// useTestMultiTargetConfig writes a configuration with the targets tool and
+// other, both built from source with command
+func useTestMultiTargetConfig(t *testing.T, source, command string) string {
+	t.Helper()
+	var content strings.Builder
+	content.WriteString("targets:\n")
+	for _, name := range []string{"tool", "other"} {
+		fmt.Fprintf(&content, "  %s:\n    source: %s\n    default-branch: master\n    build-command:\n      linux: %q\n      darwin: %q\n", name, source, command, command)
+	}
+	return useTestConfig(t, content.String())
+}
+
+func TestBuildMultipleTargets(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+
+	tests := []struct {
+		name       string
+		args       []string
+		failOther  bool
+		wantErr    string
+		wantBuilt  []string
+		wantOutput []string
+		wantRows   []string
+	}{
+		{
+			name:       "targets as arguments",
+			args:       []string{"tool", "other", "--jobs", "2"},
+			wantBuilt:  []string{"tool", "other"},
+			wantOutput: []string{"[tool] Target 'tool' built", "[other] Target 'other' built", "Build summary:"},
+			wantRows:   []string{`tool\s+success`, `other\s+success`},
+		},
+		{
+			name:      "all targets",
+			args:      []string{"--all", "-j", "1"},
+			wantBuilt: []string{"other", "tool"},
+			wantRows:  []string{`other\s+success`, `tool\s+success`},
+		},
+		{
+			name:       "failed target is reported",
+			args:       []string{"--all"},
+			failOther:  true,
+			wantErr:    "failed to build 1 of 2 targets: other",
+			wantBuilt:  []string{"tool"},
+			wantOutput: []string{"  other: build failed"},
+			wantRows:   []string{`other\s+failed`, `tool\s+success`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestMultiTargetConfig(t, repoDir, "make")
+			fake := &exec.Fake{Handler: func(call exec.Call) ([]byte, []byte, error) {
+				if tt.failOther && strings.Contains(call.Opts.Dir, string(filepath.Separator)+"other"+string(filepath.Separator)) {
+					return nil, nil, &exec.ExitError{Code: 2}
+				}
+				return nil, nil, nil
+			}}
+
+			var out bytes.Buffer
+			c := newBuildCommand()
+			c.runner = fake
+			c.cmd.SetOut(&out)
+			c.cmd.SetErr(&out)
+			c.cmd.SetArgs(tt.args)
+			err := c.cmd.Execute()
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			for _, name := range tt.wantBuilt {
+				assert.DirExists(t, filepath.Join(root, name, hash[:7]))
+			}
+			for _, want := range tt.wantOutput {
+				assert.Contains(t, out.String(), want)
+			}
+			for _, row := range tt.wantRows {
+				assert.Regexp(t, `(?m)^  `+row+`\s+`+hash[:7]+`\s`, out.String())
+			}
+
+			// Each build runs in its own source directory
+			dirs := map[string]bool{}
+			for _, call := range fake.Calls() {
+				dirs[call.Opts.Dir] = true
+			}
+			assert.Len(t, dirs, 2)
+		})
+	}
+}
+
+func TestBuildMultipleTargetsOutputJSON(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestMultiTargetConfig(t, repoDir, "make")
+	// A previous build of tool makes it up to date
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", hash[:7]), 0755))
+	fake := &exec.Fake{Handler: func(call exec.Call) ([]byte, []byte, error) {
+		return nil, nil, &exec.ExitError{Code: 2}
+	}}
+
+	var stdout, stderr bytes.Buffer
+	c := newBuildCommand()
+	c.runner = fake
+	c.cmd.SetOut(&stdout)
+	c.cmd.SetErr(&stderr)
+	c.cmd.SetArgs([]string{"--all", "--output", "json"})
+	err := c.cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to build 1 of 2 targets: other")
+
+	var results []BuildResult
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &results), stdout.String())
+	require.Len(t, results, 2)
+	assert.Equal(t, "other", results[0].Target)
+	assert.Equal(t, BuildResultFailed, results[0].Status)
+	assert.Equal(t, hash[:7], results[0].Commit)
+	assert.Contains(t, results[0].Error, "build failed")
+	assert.Equal(t, "tool", results[1].Target)
+	assert.Equal(t, BuildResultUpToDate, results[1].Status)
+	assert.Empty(t, results[1].Error)
+	// The build output is kept off stdout
+	assert.Contains(t, stderr.String(), "[tool] Commit "+hash[:7]+" has already been built")
+}
+
+func TestBuildMultipleTargetsLeavesWorkingDirectory(t *testing.T) {
+	repoDir, _ := createTestSourceRepo(t)
+	useTestNigiriRoot(t)
+	useTestMultiTargetConfig(t, repoDir, "make")
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+
+	c := newBuildCommand()
+	c.runner = &exec.Fake{}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "other"})
+	require.NoError(t, c.cmd.Execute())
+
+	after, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, wd, after)
+}
+
+func TestBuildMultipleTargetsRejectsInvalidArguments(t *testing.T) {
+	useTestNigiriRoot(t)
+	useTestMultiTargetConfig(t, t.TempDir(), "make")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "all with targets", args: []string{"tool", "--all"}, wantErr: "--all cannot be combined with target names"},
+		{name: "zero jobs", args: []string{"--all", "--jobs", "0"}, wantErr: "--jobs must be at least 1"},
+		{name: "repeated target", args: []string{"tool", "other", "tool"}, wantErr: "target 'tool' is given more than once"},
+		{name: "print plan", args: []string{"--all", "--print-plan"}, wantErr: "build a single target"},
+		{name: "tag", args: []string{"tool", "other", "--tag", "v1.0"}, wantErr: "build a single target"},
+		{name: "ssh with token", args: []string{"--all", "--ssh", "--use-token"}, wantErr: "--ssh cannot be combined with --use-token"},
+		{name: "archive only with keep clone", args: []string{"tool", "other", "--archive-only", "--keep-clone"}, wantErr: "--archive-only cannot be combined"},
+		{name: "archive only with cas", args: []string{"--all", "--archive-only", "--cas"}, wantErr: "--archive-only cannot be combined"},
+		{name: "negative pull request", args: []string{"tool", "other", "--from-pr", "-1"}, wantErr: "invalid pull request number -1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newBuildCommand()
+			c.runner = &exec.Fake{}
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs(tt.args)
+			err := c.cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestBuildLeavesWorkingDirectoryUntouched(t *testing.T) {
+	repoDir, hash := createTestSourceRepoWithFiles(t, map[string]string{"app/main.go": "package main\n"})
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make app", "working-directory: app")
+
+	// A relative root must still produce absolute build paths
+	cwd := t.TempDir()
+	t.Chdir(cwd)
+	nigiriRoot = "root"
+	commitDir := filepath.Join(cwd, "root", "tool", hash[:7])
+
+	fake := &exec.Fake{Handler: func(call exec.Call) ([]byte, []byte, error) {
+		binDir := filepath.Join(call.Opts.Dir, "bin")
+		require.NoError(t, os.MkdirAll(binDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(binDir, "app"), []byte("binary"), 0755))
+		return nil, nil, nil
+	}}
+
+	// Build twice back to back, the second time rebuilding the same commit
+	for _, args := range [][]string{{"tool"}, {"tool", "--force"}} {
+		c := newBuildCommand()
+		c.runner = fake
+		c.cmd.SetOut(&bytes.Buffer{})
+		c.cmd.SetArgs(args)
+		require.NoError(t, c.cmd.Execute())
+
+		wd, err := os.Getwd()
+		require.NoError(t, err)
+		assert.Equal(t, cwd, wd)
+
+		assert.FileExists(t, filepath.Join(commitDir, "bin"))
+		assert.FileExists(t, filepath.Join(commitDir, "source.tar.gz"))
+		assert.FileExists(t, filepath.Join(commitDir, "logs", "build.log"))
+		assert.FileExists(t, filepath.Join(commitDir, targets.BuildInfoFileName))
+		assert.NoDirExists(t, filepath.Join(commitDir, "src"))
+	}
+
+	calls := fake.Calls()
+	require.Len(t, calls, 2)
+	for _, call := range calls {
+		assert.True(t, filepath.IsAbs(call.Opts.Dir))
+		assert.Equal(t, filepath.Join(commitDir, "src", "app"), call.Opts.Dir)
+	}
+}
+
+func TestPruneOldBuilds(t *testing.T) {
+	store := storage.NewMemory()
+	now := time.Now()
+	for i, build := range []string{"aaa1111", "bbb2222", "ccc3333", "ddd4444", "eee5555"} {
+		_, err := store.CreateCommitDir("tool", build)
+		require.NoError(t, err)
+		require.NoError(t, store.SetModTime("tool", build, now.Add(time.Duration(i)*time.Hour)))
+	}
+
+	// eee5555 just finished and bbb2222 is protected, so aaa1111 goes instead
+	protected := func(build string) bool { return build == "bbb2222" }
+	removed, err := pruneOldBuilds(store, "tool", 3, "eee5555", protected)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"aaa1111"}, removed)
+
+	builds, err := store.List("tool")
+	require.NoError(t, err)
+	var names []string
+	for _, build := range builds {
+		names = append(names, build.Name)
+	}
+	assert.Equal(t, []string{"eee5555", "ddd4444", "ccc3333", "bbb2222"}, names)
+
+	removed, err = pruneOldBuilds(store, "tool", 10, "eee5555", protected)
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+}
+
+func TestBuildValidatesCommit(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+
+	tests := []struct {
+		name    string
+		commit  string
+		wantErr string
+	}{
+		{name: "upper-case full hash", commit: strings.ToUpper(hash)},
+		{name: "not a hash", commit: "zzzzzzzz", wantErr: "invalid commit: hash contains 'z'"},
+		{name: "too long", commit: strings.Repeat("a", 65), wantErr: "invalid commit: hash is too long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make", "")
+
+			fake := &exec.Fake{}
+			c := newBuildCommand()
+			c.runner = fake
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs([]string{"tool", tt.commit})
+			err := c.cmd.Execute()
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				// No commit directory is created for an invalid commit
+				entries, _ := os.ReadDir(filepath.Join(root, "tool"))
+				assert.Empty(t, entries)
+				return
+			}
+			require.NoError(t, err)
+			info, err := targets.ReadBuildInfo(filepath.Join(root, "tool", hash[:7]))
+			require.NoError(t, err)
+			assert.Equal(t, hash, info.Commit)
+		})
+	}
+}
+
+func TestBuildShortHashLength(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	// otherCommit returns the hash of another commit sharing the first n
+	// characters of the built one
+	otherCommit := func(n int) string {
+		fill := "0"
+		if hash[n] == '0' {
+			fill = "f"
+		}
+		return hash[:n] + strings.Repeat(fill, len(hash)-n)
+	}
+
+	tests := []struct {
+		name  string
+		extra string
+		// existingDir holds a build of existingCommit before the build
+		existingDir    string
+		existingCommit string
+		wantDir        string
+	}{
+		{name: "default length", wantDir: hash[:7]},
+		{name: "configured length", extra: "short-hash-length: 10", wantDir: hash[:10]},
+		{name: "lengthened on collision", existingDir: hash[:7], existingCommit: otherCommit(7), wantDir: hash[:8]},
+		{name: "lengthened from configured length", extra: "short-hash-length: 10", existingDir: hash[:10], existingCommit: otherCommit(10), wantDir: hash[:11]},
+		{name: "existing build of the commit", existingDir: hash[:7], existingCommit: hash, wantDir: hash[:7]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make", tt.extra)
+			if tt.existingDir != "" {
+				existingDir := filepath.Join(root, "tool", tt.existingDir)
+				require.NoError(t, os.MkdirAll(existingDir, 0755))
+				require.NoError(t, targets.WriteBuildInfo(existingDir, &targets.BuildInfo{Commit: tt.existingCommit}))
+			}
+
+			c := newBuildCommand()
+			c.runner = &exec.Fake{}
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs([]string{"tool"})
+			require.NoError(t, c.cmd.Execute())
+
+			info, err := targets.ReadBuildInfo(filepath.Join(root, "tool", tt.wantDir))
+			require.NoError(t, err)
+			assert.Equal(t, hash, info.Commit)
+		})
+	}
+}
+
+func TestCheckCommitPrefix(t *testing.T) {
+	useTestBuildConfig(t, "https://example.com/tool.git", "make", "short-hash-length: 10")
+
+	assert.NoError(t, checkCommitPrefix("tool", "abcdef1234"))
+	err := checkCommitPrefix("tool", "abcdef1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "commit hash is too short: abcdef1 (minimum 10 characters)")
+	// Targets that are not configured use the default length
+	assert.NoError(t, checkCommitPrefix("other", "abcdef1"))
+	assert.Error(t, checkCommitPrefix("other", "abcdef"))
+}
+
+// triggerWriter calls fn the first time a write contains match
+type triggerWriter struct {
+	bytes.Buffer
+	match string
+	fn    func()
+}
+
+func (w *triggerWriter) Write(p []byte) (int, error) {
+	if w.fn != nil && bytes.Contains(p, []byte(w.match)) {
+		w.fn()
+		w.fn = nil
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestBuildChecksOutResolvedCommitWhenBranchMoved(t *testing.T) {
+	for _, noCache := range []bool{false, true} {
+		t.Run(fmt.Sprintf("no-cache=%t", noCache), func(t *testing.T) {
+			repoDir, resolved := createTestSourceRepo(t)
+			root := useTestNigiriRoot(t)
+			useTestBuildConfig(t, repoDir, "make", "")
+
+			// The branch moves after its HEAD was resolved, before the clone
+			out := &triggerWriter{match: "Cloning repository", fn: func() {
+				r, err := git.PlainOpen(repoDir)
+				require.NoError(t, err)
+				w, err := r.Worktree()
+				require.NoError(t, err)
+				sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+				_, err = w.Commit("next", &git.CommitOptions{Author: sig, AllowEmptyCommits: true})
+				require.NoError(t, err)
+			}}
+			c := newBuildCommand()
+			c.runner = &exec.Fake{}
+			c.noCache = noCache
+			c.cmd.SetOut(out)
+			err := c.executeBuild("tool")
+			require.NoError(t, err)
+			assert.Contains(t, out.String(), "The branch moved to")
+			info, err := targets.ReadBuildInfo(filepath.Join(root, "tool", resolved[:7]))
+			require.NoError(t, err)
+			assert.Equal(t, resolved, info.Commit)
+			assert.Equal(t, resolved[:7], info.ShortHash)
 		})
 	}
 }
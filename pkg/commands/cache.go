@@ -0,0 +1,276 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/spf13/cobra"
+)
+
+// cacheCommand represents the structure for the cache command
+type cacheCommand struct {
+	cmd *cobra.Command
+}
+
+// newCacheCommand creates a new cache command instance which groups the
+// subcommands that inspect and prune the clone cache.
+//
+// Returns:
+//   - *cacheCommand: A configured cache command instance
+func newCacheCommand() *cacheCommand {
+	c := &cacheCommand{}
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the clone cache",
+		Long: `Inspect and prune the clone cache, which keeps one clone of each source
+repository under the nigiri root so repeated builds only need to fetch.`,
+	}
+	cmd.AddCommand(newCacheListCommand().cmd)
+	cmd.AddCommand(newCacheInfoCommand().cmd)
+	cmd.AddCommand(newCacheCleanCommand().cmd)
+	c.cmd = cmd
+	return c
+}
+
+// cloneCacheDir returns the directory of the clone cache in the nigiri root
+func cloneCacheDir() string {
+	return filepath.Join(nigiriRoot, targets.CloneCacheDirName)
+}
+
+// formatLastFetch formats the last fetch time of a cache entry
+func formatLastFetch(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// cacheListCommand represents the structure for the cache list command
+type cacheListCommand struct {
+	cmd *cobra.Command
+}
+
+// newCacheListCommand creates a new cache list command instance which shows
+// the cached repositories with their sizes and last fetch times.
+//
+// Returns:
+//   - *cacheListCommand: A configured cache list command instance
+func newCacheListCommand() *cacheListCommand {
+	c := &cacheListCommand{}
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List cached repositories",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeList()
+		},
+	}
+	c.cmd = cmd
+	return c
+}
+
+// executeList prints every entry of the clone cache
+//
+// Returns:
+//   - error: Any error encountered while reading the cache
+func (c *cacheListCommand) executeList() error {
+	entries, err := targets.ListCloneCache(cloneCacheDir())
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		c.cmd.Println("The clone cache is empty.")
+		return nil
+	}
+
+	var total int64
+	for _, entry := range entries {
+		size, _ := dirutils.GetDirSize(entry.Path)
+		total += size
+		source := entry.Source
+		if source == "" {
+			source = entry.Key
+		}
+		inUse := ""
+		if entry.Locked {
+			inUse = "  (in use)"
+		}
+		c.cmd.Printf("%-50s  %8.2f MB  fetched %s%s\n", source, float64(size)/(1024*1024), formatLastFetch(entry.LastFetch), inUse)
+	}
+	c.cmd.Printf("\n%d cached repositories, %.2f MB total\n", len(entries), float64(total)/(1024*1024))
+	return nil
+}
+
+// cacheInfoCommand represents the structure for the cache info command
+type cacheInfoCommand struct {
+	cmd *cobra.Command
+}
+
+// newCacheInfoCommand creates a new cache info command instance which shows
+// the details of the cache entry of one repository.
+//
+// Returns:
+//   - *cacheInfoCommand: A configured cache info command instance
+func newCacheInfoCommand() *cacheInfoCommand {
+	c := &cacheInfoCommand{}
+	cmd := &cobra.Command{
+		Use:   "info <url>",
+		Short: "Show the cache entry of a repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeInfo(args[0])
+		},
+	}
+	c.cmd = cmd
+	return c
+}
+
+// executeInfo prints the cache entry of the repository at source
+//
+// Parameters:
+//   - source: The source URL of the repository
+//
+// Returns:
+//   - error: An error if the repository is not cached
+func (c *cacheInfoCommand) executeInfo(source string) error {
+	key := targets.CloneCacheKey(source)
+	entry, err := targets.ReadCloneCacheEntry(filepath.Join(cloneCacheDir(), key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("repository %s is not cached", source)
+		}
+		return fmt.Errorf("failed to read cache entry: %w", err)
+	}
+	size, _ := dirutils.GetDirSize(entry.Path)
+
+	c.cmd.Printf("Source:     %s\n", entry.Source)
+	c.cmd.Printf("Key:        %s\n", entry.Key)
+	c.cmd.Printf("Path:       %s\n", entry.Path)
+	c.cmd.Printf("Size:       %.2f MB\n", float64(size)/(1024*1024))
+	c.cmd.Printf("Last fetch: %s\n", formatLastFetch(entry.LastFetch))
+	c.cmd.Printf("In use:     %t\n", entry.Locked)
+	return nil
+}
+
+// cacheCleanCommand represents the structure for the cache clean command
+type cacheCleanCommand struct {
+	cmd         *cobra.Command
+	all         bool
+	olderThan   time.Duration
+	dryRun      bool
+	skipConfirm bool
+}
+
+// newCacheCleanCommand creates a new cache clean command instance which
+// removes cache entries, either all of them or those not fetched recently.
+//
+// Returns:
+//   - *cacheCleanCommand: A configured cache clean command instance
+func newCacheCleanCommand() *cacheCleanCommand {
+	c := &cacheCleanCommand{}
+	cmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove cached repositories",
+		Long: `Remove entries from the clone cache.
+With --all, every entry is removed; with --older-than, only entries that have
+not been fetched within the given duration. Entries in use by a running clone
+or fetch are always kept.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if c.all == (c.olderThan > 0) {
+				return fmt.Errorf("specify exactly one of --all or --older-than")
+			}
+			return c.executeClean()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVarP(&c.all, "all", "A", false, "Remove every cache entry")
+	flags.DurationVar(&c.olderThan, "older-than", 0, "Remove entries not fetched within this duration (e.g. 720h)")
+	flags.BoolVarP(&c.dryRun, "dry-run", "d", false, "Show what would be removed without actually removing anything")
+	flags.BoolVarP(&c.skipConfirm, "yes", "y", false, "Skip confirmation prompt")
+
+	c.cmd = cmd
+	return c
+}
+
+// executeClean removes the selected cache entries
+//
+// Returns:
+//   - error: Any error encountered while reading the cache
+func (c *cacheCleanCommand) executeClean() error {
+	entries, err := targets.ListCloneCache(cloneCacheDir())
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-c.olderThan)
+	var selected []targets.CloneCacheEntry
+	var total int64
+	for _, entry := range entries {
+		if !c.all && entry.LastFetch.After(cutoff) {
+			continue
+		}
+		name := entry.Source
+		if name == "" {
+			name = entry.Key
+		}
+		if entry.Locked {
+			c.cmd.Printf("Skipping %s: in use.\n", name)
+			continue
+		}
+		size, _ := dirutils.GetDirSize(entry.Path)
+		total += size
+		selected = append(selected, entry)
+		c.cmd.Printf("  %s (%.2f MB)\n", name, float64(size)/(1024*1024))
+	}
+	if len(selected) == 0 {
+		c.cmd.Println("No cache entries to remove.")
+		return nil
+	}
+	c.cmd.Printf("Found %d cache entries to remove, freeing approximately %.2f MB of disk space.\n",
+		len(selected), float64(total)/(1024*1024))
+
+	if c.dryRun {
+		c.cmd.Println("\nDry run: Nothing was removed.")
+		return nil
+	}
+	if !c.skipConfirm {
+		ok, err := confirm(c.cmd, "\nDo you want to continue?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			c.cmd.Println("Cache clean cancelled.")
+			return nil
+		}
+	}
+
+	removed := 0
+	for _, entry := range selected {
+		// A clone may have started using the entry since it was listed, and
+		// none may start while it is removed
+		release, locked, err := targets.TryAcquireCloneCacheLock(entry.Path)
+		if err != nil {
+			c.cmd.Printf("Warning: Failed to lock %s: %v\n", entry.Key, err)
+			continue
+		}
+		if !locked {
+			c.cmd.Printf("Skipping %s: in use.\n", entry.Key)
+			continue
+		}
+		err = os.RemoveAll(entry.Path)
+		release()
+		if err != nil {
+			c.cmd.Printf("Warning: Failed to remove %s: %v\n", entry.Key, err)
+			continue
+		}
+		removed++
+	}
+	c.cmd.Printf("%d cache entries removed.\n", removed)
+	return nil
+}
@@ -0,0 +1,112 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestCacheEntry creates a clone cache entry for source holding a file of
+// size bytes, last fetched at fetched
+func createTestCacheEntry(t *testing.T, root, source string, fetched time.Time, size int) string {
+	t.Helper()
+	entryDir := filepath.Join(root, targets.CloneCacheDirName, targets.CloneCacheKey(source))
+	require.NoError(t, os.MkdirAll(entryDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(entryDir, "pack"), make([]byte, size), 0644))
+	require.NoError(t, targets.RecordCloneCacheFetch(entryDir, source))
+	require.NoError(t, os.Chtimes(filepath.Join(entryDir, ".fetch"), fetched, fetched))
+	return entryDir
+}
+
+func TestCacheList(t *testing.T) {
+	root := useTestNigiriRoot(t)
+
+	cmd := newCacheCommand()
+	var out bytes.Buffer
+	cmd.cmd.SetOut(&out)
+	cmd.cmd.SetArgs([]string{"list"})
+	require.NoError(t, cmd.cmd.Execute())
+	assert.Contains(t, out.String(), "The clone cache is empty.")
+
+	fetched := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	createTestCacheEntry(t, root, "https://example.com/tool.git", fetched, 1<<20)
+	createTestCacheEntry(t, root, "https://example.com/other", fetched, 10)
+
+	out.Reset()
+	cmd = newCacheCommand()
+	cmd.cmd.SetOut(&out)
+	cmd.cmd.SetArgs([]string{"list"})
+	require.NoError(t, cmd.cmd.Execute())
+	assert.Contains(t, out.String(), "https://example.com/tool.git")
+	assert.Contains(t, out.String(), "https://example.com/other")
+	assert.Contains(t, out.String(), "fetched 2024-05-01 12:00:00")
+	assert.Contains(t, out.String(), "2 cached repositories")
+
+	out.Reset()
+	cmd = newCacheCommand()
+	cmd.cmd.SetOut(&out)
+	cmd.cmd.SetArgs([]string{"info", "git@example.com:tool"})
+	require.NoError(t, cmd.cmd.Execute())
+	assert.Contains(t, out.String(), "Source:     https://example.com/tool.git")
+	assert.Contains(t, out.String(), "Size:       1.00 MB")
+
+	cmd = newCacheCommand()
+	cmd.cmd.SetOut(&out)
+	cmd.cmd.SetErr(&out)
+	cmd.cmd.SetArgs([]string{"info", "https://example.com/missing"})
+	assert.EqualError(t, cmd.cmd.Execute(), "repository https://example.com/missing is not cached")
+}
+
+func TestCacheCleanOlderThan(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	old := createTestCacheEntry(t, root, "https://example.com/old", time.Now().Add(-48*time.Hour), 10)
+	recent := createTestCacheEntry(t, root, "https://example.com/recent", time.Now().Add(-time.Hour), 10)
+	inUse := createTestCacheEntry(t, root, "https://example.com/in-use", time.Now().Add(-48*time.Hour), 10)
+	release, err := targets.AcquireCloneCacheLock(inUse)
+	require.NoError(t, err)
+	defer release()
+
+	cmd := newCacheCommand()
+	var out bytes.Buffer
+	cmd.cmd.SetOut(&out)
+	cmd.cmd.SetArgs([]string{"clean", "--older-than", "24h", "--dry-run"})
+	require.NoError(t, cmd.cmd.Execute())
+	assert.Contains(t, out.String(), "Dry run: Nothing was removed.")
+	assert.DirExists(t, old)
+
+	out.Reset()
+	cmd = newCacheCommand()
+	cmd.cmd.SetOut(&out)
+	cmd.cmd.SetArgs([]string{"clean", "--older-than", "24h", "--yes"})
+	require.NoError(t, cmd.cmd.Execute())
+	assert.Contains(t, out.String(), "Skipping https://example.com/in-use: in use.")
+	assert.Contains(t, out.String(), "1 cache entries removed.")
+	assert.NoDirExists(t, old)
+	assert.DirExists(t, recent)
+	assert.DirExists(t, inUse)
+
+	out.Reset()
+	cmd = newCacheCommand()
+	cmd.cmd.SetOut(&out)
+	cmd.cmd.SetArgs([]string{"clean", "--all", "--yes"})
+	require.NoError(t, cmd.cmd.Execute())
+	assert.NoDirExists(t, recent)
+	assert.DirExists(t, inUse)
+}
+
+func TestCacheCleanRequiresMode(t *testing.T) {
+	useTestNigiriRoot(t)
+	for _, args := range [][]string{{"clean"}, {"clean", "--all", "--older-than", "1h"}} {
+		cmd := newCacheCommand()
+		cmd.cmd.SetOut(&bytes.Buffer{})
+		cmd.cmd.SetErr(&bytes.Buffer{})
+		cmd.cmd.SetArgs(args)
+		assert.Error(t, cmd.cmd.Execute(), args)
+	}
+}
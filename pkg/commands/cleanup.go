@@ -2,8 +2,10 @@ package commands
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,12 +17,17 @@ import (
 
 // cleanupCommand represents the structure for the cleanup command
 type cleanupCommand struct {
-	cmd         *cobra.Command
-	maxAge      int
-	maxBuilds   int
-	dryRun      bool
-	allTargets  bool
-	skipConfirm bool
+	cmd          *cobra.Command
+	maxAge       int
+	maxBuilds    int
+	dryRun       bool
+	allTargets   bool
+	skipConfirm  bool
+	emptyTargets bool
+	// keepRecentlyRun protects builds run within this window from removal
+	keepRecentlyRun time.Duration
+	// maxSize is the disk quota of each target in bytes (0 disables it)
+	maxSize sizeValue
 }
 
 // newCleanupCommand creates a new cleanup command instance which helps users
@@ -35,8 +42,15 @@ func newCleanupCommand() *cleanupCommand {
 		Short: "Clean up old builds",
 		Long: `Clean up old builds to manage disk space.
 If a target is specified, only that target's builds will be cleaned up.
-Without arguments, shows the current disk usage of builds.`,
+Without arguments, shows the current disk usage of builds.
+With --empty-targets, removes target directories that contain no builds.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if c.emptyTargets {
+				if len(args) > 0 {
+					return fmt.Errorf("cannot specify a target with --empty-targets flag")
+				}
+				return c.executeCleanupEmptyTargets()
+			}
 			if len(args) == 0 {
 				if c.allTargets {
 					return c.executeCleanupAll()
@@ -60,11 +74,71 @@ Without arguments, shows the current disk usage of builds.`,
 	flags.BoolVarP(&c.dryRun, "dry-run", "d", false, "Show what would be removed without actually removing anything")
 	flags.BoolVarP(&c.allTargets, "all", "A", false, "Clean up all targets")
 	flags.BoolVarP(&c.skipConfirm, "yes", "y", false, "Skip confirmation prompt")
+	flags.BoolVar(&c.emptyTargets, "empty-targets", false, "Remove target directories that contain no builds")
+	flags.DurationVar(&c.keepRecentlyRun, "keep-recently-run", 0, "Keep builds run within this duration (e.g. 168h) regardless of age or count")
+	flags.Var(&c.maxSize, "max-size", "Maximum total size of the builds of each target such as 500MB or 2GB; the oldest builds are removed until the target fits (0 to disable)")
 
 	c.cmd = cmd
 	return c
 }
 
+// sizeUnits are the multipliers of the units accepted by --max-size. Like the
+// sizes cleanup prints, they are powers of 1024.
+var sizeUnits = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// sizeValue is the value of a --max-size flag in bytes. It accepts a number
+// with an optional unit such as 500MB or 1.5GB; a bare number is bytes.
+type sizeValue int64
+
+// String returns the size in the largest unit that keeps it readable
+func (v *sizeValue) String() string {
+	size := float64(*v)
+	for _, unit := range sizeUnits[:4] {
+		if size >= unit.multiplier {
+			return strconv.FormatFloat(size/unit.multiplier, 'f', -1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatInt(int64(*v), 10) + "B"
+}
+
+// Set parses a size with an optional unit
+//
+// Parameters:
+//   - s: The value given on the command line
+//
+// Returns:
+//   - error: An error if s is not a size, or is negative
+func (v *sizeValue) Set(s string) error {
+	number, multiplier := strings.ToUpper(strings.TrimSpace(s)), 1.0
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSpace(strings.TrimSuffix(number, unit.suffix)), unit.multiplier
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || math.IsNaN(size) || math.IsInf(size, 0) {
+		return fmt.Errorf("invalid size %q: use a number with a unit such as 500MB or 2GB", s)
+	}
+	if size < 0 {
+		return fmt.Errorf("invalid size %q: must not be negative", s)
+	}
+	*v = sizeValue(size * multiplier)
+	return nil
+}
+
+// Type returns the type name shown in the help
+func (v *sizeValue) Type() string {
+	return "size"
+}
+
 // getCompletionTargets returns a list of available targets for command completion
 func (c *cleanupCommand) getCompletionTargets(prefix string) []string {
 	return getInstalledTargets(prefix)
@@ -78,7 +152,7 @@ func (c *cleanupCommand) showDiskUsage() error {
 	entries, err := os.ReadDir(nigiriRoot)
 	if err != nil {
 		if os.IsNotExist(err) {
-			c.cmd.Println("No builds found.")
+			c.cmd.Println(noTargetsMessage)
 			return nil
 		}
 		return fmt.Errorf("failed to read nigiri root directory: %w", err)
@@ -101,7 +175,7 @@ func (c *cleanupCommand) showDiskUsage() error {
 			buildCount := 0
 			if err == nil {
 				for _, buildDir := range buildDirs {
-					if buildDir.IsDir() {
+					if buildDir.IsDir() && !strings.HasPrefix(buildDir.Name(), ".") {
 						buildCount++
 					}
 				}
@@ -118,6 +192,62 @@ func (c *cleanupCommand) showDiskUsage() error {
 	return nil
 }
 
+// keepRunningBuilds reports whether the configuration of target enables
+// keep-running. Cleanup does not require a configuration, so a missing or
+// unreadable one disables the rule.
+//
+// Parameters:
+//   - target: The name of the target
+//
+// Returns:
+//   - bool: True if builds of the target that are being run must be kept
+func keepRunningBuilds(target string) bool {
+	cm := newConfigManager()
+	if err := cm.LoadCfgFile(); err != nil {
+		return false
+	}
+	return cm.Config.Targets[target].KeepRunning
+}
+
+// keepReasonPinned is the reason buildKeeper gives for pinned builds
+const keepReasonPinned = "pinned"
+
+// buildKeeper returns a function that tells why a build of target must not
+// be removed by cleanup: it is pinned, it is being run and the target has
+// keep-running, or it was run within --keep-recently-run
+//
+// Parameters:
+//   - target: The name of the target
+//   - targetRootDir: The directory holding the builds of the target
+//
+// Returns:
+//   - func(name string) string: A function returning the reason a build is kept, or "" if it may be removed
+func (c *cleanupCommand) buildKeeper(target, targetRootDir string) func(name string) string {
+	var history map[string]targets.RunRecord
+	if c.keepRecentlyRun > 0 {
+		var err error
+		history, err = targets.ReadRunHistory(targetRootDir)
+		if err != nil {
+			c.cmd.Printf("Warning: Failed to read run history for target '%s': %v\n", target, err)
+		}
+	}
+	keepRunning := keepRunningBuilds(target)
+
+	return func(name string) string {
+		buildPath := filepath.Join(targetRootDir, name)
+		if dirutils.IsPinned(buildPath) {
+			return keepReasonPinned
+		}
+		if record, ok := history[name]; ok && time.Since(record.LastRun) <= c.keepRecentlyRun {
+			return fmt.Sprintf("last run on %s", record.LastRun.Format("2006-01-02 15:04:05"))
+		}
+		if keepRunning && targets.IsRunning(buildPath) {
+			return "currently running"
+		}
+		return ""
+	}
+}
+
 // executeCleanup handles the cleanup of old builds for a specific target
 //
 // Parameters:
@@ -126,6 +256,9 @@ func (c *cleanupCommand) showDiskUsage() error {
 // Returns:
 //   - error: Any error encountered during the cleanup process
 func (c *cleanupCommand) executeCleanup(target string) error {
+	if nigiriRootMissing() {
+		return errNoTargets
+	}
 	// Create target directory if it doesn't exist
 	fsTarget := targets.Target{
 		Target:  target,
@@ -187,8 +320,70 @@ func (c *cleanupCommand) executeCleanup(target string) error {
 		}
 	}
 
+	// Keep builds that are pinned, were run recently or are being run
+	pinnedCount := 0
+	var keepReason func(name string) string
+	if len(buildsToRemove) > 0 || c.maxSize > 0 {
+		keepReason = c.buildKeeper(target, targetRootDir)
+		var kept []dirutils.DirEntry
+		for _, build := range buildsToRemove {
+			if reason := keepReason(build.Name); reason != "" {
+				c.cmd.Printf("Keeping %s (%s)\n", build.Name, reason)
+				if reason == keepReasonPinned {
+					pinnedCount++
+				}
+				continue
+			}
+			kept = append(kept, build)
+		}
+		buildsToRemove = kept
+	}
+
+	// By size, removing the oldest builds that are left until the target fits
+	// in the quota
+	var remainingSize int64
+	if c.maxSize > 0 {
+		remainingSize, err = dirutils.GetDirSize(targetRootDir)
+		if err != nil {
+			return fmt.Errorf("failed to calculate the size of target '%s': %w", target, err)
+		}
+		marked := make(map[string]bool, len(buildsToRemove))
+		for _, build := range buildsToRemove {
+			marked[build.Name] = true
+			size, _ := dirutils.GetDirSize(filepath.Join(targetRootDir, build.Name))
+			remainingSize -= size
+		}
+		for i := len(builds) - 1; i >= 0 && remainingSize > int64(c.maxSize); i-- {
+			build := builds[i]
+			if marked[build.Name] {
+				continue
+			}
+			if reason := keepReason(build.Name); reason != "" {
+				c.cmd.Printf("Keeping %s (%s)\n", build.Name, reason)
+				if reason == keepReasonPinned {
+					pinnedCount++
+				}
+				continue
+			}
+			size, err := dirutils.GetDirSize(filepath.Join(targetRootDir, build.Name))
+			if err != nil {
+				c.cmd.Printf("Warning: Failed to calculate the size of build '%s': %v\n", build.Name, err)
+				continue
+			}
+			buildsToRemove = append(buildsToRemove, build)
+			remainingSize -= size
+		}
+		if remainingSize > int64(c.maxSize) {
+			c.cmd.Printf("Warning: Target '%s' will still use %.2f MB, more than the quota of %s, because its remaining builds are kept.\n",
+				target, float64(remainingSize)/(1024*1024), c.maxSize.String())
+		}
+	}
+
 	if len(buildsToRemove) == 0 {
-		c.cmd.Printf("No builds to remove for target '%s'.\n", target)
+		c.cmd.Printf("No builds to remove for target '%s'%s.\n", target, pinnedSummary(pinnedCount))
+		if c.maxSize > 0 {
+			c.cmd.Printf("The target uses %.2f MB of its %s quota.\n", float64(remainingSize)/(1024*1024), c.maxSize.String())
+		}
 		return nil
 	}
 
@@ -203,8 +398,11 @@ func (c *cleanupCommand) executeCleanup(target string) error {
 	}
 
 	// Show what will be removed
-	c.cmd.Printf("Found %d builds to remove for target '%s'.\n", len(buildsToRemove), target)
+	c.cmd.Printf("Found %d builds to remove for target '%s'%s.\n", len(buildsToRemove), target, pinnedSummary(pinnedCount))
 	c.cmd.Printf("This will free approximately %.2f MB of disk space.\n", float64(totalSizeToFree)/(1024*1024))
+	if c.maxSize > 0 {
+		c.cmd.Printf("The target will then use %.2f MB of its %s quota.\n", float64(remainingSize)/(1024*1024), c.maxSize.String())
+	}
 
 	for _, build := range buildsToRemove {
 		c.cmd.Printf("  %s (built on %s)\n", build.Name, build.ModTime.Format("2006-01-02 15:04:05"))
@@ -217,12 +415,11 @@ func (c *cleanupCommand) executeCleanup(target string) error {
 
 	// Confirm before removing
 	if !c.skipConfirm {
-		c.cmd.Print("\nDo you want to continue? (y/n): ")
-		var confirm string
-		if _, err := fmt.Scanln(&confirm); err != nil {
-			return fmt.Errorf("failed to read confirmation: %w", err)
+		ok, err := confirm(c.cmd, "\nDo you want to continue?")
+		if err != nil {
+			return err
 		}
-		if confirm != "y" && confirm != "Y" {
+		if !ok {
 			c.cmd.Println("Cleanup cancelled.")
 			return nil
 		}
@@ -239,11 +436,31 @@ func (c *cleanupCommand) executeCleanup(target string) error {
 		removedCount++
 	}
 
-	c.cmd.Printf("%d builds removed successfully, freeing %.2f MB of disk space.\n",
-		removedCount, float64(totalSizeToFree)/(1024*1024))
+	c.cmd.Printf("%d builds removed successfully, freeing %.2f MB of disk space%s.\n",
+		removedCount, float64(totalSizeToFree)/(1024*1024), pinnedSummary(pinnedCount))
+	if c.maxSize > 0 {
+		if size, err := dirutils.GetDirSize(targetRootDir); err == nil {
+			c.cmd.Printf("Target '%s' now uses %.2f MB of its %s quota.\n", target, float64(size)/(1024*1024), c.maxSize.String())
+		}
+	}
 	return nil
 }
 
+// pinnedSummary describes how many builds cleanup skipped because they are
+// pinned, for appending to a summary line
+//
+// Parameters:
+//   - count: The number of pinned builds skipped
+//
+// Returns:
+//   - string: The description, empty if no build was skipped
+func pinnedSummary(count int) string {
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d pinned builds skipped)", count)
+}
+
 // executeCleanupAll handles the cleanup of old builds for all targets
 //
 // Returns:
@@ -252,7 +469,7 @@ func (c *cleanupCommand) executeCleanupAll() error {
 	entries, err := os.ReadDir(nigiriRoot)
 	if err != nil {
 		if os.IsNotExist(err) {
-			c.cmd.Println("No targets found.")
+			c.cmd.Println(noTargetsMessage)
 			return nil
 		}
 		return fmt.Errorf("failed to read nigiri root directory: %w", err)
@@ -272,14 +489,14 @@ func (c *cleanupCommand) executeCleanupAll() error {
 
 	c.cmd.Printf("Cleaning up builds for %d targets...\n", len(targets))
 
-	// If not skipping confirmation and not in dry run mode, confirm once for all targets
+	// If not skipping confirmation and not in dry run mode, have the user type
+	// the confirmation phrase once for all targets
 	if !c.skipConfirm && !c.dryRun {
-		c.cmd.Print("This will clean up old builds for all targets. Continue? (y/n): ")
-		var confirm string
-		if _, err := fmt.Scanln(&confirm); err != nil {
-			return fmt.Errorf("failed to read confirmation: %w", err)
+		ok, err := confirmTyped(c.cmd, fmt.Sprintf("This will clean up old builds for all %d targets.", len(targets)), deleteConfirmPhrase)
+		if err != nil {
+			return err
 		}
-		if confirm != "y" && confirm != "Y" {
+		if !ok {
 			c.cmd.Println("Cleanup cancelled.")
 			return nil
 		}
@@ -297,3 +514,95 @@ func (c *cleanupCommand) executeCleanupAll() error {
 
 	return nil
 }
+
+// executeCleanupEmptyTargets removes target directories that contain no build
+// subdirectories. Targets with a build in progress are left untouched even if
+// their commit directory has not been created yet.
+//
+// Returns:
+//   - error: Any error encountered during the cleanup process
+func (c *cleanupCommand) executeCleanupEmptyTargets() error {
+	entries, err := os.ReadDir(nigiriRoot)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.cmd.Println(noTargetsMessage)
+			return nil
+		}
+		return fmt.Errorf("failed to read nigiri root directory: %w", err)
+	}
+
+	var emptyTargets []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		targetDir := filepath.Join(nigiriRoot, entry.Name())
+		if targets.IsBuildLocked(targetDir) {
+			c.cmd.Printf("Skipping target '%s': a build is in progress.\n", entry.Name())
+			continue
+		}
+		if !hasBuildDirs(targetDir) {
+			emptyTargets = append(emptyTargets, entry.Name())
+		}
+	}
+
+	if len(emptyTargets) == 0 {
+		c.cmd.Println("No empty targets found.")
+		return nil
+	}
+
+	c.cmd.Printf("Found %d empty targets:\n", len(emptyTargets))
+	for _, target := range emptyTargets {
+		c.cmd.Printf("  %s\n", target)
+	}
+
+	if c.dryRun {
+		c.cmd.Println("\nDry run: No targets were removed.")
+		return nil
+	}
+
+	if !c.skipConfirm {
+		ok, err := confirm(c.cmd, "\nDo you want to continue?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			c.cmd.Println("Cleanup cancelled.")
+			return nil
+		}
+	}
+
+	removedCount := 0
+	for _, target := range emptyTargets {
+		if err := os.RemoveAll(filepath.Join(nigiriRoot, target)); err != nil {
+			c.cmd.Printf("Warning: Failed to remove target '%s': %v\n", target, err)
+			continue
+		}
+		removedCount++
+	}
+
+	c.cmd.Printf("%d empty targets removed successfully.\n", removedCount)
+	return nil
+}
+
+// hasBuildDirs reports whether a target directory contains any build
+// subdirectory. Unreadable directories are reported as having builds so that
+// they are never removed as empty.
+//
+// Parameters:
+//   - targetDir: The root directory of the target
+//
+// Returns:
+//   - bool: True if the target has at least one build directory
+func hasBuildDirs(targetDir string) bool {
+	builds, err := os.ReadDir(targetDir)
+	if err != nil {
+		return true
+	}
+	for _, build := range builds {
+		if build.IsDir() && !strings.HasPrefix(build.Name(), ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -10,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
 	"github.com/spf13/cobra"
 )
 
@@ -129,21 +130,9 @@ func TestCleanupCommand(t *testing.T) {
 		os.RemoveAll(tempDir)
 		setupTestTargets(t, tempDir)
 
-		// Mock user input
-		oldStdin := os.Stdin
-		r, w, _ := os.Pipe()
-		os.Stdin = r
-		defer func() { os.Stdin = oldStdin }()
-
-		// Create input in a goroutine
-		go func() {
-			defer w.Close()
-			w.Write([]byte("y\n"))
-		}()
-
-		// Create a buffer to capture command output
+		// The answer is read from the command's input, not from os.Stdin
 		var stdout bytes.Buffer
-		cmd := setupCleanupTestCommand(&stdout, r, "--max-builds", "2", "test-target-1")
+		cmd := setupCleanupTestCommand(&stdout, strings.NewReader("y\n"), "--max-builds", "2", "test-target-1")
 
 		// Execute the command
 		err := cmd.Execute()
@@ -172,21 +161,9 @@ func TestCleanupCommand(t *testing.T) {
 		os.RemoveAll(tempDir)
 		setupTestTargets(t, tempDir)
 
-		// Mock user input
-		oldStdin := os.Stdin
-		r, w, _ := os.Pipe()
-		os.Stdin = r
-		defer func() { os.Stdin = oldStdin }()
-
-		// Create input in a goroutine
-		go func() {
-			defer w.Close()
-			w.Write([]byte("n\n"))
-		}()
-
-		// Create a buffer to capture command output
+		// The answer is read from the command's input, not from os.Stdin
 		var stdout bytes.Buffer
-		cmd := setupCleanupTestCommand(&stdout, r, "--max-builds", "2", "test-target-1")
+		cmd := setupCleanupTestCommand(&stdout, strings.NewReader("n\n"), "--max-builds", "2", "test-target-1")
 
 		// Execute the command
 		err := cmd.Execute()
@@ -355,3 +332,313 @@ func createTestBuild(t *testing.T, targetDir string, buildName string, modTime t
 		t.Fatalf("Failed to set modification time: %v", err)
 	}
 }
+
+// TestCleanupEmptyTargets tests removal of target directories without builds
+func TestCleanupEmptyTargets(t *testing.T) {
+	originalNigiriRoot := nigiriRoot
+	tempDir := t.TempDir()
+	defer func() { nigiriRoot = originalNigiriRoot }()
+	nigiriRoot = tempDir
+
+	// A target with a build, an empty target, and an empty target mid-build
+	os.MkdirAll(filepath.Join(tempDir, "non-empty"), 0755)
+	createTestBuild(t, filepath.Join(tempDir, "non-empty"), "abc1234", time.Now())
+	os.MkdirAll(filepath.Join(tempDir, "empty"), 0755)
+	lockedDir := filepath.Join(tempDir, "locked")
+	os.MkdirAll(lockedDir, 0755)
+	release, err := targets.AcquireBuildLock(lockedDir)
+	if err != nil {
+		t.Fatalf("Failed to lock target: %v", err)
+	}
+	defer release()
+
+	t.Run("dry run keeps everything", func(t *testing.T) {
+		var stdout bytes.Buffer
+		cmd := setupCleanupTestCommand(&stdout, nil, "--empty-targets", "--dry-run")
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if !strings.Contains(stdout.String(), "Dry run: No targets were removed") {
+			t.Errorf("Expected dry run message, got: %s", stdout.String())
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "empty")); err != nil {
+			t.Errorf("Empty target should remain after dry run: %v", err)
+		}
+	})
+
+	t.Run("removes only empty unlocked targets", func(t *testing.T) {
+		var stdout bytes.Buffer
+		cmd := setupCleanupTestCommand(&stdout, nil, "--empty-targets", "--yes")
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "empty")); !os.IsNotExist(err) {
+			t.Errorf("Expected empty target to be removed")
+		}
+		if _, err := os.Stat(filepath.Join(tempDir, "non-empty", "abc1234")); err != nil {
+			t.Errorf("Expected non-empty target to be preserved: %v", err)
+		}
+		if _, err := os.Stat(lockedDir); err != nil {
+			t.Errorf("Expected locked target to be preserved: %v", err)
+		}
+		if !strings.Contains(stdout.String(), "1 empty targets removed successfully") {
+			t.Errorf("Expected removal summary, got: %s", stdout.String())
+		}
+	})
+}
+
+func TestCleanupKeepRecentlyRun(t *testing.T) {
+	tempDir := useTestNigiriRoot(t)
+	targetDir := filepath.Join(tempDir, "tool")
+	os.MkdirAll(targetDir, 0755)
+	now := time.Now()
+	createTestBuild(t, targetDir, "build-new", now)
+	createTestBuild(t, targetDir, "build-old-run", now.AddDate(0, 0, -60))
+	createTestBuild(t, targetDir, "build-old-unused", now.AddDate(0, 0, -60))
+	if err := targets.RecordRun(targetDir, "build-old-run", targets.RunRecord{LastRun: now.Add(-time.Hour)}); err != nil {
+		t.Fatalf("RecordRun failed: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := setupCleanupTestCommand(&stdout, nil, "tool", "--max-age", "30", "--keep-recently-run", "24h", "--yes")
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(targetDir, "build-old-run")); err != nil {
+		t.Errorf("Expected recently run build to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "build-old-unused")); !os.IsNotExist(err) {
+		t.Errorf("Expected unused old build to be removed")
+	}
+	if !strings.Contains(stdout.String(), "Keeping build-old-run") {
+		t.Errorf("Expected keep message, got: %s", stdout.String())
+	}
+}
+
+func TestCleanupKeepRecentlyRunWithoutHistory(t *testing.T) {
+	tempDir := useTestNigiriRoot(t)
+	targetDir := filepath.Join(tempDir, "tool")
+	os.MkdirAll(targetDir, 0755)
+	createTestBuild(t, targetDir, "build-old", time.Now().AddDate(0, 0, -60))
+
+	var stdout bytes.Buffer
+	cmd := setupCleanupTestCommand(&stdout, nil, "tool", "--keep-recently-run", "24h", "--yes")
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "build-old")); !os.IsNotExist(err) {
+		t.Errorf("Expected old build to be removed when no run history exists")
+	}
+}
+
+func TestCleanupKeepRunning(t *testing.T) {
+	for _, keepRunning := range []bool{true, false} {
+		t.Run(fmt.Sprintf("keep-running %v", keepRunning), func(t *testing.T) {
+			tempDir := useTestNigiriRoot(t)
+			useTestConfig(t, fmt.Sprintf(`
+targets:
+  tool:
+    source: https://example.com/tool
+    keep-running: %v
+    build-command:
+      linux: make
+`, keepRunning))
+			targetDir := filepath.Join(tempDir, "tool")
+			os.MkdirAll(targetDir, 0755)
+			now := time.Now()
+			createTestBuild(t, targetDir, "build-new", now)
+			createTestBuild(t, targetDir, "build-old-running", now.AddDate(0, 0, -60))
+			createTestBuild(t, targetDir, "build-old-idle", now.AddDate(0, 0, -30))
+			release, err := targets.MarkRunning(filepath.Join(targetDir, "build-old-running"), os.Getpid())
+			if err != nil {
+				t.Fatalf("MarkRunning failed: %v", err)
+			}
+			defer release()
+
+			var stdout bytes.Buffer
+			cmd := setupCleanupTestCommand(&stdout, nil, "tool", "--max-builds", "1", "--yes")
+			if err := cmd.Execute(); err != nil {
+				t.Fatalf("Execute failed: %v", err)
+			}
+
+			if _, err := os.Stat(filepath.Join(targetDir, "build-new")); err != nil {
+				t.Errorf("Expected newest build to be kept: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(targetDir, "build-old-idle")); !os.IsNotExist(err) {
+				t.Errorf("Expected idle old build to be removed")
+			}
+			_, err = os.Stat(filepath.Join(targetDir, "build-old-running"))
+			if keepRunning && err != nil {
+				t.Errorf("Expected running build to be kept: %v", err)
+			}
+			if !keepRunning && !os.IsNotExist(err) {
+				t.Errorf("Expected running build to be removed without keep-running")
+			}
+		})
+	}
+}
+
+func TestCleanupKeepPinned(t *testing.T) {
+	tempDir := useTestNigiriRoot(t)
+	targetDir := filepath.Join(tempDir, "tool")
+	os.MkdirAll(targetDir, 0755)
+	now := time.Now()
+	createTestBuild(t, targetDir, "build-new", now)
+	createTestBuild(t, targetDir, "build-old-idle", now.AddDate(0, 0, -30))
+	createTestBuild(t, targetDir, "build-old-pinned", now.AddDate(0, 0, -60))
+	if _, err := targets.Pin(filepath.Join(targetDir, "build-old-pinned")); err != nil {
+		t.Fatalf("Pin failed: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := setupCleanupTestCommand(&stdout, nil, "tool", "--max-builds", "1", "--yes")
+	if err := cmd.Execute(); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(targetDir, "build-old-pinned")); err != nil {
+		t.Errorf("Expected pinned build to be kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "build-old-idle")); !os.IsNotExist(err) {
+		t.Errorf("Expected idle old build to be removed")
+	}
+	if !strings.Contains(stdout.String(), "(1 pinned builds skipped)") {
+		t.Errorf("Expected the summary to mention the pinned build, got:\n%s", stdout.String())
+	}
+}
+
+func TestSizeValue(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "1024", want: 1024},
+		{input: "500MB", want: 500 << 20},
+		{input: "2GB", want: 2 << 30},
+		{input: "1.5gb", want: 3 << 29},
+		{input: "64K", want: 64 << 10},
+		{input: "10 B", want: 10},
+		{input: "0", want: 0},
+		{input: "MB", wantErr: true},
+		{input: "lots", wantErr: true},
+		{input: "-1GB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			var v sizeValue
+			err := v.Set(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Set(%q) = nil, want error", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Set(%q) failed: %v", tt.input, err)
+			}
+			if int64(v) != tt.want {
+				t.Errorf("Set(%q) = %d, want %d", tt.input, v, tt.want)
+			}
+		})
+	}
+
+	v := sizeValue(2 << 30)
+	if got := v.String(); got != "2GB" {
+		t.Errorf("String() = %q, want 2GB", got)
+	}
+}
+
+func TestCleanupMaxSize(t *testing.T) {
+	setup := func(t *testing.T) string {
+		tempDir := useTestNigiriRoot(t)
+		targetDir := filepath.Join(tempDir, "tool")
+		now := time.Now()
+		for i, name := range []string{"build-1", "build-2", "build-3", "build-4"} {
+			createTestBuild(t, targetDir, name, now)
+			if err := os.WriteFile(filepath.Join(targetDir, name, "bin"), make([]byte, 1024), 0644); err != nil {
+				t.Fatalf("Failed to write binary: %v", err)
+			}
+			modTime := now.Add(-time.Duration(4-i) * time.Hour)
+			if err := os.Chtimes(filepath.Join(targetDir, name), modTime, modTime); err != nil {
+				t.Fatalf("Failed to set modification time: %v", err)
+			}
+		}
+		return targetDir
+	}
+	exists := func(targetDir, name string) bool {
+		_, err := os.Stat(filepath.Join(targetDir, name))
+		return err == nil
+	}
+
+	t.Run("removes the oldest builds", func(t *testing.T) {
+		targetDir := setup(t)
+		var stdout bytes.Buffer
+		cmd := setupCleanupTestCommand(&stdout, nil, "tool", "--max-size", "2.5KB", "--max-builds", "0", "--max-age", "0", "--yes")
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		for name, want := range map[string]bool{"build-1": false, "build-2": false, "build-3": true, "build-4": true} {
+			if got := exists(targetDir, name); got != want {
+				t.Errorf("%s exists = %v, want %v", name, got, want)
+			}
+		}
+		if !strings.Contains(stdout.String(), "of its 2.5KB quota") {
+			t.Errorf("Expected the summary to report the quota, got:\n%s", stdout.String())
+		}
+	})
+
+	t.Run("composes with max-builds and pins", func(t *testing.T) {
+		targetDir := setup(t)
+		if _, err := targets.Pin(filepath.Join(targetDir, "build-1")); err != nil {
+			t.Fatalf("Pin failed: %v", err)
+		}
+		var stdout bytes.Buffer
+		cmd := setupCleanupTestCommand(&stdout, nil, "tool", "--max-size", "2.5KB", "--max-builds", "3", "--max-age", "0", "--yes")
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		// build-1 is pinned, so the quota is met by removing build-2 and build-3
+		for name, want := range map[string]bool{"build-1": true, "build-2": false, "build-3": false, "build-4": true} {
+			if got := exists(targetDir, name); got != want {
+				t.Errorf("%s exists = %v, want %v", name, got, want)
+			}
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		targetDir := setup(t)
+		var stdout bytes.Buffer
+		cmd := setupCleanupTestCommand(&stdout, nil, "tool", "--max-size", "1KB", "--max-builds", "0", "--max-age", "0", "--dry-run")
+		if err := cmd.Execute(); err != nil {
+			t.Fatalf("Execute failed: %v", err)
+		}
+		for _, name := range []string{"build-1", "build-2", "build-3", "build-4"} {
+			if !exists(targetDir, name) {
+				t.Errorf("Expected %s to be kept in a dry run", name)
+			}
+		}
+		if !strings.Contains(stdout.String(), "Found 4 builds to remove") {
+			t.Errorf("Expected every build to exceed a 1KB quota, got:\n%s", stdout.String())
+		}
+	})
+}
+
+// TestHasBuildDirsSkipsSwapDirectories tests that directories of a build being
+// swapped into place do not count as builds
+func TestHasBuildDirsSkipsSwapDirectories(t *testing.T) {
+	targetDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(targetDir, ".abc1234.old-1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if hasBuildDirs(targetDir) {
+		t.Error("hasBuildDirs() = true for a target with only a swap directory")
+	}
+	if err := os.MkdirAll(filepath.Join(targetDir, "abc1234"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if !hasBuildDirs(targetDir) {
+		t.Error("hasBuildDirs() = false for a target with a build")
+	}
+}
@@ -1,13 +1,40 @@
 package commands
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/oota-sushikuitee/nigiri/internal/targets"
 	"github.com/oota-sushikuitee/nigiri/pkg/commits"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
 )
 
+// refCacheDirName is the name of the directory in the nigiri root holding the
+// remote references listed for completion, one file per repository
+const refCacheDirName = ".ref-cache"
+
+// refCacheTTL is how long listed remote references are reused. Every tab
+// press runs a new process, so the listing is cached on disk.
+const refCacheTTL = time.Minute
+
+// refListTimeout is how long completion waits for the remote references to
+// be listed. Completion runs on every tab press, so a slow or unreachable
+// remote offers no references rather than blocking the shell.
+var refListTimeout = 2 * time.Second
+
+// remoteLister lists the branches and tags of a source repository
+type remoteLister func(source string) ([]vcsutils.RemoteRef, error)
+
+// lsRemote lists the remote references of source over the network
+func lsRemote(source string) ([]vcsutils.RemoteRef, error) {
+	git := vcsutils.Git{Source: source}
+	return git.LsRemote()
+}
+
 // getConfiguredTargets returns a list of target names from the configuration file
 // that match the given prefix. This is used for shell completion.
 //
@@ -82,9 +109,102 @@ func getTargetCommits(target, prefix string) []string {
 
 	var commitList []string
 	for _, dir := range dirs {
-		if dir.IsDir() && strings.HasPrefix(dir.Name(), prefix) {
+		if dir.IsDir() && commits.HasHashPrefix(dir.Name(), prefix) {
 			commitList = append(commitList, dir.Name())
 		}
 	}
 	return commitList
 }
+
+// getRemoteRefNames returns the names of the branches and tags of a target's
+// repository that match the given prefix. This is used for shell completion.
+// The listing is cached briefly in the nigiri root, and nothing is returned
+// when the target is not configured or the remote cannot be reached.
+//
+// Parameters:
+//   - target: The target name to get references for
+//   - prefix: The prefix to filter references by
+//   - list: Lists the references when the cache is missing or stale
+//
+// Returns:
+//   - []string: A list of matching branch and tag names
+func getRemoteRefNames(target, prefix string, list remoteLister) []string {
+	cm := newConfigManager()
+	if err := cm.LoadCfgFile(); err != nil {
+		return nil
+	}
+	targetCfg, exists := cm.Config.Targets[target]
+	if !exists || targetCfg.Sources == "" {
+		return nil
+	}
+
+	cachePath := filepath.Join(nigiriRoot, refCacheDirName, targets.CloneCacheKey(targetCfg.Sources)+".json")
+	refs, ok := readRefCache(cachePath)
+	if !ok {
+		var err error
+		refs, err = listWithTimeout(list, targetCfg.Sources, refListTimeout)
+		if err != nil {
+			return nil
+		}
+		if data, err := json.Marshal(refs); err == nil {
+			if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+				_ = os.WriteFile(cachePath, data, 0644)
+			}
+		}
+	}
+
+	var names []string
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name, prefix) {
+			names = append(names, ref.Name)
+		}
+	}
+	return names
+}
+
+// listWithTimeout lists the references of source, giving up after timeout.
+// The listing is left to finish in the background; completion exits soon
+// after anyway.
+//
+// Parameters:
+//   - list: Lists the references
+//   - source: The source repository
+//   - timeout: How long to wait for the listing
+//
+// Returns:
+//   - []vcsutils.RemoteRef: The references
+//   - error: The error of the listing, or an error if it timed out
+func listWithTimeout(list remoteLister, source string, timeout time.Duration) ([]vcsutils.RemoteRef, error) {
+	type result struct {
+		refs []vcsutils.RemoteRef
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		refs, err := list(source)
+		done <- result{refs: refs, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.refs, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("listing the references of %s timed out after %s", source, timeout)
+	}
+}
+
+// readRefCache reads remote references cached within refCacheTTL
+func readRefCache(path string) ([]vcsutils.RemoteRef, bool) {
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > refCacheTTL {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var refs []vcsutils.RemoteRef
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, false
+	}
+	return refs, true
+}
@@ -0,0 +1,551 @@
+package commands
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/pkg/config"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+// maxImportSize is the maximum size of a configuration fetched from a URL
+const maxImportSize = 1 << 20
+
+// configCommand represents the structure for the config command
+type configCommand struct {
+	cmd *cobra.Command
+}
+
+// newConfigCommand creates a new config command instance which groups the
+// subcommands that manage the nigiri configuration file.
+//
+// Returns:
+//   - *configCommand: A configured config command instance
+func newConfigCommand() *configCommand {
+	c := &configCommand{}
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage the nigiri configuration",
+		Long:  `Manage the nigiri configuration file.`,
+	}
+	cmd.AddCommand(newConfigImportCommand().cmd)
+	cmd.AddCommand(newConfigValidateCommand().cmd)
+	cmd.AddCommand(newConfigLintCommand().cmd)
+	cmd.AddCommand(newConfigAddCommand().cmd)
+	cmd.AddCommand(newConfigSetCommand().cmd)
+	c.cmd = cmd
+	return c
+}
+
+// configValidateCommand represents the structure for the config validate command
+type configValidateCommand struct {
+	cmd *cobra.Command
+}
+
+// newConfigValidateCommand creates a new config validate command instance
+// which checks the configuration file for problems that loading it ignores.
+//
+// Returns:
+//   - *configValidateCommand: A configured config validate command instance
+func newConfigValidateCommand() *configValidateCommand {
+	c := &configValidateCommand{}
+	cmd := &cobra.Command{
+		Use:   "validate [file]",
+		Short: "Check the configuration for problems",
+		Long: `Check the configuration file, or the given file, for problems: keys nigiri does
+not know and would ignore, values of the wrong type, targets without a source or
+without a build command for any platform (after applying defaults), and source
+URLs that do not parse.
+All problems are listed and the command exits with a non-zero status if there
+are any, so it can be used in CI.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var file string
+			if len(args) == 1 {
+				file = args[0]
+			}
+			return c.executeValidate(file)
+		},
+	}
+	c.cmd = cmd
+	return c
+}
+
+// executeValidate checks a configuration file and lists its problems
+//
+// Parameters:
+//   - file: The configuration file to check (empty checks the configuration in use)
+//
+// Returns:
+//   - error: An error if the file cannot be read or has problems
+func (c *configValidateCommand) executeValidate(file string) error {
+	cm := newConfigManager()
+	if file != "" {
+		cm.SetCfgPath(file)
+	}
+	path, problems, err := cm.ValidateCfgFile()
+	if err != nil {
+		return logger.CreateErrorf("failed to load configuration: %w", err)
+	}
+	if len(problems) > 0 {
+		c.cmd.Printf("Found %d problem(s) in %s:\n", len(problems), path)
+		for _, problem := range problems {
+			c.cmd.Printf("  - %s\n", problem)
+		}
+		return logger.CreateErrorf("configuration %s is invalid", path)
+	}
+	c.cmd.Printf("Configuration %s is valid (%d targets)\n", path, len(cm.Config.Targets))
+	return nil
+}
+
+// configLintCommand represents the structure for the config lint command
+type configLintCommand struct {
+	cmd *cobra.Command
+	// strict makes warnings fail the command
+	strict bool
+}
+
+// newConfigLintCommand creates a new config lint command instance which
+// warns about risky settings in the configuration.
+//
+// Returns:
+//   - *configLintCommand: A configured config lint command instance
+func newConfigLintCommand() *configLintCommand {
+	c := &configLintCommand{}
+	cmd := &cobra.Command{
+		Use:   "lint [file]",
+		Short: "Warn about risky settings in the configuration",
+		Long: `Check the configuration file, or the given file, for settings that are valid
+but risky: binary-only targets without a binary-path, build commands that cd or
+use absolute paths, targets without a default-branch, secrets stored in
+plaintext in env or in source URLs, and targets that share a source.
+Warnings do not fail the command unless --strict is given; use
+'nigiri config validate' for hard errors.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var file string
+			if len(args) == 1 {
+				file = args[0]
+			}
+			return c.executeLint(file)
+		},
+	}
+	cmd.Flags().BoolVar(&c.strict, "strict", false, "Exit with a non-zero status if there are warnings")
+	c.cmd = cmd
+	return c
+}
+
+// executeLint loads a configuration file and lists its lint warnings
+//
+// Parameters:
+//   - file: The configuration file to check (empty checks the configuration in use)
+//
+// Returns:
+//   - error: An error if the file cannot be loaded, or if there are warnings with --strict
+func (c *configLintCommand) executeLint(file string) error {
+	cm := newConfigManager()
+	// Secrets referenced from the environment are fine, so keep the references
+	cm.SetKeepEnvReferences(true)
+	if file != "" {
+		cm.SetCfgPath(file)
+	}
+	if err := cm.LoadCfgFile(); err != nil {
+		return logger.CreateErrorf("failed to load configuration: %w", err)
+	}
+	path := cm.Config.GetCfgFile()
+	if path == "" {
+		path = filepath.Join(cm.Config.GetCfgDir(), ".nigiri.yml")
+	}
+
+	warnings := config.LintConfig(cm.Config)
+	if len(warnings) == 0 {
+		c.cmd.Printf("No warnings in %s (%d targets)\n", path, len(cm.Config.Targets))
+		return nil
+	}
+	c.cmd.Printf("Found %d warning(s) in %s:\n", len(warnings), path)
+	for _, warning := range warnings {
+		c.cmd.Printf("  [%s] %s: %s\n", warning.Category, warning.Target, warning.Message)
+	}
+	if c.strict {
+		return logger.CreateErrorf("configuration %s has %d warning(s)", path, len(warnings))
+	}
+	return nil
+}
+
+// configImportCommand represents the structure for the config import command
+type configImportCommand struct {
+	cmd *cobra.Command
+	// overwrite replaces existing targets that have the same name as imported ones
+	overwrite bool
+	// timeout limits how long fetching a configuration from a URL may take
+	timeout time.Duration
+}
+
+// newConfigImportCommand creates a new config import command instance which
+// merges the targets of an external configuration into the user's configuration.
+//
+// Returns:
+//   - *configImportCommand: A configured config import command instance
+func newConfigImportCommand() *configImportCommand {
+	c := &configImportCommand{}
+	cmd := &cobra.Command{
+		Use:   "import <file-or-url>",
+		Short: "Merge targets from another configuration",
+		Long: `Merge the targets defined in another configuration file into your configuration.
+The configuration can be read from a local file or fetched from an HTTP(S) URL.
+Targets that already exist are skipped unless --overwrite is specified.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeImport(args[0])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&c.overwrite, "overwrite", false, "Replace existing targets with the imported ones")
+	flags.DurationVar(&c.timeout, "timeout", 30*time.Second, "Timeout for fetching a configuration from a URL")
+
+	c.cmd = cmd
+	return c
+}
+
+// executeImport reads, validates and merges the configuration at location
+//
+// Parameters:
+//   - location: The path or HTTP(S) URL of the configuration to import
+//
+// Returns:
+//   - error: Any error encountered while importing
+func (c *configImportCommand) executeImport(location string) error {
+	data, err := c.readImport(location)
+	if err != nil {
+		return err
+	}
+
+	// The configuration is saved again, so environment variable references
+	// must not be replaced by the values of this environment
+	imported := config.NewConfigManager()
+	imported.SetKeepEnvReferences(true)
+	if err := imported.LoadCfgData(data, location); err != nil {
+		return logger.CreateErrorf("failed to load imported configuration: %w", err)
+	}
+	for name, target := range imported.Config.Targets {
+		if err := config.ValidateTarget(name, target); err != nil {
+			return logger.CreateErrorf("invalid imported configuration: %w", err)
+		}
+	}
+
+	cm, cfgPath, err := loadConfigForEdit()
+	if err != nil {
+		return err
+	}
+
+	added, replaced, skipped := mergeTargets(cm.Config.Targets, imported.Config.Targets, c.overwrite)
+	for _, name := range skipped {
+		c.cmd.Printf("Warning: Target '%s' already exists, skipping (use --overwrite to replace it)\n", name)
+	}
+	for _, name := range replaced {
+		c.cmd.Printf("Replaced target '%s'\n", name)
+	}
+	for _, name := range added {
+		c.cmd.Printf("Added target '%s'\n", name)
+	}
+
+	if len(added) == 0 && len(replaced) == 0 {
+		c.cmd.Println("No targets imported.")
+		return nil
+	}
+	if err := cm.SaveCfgFile(); err != nil {
+		return logger.CreateErrorf("failed to save configuration: %w", err)
+	}
+	c.cmd.Printf("Imported %d targets into %s\n", len(added)+len(replaced), cfgPath)
+	return nil
+}
+
+// loadConfigForEdit loads the configuration for a command that changes and
+// saves it. Environment variable references are kept as written, so saving
+// does not replace them with the values of this environment, and a missing
+// configuration file starts out without targets.
+//
+// Returns:
+//   - *config.ConfigManager: The loaded configuration
+//   - string: The path of the configuration file
+//   - error: Any error encountered while loading the configuration
+func loadConfigForEdit() (*config.ConfigManager, string, error) {
+	cm := newConfigManager()
+	cm.SetKeepEnvReferences(true)
+	cfgPath := cm.Config.GetCfgFile()
+	if cfgPath == "" {
+		cfgPath = filepath.Join(cm.Config.GetCfgDir(), ".nigiri.yml")
+	}
+	if _, statErr := os.Stat(cfgPath); statErr == nil {
+		if err := cm.LoadCfgFile(); err != nil {
+			return nil, "", logger.CreateErrorf("failed to load configuration: %w", err)
+		}
+	} else if os.IsNotExist(statErr) {
+		// Editing a fresh setup creates the configuration file
+		cm.Config.Targets = make(map[string]internalconfig.Target)
+		if err := os.MkdirAll(filepath.Dir(cfgPath), 0755); err != nil {
+			return nil, "", logger.CreateErrorf("failed to create configuration directory: %w", err)
+		}
+	} else {
+		return nil, "", logger.CreateErrorf("failed to access configuration file: %w", statErr)
+	}
+	return cm, cfgPath, nil
+}
+
+// configAddCommand represents the structure for the config add command
+type configAddCommand struct {
+	cmd *cobra.Command
+	// source is the repository URL or path of the new target
+	source string
+	// branch is the default branch of the new target
+	branch string
+	// linux is the build command on Linux
+	linux string
+	// windows is the build command on Windows
+	windows string
+	// darwin is the build command on macOS
+	darwin string
+	// binaryPath is the path of the built binary relative to the working directory
+	binaryPath string
+}
+
+// newConfigAddCommand creates a new config add command instance which adds a
+// target to the configuration file.
+//
+// Returns:
+//   - *configAddCommand: A configured config add command instance
+func newConfigAddCommand() *configAddCommand {
+	c := &configAddCommand{}
+	cmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Add a target to the configuration",
+		Long: `Add a target to the configuration file without editing it by hand.
+The target must not exist yet; use 'nigiri config set' to change an existing one.
+A target without build commands uses the defaults of the configuration.
+
+Example:
+  nigiri config add tool --source https://github.com/owner/tool --branch main --linux "make" --binary-path bin/tool`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeAdd(args[0])
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&c.source, "source", "", "Repository URL or local path of the target")
+	flags.StringVar(&c.branch, "branch", "", "Default branch of the target")
+	flags.StringVar(&c.linux, "linux", "", "Build command on Linux")
+	flags.StringVar(&c.windows, "windows", "", "Build command on Windows")
+	flags.StringVar(&c.darwin, "darwin", "", "Build command on macOS")
+	flags.StringVar(&c.binaryPath, "binary-path", "", "Path of the built binary, relative to the working directory")
+	_ = cmd.MarkFlagRequired("source")
+
+	c.cmd = cmd
+	return c
+}
+
+// executeAdd adds the target name to the configuration file
+//
+// Parameters:
+//   - name: The name of the new target
+//
+// Returns:
+//   - error: An error if the target exists or is invalid, or the configuration cannot be saved
+func (c *configAddCommand) executeAdd(name string) error {
+	cm, cfgPath, err := loadConfigForEdit()
+	if err != nil {
+		return err
+	}
+	if _, exists := cm.Config.Targets[name]; exists {
+		return logger.CreateErrorf("target '%s' already exists in %s (use 'nigiri config set' to change it)", name, cfgPath)
+	}
+
+	target := internalconfig.Target{
+		Sources:       c.source,
+		DefaultBranch: c.branch,
+		BuildCommand: internalconfig.BuildCommand{
+			Linux:           c.linux,
+			Windows:         c.windows,
+			Darwin:          c.darwin,
+			BinaryPathValue: c.binaryPath,
+		},
+	}
+	if err := validateEditedTarget(cm, name, target); err != nil {
+		return err
+	}
+	cm.Config.Targets[name] = target
+	if err := cm.SaveCfgFile(); err != nil {
+		return logger.CreateErrorf("failed to save configuration: %w", err)
+	}
+	c.cmd.Printf("Added target '%s' to %s\n", name, cfgPath)
+	return nil
+}
+
+// configSetCommand represents the structure for the config set command
+type configSetCommand struct {
+	cmd *cobra.Command
+}
+
+// newConfigSetCommand creates a new config set command instance which changes
+// a single field of a target in the configuration file.
+//
+// Returns:
+//   - *configSetCommand: A configured config set command instance
+func newConfigSetCommand() *configSetCommand {
+	c := &configSetCommand{}
+	cmd := &cobra.Command{
+		Use:   "set <name> <key> <value>",
+		Short: "Change a field of a target in the configuration",
+		Long: `Change a single field of an existing target in the configuration file.
+Keys are written as in the configuration file, with build-command.<key> for the
+keys of the build command; binary-path is a shorthand for build-command.binary-path.
+An empty value clears a field.
+
+Keys: ` + strings.Join(config.SettableTargetKeys(), ", ") + `
+
+Example:
+  nigiri config set tool binary-path bin/app`,
+		Args: cobra.ExactArgs(3),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeSet(args[0], args[1], args[2])
+		},
+	}
+	c.cmd = cmd
+	return c
+}
+
+// executeSet sets the field key of the target name to value
+//
+// Parameters:
+//   - name: The name of the target
+//   - key: The configuration key of the field
+//   - value: The new value
+//
+// Returns:
+//   - error: An error if the target does not exist, the key or value is
+//     invalid, or the configuration cannot be saved
+func (c *configSetCommand) executeSet(name, key, value string) error {
+	cm, cfgPath, err := loadConfigForEdit()
+	if err != nil {
+		return err
+	}
+	target, exists := cm.Config.Targets[name]
+	if !exists {
+		return logger.CreateErrorf("target '%s' not found in %s (use 'nigiri config add' to create it)", name, cfgPath)
+	}
+	if err := config.SetTargetField(&target, key, value); err != nil {
+		return logger.CreateErrorf("failed to set %s of target '%s': %w", key, name, err)
+	}
+	if err := validateEditedTarget(cm, name, target); err != nil {
+		return err
+	}
+	cm.Config.Targets[name] = target
+	if err := cm.SaveCfgFile(); err != nil {
+		return logger.CreateErrorf("failed to save configuration: %w", err)
+	}
+	c.cmd.Printf("Set %s of target '%s' to %q\n", key, name, value)
+	return nil
+}
+
+// validateEditedTarget checks a target before it is saved, with the defaults
+// of the configuration applied as they are when it is built
+//
+// Parameters:
+//   - cm: The configuration the target belongs to
+//   - name: The name of the target
+//   - target: The target to check
+//
+// Returns:
+//   - error: An error describing the first problem found, or nil
+func validateEditedTarget(cm *config.ConfigManager, name string, target internalconfig.Target) error {
+	target.BuildCommand = target.BuildCommand.WithDefaults(cm.Config.Defaults)
+	if err := config.ValidateTarget(name, target); err != nil {
+		return logger.CreateErrorf("invalid target: %w", err)
+	}
+	return nil
+}
+
+// readImport reads the configuration at location from a file or an HTTP(S) URL
+//
+// Parameters:
+//   - location: The path or URL of the configuration
+//
+// Returns:
+//   - []byte: The raw configuration
+//   - error: Any error encountered while reading
+func (c *configImportCommand) readImport(location string) ([]byte, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		data, err := os.ReadFile(location)
+		if err != nil {
+			return nil, logger.CreateErrorf("failed to read configuration: %w", err)
+		}
+		return data, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, logger.CreateErrorf("invalid configuration URL: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, logger.CreateErrorf("failed to fetch configuration: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, logger.CreateErrorf("failed to fetch configuration: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImportSize+1))
+	if err != nil {
+		return nil, logger.CreateErrorf("failed to read configuration: %w", err)
+	}
+	if len(data) > maxImportSize {
+		return nil, logger.CreateErrorf("configuration at %s exceeds %d bytes", location, maxImportSize)
+	}
+	return data, nil
+}
+
+// mergeTargets merges src into dst. Targets already in dst are only replaced
+// when overwrite is true.
+//
+// Parameters:
+//   - dst: The targets to merge into
+//   - src: The targets to merge
+//   - overwrite: Whether to replace targets that exist in both
+//
+// Returns:
+//   - added: The names of targets new to dst, sorted
+//   - replaced: The names of targets that were replaced, sorted
+//   - skipped: The names of existing targets that were left untouched, sorted
+func mergeTargets(dst, src map[string]internalconfig.Target, overwrite bool) (added, replaced, skipped []string) {
+	names := make([]string, 0, len(src))
+	for name := range src {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		_, exists := dst[name]
+		switch {
+		case !exists:
+			added = append(added, name)
+		case overwrite:
+			replaced = append(replaced, name)
+		default:
+			skipped = append(skipped, name)
+			continue
+		}
+		dst[name] = src[name]
+	}
+	return added, replaced, skipped
+}
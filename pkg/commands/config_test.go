@@ -0,0 +1,441 @@
+package commands
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testUserConfig = `targets:
+  existing:
+    source: https://example.com/existing.git
+    build-command:
+      linux: make
+  shared:
+    source: https://example.com/mine.git
+    build-command:
+      linux: make
+`
+
+const testImportConfig = `targets:
+  shared:
+    source: https://example.com/theirs.git
+    build-command:
+      linux: make release
+  new:
+    source: https://example.com/new.git
+    default-branch: main
+    build-command:
+      linux: make
+`
+
+// loadTestConfig loads the configuration file at path
+func loadTestConfig(t *testing.T, path string) *config.ConfigManager {
+	t.Helper()
+	cm := config.NewConfigManager()
+	cm.Config.SetCfgFile(path)
+	require.NoError(t, cm.LoadCfgFile())
+	return cm
+}
+
+func TestConfigImport(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantShared string
+		wantOutput string
+	}{
+		{
+			name:       "collisions are skipped by default",
+			wantShared: "https://example.com/mine.git",
+			wantOutput: "Warning: Target 'shared' already exists, skipping",
+		},
+		{
+			name:       "collisions are replaced with --overwrite",
+			args:       []string{"--overwrite"},
+			wantShared: "https://example.com/theirs.git",
+			wantOutput: "Replaced target 'shared'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := useTestConfig(t, testUserConfig)
+			importPath := filepath.Join(t.TempDir(), "team.yml")
+			require.NoError(t, os.WriteFile(importPath, []byte(testImportConfig), 0644))
+
+			var out bytes.Buffer
+			cmd := newConfigCommand().cmd
+			cmd.SetOut(&out)
+			cmd.SetArgs(append([]string{"import", importPath}, tt.args...))
+			require.NoError(t, cmd.Execute())
+
+			cm := loadTestConfig(t, cfgPath)
+			assert.Len(t, cm.Config.Targets, 3)
+			assert.Equal(t, "https://example.com/existing.git", cm.Config.Targets["existing"].Sources)
+			assert.Equal(t, tt.wantShared, cm.Config.Targets["shared"].Sources)
+			assert.Equal(t, "main", cm.Config.Targets["new"].DefaultBranch)
+			assert.Contains(t, out.String(), tt.wantOutput)
+			assert.Contains(t, out.String(), "Added target 'new'")
+		})
+	}
+}
+
+func TestConfigImportFromURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/team.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(testImportConfig))
+	}))
+	defer server.Close()
+	cfgPath := useTestConfig(t, testUserConfig)
+
+	c := newConfigImportCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, c.executeImport(server.URL+"/team.yml"))
+	assert.Contains(t, loadTestConfig(t, cfgPath).Config.Targets, "new")
+
+	assert.Error(t, c.executeImport(server.URL+"/missing.yml"))
+}
+
+func TestConfigImportRejectsInvalidConfig(t *testing.T) {
+	cfgPath := useTestConfig(t, testUserConfig)
+	importPath := filepath.Join(t.TempDir(), "team.yml")
+	require.NoError(t, os.WriteFile(importPath, []byte("targets:\n  broken:\n    default-branch: main\n"), 0644))
+	before, err := os.ReadFile(cfgPath)
+	require.NoError(t, err)
+
+	c := newConfigImportCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	err = c.executeImport(importPath)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has no source")
+
+	after, err := os.ReadFile(cfgPath)
+	require.NoError(t, err)
+	assert.Equal(t, string(before), string(after), "the user configuration must not change")
+}
+
+func TestConfigImportKeepsEnvReferences(t *testing.T) {
+	t.Setenv("NIGIRI_TEST_HOST", "example.com")
+	cfgPath := useTestConfig(t, `targets:
+  existing:
+    source: https://${NIGIRI_TEST_HOST}/existing.git
+    build-command:
+      linux: make
+`)
+	importPath := filepath.Join(t.TempDir(), "team.yml")
+	require.NoError(t, os.WriteFile(importPath, []byte(`targets:
+  new:
+    source: https://$NIGIRI_TEST_HOST/new.git
+    build-command:
+      linux: make
+`), 0644))
+
+	cmd := newConfigCommand().cmd
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{"import", importPath})
+	require.NoError(t, cmd.Execute())
+
+	data, err := os.ReadFile(cfgPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "https://${NIGIRI_TEST_HOST}/existing.git")
+	assert.Contains(t, string(data), "https://$NIGIRI_TEST_HOST/new.git")
+	assert.NotContains(t, string(data), "example.com")
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		wantErr      bool
+		wantProblems []string
+	}{
+		{
+			name:         "valid configuration",
+			config:       testUserConfig,
+			wantProblems: []string{"is valid (2 targets)"},
+		},
+		{
+			name: "defaults provide the build command",
+			config: `defaults:
+  linux: make
+targets:
+  tool:
+    source: git@github.com:owner/tool.git
+`,
+			wantProblems: []string{"is valid (1 targets)"},
+		},
+		{
+			name: "problems are listed",
+			config: `defualts:
+  linux: make
+targets:
+  tool:
+    source: https://example.com/tool.git
+    defualt-branch: main
+    build-command:
+      linux: make
+      binary-paht: bin/tool
+  nosource:
+    build-command:
+      linux: make
+  nocommand:
+    source: https://example.com/nocommand.git
+  badurl:
+    source: "https://exa mple.com/repo"
+    build-command:
+      linux: make
+  nohost:
+    source: https:///repo.git
+    build-command:
+      linux: make
+`,
+			wantErr: true,
+			wantProblems: []string{
+				"Found 7 problem(s) in",
+				"  - unknown top-level key 'defualts'\n",
+				"  - unknown key 'defualt-branch' in target 'tool'\n",
+				"  - unknown key 'build-command.binary-paht' in target 'tool'\n",
+				"  - target 'nosource' has no source\n",
+				"  - target 'nocommand' has no build command\n",
+				"  - invalid source in target 'badurl': URL does not parse",
+				"  - invalid source in target 'nohost': URL https:///repo.git has no host\n",
+			},
+		},
+		{
+			name: "type errors are reported",
+			config: `targets:
+  tool:
+    source: https://example.com/tool.git
+    shell: [bash, -c]
+    build-command:
+      linux: make
+`,
+			wantErr:      true,
+			wantProblems: []string{"  - invalid type for 'shell' in target 'tool': expected string\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := useTestConfig(t, tt.config)
+
+			var out bytes.Buffer
+			cmd := newConfigCommand().cmd
+			cmd.SetOut(&out)
+			cmd.SetArgs([]string{"validate"})
+			err := cmd.Execute()
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "configuration "+cfgPath+" is invalid")
+			} else {
+				require.NoError(t, err)
+			}
+			for _, want := range tt.wantProblems {
+				assert.Contains(t, out.String(), want)
+			}
+		})
+	}
+}
+
+func TestConfigLint(t *testing.T) {
+	const risky = `targets:
+  tool:
+    source: https://github.com/owner/tool
+    default-branch: main
+    binary-only: true
+    env:
+      - API_TOKEN=${API_TOKEN}
+      - DEPLOY_SECRET=plaintext
+    build-command:
+      linux: cd cmd && make
+`
+	const clean = `targets:
+  tool:
+    source: https://github.com/owner/tool
+    default-branch: main
+    env:
+      - API_TOKEN=${API_TOKEN}
+    build-command:
+      linux: make
+      binary-path: bin/tool
+`
+	tests := []struct {
+		name    string
+		config  string
+		args    []string
+		wantErr bool
+		want    []string
+		notWant []string
+	}{
+		{
+			name:   "warnings are listed",
+			config: risky,
+			want: []string{
+				"Found 3 warning(s) in",
+				"  [binary-only] tool: binary-only is set without build-command.binary-path",
+				"  [portability] tool: the linux build command changes directory with cd",
+				"  [secret] tool: env DEPLOY_SECRET holds a secret in plaintext",
+			},
+			notWant: []string{"API_TOKEN", "=plaintext"},
+		},
+		{
+			name:    "strict fails on warnings",
+			config:  risky,
+			args:    []string{"--strict"},
+			wantErr: true,
+			want:    []string{"Found 3 warning(s) in"},
+		},
+		{
+			name:   "clean configuration",
+			config: clean,
+			args:   []string{"--strict"},
+			want:   []string{"No warnings in", "(1 targets)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("API_TOKEN", "secret-from-env")
+			cfgPath := useTestConfig(t, tt.config)
+
+			var out bytes.Buffer
+			cmd := newConfigCommand().cmd
+			cmd.SetOut(&out)
+			cmd.SetArgs(append([]string{"lint"}, tt.args...))
+			err := cmd.Execute()
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "configuration "+cfgPath+" has 3 warning(s)")
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Contains(t, out.String(), cfgPath)
+			for _, want := range tt.want {
+				assert.Contains(t, out.String(), want)
+			}
+			for _, notWant := range tt.notWant {
+				assert.NotContains(t, out.String(), notWant)
+			}
+		})
+	}
+}
+
+func TestConfigValidateFileArgument(t *testing.T) {
+	useTestConfig(t, testUserConfig)
+	path := filepath.Join(t.TempDir(), "team.yml")
+	require.NoError(t, os.WriteFile(path, []byte("targets:\n  tool:\n    source: https://example.com/tool.git\n"), 0644))
+
+	var out bytes.Buffer
+	cmd := newConfigCommand().cmd
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"validate", path})
+	require.Error(t, cmd.Execute())
+	assert.Contains(t, out.String(), "Found 1 problem(s) in "+path)
+	assert.Contains(t, out.String(), "target 'tool' has no build command")
+}
+
+func TestConfigAdd(t *testing.T) {
+	cfgPath := useTestConfig(t, testUserConfig)
+
+	var out bytes.Buffer
+	cmd := newConfigCommand().cmd
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"add", "tool", "--source", "https://example.com/tool.git", "--branch", "main", "--linux", "make tool", "--binary-path", "bin/tool"})
+	require.NoError(t, cmd.Execute())
+	assert.Contains(t, out.String(), "Added target 'tool' to "+cfgPath)
+
+	cm := loadTestConfig(t, cfgPath)
+	assert.Len(t, cm.Config.Targets, 3)
+	tool := cm.Config.Targets["tool"]
+	assert.Equal(t, "https://example.com/tool.git", tool.Sources)
+	assert.Equal(t, "main", tool.DefaultBranch)
+	assert.Equal(t, "make tool", tool.BuildCommand.Linux)
+	assert.Equal(t, "bin/tool", tool.BuildCommand.BinaryPathValue)
+	assert.Equal(t, "https://example.com/mine.git", cm.Config.Targets["shared"].Sources)
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "existing target", args: []string{"add", "shared", "--source", "https://example.com/x.git", "--linux", "make"}, wantErr: "target 'shared' already exists"},
+		{name: "no build command", args: []string{"add", "other", "--source", "https://example.com/x.git"}, wantErr: "target 'other' has no build command"},
+		{name: "no source", args: []string{"add", "other", "--linux", "make"}, wantErr: `required flag(s) "source" not set`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newConfigCommand().cmd
+			cmd.SetOut(&bytes.Buffer{})
+			cmd.SetArgs(tt.args)
+			err := cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Len(t, loadTestConfig(t, cfgPath).Config.Targets, 3)
+		})
+	}
+}
+
+func TestConfigAddCreatesConfig(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "nigiri", ".nigiri.yml")
+	originalCfgFile := cfgFileFlag
+	t.Cleanup(func() { cfgFileFlag = originalCfgFile })
+	cfgFileFlag = cfgPath
+
+	cmd := newConfigCommand().cmd
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{"add", "tool", "--source", "https://example.com/tool.git", "--linux", "make"})
+	require.NoError(t, cmd.Execute())
+	assert.Equal(t, "make", loadTestConfig(t, cfgPath).Config.Targets["tool"].BuildCommand.Linux)
+}
+
+func TestConfigSet(t *testing.T) {
+	cfgPath := useTestConfig(t, testUserConfig)
+
+	var out bytes.Buffer
+	cmd := newConfigCommand().cmd
+	cmd.SetOut(&out)
+	cmd.SetArgs([]string{"set", "shared", "binary-path", "bin/app"})
+	require.NoError(t, cmd.Execute())
+	assert.Contains(t, out.String(), `Set binary-path of target 'shared' to "bin/app"`)
+
+	cmd = newConfigCommand().cmd
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{"set", "shared", "keep-running", "true"})
+	require.NoError(t, cmd.Execute())
+
+	cm := loadTestConfig(t, cfgPath)
+	assert.Equal(t, "bin/app", cm.Config.Targets["shared"].BuildCommand.BinaryPathValue)
+	assert.True(t, cm.Config.Targets["shared"].KeepRunning)
+	assert.Equal(t, "https://example.com/mine.git", cm.Config.Targets["shared"].Sources)
+	assert.Equal(t, "make", cm.Config.Targets["existing"].BuildCommand.Linux)
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "missing target", args: []string{"set", "missing", "source", "x"}, wantErr: "target 'missing' not found"},
+		{name: "unknown key", args: []string{"set", "shared", "sorce", "x"}, wantErr: "unsupported key 'sorce'"},
+		{name: "invalid bool", args: []string{"set", "shared", "keep-running", "sometimes"}, wantErr: "invalid value for 'keep-running'"},
+		{name: "invalid target", args: []string{"set", "shared", "metadata", "partial"}, wantErr: "invalid metadata 'partial'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := newConfigCommand().cmd
+			cmd.SetOut(&bytes.Buffer{})
+			cmd.SetArgs(tt.args)
+			err := cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			assert.Empty(t, loadTestConfig(t, cfgPath).Config.Targets["shared"].Metadata)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+// assumeYesEnv is the environment variable that, when set to a true value,
+// makes every confirmation prompt auto-accept
+const assumeYesEnv = "NIGIRI_ASSUME_YES"
+
+// assumeYesFlag holds the value of the global --assume-yes flag
+var assumeYesFlag bool
+
+// assumeYes reports whether confirmation prompts should be accepted without
+// asking, either through the --assume-yes flag or the NIGIRI_ASSUME_YES
+// environment variable. Nothing is assumed unless one of them is set explicitly.
+//
+// Returns:
+//   - bool: True if prompts should be auto-accepted, false otherwise
+func assumeYes() bool {
+	if assumeYesFlag {
+		return true
+	}
+	v, err := strconv.ParseBool(os.Getenv(assumeYesEnv))
+	return err == nil && v
+}
+
+// confirm asks the user a yes/no question and reports whether they accepted.
+// The prompt is auto-accepted when assumeYes reports true.
+//
+// Parameters:
+//   - cmd: The command whose output the prompt is written to
+//   - prompt: The question to ask, without the trailing "(y/n)"
+//
+// Returns:
+//   - bool: True if the user answered yes, false otherwise
+//   - error: Any error encountered while reading the answer
+func confirm(cmd *cobra.Command, prompt string) (bool, error) {
+	cmd.Print(prompt + " (y/n): ")
+	if assumeYes() {
+		cmd.Println("y (assumed)")
+		return true, nil
+	}
+
+	answer, err := readAnswer(cmd)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(answer, "y"), nil
+}
+
+// deleteConfirmPhrase is the phrase the user types to confirm an operation
+// that removes the builds of every target
+const deleteConfirmPhrase = "DELETE"
+
+// confirmTyped asks the user to confirm a destructive operation by typing a
+// phrase, so that it cannot be accepted by a stray "y". The prompt is
+// auto-accepted when assumeYes reports true.
+//
+// Parameters:
+//   - cmd: The command whose output the prompt is written to
+//   - prompt: A description of what is about to happen
+//   - phrase: The phrase the user has to type, compared case-sensitively
+//
+// Returns:
+//   - bool: True if the user typed the phrase, false otherwise
+//   - error: Any error encountered while reading the answer
+func confirmTyped(cmd *cobra.Command, prompt, phrase string) (bool, error) {
+	cmd.Printf("%s\nType %s to continue: ", prompt, phrase)
+	if assumeYes() {
+		cmd.Println(phrase + " (assumed)")
+		return true, nil
+	}
+
+	answer, err := readAnswer(cmd)
+	if err != nil {
+		return false, err
+	}
+	if answer != phrase {
+		cmd.Printf("The confirmation did not match %s.\n", phrase)
+		return false, nil
+	}
+	return true, nil
+}
+
+// byteReader reads at most one byte per call, so that a scanner over it
+// stops at the end of a line instead of buffering input meant for later
+// prompts
+type byteReader struct {
+	r io.Reader
+}
+
+// Read reads a single byte into p
+func (b byteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return b.r.Read(p)
+}
+
+// readAnswer reads a line from the input of cmd, which is stdin unless the
+// command's input was replaced with SetIn
+//
+// Parameters:
+//   - cmd: The command whose input the answer is read from
+//
+// Returns:
+//   - string: The answer without surrounding whitespace
+//   - error: Any error encountered while reading, including the end of the input
+func readAnswer(cmd *cobra.Command) (string, error) {
+	scanner := bufio.NewScanner(byteReader{r: cmd.InOrStdin()})
+	if !scanner.Scan() {
+		err := scanner.Err()
+		if err == nil {
+			err = io.EOF
+		}
+		return "", logger.CreateErrorf("failed to read confirmation: %w", err)
+	}
+	return strings.TrimSpace(scanner.Text()), nil
+}
@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssumeYes(t *testing.T) {
+	tests := []struct {
+		name string
+		flag bool
+		env  string
+		want bool
+	}{
+		{name: "nothing set", want: false},
+		{name: "flag set", flag: true, want: true},
+		{name: "env true", env: "1", want: true},
+		{name: "env false", env: "false", want: false},
+		{name: "env invalid", env: "maybe", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalFlag := assumeYesFlag
+			defer func() { assumeYesFlag = originalFlag }()
+			assumeYesFlag = tt.flag
+			t.Setenv(assumeYesEnv, tt.env)
+			assert.Equal(t, tt.want, assumeYes())
+		})
+	}
+}
+
+func TestConfirmBypassedWhenAssumeYes(t *testing.T) {
+	t.Setenv(assumeYesEnv, "true")
+
+	// Nothing is readable from stdin, so a real prompt would fail
+	oldStdin := os.Stdin
+	r, w, _ := os.Pipe()
+	w.Close()
+	os.Stdin = r
+	defer func() { os.Stdin = oldStdin }()
+
+	var out bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&out)
+	ok, err := confirm(cmd, "Continue?")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Contains(t, out.String(), "Continue? (y/n): y (assumed)")
+}
+
+func TestRemoveAllRequiresExplicitAssumeYes(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", "abc1234"), 0755))
+
+	// Without the flag or env, remove --all must still prompt; an empty
+	// stdin makes the prompt fail and nothing is removed
+	t.Setenv(assumeYesEnv, "")
+	oldStdin := os.Stdin
+	r, w, _ := os.Pipe()
+	w.Close()
+	os.Stdin = r
+	defer func() { os.Stdin = oldStdin }()
+
+	c := newRemoveCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	assert.Error(t, c.executeRemoveAll())
+	assert.DirExists(t, filepath.Join(root, "tool"))
+
+	// With NIGIRI_ASSUME_YES set explicitly the prompt is bypassed
+	t.Setenv(assumeYesEnv, "1")
+	require.NoError(t, c.executeRemoveAll())
+	assert.NoDirExists(t, filepath.Join(root, "tool"))
+}
+
+func TestConfirmTyped(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{name: "phrase", input: "DELETE\n", want: true},
+		{name: "lower case", input: "delete\n"},
+		{name: "yes", input: "y\n"},
+		{name: "other word", input: "DELETED\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(assumeYesEnv, "")
+			oldStdin := os.Stdin
+			r, w, _ := os.Pipe()
+			_, _ = w.Write([]byte(tt.input))
+			w.Close()
+			os.Stdin = r
+			defer func() { os.Stdin = oldStdin }()
+
+			var out bytes.Buffer
+			cmd := &cobra.Command{}
+			cmd.SetOut(&out)
+			ok, err := confirmTyped(cmd, "This removes everything.", deleteConfirmPhrase)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ok)
+			assert.Contains(t, out.String(), "This removes everything.\nType DELETE to continue: ")
+			if !tt.want {
+				assert.Contains(t, out.String(), "The confirmation did not match DELETE.")
+			}
+		})
+	}
+}
+
+func TestRemoveAllTypedConfirmation(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        []string
+		input       string
+		wantRemoved bool
+	}{
+		{name: "phrase", args: []string{"--all"}, input: "DELETE\n", wantRemoved: true},
+		{name: "y is not enough", args: []string{"--all"}, input: "y\n"},
+		{name: "yes flag", args: []string{"--all", "--yes"}, wantRemoved: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", "abc1234"), 0755))
+			t.Setenv(assumeYesEnv, "")
+			oldStdin := os.Stdin
+			r, w, _ := os.Pipe()
+			_, _ = w.Write([]byte(tt.input))
+			w.Close()
+			os.Stdin = r
+			defer func() { os.Stdin = oldStdin }()
+
+			var out bytes.Buffer
+			c := newRemoveCommand()
+			c.cmd.SetOut(&out)
+			c.cmd.SetArgs(tt.args)
+			require.NoError(t, c.cmd.Execute())
+			if tt.wantRemoved {
+				assert.NoDirExists(t, filepath.Join(root, "tool"))
+				assert.Contains(t, out.String(), "1 targets removed successfully.")
+			} else {
+				assert.DirExists(t, filepath.Join(root, "tool"))
+				assert.Contains(t, out.String(), "Operation cancelled.")
+			}
+		})
+	}
+}
+
+func TestConfirmReadsCommandInput(t *testing.T) {
+	t.Setenv(assumeYesEnv, "")
+
+	// Answers to successive prompts are read from the same piped input
+	cmd := &cobra.Command{}
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetIn(strings.NewReader("y\n\n  Y  \nn\n"))
+	for _, want := range []bool{true, false, true, false} {
+		ok, err := confirm(cmd, "Continue?")
+		require.NoError(t, err)
+		assert.Equal(t, want, ok)
+	}
+
+	_, err := confirm(cmd, "Continue?")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read confirmation")
+}
+
+func TestPromptsReadCommandInput(t *testing.T) {
+	t.Setenv(assumeYesEnv, "")
+
+	t.Run("remove", func(t *testing.T) {
+		root := useTestNigiriRoot(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", "abc1234"), 0755))
+
+		c := newRemoveCommand()
+		var out bytes.Buffer
+		c.cmd.SetOut(&out)
+		c.cmd.SetIn(strings.NewReader("n\n"))
+		c.cmd.SetArgs([]string{"tool"})
+		require.NoError(t, c.cmd.Execute())
+		assert.DirExists(t, filepath.Join(root, "tool"))
+
+		c = newRemoveCommand()
+		c.cmd.SetOut(&out)
+		c.cmd.SetIn(strings.NewReader("y\n"))
+		c.cmd.SetArgs([]string{"tool"})
+		require.NoError(t, c.cmd.Execute())
+		assert.NoDirExists(t, filepath.Join(root, "tool"))
+	})
+
+	t.Run("init", func(t *testing.T) {
+		root := useTestNigiriRoot(t)
+		cfgPath := filepath.Join(root, ".nigiri.yml")
+		require.NoError(t, os.WriteFile(cfgPath, []byte("targets: {}\n"), 0644))
+
+		c := newInitCommand()
+		var out bytes.Buffer
+		c.cmd.SetOut(&out)
+		c.cmd.SetIn(strings.NewReader("n\n"))
+		c.cmd.SetArgs(nil)
+		require.NoError(t, c.cmd.Execute())
+		assert.Contains(t, out.String(), "Initialization cancelled.")
+		data, err := os.ReadFile(cfgPath)
+		require.NoError(t, err)
+		assert.Equal(t, "targets: {}\n", string(data))
+	})
+}
@@ -0,0 +1,31 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+)
+
+// diskFullError turns an error caused by a full disk, which otherwise
+// surfaces as a bare "no space left on device" from deep within cloning or
+// archiving, into one that tells the user how much space nigiri uses and how
+// to free it. Other errors are returned unchanged.
+//
+// Parameters:
+//   - err: The error to check
+//   - root: The nigiri root whose disk usage is reported
+//
+// Returns:
+//   - error: The error with the advice added if the disk is full, err otherwise
+func diskFullError(err error, root string) error {
+	if err == nil || !errors.Is(err, syscall.ENOSPC) {
+		return err
+	}
+	usage := "an unknown amount of space"
+	if size, sizeErr := dirutils.GetDirSize(root); sizeErr == nil {
+		usage = fmt.Sprintf("%.2f MB", float64(size)/(1024*1024))
+	}
+	return fmt.Errorf("%w\nThe disk is full. nigiri uses %s in %s; free space with 'nigiri cleanup --all' or 'nigiri gc' and try again", err, usage, root)
+}
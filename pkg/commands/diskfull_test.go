@@ -0,0 +1,51 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskFullError(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "bin"), make([]byte, 1024*1024), 0644))
+
+	assert.NoError(t, diskFullError(nil, root))
+	other := errors.New("permission denied")
+	assert.Equal(t, other, diskFullError(other, root))
+
+	full := fmt.Errorf("failed to clone repository: %w", &os.PathError{Op: "write", Path: "pack", Err: syscall.ENOSPC})
+	err := diskFullError(full, root)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, syscall.ENOSPC)
+	assert.Contains(t, err.Error(), "failed to clone repository: write pack: no space left on device")
+	assert.Contains(t, err.Error(), "nigiri uses 1.00 MB in "+root)
+	assert.Contains(t, err.Error(), "'nigiri cleanup --all' or 'nigiri gc'")
+}
+
+func TestBuildDiskFull(t *testing.T) {
+	repoDir, _ := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make app", "")
+
+	c := newBuildCommand()
+	c.runner = &exec.Fake{Handler: func(exec.Call) ([]byte, []byte, error) {
+		return nil, nil, &os.PathError{Op: "write", Path: "app", Err: syscall.ENOSPC}
+	}}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool"})
+	err := c.cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no space left on device")
+	assert.Contains(t, err.Error(), "The disk is full. nigiri uses")
+	assert.Contains(t, err.Error(), "in "+root)
+	assert.Contains(t, err.Error(), "'nigiri cleanup --all' or 'nigiri gc'")
+}
@@ -0,0 +1,299 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/pkg/config"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
+	"github.com/spf13/cobra"
+)
+
+// Statuses of a doctor check. Every failed check is critical and makes the
+// command fail; warnings are reported only.
+const (
+	doctorPass = "PASS"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+)
+
+// doctorStatusColors maps the statuses of doctor checks to their color
+var doctorStatusColors = map[string]string{
+	doctorPass: colorGreen,
+	doctorWarn: colorYellow,
+	doctorFail: colorRed,
+}
+
+// errAnonymousProbe is returned instead of a token when the sources are first
+// probed anonymously, so that private sources can be told apart
+var errAnonymousProbe = errors.New("no token is used for the anonymous probe")
+
+// doctorCheck is the outcome of one check of the doctor command
+type doctorCheck struct {
+	// name is what was checked, e.g. "nigiri root"
+	name string
+	// status is one of doctorPass, doctorWarn and doctorFail
+	status string
+	// detail explains the outcome
+	detail string
+}
+
+// doctorCommand represents the structure for the doctor command
+type doctorCommand struct {
+	cmd *cobra.Command
+	// probe lists the references of the source of g to check it is reachable
+	probe func(g *vcsutils.Git) error
+	// lookPath finds the shell build commands run through
+	lookPath func(file string) (string, error)
+	// tokens resolves the token of each host private sources are on
+	tokens *vcsutils.TokenCache
+}
+
+// newDoctorCommand creates a new doctor command instance which checks that
+// the environment nigiri runs in is set up correctly.
+//
+// Returns:
+//   - *doctorCommand: A configured doctor command instance
+func newDoctorCommand() *doctorCommand {
+	c := &doctorCommand{
+		probe: func(g *vcsutils.Git) error {
+			_, err := g.LsRemote()
+			return err
+		},
+		lookPath: osexec.LookPath,
+		tokens:   &vcsutils.TokenCache{},
+	}
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check that the environment is set up for nigiri",
+		Long: `Check the environment nigiri runs in and print a checklist: the nigiri root
+exists and is writable, the configuration file parses, the source of every
+target is reachable (requires network access), a token can be found for the
+sources that require authentication, and the shell build commands run through
+exists. Nothing is changed. The command fails if any check fails.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeDoctor()
+		},
+	}
+	c.cmd = cmd
+	return c
+}
+
+// executeDoctor runs every check and prints the checklist
+//
+// Returns:
+//   - error: An error if any check failed
+func (c *doctorCommand) executeDoctor() error {
+	checks := []doctorCheck{checkNigiriRoot()}
+	cm := newConfigManager()
+	checks = append(checks, checkConfig(cm))
+	if cm.Config != nil {
+		checks = append(checks, c.checkSources(cm.Config)...)
+		checks = append(checks, c.checkShells(cm.Config)...)
+	}
+
+	out := c.cmd.OutOrStdout()
+	color := logger.UseColor(out)
+	failed := 0
+	for _, check := range checks {
+		status := check.status
+		if color {
+			status = doctorStatusColors[check.status] + status + colorReset
+		}
+		fmt.Fprintf(out, "[%s] %s: %s\n", status, check.name, check.detail)
+		if check.status == doctorFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return logger.CreateErrorf("%d check(s) failed", failed)
+	}
+	return nil
+}
+
+// checkNigiriRoot checks that the nigiri root is a writable directory. A root
+// that does not exist yet is only a warning if it can be created.
+//
+// Returns:
+//   - doctorCheck: The outcome of the check
+func checkNigiriRoot() doctorCheck {
+	check := doctorCheck{name: "nigiri root"}
+	info, err := os.Stat(nigiriRoot)
+	switch {
+	case os.IsNotExist(err):
+		parent := existingAncestor(nigiriRoot)
+		if dirWritable(parent) {
+			check.status, check.detail = doctorWarn, fmt.Sprintf("%s does not exist yet; it is created by the first build", nigiriRoot)
+		} else {
+			check.status, check.detail = doctorFail, fmt.Sprintf("%s does not exist and cannot be created because %s is not writable", nigiriRoot, parent)
+		}
+	case err != nil:
+		check.status, check.detail = doctorFail, err.Error()
+	case !info.IsDir():
+		check.status, check.detail = doctorFail, fmt.Sprintf("%s is not a directory", nigiriRoot)
+	case !dirWritable(nigiriRoot):
+		check.status, check.detail = doctorFail, fmt.Sprintf("%s is not writable", nigiriRoot)
+	default:
+		check.status, check.detail = doctorPass, fmt.Sprintf("%s is writable", nigiriRoot)
+	}
+	return check
+}
+
+// existingAncestor returns the closest ancestor of path that exists
+//
+// Parameters:
+//   - path: The path that does not exist
+//
+// Returns:
+//   - string: The closest existing ancestor (the root of the file system at most)
+func existingAncestor(path string) string {
+	for {
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		if _, err := os.Stat(parent); err == nil {
+			return parent
+		}
+		path = parent
+	}
+}
+
+// checkConfig checks that the configuration file exists, parses and is
+// valid. The configuration is loaded into cm as a side effect.
+//
+// Parameters:
+//   - cm: The configuration manager to load the configuration with
+//
+// Returns:
+//   - doctorCheck: The outcome of the check
+func checkConfig(cm *config.ConfigManager) doctorCheck {
+	check := doctorCheck{name: "config"}
+	path, problems, err := cm.ValidateCfgFile()
+	switch {
+	case err != nil:
+		check.status, check.detail = doctorFail, fmt.Sprintf("failed to load configuration: %v", err)
+	case len(problems) > 0:
+		check.status, check.detail = doctorFail, fmt.Sprintf("%s has %d problem(s); run 'nigiri config validate' to list them", path, len(problems))
+	default:
+		check.status, check.detail = doctorPass, fmt.Sprintf("%s is valid", path)
+	}
+	return check
+}
+
+// checkSources checks that the source of every target is reachable. Sources
+// are first probed anonymously; the ones that require authentication must
+// have a token that the remote accepts.
+//
+// Parameters:
+//   - cfg: The configuration whose targets are checked
+//
+// Returns:
+//   - []doctorCheck: A check per source, preceded by a check per host a token is needed for
+func (c *doctorCommand) checkSources(cfg *internalconfig.Config) []doctorCheck {
+	targetsBySource := make(map[string][]string)
+	var sources []string
+	for _, name := range slices.Sorted(maps.Keys(cfg.Targets)) {
+		source := cfg.Targets[name].Sources
+		if source == "" {
+			continue
+		}
+		if _, ok := targetsBySource[source]; !ok {
+			sources = append(sources, source)
+		}
+		targetsBySource[source] = append(targetsBySource[source], name)
+	}
+
+	var checks []doctorCheck
+	checkedHosts := make(map[string]bool)
+	for _, source := range sources {
+		check := doctorCheck{name: fmt.Sprintf("source (%s)", strings.Join(targetsBySource[source], ", "))}
+		anonymous := &vcsutils.TokenCache{Resolve: func() (string, error) { return "", errAnonymousProbe }}
+		err := c.probe(&vcsutils.Git{Source: source, Tokens: anonymous})
+		if err != nil && !vcsutils.IsSSHSource(source) && vcsutils.IsAuthRequiredError(err) {
+			host := vcsutils.TokenHost(source)
+			_, tokenErr := c.tokens.TokenFor(host)
+			if !checkedHosts[host] {
+				checkedHosts[host] = true
+				checks = append(checks, tokenCheck(host, tokenErr))
+			}
+			if tokenErr != nil {
+				check.status, check.detail = doctorFail, fmt.Sprintf("%s requires authentication and no token was found for %s", source, host)
+				checks = append(checks, check)
+				continue
+			}
+			if err = c.probe(&vcsutils.Git{Source: source, Tokens: c.tokens}); err == nil {
+				check.status, check.detail = doctorPass, fmt.Sprintf("%s is reachable with the token for %s", source, host)
+				checks = append(checks, check)
+				continue
+			}
+		}
+		if err != nil {
+			check.status, check.detail = doctorFail, fmt.Sprintf("%s is not reachable: %v", source, err)
+		} else {
+			check.status, check.detail = doctorPass, fmt.Sprintf("%s is reachable", source)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
+
+// tokenCheck reports whether a token was found for host
+//
+// Parameters:
+//   - host: The host the token is for
+//   - err: The error of resolving the token, or nil if one was found
+//
+// Returns:
+//   - doctorCheck: The outcome of the check
+func tokenCheck(host string, err error) doctorCheck {
+	check := doctorCheck{name: fmt.Sprintf("token (%s)", host)}
+	if err != nil {
+		check.status, check.detail = doctorFail, err.Error()
+	} else {
+		check.status, check.detail = doctorPass, fmt.Sprintf("a token was found for %s", host)
+	}
+	return check
+}
+
+// checkShells checks that the shell every target's build commands run
+// through can be found
+//
+// Parameters:
+//   - cfg: The configuration whose targets are checked
+//
+// Returns:
+//   - []doctorCheck: A check per distinct shell
+func (c *doctorCommand) checkShells(cfg *internalconfig.Config) []doctorCheck {
+	targetsByShell := make(map[string][]string)
+	var shells []string
+	for _, name := range slices.Sorted(maps.Keys(cfg.Targets)) {
+		shell := shellCommand(cfg.Targets[name].Shell, runtime.GOOS, "")[0]
+		if _, ok := targetsByShell[shell]; !ok {
+			shells = append(shells, shell)
+		}
+		targetsByShell[shell] = append(targetsByShell[shell], name)
+	}
+
+	checks := make([]doctorCheck, 0, len(shells))
+	for _, shell := range shells {
+		check := doctorCheck{name: fmt.Sprintf("shell (%s)", strings.Join(targetsByShell[shell], ", "))}
+		if path, err := c.lookPath(shell); err != nil {
+			check.status, check.detail = doctorFail, fmt.Sprintf("%s was not found: %v", shell, err)
+		} else {
+			check.status, check.detail = doctorPass, fmt.Sprintf("%s found at %s", shell, path)
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package commands
+
+import "os"
+
+// dirWritable reports whether dir is writable from its permission bits,
+// without creating any file. Access control lists are not taken into account.
+//
+// Parameters:
+//   - dir: The directory to check
+//
+// Returns:
+//   - bool: True if dir is writable, false otherwise
+func dirWritable(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.Mode().Perm()&0200 != 0
+}
@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDoctorCommand returns a doctor command that finds every shell
+func newTestDoctorCommand() (*doctorCommand, *bytes.Buffer) {
+	c := newDoctorCommand()
+	c.lookPath = func(file string) (string, error) { return "/usr/bin/" + filepath.Base(file), nil }
+	var out bytes.Buffer
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{})
+	return c, &out
+}
+
+func TestDoctorHealthyEnvironment(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	repoDir, _ := createTestSourceRepo(t)
+	cfgPath := useTestBuildConfig(t, repoDir, "make", "")
+
+	c, out := newTestDoctorCommand()
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), fmt.Sprintf("[PASS] nigiri root: %s is writable", root))
+	assert.Contains(t, out.String(), fmt.Sprintf("[PASS] config: %s is valid", cfgPath))
+	assert.Contains(t, out.String(), fmt.Sprintf("[PASS] source (tool): %s is reachable", repoDir))
+	assert.Contains(t, out.String(), "[PASS] shell (tool): /bin/sh found at /usr/bin/sh")
+	assert.NotContains(t, out.String(), "token")
+}
+
+func TestDoctorMissingRootIsAWarning(t *testing.T) {
+	root := filepath.Join(useTestNigiriRoot(t), "missing")
+	nigiriRoot = root
+	repoDir, _ := createTestSourceRepo(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+
+	c, out := newTestDoctorCommand()
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), fmt.Sprintf("[WARN] nigiri root: %s does not exist yet", root))
+	assert.NoDirExists(t, root)
+}
+
+func TestDoctorFailures(t *testing.T) {
+	missingRepo := filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name     string
+		config   func(t *testing.T)
+		lookPath func(file string) (string, error)
+		wantOut  string
+	}{
+		{
+			name:    "missing config",
+			config:  func(t *testing.T) { cfgFileFlag = filepath.Join(t.TempDir(), "missing.yml") },
+			wantOut: "[FAIL] config: failed to load configuration",
+		},
+		{
+			name:    "invalid config",
+			config:  func(t *testing.T) { useTestConfig(t, "targets:\n  tool:\n    source: \"\"\n") },
+			wantOut: "problem(s); run 'nigiri config validate' to list them",
+		},
+		{
+			name:    "unreachable source",
+			config:  func(t *testing.T) { useTestBuildConfig(t, missingRepo, "make", "") },
+			wantOut: fmt.Sprintf("[FAIL] source (tool): %s is not reachable", missingRepo),
+		},
+		{
+			name: "missing shell",
+			config: func(t *testing.T) {
+				repoDir, _ := createTestSourceRepo(t)
+				useTestBuildConfig(t, repoDir, "make", "shell: zsh -c")
+			},
+			lookPath: func(file string) (string, error) { return "", fmt.Errorf("executable file not found in $PATH") },
+			wantOut:  "[FAIL] shell (tool): zsh was not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestNigiriRoot(t)
+			originalCfgFile := cfgFileFlag
+			t.Cleanup(func() { cfgFileFlag = originalCfgFile })
+			tt.config(t)
+
+			c, out := newTestDoctorCommand()
+			if tt.lookPath != nil {
+				c.lookPath = tt.lookPath
+			}
+			err := c.cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "1 check(s) failed")
+			assert.Contains(t, out.String(), tt.wantOut)
+		})
+	}
+}
+
+func TestDoctorPrivateSource(t *testing.T) {
+	const source = "https://github.com/octocat/private"
+
+	tests := []struct {
+		name      string
+		resolve   func() (string, error)
+		accepted  bool
+		wantErr   bool
+		wantLines []string
+	}{
+		{
+			name:     "token accepted",
+			resolve:  func() (string, error) { return "secret", nil },
+			accepted: true,
+			wantLines: []string{
+				"[PASS] token (github.com): a token was found for github.com",
+				"[PASS] source (tool): " + source + " is reachable with the token for github.com",
+			},
+		},
+		{
+			name:    "no token",
+			resolve: func() (string, error) { return "", fmt.Errorf("no token found for github.com") },
+			wantErr: true,
+			wantLines: []string{
+				"[FAIL] token (github.com): no token found for github.com",
+				"[FAIL] source (tool): " + source + " requires authentication and no token was found for github.com",
+			},
+		},
+		{
+			name:    "token rejected",
+			resolve: func() (string, error) { return "secret", nil },
+			wantErr: true,
+			wantLines: []string{
+				"[PASS] token (github.com): a token was found for github.com",
+				"[FAIL] source (tool): " + source + " is not reachable",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestNigiriRoot(t)
+			useTestBuildConfig(t, source, "make", "")
+
+			c, out := newTestDoctorCommand()
+			c.tokens = &vcsutils.TokenCache{Resolve: tt.resolve}
+			c.probe = func(g *vcsutils.Git) error {
+				if g.Tokens == c.tokens && tt.accepted {
+					return nil
+				}
+				return transport.ErrAuthenticationRequired
+			}
+			err := c.cmd.Execute()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			for _, line := range tt.wantLines {
+				assert.Contains(t, out.String(), line)
+			}
+		})
+	}
+}
+
+func TestExistingAncestor(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a"), 0755))
+	assert.Equal(t, filepath.Join(dir, "a"), existingAncestor(filepath.Join(dir, "a", "b", "c")))
+	assert.Equal(t, dir, existingAncestor(filepath.Join(dir, "x")))
+}
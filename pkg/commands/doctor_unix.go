@@ -0,0 +1,20 @@
+//go:build unix
+
+package commands
+
+import "syscall"
+
+// accessWrite is the W_OK mode of access(2)
+const accessWrite = 0x2
+
+// dirWritable reports whether the current user may create files in dir,
+// without creating any
+//
+// Parameters:
+//   - dir: The directory to check
+//
+// Returns:
+//   - bool: True if dir is writable, false otherwise
+func dirWritable(dir string) bool {
+	return syscall.Access(dir, accessWrite) == nil
+}
@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+// exportDirCommand represents the structure for the export-dir command
+type exportDirCommand struct {
+	cmd *cobra.Command
+	// to is the directory the build is copied to
+	to string
+}
+
+// newExportDirCommand creates a new export-dir command instance which copies
+// the complete commit directory of a build to a user path for inspection.
+//
+// Returns:
+//   - *exportDirCommand: A configured export-dir command instance
+func newExportDirCommand() *exportDirCommand {
+	c := &exportDirCommand{}
+	cmd := &cobra.Command{
+		Use:   "export-dir target commit --to path",
+		Short: "Copy the directory of a build to another location",
+		Long: `Copy the complete commit directory of a build, including its logs, metadata,
+source archive and binary, to the given path without archiving it.
+The directory structure, file permissions and symbolic links are preserved.
+The destination must not exist yet.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if c.to == "" {
+				return logger.CreateErrorf("--to is required")
+			}
+			return c.executeExportDir(args[0], args[1])
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return getInstalledTargets(toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			if len(args) == 1 {
+				return getTargetCommits(args[0], toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.StringVar(&c.to, "to", "", "Directory to copy the build to (must not exist)")
+
+	c.cmd = cmd
+	return c
+}
+
+// executeExportDir copies the commit directory of a build to c.to
+//
+// Parameters:
+//   - target: The name of the target
+//   - commitHash: The commit hash (or unique prefix of at least 7 characters) of the build
+//
+// Returns:
+//   - error: Any error encountered while finding or copying the build
+func (c *exportDirCommand) executeExportDir(target, commitHash string) error {
+	commitDir, err := findBuildDir(target, commitHash)
+	if err != nil {
+		return err
+	}
+	build := filepath.Base(commitDir)
+
+	dest, err := filepath.Abs(c.to)
+	if err != nil {
+		return logger.CreateErrorf("invalid destination %s: %w", c.to, err)
+	}
+	absCommitDir, err := filepath.Abs(commitDir)
+	if err != nil {
+		return logger.CreateErrorf("failed to resolve build directory: %w", err)
+	}
+	if rel, relErr := filepath.Rel(absCommitDir, dest); relErr == nil && (rel == "." || !strings.HasPrefix(rel, "..")) {
+		return logger.CreateErrorf("destination %s is inside the build directory %s", dest, absCommitDir)
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		return logger.CreateErrorf("destination %s already exists", dest)
+	} else if !os.IsNotExist(err) {
+		return logger.CreateErrorf("failed to check destination %s: %w", dest, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return logger.CreateErrorf("failed to create parent directory of %s: %w", dest, err)
+	}
+
+	if err := copyDir(commitDir, dest); err != nil {
+		_ = os.RemoveAll(dest)
+		return logger.CreateErrorf("failed to copy build %s of target %s: %w", build, target, err)
+	}
+	c.cmd.Printf("Copied build %s of target '%s' to %s\n", build, target, dest)
+	return nil
+}
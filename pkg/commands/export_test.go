@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewExportDirCommand(t *testing.T) {
+	cmd := newExportDirCommand()
+	assert.NotNil(t, cmd)
+	assert.NotNil(t, cmd.cmd)
+}
+
+// treeEntry describes a file in a directory tree for comparing trees
+type treeEntry struct {
+	mode    fs.FileMode
+	content string
+}
+
+// readTree returns every entry below dir keyed by its relative path
+func readTree(t *testing.T, dir string) map[string]treeEntry {
+	t.Helper()
+	tree := map[string]treeEntry{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		entry := treeEntry{mode: info.Mode()}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			entry.content, err = os.Readlink(path)
+		case info.Mode().IsRegular():
+			var data []byte
+			data, err = os.ReadFile(path)
+			entry.content = string(data)
+		}
+		tree[rel] = entry
+		return err
+	})
+	require.NoError(t, err)
+	return tree
+}
+
+func TestExportDir(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	commitDir := filepath.Join(root, "tool", "abcdef1")
+	files := map[string]string{
+		"bin":                                 "binary",
+		"source.tar.gz":                       "archive",
+		"build-info.json":                     `{"commit":"abcdef1"}`,
+		filepath.Join("logs", "build.log"):    "build output",
+		filepath.Join("src", "cmd", "app.go"): "package main",
+	}
+	for name, content := range files {
+		path := filepath.Join(commitDir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	require.NoError(t, os.Chmod(filepath.Join(commitDir, "bin"), 0755))
+	if runtime.GOOS != "windows" {
+		require.NoError(t, os.Symlink("cmd/app.go", filepath.Join(commitDir, "src", "main.go")))
+	}
+
+	dest := filepath.Join(t.TempDir(), "inspect", "build")
+	var out bytes.Buffer
+	c := newExportDirCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", "abcdef1", "--to", dest})
+	require.NoError(t, c.cmd.Execute())
+
+	assert.Equal(t, readTree(t, commitDir), readTree(t, dest))
+	assert.Contains(t, out.String(), "Copied build abcdef1 of target 'tool' to "+dest)
+}
+
+func TestExportDirErrors(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	commitDir := filepath.Join(root, "tool", "abcdef1")
+	require.NoError(t, os.MkdirAll(commitDir, 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", "abcdef2"), 0755))
+	existing := t.TempDir()
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "missing destination", args: []string{"tool", "abcdef1"}, wantErr: "--to is required"},
+		{name: "unknown commit", args: []string{"tool", "1234567", "--to", filepath.Join(existing, "new")}, wantErr: "no build found for commit 1234567"},
+		{name: "short commit", args: []string{"tool", "abcdef", "--to", filepath.Join(existing, "new")}, wantErr: "commit hash is too short"},
+		{name: "existing destination", args: []string{"tool", "abcdef1", "--to", existing}, wantErr: "already exists"},
+		{name: "destination inside build", args: []string{"tool", "abcdef1", "--to", filepath.Join(commitDir, "copy")}, wantErr: "inside the build directory"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newExportDirCommand()
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs(tt.args)
+			err := c.cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+	assert.NoDirExists(t, filepath.Join(commitDir, "copy"))
+}
@@ -0,0 +1,327 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/spf13/cobra"
+)
+
+// gcItemKind describes why gc removes an item
+type gcItemKind string
+
+const (
+	// gcOrphanedTarget is a target directory whose target is no longer configured
+	gcOrphanedTarget gcItemKind = "orphaned target"
+	// gcFailedBuild is a build directory whose recorded build failed
+	gcFailedBuild gcItemKind = "failed build"
+	// gcSwapLeftover is a hidden directory left in a target directory by a
+	// build that was interrupted while being moved into place
+	gcSwapLeftover gcItemKind = "interrupted build swap"
+	// gcEmptyTarget is a target directory left without builds
+	gcEmptyTarget gcItemKind = "empty target"
+	// gcUnreferencedBlob is a file in the content-addressed store no build links to
+	gcUnreferencedBlob gcItemKind = "unreferenced blob"
+	// gcUnusedCloneCache is a clone cache entry of a source no target is built from
+	gcUnusedCloneCache gcItemKind = "unused clone cache"
+)
+
+// gcItem is a directory gc removes
+type gcItem struct {
+	kind gcItemKind
+	// name identifies the item relative to the nigiri root
+	name string
+	path string
+	// size is the disk space reclaimed by removing the item
+	size int64
+}
+
+// gcCommand represents the structure for the gc command
+type gcCommand struct {
+	cmd         *cobra.Command
+	dryRun      bool
+	skipConfirm bool
+}
+
+// newGCCommand creates a new gc command instance which reclaims disk space by
+// removing everything nigiri no longer needs in a single pass.
+//
+// Returns:
+//   - *gcCommand: A configured gc command instance
+func newGCCommand() *gcCommand {
+	c := &gcCommand{}
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove orphaned targets, failed builds and empty targets",
+		Long: `Reclaim disk space in a single pass by removing:
+  - target directories whose target is no longer in the configuration
+  - builds whose recorded build failed
+  - directories left behind by a build --build-in-temp that was interrupted
+    while being moved into place
+  - target directories that contain no builds
+  - files in the content-addressed store (build --cas) that no build links to
+  - clone cache entries of sources no configured target is built from
+Targets with a build in progress, builds that are being run and clone cache
+entries in use are left untouched.
+Running gc again after it completes finds nothing to remove.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeGC()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVarP(&c.dryRun, "dry-run", "d", false, "Show what would be removed without actually removing anything")
+	flags.BoolVarP(&c.skipConfirm, "yes", "y", false, "Skip confirmation prompt")
+
+	c.cmd = cmd
+	return c
+}
+
+// executeGC finds and removes everything gc collects
+//
+// Returns:
+//   - error: Any error encountered while collecting
+func (c *gcCommand) executeGC() error {
+	if nigiriRootMissing() {
+		c.cmd.Println(noTargetsMessage)
+		return nil
+	}
+
+	// Without a configuration, every target and clone cache entry would
+	// look orphaned
+	var configured, cacheKeys map[string]bool
+	cm := newConfigManager()
+	if err := cm.LoadCfgFile(); err != nil {
+		c.cmd.Printf("Warning: Skipping orphaned targets and clone cache entries: failed to load configuration: %v\n", err)
+	} else {
+		configured = make(map[string]bool, len(cm.Config.Targets))
+		cacheKeys = make(map[string]bool, len(cm.Config.Targets))
+		for name, target := range cm.Config.Targets {
+			configured[name] = true
+			if target.Sources != "" {
+				cacheKeys[targets.CloneCacheKey(target.Sources)] = true
+			}
+		}
+	}
+
+	items, locked, err := planGC(nigiriRoot, configured, cacheKeys)
+	if err != nil {
+		return err
+	}
+	for _, name := range locked {
+		c.cmd.Printf("Skipping target '%s': a build is in progress.\n", name)
+	}
+	if len(items) == 0 {
+		c.cmd.Println("Nothing to collect.")
+		return nil
+	}
+
+	var total int64
+	for _, item := range items {
+		c.cmd.Printf("  %s: %s (%.2f MB)\n", item.kind, item.name, float64(item.size)/(1024*1024))
+		total += item.size
+	}
+	c.cmd.Printf("Found %d items to remove, freeing approximately %.2f MB of disk space.\n",
+		len(items), float64(total)/(1024*1024))
+
+	if c.dryRun {
+		c.cmd.Println("\nDry run: Nothing was removed.")
+		return nil
+	}
+
+	if !c.skipConfirm {
+		ok, err := confirm(c.cmd, "\nDo you want to continue?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			c.cmd.Println("Garbage collection cancelled.")
+			return nil
+		}
+	}
+
+	removed, reclaimed := removeGCItems(items, func(item gcItem, err error) {
+		c.cmd.Printf("Warning: Failed to remove %s '%s': %v\n", item.kind, item.name, err)
+	})
+	c.cmd.Printf("%d items removed, reclaiming %.2f MB of disk space.\n", removed, float64(reclaimed)/(1024*1024))
+	return nil
+}
+
+// planGC finds what gc removes under root. Failed builds of a target are
+// listed before the target itself, which is listed as empty when no other
+// builds remain, and blobs are listed after them counting only the links that
+// survive the removals, so removing the items in order leaves nothing for a
+// second pass. Unused clone cache entries are listed last.
+//
+// Parameters:
+//   - root: The nigiri root directory
+//   - configured: The configured target names, or nil to keep every target
+//   - cacheKeys: The clone cache keys of the configured sources, or nil to keep every entry
+//
+// Returns:
+//   - []gcItem: The items to remove, in removal order
+//   - []string: The targets skipped because a build is in progress
+//   - error: Any error encountered while reading the nigiri root
+func planGC(root string, configured, cacheKeys map[string]bool) ([]gcItem, []string, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to read nigiri root directory: %w", err)
+	}
+
+	var items []gcItem
+	var locked []string
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		name := entry.Name()
+		targetDir := filepath.Join(root, name)
+		if targets.IsBuildLocked(targetDir) {
+			locked = append(locked, name)
+			continue
+		}
+		builds, err := os.ReadDir(targetDir)
+		if err != nil {
+			continue
+		}
+
+		targetSize, _ := dirutils.GetDirSize(targetDir)
+		if configured != nil && !configured[name] && !anyBuildKept(targetDir, builds) {
+			items = append(items, gcItem{kind: gcOrphanedTarget, name: name, path: targetDir, size: targetSize})
+			continue
+		}
+
+		remaining := 0
+		var failedSize int64
+		for _, build := range builds {
+			if !build.IsDir() {
+				continue
+			}
+			buildDir := filepath.Join(targetDir, build.Name())
+			if strings.HasPrefix(build.Name(), ".") {
+				// Hidden directories are only used while a build is swapped
+				// into place, under the build lock of the target
+				size, _ := dirutils.GetDirSize(buildDir)
+				failedSize += size
+				items = append(items, gcItem{kind: gcSwapLeftover, name: name + "/" + build.Name(), path: buildDir, size: size})
+				continue
+			}
+			if !isFailedBuild(buildDir) || targets.IsRunning(buildDir) || dirutils.IsPinned(buildDir) {
+				remaining++
+				continue
+			}
+			size, _ := dirutils.GetDirSize(buildDir)
+			failedSize += size
+			items = append(items, gcItem{kind: gcFailedBuild, name: name + "/" + build.Name(), path: buildDir, size: size})
+		}
+		if remaining == 0 {
+			items = append(items, gcItem{kind: gcEmptyTarget, name: name, path: targetDir, size: targetSize - failedSize})
+		}
+	}
+
+	removing := make([]string, 0, len(items))
+	for _, item := range items {
+		removing = append(removing, item.path)
+	}
+	blobs, err := targets.UnreferencedCASBlobs(filepath.Join(root, targets.CASDirName), removing)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, blob := range blobs {
+		name, _ := filepath.Rel(root, blob.Path)
+		items = append(items, gcItem{kind: gcUnreferencedBlob, name: filepath.ToSlash(name), path: blob.Path, size: blob.Size})
+	}
+
+	if cacheKeys != nil {
+		entries, err := targets.ListCloneCache(filepath.Join(root, targets.CloneCacheDirName))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, entry := range entries {
+			if cacheKeys[entry.Key] || entry.Locked {
+				continue
+			}
+			size, _ := dirutils.GetDirSize(entry.Path)
+			items = append(items, gcItem{kind: gcUnusedCloneCache, name: targets.CloneCacheDirName + "/" + entry.Key, path: entry.Path, size: size})
+		}
+	}
+	return items, locked, nil
+}
+
+// removeGCItems removes the items in order
+//
+// Parameters:
+//   - items: The items to remove
+//   - onError: Called for each item that could not be removed
+//
+// Returns:
+//   - int: The number of items removed
+//   - int64: The disk space reclaimed in bytes
+func removeGCItems(items []gcItem, onError func(gcItem, error)) (int, int64) {
+	removed := 0
+	var reclaimed int64
+	for _, item := range items {
+		if err := removeGCItem(item); err != nil {
+			onError(item, err)
+			continue
+		}
+		removed++
+		reclaimed += item.size
+	}
+	return removed, reclaimed
+}
+
+// removeGCItem removes one item. A clone may have started using a clone
+// cache entry since it was planned, and none may start while it is removed.
+//
+// Parameters:
+//   - item: The item to remove
+//
+// Returns:
+//   - error: Any error encountered while removing the item
+func removeGCItem(item gcItem) error {
+	if item.kind != gcUnusedCloneCache {
+		return os.RemoveAll(item.path)
+	}
+	release, locked, err := targets.TryAcquireCloneCacheLock(item.path)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return errors.New("the entry is in use")
+	}
+	defer release()
+	return os.RemoveAll(item.path)
+}
+
+// isFailedBuild reports whether the build metadata in buildDir records a
+// failed build. Builds without readable metadata and source archives are not
+// considered failed.
+func isFailedBuild(buildDir string) bool {
+	info, err := targets.ReadBuildInfo(buildDir)
+	return err == nil && info.Status == targets.BuildStatusFailed
+}
+
+// anyBuildKept reports whether any of the builds in targetDir is being run
+// or pinned, which keeps the target from being removed
+func anyBuildKept(targetDir string, builds []os.DirEntry) bool {
+	for _, build := range builds {
+		if !build.IsDir() {
+			continue
+		}
+		buildDir := filepath.Join(targetDir, build.Name())
+		if targets.IsRunning(buildDir) || dirutils.IsPinned(buildDir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,238 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createGCTestBuild creates a build directory holding size bytes and the
+// build metadata recording status
+func createGCTestBuild(t *testing.T, root, target, build, status string, size int) string {
+	t.Helper()
+	buildDir := filepath.Join(root, target, build)
+	require.NoError(t, os.MkdirAll(buildDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(buildDir, "bin"), make([]byte, size), 0755))
+	require.NoError(t, targets.WriteBuildInfo(buildDir, &targets.BuildInfo{Status: status}))
+	return buildDir
+}
+
+func TestGC(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	useTestConfig(t, `
+targets:
+  tool:
+    source: https://example.com/tool
+    build-command:
+      linux: make
+  failing:
+    source: https://example.com/failing
+    build-command:
+      linux: make
+  empty:
+    source: https://example.com/empty
+    build-command:
+      linux: make
+`)
+	good := createGCTestBuild(t, root, "tool", "good123", targets.BuildStatusSuccess, 100<<10)
+	bad := createGCTestBuild(t, root, "tool", "bad4567", targets.BuildStatusFailed, 200<<10)
+	createGCTestBuild(t, root, "failing", "bad8901", targets.BuildStatusFailed, 300<<10)
+	createGCTestBuild(t, root, "removed", "good234", targets.BuildStatusSuccess, 400<<10)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "empty"), 0755))
+	running := createGCTestBuild(t, root, "tool", "run5678", targets.BuildStatusFailed, 10)
+	release, err := targets.MarkRunning(running, os.Getpid())
+	require.NoError(t, err)
+	defer release()
+
+	var want int64
+	for _, dir := range []string{bad, filepath.Join(root, "failing"), filepath.Join(root, "removed")} {
+		size, err := dirutils.GetDirSize(dir)
+		require.NoError(t, err)
+		want += size
+	}
+
+	items, _, err := planGC(root, map[string]bool{"tool": true, "failing": true, "empty": true}, nil)
+	require.NoError(t, err)
+	var got []string
+	var total int64
+	for _, item := range items {
+		got = append(got, fmt.Sprintf("%s: %s", item.kind, item.name))
+		total += item.size
+	}
+	assert.ElementsMatch(t, []string{
+		"empty target: empty",
+		"failed build: failing/bad8901",
+		"empty target: failing",
+		"orphaned target: removed",
+		"failed build: tool/bad4567",
+	}, got)
+	assert.Equal(t, want, total)
+
+	// A dry run removes nothing
+	var out bytes.Buffer
+	c := newGCCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"--dry-run"})
+	require.NoError(t, c.cmd.Execute())
+	assert.DirExists(t, bad)
+	assert.Contains(t, out.String(), "Dry run")
+
+	out.Reset()
+	c = newGCCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"--yes"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), fmt.Sprintf("5 items removed, reclaiming %.2f MB", float64(want)/(1024*1024)))
+	for _, name := range []string{"empty", "failing", "removed"} {
+		assert.NoDirExists(t, filepath.Join(root, name))
+	}
+	assert.NoDirExists(t, bad)
+	assert.DirExists(t, good)
+	assert.DirExists(t, running, "builds being run must be kept")
+
+	// A second pass finds nothing left to collect
+	out.Reset()
+	c = newGCCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"--yes"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "Nothing to collect.")
+}
+
+func TestGCSwapLeftovers(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	good := createGCTestBuild(t, root, "tool", "good123", targets.BuildStatusSuccess, 10)
+	leftover := createGCTestBuild(t, root, "tool", ".good123.old-1", targets.BuildStatusSuccess, 10)
+	createGCTestBuild(t, root, "swapped", ".abc1234.new-1", targets.BuildStatusSuccess, 10)
+	inProgress := createGCTestBuild(t, root, "building", ".def5678.new-1", targets.BuildStatusSuccess, 10)
+	release, err := targets.AcquireBuildLock(filepath.Join(root, "building"))
+	require.NoError(t, err)
+	defer release()
+
+	items, locked, err := planGC(root, nil, nil)
+	require.NoError(t, err)
+	var got []string
+	for _, item := range items {
+		got = append(got, fmt.Sprintf("%s: %s", item.kind, item.name))
+	}
+	assert.ElementsMatch(t, []string{
+		"interrupted build swap: tool/.good123.old-1",
+		"interrupted build swap: swapped/.abc1234.new-1",
+		"empty target: swapped",
+	}, got)
+	assert.Equal(t, []string{"building"}, locked)
+
+	removed, _ := removeGCItems(items, func(item gcItem, err error) {
+		t.Errorf("failed to remove %s: %v", item.name, err)
+	})
+	assert.Equal(t, 3, removed)
+	assert.DirExists(t, good)
+	assert.NoDirExists(t, leftover)
+	assert.NoDirExists(t, filepath.Join(root, "swapped"))
+	assert.DirExists(t, inProgress, "a swap under the build lock must be kept")
+}
+
+func TestGCWithoutConfigKeepsTargets(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	useTestConfig(t, "")
+	createGCTestBuild(t, root, "tool", "good123", targets.BuildStatusSuccess, 10)
+
+	items, _, err := planGC(root, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+
+	var out bytes.Buffer
+	c := newGCCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"--yes"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "Skipping orphaned targets")
+	assert.DirExists(t, filepath.Join(root, "tool", "good123"))
+}
+
+func TestGCUnreferencedBlobs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard link counts are not reported on Windows")
+	}
+	root := useTestNigiriRoot(t)
+	casDir := filepath.Join(root, targets.CASDirName)
+	good := createGCTestBuild(t, root, "tool", "good123", targets.BuildStatusSuccess, 0)
+	bad := createGCTestBuild(t, root, "tool", "bad4567", targets.BuildStatusFailed, 0)
+	require.NoError(t, os.MkdirAll(filepath.Join(good, "src"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(bad, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(good, "src", "shared"), []byte("shared\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(bad, "src", "shared"), []byte("shared\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(bad, "src", "failed-only"), []byte("failed\n"), 0644))
+	for _, dir := range []string{good, bad} {
+		stats, err := targets.StoreInCAS(casDir, filepath.Join(dir, "src"))
+		require.NoError(t, err)
+		if dir == bad && stats.Shared == 0 {
+			t.Skip("hard links are not supported")
+		}
+	}
+
+	// The blob only the failed build links to is collected with it
+	items, _, err := planGC(root, nil, nil)
+	require.NoError(t, err)
+	var got []string
+	for _, item := range items {
+		got = append(got, string(item.kind))
+	}
+	assert.Equal(t, []string{string(gcFailedBuild), string(gcUnreferencedBlob)}, got)
+	assert.Equal(t, int64(len("failed\n")), items[1].size)
+
+	c := newGCCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"--yes"})
+	require.NoError(t, c.cmd.Execute())
+	assert.FileExists(t, filepath.Join(good, "src", "shared"))
+	items, _, err = planGC(root, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
+
+func TestGCUnusedCloneCache(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	useTestConfig(t, `
+targets:
+  tool:
+    source: https://example.com/tool
+    build-command:
+      linux: make
+`)
+	cacheDir := filepath.Join(root, targets.CloneCacheDirName)
+	entry := func(source string) string {
+		dir := filepath.Join(cacheDir, targets.CloneCacheKey(source))
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		return dir
+	}
+	used := entry("git@example.com:tool.git")
+	unused := entry("https://example.com/removed")
+	inUse := entry("https://example.com/building")
+	release, err := targets.AcquireCloneCacheLock(inUse)
+	require.NoError(t, err)
+	defer release()
+
+	var out bytes.Buffer
+	c := newGCCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"--yes"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "unused clone cache: "+targets.CloneCacheDirName+"/"+filepath.Base(unused))
+	assert.NoDirExists(t, unused)
+	assert.DirExists(t, used, "the entry of a configured source must be kept")
+	assert.DirExists(t, inUse, "entries in use must be kept")
+
+	// Without a configuration, no entry looks unused
+	items, _, err := planGC(root, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
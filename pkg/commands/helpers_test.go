@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// useTestNigiriRoot points nigiriRoot at a fresh temporary directory for the
+// duration of the test and returns it
+func useTestNigiriRoot(t *testing.T) string {
+	t.Helper()
+	originalNigiriRoot := nigiriRoot
+	t.Cleanup(func() { nigiriRoot = originalNigiriRoot })
+	nigiriRoot = t.TempDir()
+	return nigiriRoot
+}
+
+// useTestConfig writes content to a temporary configuration file and points
+// the global --config flag at it for the duration of the test
+func useTestConfig(t *testing.T, content string) string {
+	t.Helper()
+	cfgPath := filepath.Join(t.TempDir(), ".nigiri.yml")
+	if err := os.WriteFile(cfgPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write test config: %v", err)
+	}
+	originalCfgFile := cfgFileFlag
+	t.Cleanup(func() { cfgFileFlag = originalCfgFile })
+	cfgFileFlag = cfgPath
+	return cfgPath
+}
+
+// useTestBuildConfig writes a configuration with a single target "tool" built
+// from source with command on every OS. extra holds additional target keys,
+// each on its own line without indentation.
+func useTestBuildConfig(t *testing.T, source, command, extra string) string {
+	t.Helper()
+	content := fmt.Sprintf(`targets:
+  tool:
+    source: %s
+    default-branch: master
+    build-command:
+      linux: %q
+      darwin: %q
+      binary-path: bin/app
+`, source, command, command)
+	for _, line := range strings.Split(strings.TrimSpace(extra), "\n") {
+		if line != "" {
+			content += "    " + line + "\n"
+		}
+	}
+	return useTestConfig(t, content)
+}
+
+// createTestCommitDir creates a commit directory for target containing a bin
+// shell script that runs script, and returns the commit directory
+func createTestCommitDir(t *testing.T, root, target, shortHash, script string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("shell script binaries are not supported on Windows")
+	}
+	commitDir := filepath.Join(root, target, shortHash)
+	if err := os.MkdirAll(filepath.Join(commitDir, "logs"), 0755); err != nil {
+		t.Fatalf("Failed to create commit directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(commitDir, "bin"), []byte("#!/bin/sh\n"+script+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write test binary: %v", err)
+	}
+	return commitDir
+}
+
+// createTestSourceRepo creates a local git repository on the master branch
+// with a single commit and returns its path and the commit hash
+func createTestSourceRepo(t *testing.T) (repoDir, hash string) {
+	t.Helper()
+	return createTestSourceRepoWithFiles(t, map[string]string{"README": "test\n"})
+}
+
+// createTestSourceRepoWithFiles creates a git repository with a single commit
+// adding files, keyed by their slash-separated paths, and returns the
+// repository directory and the commit hash
+func createTestSourceRepoWithFiles(t *testing.T, files map[string]string) (repoDir, hash string) {
+	t.Helper()
+	repoDir = t.TempDir()
+	r, err := git.PlainInit(repoDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("Failed to get worktree: %v", err)
+	}
+	for name, content := range files {
+		path := filepath.Join(repoDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		if _, err := w.Add(name); err != nil {
+			t.Fatalf("Failed to add file: %v", err)
+		}
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	h, err := w.Commit("initial", &git.CommitOptions{Author: sig})
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	return repoDir, h.String()
+}
@@ -0,0 +1,216 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/commits"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+// infoCommand represents the structure for the info command
+type infoCommand struct {
+	cmd *cobra.Command
+}
+
+// newInfoCommand creates a new info command instance which shows the build
+// metadata and the artifacts of a single build.
+//
+// Returns:
+//   - *infoCommand: A configured info command instance
+func newInfoCommand() *infoCommand {
+	c := &infoCommand{}
+	cmd := &cobra.Command{
+		Use:   "info target commit",
+		Short: "Show the details of a build",
+		Long: `Show the recorded metadata of a build, such as its full commit hash, durations
+and platform, along with whether its binary and source archive are present and
+its size on disk. The commit can be a unique prefix of at least 7 characters.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeInfo(args[0], args[1])
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return getInstalledTargets(toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			if len(args) == 1 {
+				return getTargetCommits(args[0], toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+	c.cmd = cmd
+	return c
+}
+
+// checkCommitPrefix checks that a commit prefix selecting a build of target
+// is at least as long as the target's short hashes (short-hash-length, 7 by
+// default). Targets that are not configured, including when the
+// configuration cannot be loaded, use the default length.
+//
+// Parameters:
+//   - target: The name of the target
+//   - commitHash: The commit hash or prefix
+//
+// Returns:
+//   - error: An error if the prefix is too short
+func checkCommitPrefix(target, commitHash string) error {
+	minLength := internalconfig.DefaultShortHashLength
+	cm := newConfigManager()
+	if err := cm.LoadCfgFile(); err == nil {
+		minLength = cm.Config.Targets[target].ShortHashLength()
+	}
+	if len(commitHash) < minLength {
+		return logger.CreateErrorf("commit hash is too short: %s (minimum %d characters)", commitHash, minLength)
+	}
+	return nil
+}
+
+// findBuildDir resolves a commit hash or unique prefix to the commit
+// directory of a build of a target
+//
+// Parameters:
+//   - target: The name of the target
+//   - commitHash: The commit hash (or unique prefix of at least 7 characters) of the build
+//
+// Returns:
+//   - string: The commit directory of the build
+//   - error: An error if the target or build does not exist or the prefix is ambiguous
+func findBuildDir(target, commitHash string) (string, error) {
+	if nigiriRootMissing() {
+		return "", errNoTargets
+	}
+	t := targets.Target{Target: target}
+	targetRootDir, err := t.GetTargetRootDir(nigiriRoot)
+	if err != nil {
+		return "", logger.CreateErrorf("target '%s' not found", target)
+	}
+	if err := checkCommitPrefix(target, commitHash); err != nil {
+		return "", err
+	}
+
+	dirs, err := os.ReadDir(targetRootDir)
+	if err != nil {
+		return "", logger.CreateErrorf("failed to read target directory: %w", err)
+	}
+	var matchingDirs []string
+	for _, dir := range dirs {
+		if dir.IsDir() && commits.HasHashPrefix(dir.Name(), commitHash) {
+			matchingDirs = append(matchingDirs, dir.Name())
+		}
+	}
+	if len(matchingDirs) == 0 {
+		return "", logger.CreateErrorf("no build found for commit %s", commitHash)
+	}
+	if len(matchingDirs) > 1 {
+		return "", logger.CreateErrorf("multiple builds match commit %s: %s", commitHash, strings.Join(matchingDirs, ", "))
+	}
+	return filepath.Join(targetRootDir, matchingDirs[0]), nil
+}
+
+// executeInfo prints the details of the build of target at commitHash
+//
+// Parameters:
+//   - target: The name of the target
+//   - commitHash: The commit hash (or unique prefix of at least 7 characters) of the build
+//
+// Returns:
+//   - error: Any error encountered while finding the build or reading its metadata
+func (c *infoCommand) executeInfo(target, commitHash string) error {
+	commitDir, err := findBuildDir(target, commitHash)
+	if err != nil {
+		return err
+	}
+
+	info, err := targets.ReadBuildInfo(commitDir)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		// Builds made with metadata disabled only have their directory name
+		info = &targets.BuildInfo{ShortHash: filepath.Base(commitDir)}
+	default:
+		return logger.CreateErrorf("failed to read build metadata: %w", err)
+	}
+
+	c.cmd.Printf("Target:          %s\n", target)
+	if info.Commit != "" {
+		c.cmd.Printf("Commit:          %s\n", info.Commit)
+	} else {
+		c.cmd.Println("Commit:          unknown (no build metadata recorded)")
+	}
+	shortHash := info.ShortHash
+	if shortHash == "" {
+		shortHash = filepath.Base(commitDir)
+	}
+	c.cmd.Printf("Short hash:      %s\n", shortHash)
+	if info.Ref != "" {
+		c.cmd.Printf("Ref:             %s\n", info.Ref)
+	}
+	if info.PullRequest > 0 {
+		c.cmd.Printf("Pull request:    #%d\n", info.PullRequest)
+	}
+	if info.Tag != "" {
+		c.cmd.Printf("Tag:             %s\n", info.Tag)
+	}
+	status := info.Status
+	if status == "" {
+		status = targets.BuildStatusUnknown
+	}
+	c.cmd.Printf("Status:          %s\n", status)
+	if !info.BuildDate.IsZero() {
+		c.cmd.Printf("Build date:      %s\n", info.BuildDate.Format(time.RFC3339))
+	}
+	if info.CloneDuration > 0 {
+		c.cmd.Printf("Clone duration:  %s\n", info.CloneDuration)
+	}
+	if info.BuildDuration > 0 {
+		c.cmd.Printf("Build duration:  %s\n", info.BuildDuration)
+	}
+	if info.OS != "" {
+		c.cmd.Printf("Platform:        %s/%s\n", info.OS, info.Arch)
+	}
+	if len(info.Labels) > 0 {
+		c.cmd.Printf("Labels:          %s\n", formatLabels(info.Labels))
+	}
+
+	c.cmd.Printf("Binary:          %s\n", describeArtifact(filepath.Join(commitDir, "bin"), info.BinarySHA256))
+	source := describeArtifact(filepath.Join(commitDir, "source.tar.gz"), info.SourceArchiveSHA256)
+	if info.KeptClone {
+		source = "kept clone (src)"
+	}
+	c.cmd.Printf("Source archive:  %s\n", source)
+
+	size, err := dirutils.GetDirSize(commitDir)
+	if err != nil {
+		return logger.CreateErrorf("failed to measure build directory: %w", err)
+	}
+	c.cmd.Printf("Size:            %.2f MB\n", float64(size)/(1024*1024))
+	c.cmd.Printf("Directory:       %s\n", commitDir)
+	return nil
+}
+
+// describeArtifact reports whether an artifact of a build is present, with
+// its recorded checksum when there is one
+//
+// Parameters:
+//   - path: The path of the artifact in the commit directory
+//   - sum: The SHA-256 checksum recorded in the build metadata (may be empty)
+//
+// Returns:
+//   - string: "present", "present (sha256:...)" or "missing"
+func describeArtifact(path, sum string) string {
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		return "missing"
+	}
+	if sum == "" {
+		return "present"
+	}
+	return "present (sha256:" + sum + ")"
+}
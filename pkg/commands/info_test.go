@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInfoCommand(t *testing.T) {
+	cmd := newInfoCommand()
+	assert.NotNil(t, cmd)
+	assert.NotNil(t, cmd.cmd)
+}
+
+func TestInfo(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	commitDir := filepath.Join(root, "tool", "abcdef1")
+	require.NoError(t, os.MkdirAll(commitDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(commitDir, "bin"), []byte("binary"), 0755))
+	require.NoError(t, targets.WriteBuildInfo(commitDir, &targets.BuildInfo{
+		BuildDate:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Target:        "tool",
+		Commit:        "abcdef1234567890abcdef1234567890abcdef12",
+		ShortHash:     "abcdef1",
+		Ref:           "main",
+		Status:        targets.BuildStatusSuccess,
+		OS:            "linux",
+		Arch:          "amd64",
+		CloneDuration: 2 * time.Second,
+		BuildDuration: 3 * time.Second,
+		BinarySHA256:  "deadbeef",
+	}))
+
+	var out bytes.Buffer
+	c := newInfoCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", "ABCDEF1"})
+	require.NoError(t, c.cmd.Execute())
+
+	for _, want := range []string{
+		"Commit:          abcdef1234567890abcdef1234567890abcdef12\n",
+		"Short hash:      abcdef1\n",
+		"Ref:             main\n",
+		"Status:          success\n",
+		"Build date:      2024-01-02T03:04:05Z\n",
+		"Clone duration:  2s\n",
+		"Build duration:  3s\n",
+		"Platform:        linux/amd64\n",
+		"Binary:          present (sha256:deadbeef)\n",
+		"Source archive:  missing\n",
+		"Size:            0.00 MB\n",
+	} {
+		assert.Contains(t, out.String(), want)
+	}
+}
+
+func TestInfoWithoutMetadata(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	commitDir := filepath.Join(root, "tool", "abcdef1")
+	require.NoError(t, os.MkdirAll(commitDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(commitDir, "source.tar.gz"), []byte("archive"), 0644))
+
+	var out bytes.Buffer
+	c := newInfoCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", "abcdef1"})
+	require.NoError(t, c.cmd.Execute())
+
+	assert.Contains(t, out.String(), "Commit:          unknown (no build metadata recorded)\n")
+	assert.Contains(t, out.String(), "Status:          unknown\n")
+	assert.Contains(t, out.String(), "Binary:          missing\n")
+	assert.Contains(t, out.String(), "Source archive:  present\n")
+}
+
+func TestInfoErrors(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", "abcdef1a"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", "abcdef1b"), 0755))
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "unknown target", args: []string{"other", "abcdef1"}, wantErr: "target 'other' not found"},
+		{name: "short commit", args: []string{"tool", "abcdef"}, wantErr: "commit hash is too short"},
+		{name: "unknown commit", args: []string{"tool", "1234567"}, wantErr: "no build found for commit 1234567"},
+		{name: "ambiguous commit", args: []string{"tool", "abcdef1"}, wantErr: "multiple builds match commit abcdef1: abcdef1a, abcdef1b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newInfoCommand()
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs(tt.args)
+			err := c.cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
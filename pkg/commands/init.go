@@ -48,12 +48,11 @@ func (c *initCommand) executeInit() error {
 	// Check if config file already exists
 	if _, err := os.Stat(configFilePath); err == nil {
 		c.cmd.Printf("Configuration file already exists at %s\n", configFilePath)
-		c.cmd.Print("Do you want to overwrite it? (y/n): ")
-		var confirm string
-		if err := logger.ReadInput(&confirm); err != nil {
-			return logger.CreateErrorf("failed to read confirmation: %w", err)
+		ok, err := confirm(c.cmd, "Do you want to overwrite it?")
+		if err != nil {
+			return err
 		}
-		if confirm != "y" && confirm != "Y" {
+		if !ok {
 			c.cmd.Println("Initialization cancelled.")
 			return nil
 		}
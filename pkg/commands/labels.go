@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labelKeyPattern matches the keys allowed in build labels
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// parseLabels parses key=value label arguments as given to --label
+//
+// Parameters:
+//   - values: The label arguments
+//
+// Returns:
+//   - map[string]string: The labels by key (nil if there are none)
+//   - error: An error if an argument is malformed or a key is repeated
+func parseLabels(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string, len(values))
+	for _, value := range values {
+		key, val, ok := strings.Cut(value, "=")
+		if !ok {
+			return nil, fmt.Errorf("label %q must be in key=value form", value)
+		}
+		if !labelKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid label key %q: use letters, digits, '.', '_' and '-'", key)
+		}
+		if _, exists := labels[key]; exists {
+			return nil, fmt.Errorf("label %q is given more than once", key)
+		}
+		labels[key] = val
+	}
+	return labels, nil
+}
+
+// hasLabels reports whether labels contains every label in want with the same value
+//
+// Parameters:
+//   - labels: The labels of a build
+//   - want: The labels to look for
+//
+// Returns:
+//   - bool: True if all wanted labels match, false otherwise
+func hasLabels(labels, want map[string]string) bool {
+	for key, val := range want {
+		if got, ok := labels[key]; !ok || got != val {
+			return false
+		}
+	}
+	return true
+}
+
+// formatLabels formats labels as comma-separated key=value pairs sorted by key
+//
+// Parameters:
+//   - labels: The labels to format
+//
+// Returns:
+//   - string: The formatted labels, empty if there are none
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, val := range labels {
+		pairs = append(pairs, key+"="+val)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
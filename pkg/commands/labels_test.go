@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "no labels"},
+		{
+			name:   "several labels",
+			values: []string{"env=staging", "ticket=JIRA-123"},
+			want:   map[string]string{"env": "staging", "ticket": "JIRA-123"},
+		},
+		{name: "value may contain '='", values: []string{"args=a=b"}, want: map[string]string{"args": "a=b"}},
+		{name: "empty value", values: []string{"env="}, want: map[string]string{"env": ""}},
+		{name: "missing '='", values: []string{"env"}, wantErr: true},
+		{name: "empty key", values: []string{"=staging"}, wantErr: true},
+		{name: "invalid key", values: []string{"my env=staging"}, wantErr: true},
+		{name: "repeated key", values: []string{"env=staging", "env=prod"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLabels(tt.values)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHasLabels(t *testing.T) {
+	labels := map[string]string{"env": "staging", "ticket": "JIRA-123"}
+	assert.True(t, hasLabels(labels, nil))
+	assert.True(t, hasLabels(labels, map[string]string{"env": "staging"}))
+	assert.True(t, hasLabels(labels, map[string]string{"env": "staging", "ticket": "JIRA-123"}))
+	assert.False(t, hasLabels(labels, map[string]string{"env": "prod"}))
+	assert.False(t, hasLabels(labels, map[string]string{"owner": ""}))
+	assert.False(t, hasLabels(nil, map[string]string{"env": "staging"}))
+	assert.Equal(t, "env=staging,ticket=JIRA-123", formatLabels(labels))
+}
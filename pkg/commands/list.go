@@ -1,20 +1,32 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
 	"github.com/oota-sushikuitee/nigiri/internal/targets"
 	"github.com/oota-sushikuitee/nigiri/pkg/commits"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
 	"github.com/spf13/cobra"
 )
 
 // listCommand represents the structure for the list command
 type listCommand struct {
-	cmd *cobra.Command
+	cmd  *cobra.Command
+	tree bool
+	// remote lists the branches and tags of the target's repository
+	remote bool
+	// output is the output format of --remote ("text" or "json")
+	output string
+	// labels restricts the listed builds to those with all of these key=value labels
+	labels []string
 }
 
 // newListCommand creates a new list command instance which allows users
@@ -30,12 +42,41 @@ func newListCommand() *listCommand {
 		Short: "List installed targets and commits",
 		Long:  `List all installed targets and their commits, or list commits for a specific target.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			labels, err := parseLabels(c.labels)
+			if err != nil {
+				return fmt.Errorf("invalid --label: %w", err)
+			}
+			if c.tree {
+				if len(args) > 0 {
+					return fmt.Errorf("cannot specify a target with --tree flag")
+				}
+				if c.output != "text" {
+					return fmt.Errorf("--tree cannot be combined with --output %s", c.output)
+				}
+				return c.listTree(labels)
+			}
+			if c.remote {
+				if len(args) != 1 {
+					return fmt.Errorf("--remote requires exactly one target")
+				}
+				if len(labels) > 0 {
+					return fmt.Errorf("--label cannot be used with --remote")
+				}
+				return c.listRemoteRefs(args[0])
+			}
+			if c.output != "text" && c.output != "json" {
+				return fmt.Errorf("unsupported output format '%s': expected text or json", c.output)
+			}
 			if len(args) == 0 {
-				return c.listAllTargets()
+				return c.listAllTargets(labels)
 			}
-			return c.listTargetCommits(args[0])
+			return c.listTargetCommits(args[0], labels)
 		},
 	}
+	cmd.Flags().BoolVar(&c.tree, "tree", false, "Show all targets and their builds as a tree")
+	cmd.Flags().BoolVar(&c.remote, "remote", false, "List the branches and tags of the target's repository (requires network access)")
+	cmd.Flags().StringVarP(&c.output, "output", "o", "text", "Output format: text or json")
+	cmd.Flags().StringArrayVar(&c.labels, "label", nil, "Only list builds with this key=value label (can be repeated)")
 	c.cmd = cmd
 	return c
 }
@@ -43,53 +84,388 @@ func newListCommand() *listCommand {
 // listAllTargets lists all installed targets and the number of commits for each.
 // It reads the nigiri root directory and displays a summary of all available targets.
 //
+// Parameters:
+//   - labels: Only count builds with all of these labels (nil counts every build)
+//
 // Returns:
 //   - error: Any error encountered while reading the directory or target information
-func (c *listCommand) listAllTargets() error {
-	// Examine the contents of the .nigiri directory
-	entries, err := os.ReadDir(nigiriRoot)
+func (c *listCommand) listAllTargets(labels map[string]string) error {
+	if c.output == "json" {
+		return c.listAllTargetsJSON(labels)
+	}
+	if nigiriRootMissing() {
+		c.cmd.Println(noTargetsMessage)
+		return nil
+	}
+	summaries, err := gatherTargets(nigiriRoot, labels, false)
 	if err != nil {
-		if os.IsNotExist(err) {
-			c.cmd.Println("No targets installed.")
+		return err
+	}
+	if len(summaries) == 0 {
+		if len(labels) > 0 {
+			c.cmd.Printf("No builds labeled %s.\n", formatLabels(labels))
 			return nil
 		}
-		return fmt.Errorf("failed to read nigiri root directory: %w", err)
-	}
-
-	if len(entries) == 0 {
 		c.cmd.Println("No targets installed.")
 		return nil
 	}
 
 	// Display each target directory
 	c.cmd.Println("Installed targets:")
+	for _, summary := range summaries {
+		c.cmd.Printf("  %s (%d commits)\n", summary.name, len(summary.builds))
+	}
+
+	c.cmd.Println("\nUse 'nigiri list <target>' to see commits for a specific target.")
+	return nil
+}
+
+// targetSummary represents an installed target and the builds found for it
+type targetSummary struct {
+	name   string
+	builds []buildSummary
+}
+
+// buildSummary represents a single build directory of a target
+type buildSummary struct {
+	modTime time.Time
+	hash    string
+	// status is the status recorded in the build metadata, or empty if the
+	// build has no metadata
+	status string
+	// ref is the branch, tag or commit the build was requested for
+	ref string
+	// labels are the labels recorded in the build metadata
+	labels map[string]string
+	size   int64
+}
+
+// gatherTargets collects the installed targets under root together with their
+// builds. Targets are sorted by name and builds by build time, newest first.
+// When labels are given, only builds carrying all of them are collected and
+// targets without such builds are left out.
+//
+// Parameters:
+//   - root: The nigiri root directory
+//   - labels: The labels builds must carry (nil collects every build)
+//   - withSizes: Whether the disk size of every build is computed, which walks its directory
+//
+// Returns:
+//   - []targetSummary: The installed targets, empty if the root does not exist
+//   - error: Any error encountered while reading the root directory
+func gatherTargets(root string, labels map[string]string, withSizes bool) ([]targetSummary, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read nigiri root directory: %w", err)
+	}
+
+	var summaries []targetSummary
 	for _, entry := range entries {
-		if entry.IsDir() && entry.Name()[0] != '.' {
-			targetName := entry.Name()
-			// Count the number of commits
-			targetDir := filepath.Join(nigiriRoot, targetName)
-			commits, err := os.ReadDir(targetDir)
+		if !entry.IsDir() || entry.Name()[0] == '.' {
+			continue
+		}
+		targetDir := filepath.Join(root, entry.Name())
+		buildEntries, err := os.ReadDir(targetDir)
+		if err != nil {
+			continue
+		}
+		summary := targetSummary{name: entry.Name()}
+		for _, buildEntry := range buildEntries {
+			if !buildEntry.IsDir() || strings.HasPrefix(buildEntry.Name(), ".") {
+				continue
+			}
+			commitDir := filepath.Join(targetDir, buildEntry.Name())
+			info, err := os.Stat(commitDir)
 			if err != nil {
 				continue
 			}
-			commitCount := 0
-			for _, commit := range commits {
-				if commit.IsDir() {
-					commitCount++
+			build := buildSummary{
+				hash:    buildEntry.Name(),
+				modTime: info.ModTime(),
+			}
+			if buildInfo, err := targets.ReadBuildInfo(commitDir); err == nil {
+				build.status = buildInfo.Status
+				build.ref = buildInfo.Ref
+				build.labels = buildInfo.Labels
+			}
+			if !hasLabels(build.labels, labels) {
+				continue
+			}
+			if withSizes {
+				if size, err := dirutils.GetDirSize(commitDir); err == nil {
+					build.size = size
 				}
 			}
-			c.cmd.Printf("  %s (%d commits)\n", targetName, commitCount)
+			summary.builds = append(summary.builds, build)
 		}
+		if len(labels) > 0 && len(summary.builds) == 0 {
+			continue
+		}
+		sort.Slice(summary.builds, func(i, j int) bool {
+			return summary.builds[i].modTime.After(summary.builds[j].modTime)
+		})
+		summaries = append(summaries, summary)
 	}
+	return summaries, nil
+}
 
-	c.cmd.Println("\nUse 'nigiri list <target>' to see commits for a specific target.")
+// treeGlyphs holds the characters used to draw the tree and build statuses
+type treeGlyphs struct {
+	branch, last, pipe, space string
+	success, failed, unknown  string
+	// archived marks source archives made with build --archive-only
+	archived string
+}
+
+var (
+	unicodeTreeGlyphs = treeGlyphs{
+		branch: "├── ", last: "└── ", pipe: "│   ", space: "    ",
+		success: "✓", failed: "✗", unknown: "?", archived: "□",
+	}
+	asciiTreeGlyphs = treeGlyphs{
+		branch: "|-- ", last: "`-- ", pipe: "|   ", space: "    ",
+		success: "+", failed: "x", unknown: "?", archived: "a",
+	}
+)
+
+// listTree displays all installed targets with their builds nested beneath
+// them. Unicode glyphs are only used when writing to a terminal.
+//
+// Parameters:
+//   - labels: Only show builds with all of these labels (nil shows every build)
+//
+// Returns:
+//   - error: Any error encountered while gathering the targets
+func (c *listCommand) listTree(labels map[string]string) error {
+	if nigiriRootMissing() {
+		c.cmd.Println(noTargetsMessage)
+		return nil
+	}
+	summaries, err := gatherTargets(nigiriRoot, labels, true)
+	if err != nil {
+		return err
+	}
+	if len(summaries) == 0 {
+		if len(labels) > 0 {
+			c.cmd.Printf("No builds labeled %s.\n", formatLabels(labels))
+			return nil
+		}
+		c.cmd.Println("No targets installed.")
+		return nil
+	}
+
+	glyphs := asciiTreeGlyphs
+	if logger.IsTerminal(c.cmd.OutOrStdout()) {
+		glyphs = unicodeTreeGlyphs
+	}
+
+	c.cmd.Println(nigiriRoot)
+	for i, summary := range summaries {
+		prefix, indent := glyphs.branch, glyphs.pipe
+		if i == len(summaries)-1 {
+			prefix, indent = glyphs.last, glyphs.space
+		}
+		c.cmd.Printf("%s%s (%d commits)\n", prefix, summary.name, len(summary.builds))
+		for j, build := range summary.builds {
+			buildPrefix := glyphs.branch
+			if j == len(summary.builds)-1 {
+				buildPrefix = glyphs.last
+			}
+			status := glyphs.unknown
+			switch build.status {
+			case targets.BuildStatusSuccess:
+				status = glyphs.success
+			case targets.BuildStatusFailed:
+				status = glyphs.failed
+			case targets.BuildStatusArchived:
+				status = glyphs.archived
+			}
+			c.cmd.Printf("%s%s%s %s  %.2f MB  %s%s\n", indent, buildPrefix, status, build.hash,
+				float64(build.size)/(1024*1024), build.modTime.Format("2006-01-02 15:04:05"), labelSuffix(build.labels))
+		}
+	}
 	return nil
 }
 
+// labelSuffix formats labels for appending to a listed build
+//
+// Parameters:
+//   - labels: The labels of the build
+//
+// Returns:
+//   - string: The labels in brackets preceded by two spaces, empty if there are none
+func labelSuffix(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return "  [" + formatLabels(labels) + "]"
+}
+
 // commitInfo represents information about a commit, optimized for memory layout
 type commitInfo struct {
-	modTime time.Time // 24 bytes
-	hash    string    // 16 bytes (pointer + length)
+	modTime time.Time         // 24 bytes
+	hash    string            // 16 bytes (pointer + length)
+	labels  map[string]string // 8 bytes (pointer)
+	size    int64             // 8 bytes
+	// status is the status recorded in the build metadata, or empty if the
+	// build has no metadata
+	status string
+	// archived marks a source archive made with build --archive-only
+	archived bool
+	// hasBinary reports whether the build has a binary in its commit directory
+	hasBinary bool
+}
+
+// gatherCommits collects the builds of a target, newest first
+//
+// Parameters:
+//   - targetDir: The directory of the target
+//   - labels: Only collect builds with all of these labels (nil collects every build)
+//   - withSizes: Whether the disk size of every build is computed, which walks its directory
+//
+// Returns:
+//   - []commitInfo: The builds of the target
+//   - error: Any error encountered while reading the target directory
+func gatherCommits(targetDir string, labels map[string]string, withSizes bool) ([]commitInfo, error) {
+	entries, err := os.ReadDir(targetDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target directory: %w", err)
+	}
+
+	var commits []commitInfo
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		commitDir := filepath.Join(targetDir, entry.Name())
+		info, err := os.Stat(commitDir)
+		if err != nil {
+			continue
+		}
+		commit := commitInfo{
+			hash:    entry.Name(),
+			modTime: info.ModTime(),
+		}
+		if buildInfo, err := targets.ReadBuildInfo(commitDir); err == nil {
+			commit.labels = buildInfo.Labels
+			commit.status = buildInfo.Status
+			commit.archived = buildInfo.Status == targets.BuildStatusArchived
+		}
+		if !hasLabels(commit.labels, labels) {
+			continue
+		}
+		if withSizes {
+			if size, err := dirutils.GetDirSize(commitDir); err == nil {
+				commit.size = size
+			}
+		}
+		if binInfo, err := os.Stat(filepath.Join(commitDir, "bin")); err == nil && !binInfo.IsDir() {
+			commit.hasBinary = true
+		}
+		commits = append(commits, commit)
+	}
+
+	// Sort by build time (newest first)
+	sort.Slice(commits, func(i, j int) bool {
+		return commits[i].modTime.After(commits[j].modTime)
+	})
+	return commits, nil
+}
+
+// listedCommit is a build in the JSON output of list
+//
+// Fields:
+//   - ShortHash: The name of the commit directory, usually the short commit hash
+//   - BuildTime: When the build was made
+//   - Size: The size of the commit directory in bytes
+//   - HasBinary: Whether the build has a binary
+//   - Status: The status recorded in the build metadata, empty if the build has none
+//   - Archived: Whether the build is a source archive made with build --archive-only
+//   - Labels: The labels recorded in the build metadata
+type listedCommit struct {
+	ShortHash string            `json:"short_hash"`
+	BuildTime time.Time         `json:"build_time"`
+	Size      int64             `json:"size"`
+	HasBinary bool              `json:"has_binary"`
+	Status    string            `json:"status,omitempty"`
+	Archived  bool              `json:"archived,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// listedTarget is a target in the JSON output of list
+//
+// Fields:
+//   - Name: The name of the target
+//   - Commits: The builds of the target, newest first
+type listedTarget struct {
+	Name    string         `json:"name"`
+	Commits []listedCommit `json:"commits"`
+}
+
+// toListedCommits converts gathered builds for the JSON output
+//
+// Parameters:
+//   - commits: The builds gathered by gatherCommits
+//
+// Returns:
+//   - []listedCommit: The builds, never nil so that none encode as []
+func toListedCommits(commits []commitInfo) []listedCommit {
+	listed := make([]listedCommit, 0, len(commits))
+	for _, commit := range commits {
+		listed = append(listed, listedCommit{
+			ShortHash: commit.hash,
+			BuildTime: commit.modTime,
+			Size:      commit.size,
+			HasBinary: commit.hasBinary,
+			Status:    commit.status,
+			Archived:  commit.archived,
+			Labels:    commit.labels,
+		})
+	}
+	return listed
+}
+
+// writeJSON writes v to the output of the command as indented JSON
+//
+// Parameters:
+//   - v: The value to write
+//
+// Returns:
+//   - error: Any error encountered while encoding or writing the value
+func (c *listCommand) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(c.cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// listAllTargetsJSON writes every installed target and its builds as a JSON
+// array. When labels are given, targets without matching builds are left out.
+//
+// Parameters:
+//   - labels: Only list builds with all of these labels (nil lists every build)
+//
+// Returns:
+//   - error: Any error encountered while reading the nigiri root directory
+func (c *listCommand) listAllTargetsJSON(labels map[string]string) error {
+	listed := []listedTarget{}
+	entries, err := os.ReadDir(nigiriRoot)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read nigiri root directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name()[0] == '.' {
+			continue
+		}
+		commits, err := gatherCommits(filepath.Join(nigiriRoot, entry.Name()), labels, true)
+		if err != nil || (len(labels) > 0 && len(commits) == 0) {
+			continue
+		}
+		listed = append(listed, listedTarget{Name: entry.Name(), Commits: toListedCommits(commits)})
+	}
+	return c.writeJSON(listed)
 }
 
 // listTargetCommits lists all commits for a specified target, sorted by build time.
@@ -98,10 +474,14 @@ type commitInfo struct {
 //
 // Parameters:
 //   - target: The name of the target whose commits should be listed
+//   - labels: Only list commits whose builds have all of these labels (nil lists every commit)
 //
 // Returns:
 //   - error: Any error encountered while reading the target directory or commit information
-func (c *listCommand) listTargetCommits(target string) error {
+func (c *listCommand) listTargetCommits(target string, labels map[string]string) error {
+	if nigiriRootMissing() {
+		return errNoTargets
+	}
 	// Create Target instance
 	fsTarget := targets.Target{
 		Target:  target,
@@ -117,53 +497,124 @@ func (c *listCommand) listTargetCommits(target string) error {
 		return fmt.Errorf("target '%s' is not installed", target)
 	}
 
-	// Get commit directories
-	entries, err := os.ReadDir(targetDir)
+	commits, err := gatherCommits(targetDir, labels, true)
 	if err != nil {
-		return fmt.Errorf("failed to read target directory: %w", err)
+		return err
+	}
+	if c.output == "json" {
+		return c.writeJSON(toListedCommits(commits))
 	}
 
-	if len(entries) == 0 {
+	if len(commits) == 0 && len(labels) == 0 {
 		c.cmd.Printf("No commits found for target '%s'.\n", target)
 		return nil
 	}
 
-	// Collect commit information and sort by time
-	var commits []commitInfo
-	for _, entry := range entries {
-		if entry.IsDir() {
-			commitDir := filepath.Join(targetDir, entry.Name())
-			info, err := os.Stat(commitDir)
-			if err != nil {
-				continue
-			}
-			commits = append(commits, commitInfo{
-				hash:    entry.Name(),
-				modTime: info.ModTime(),
-			})
-		}
-	}
-
-	// Sort by build time (newest first)
-	sort.Slice(commits, func(i, j int) bool {
-		return commits[i].modTime.After(commits[j].modTime)
-	})
-
 	// Get configuration information
 	cm := newConfigManager()
 	if err := cm.LoadCfgFile(); err == nil {
 		if targetCfg, ok := cm.Config.Targets[target]; ok {
 			c.cmd.Printf("Target: %s\n", target)
-			c.cmd.Printf("Source: %s\n", targetCfg.Sources)
+			c.cmd.Printf("Source: %s\n", vcsutils.ScrubCredentials(targetCfg.Sources))
 			c.cmd.Printf("Default branch: %s\n", targetCfg.DefaultBranch)
 		}
 	}
 
+	if len(labels) > 0 && len(commits) == 0 {
+		c.cmd.Printf("\nNo commits of target '%s' labeled %s.\n", target, formatLabels(labels))
+		return nil
+	}
+
+	glyphs := asciiTreeGlyphs
+	if logger.IsTerminal(c.cmd.OutOrStdout()) {
+		glyphs = unicodeTreeGlyphs
+	}
 	c.cmd.Printf("\nCommits for target '%s' (newest first):\n", target)
 	for i, commit := range commits {
-		c.cmd.Printf("  %d. %s (built on %s)\n", i+1, commit.hash, commit.modTime.Format("2006-01-02 15:04:05"))
+		date := commit.modTime.Format("2006-01-02 15:04:05")
+		size := fmt.Sprintf("%.2f MB", float64(commit.size)/(1024*1024))
+		switch commit.status {
+		case targets.BuildStatusSuccess:
+			c.cmd.Printf("  %d. %s %s (built on %s, %s)%s\n", i+1, glyphs.success, commit.hash, date, size, labelSuffix(commit.labels))
+		case targets.BuildStatusFailed:
+			c.cmd.Printf("  %d. %s %s (build failed on %s, %s)%s\n", i+1, glyphs.failed, commit.hash, date, size, labelSuffix(commit.labels))
+		case targets.BuildStatusArchived:
+			c.cmd.Printf("  %d. %s %s (archived on %s, not built, %s)%s\n", i+1, glyphs.archived, commit.hash, date, size, labelSuffix(commit.labels))
+		default:
+			c.cmd.Printf("  %d. %s %s (built on %s, %s, status unknown)%s\n", i+1, glyphs.unknown, commit.hash, date, size, labelSuffix(commit.labels))
+		}
 	}
 
 	c.cmd.Println("\nUse 'nigiri run " + target + " <commit>' to run a specific commit.")
 	return nil
 }
+
+// remoteRefEntry represents a remote branch or tag and whether it was built
+type remoteRefEntry struct {
+	vcsutils.RemoteRef
+	Built bool `json:"built"`
+}
+
+// listRemoteRefs lists the branches and tags of a target's repository,
+// marking the ones whose commit has been built locally
+//
+// Parameters:
+//   - target: The name of the target as specified in the config file
+//
+// Returns:
+//   - error: Any error encountered while listing the remote references
+func (c *listCommand) listRemoteRefs(target string) error {
+	if c.output != "text" && c.output != "json" {
+		return fmt.Errorf("unsupported output format '%s': expected text or json", c.output)
+	}
+	cm := newConfigManager()
+	if err := cm.LoadCfgFile(); err != nil {
+		return logger.CreateErrorf("failed to load configuration: %w", err)
+	}
+	targetCfg, exists := cm.Config.Targets[target]
+	if !exists {
+		return logger.CreateErrorf("target '%s' not found in configuration", target)
+	}
+
+	git := vcsutils.Git{Source: targetCfg.Sources}
+	refs, err := git.LsRemote()
+	if err != nil {
+		return logger.CreateErrorf("failed to list remote references of %s (listing requires network access): %w",
+			vcsutils.ScrubCredentials(targetCfg.Sources), err)
+	}
+
+	// Builds are stored under their short hash
+	built := make(map[string]bool)
+	if entries, err := os.ReadDir(filepath.Join(nigiriRoot, target)); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+				built[entry.Name()] = true
+			}
+		}
+	}
+	entries := make([]remoteRefEntry, 0, len(refs))
+	for _, ref := range refs {
+		commit := commits.Commit{Hash: ref.Hash}
+		isBuilt := commit.CalculateShortHashLength(targetCfg.ShortHashLength()) == nil &&
+			built[uniqueShortHash(filepath.Join(nigiriRoot, target), commit, "")]
+		entries = append(entries, remoteRefEntry{RemoteRef: ref, Built: isBuilt})
+	}
+
+	if c.output == "json" {
+		return c.writeJSON(entries)
+	}
+
+	if len(entries) == 0 {
+		c.cmd.Printf("No branches or tags found for target '%s'.\n", target)
+		return nil
+	}
+	c.cmd.Printf("Remote branches and tags for target '%s' (* = built):\n", target)
+	for _, entry := range entries {
+		marker := " "
+		if entry.Built {
+			marker = "*"
+		}
+		c.cmd.Printf("  %s %-6s %s  %s\n", marker, entry.Kind, entry.Hash[:7], entry.Name)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListTree(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	for _, dir := range []string{"alpha/aaaaaaa", "alpha/bbbbbbb", "alpha/.aaaaaaa.old-1", "beta/ccccccc", ".hidden/ddddddd"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	require.NoError(t, targets.WriteBuildInfo(filepath.Join(root, "alpha", "aaaaaaa"), &targets.BuildInfo{Status: targets.BuildStatusSuccess}))
+	require.NoError(t, targets.WriteBuildInfo(filepath.Join(root, "alpha", "bbbbbbb"), &targets.BuildInfo{Status: targets.BuildStatusFailed}))
+
+	c := newListCommand()
+	var out bytes.Buffer
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"--tree"})
+	require.NoError(t, c.cmd.Execute())
+
+	got := out.String()
+	assert.Contains(t, got, "|-- alpha (2 commits)")
+	assert.Contains(t, got, "`-- beta (1 commits)")
+	assert.Contains(t, got, "+ aaaaaaa")
+	assert.Contains(t, got, "x bbbbbbb")
+	assert.Contains(t, got, "    `-- ? ccccccc")
+	assert.NotContains(t, got, ".hidden")
+	assert.NotContains(t, got, ".old-")
+	// A buffer is not a terminal, so the output must stay ASCII
+	assert.NotContains(t, got, "✓")
+}
+
+func TestListTreeRejectsTarget(t *testing.T) {
+	useTestNigiriRoot(t)
+	c := newListCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetErr(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"--tree", "alpha"})
+	assert.Error(t, c.cmd.Execute())
+}
+
+func TestListRemote(t *testing.T) {
+	repoDir, builtHash := createTestSourceRepo(t)
+	r, err := git.PlainOpen(repoDir)
+	require.NoError(t, err)
+	w, err := r.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, w.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature"), Create: true}))
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	featureHash, err := w.Commit("feature", &git.CommitOptions{Author: sig, AllowEmptyCommits: true})
+	require.NoError(t, err)
+	_, err = r.CreateTag("v1.0", plumbing.NewHash(builtHash), nil)
+	require.NoError(t, err)
+
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", builtHash[:7]), 0755))
+
+	t.Run("text", func(t *testing.T) {
+		var out bytes.Buffer
+		c := newListCommand()
+		c.cmd.SetOut(&out)
+		c.cmd.SetArgs([]string{"--remote", "tool"})
+		require.NoError(t, c.cmd.Execute())
+		assert.Contains(t, out.String(), "  * branch "+builtHash[:7]+"  master\n")
+		assert.Contains(t, out.String(), "    branch "+featureHash.String()[:7]+"  feature\n")
+		assert.Contains(t, out.String(), "  * tag    "+builtHash[:7]+"  v1.0\n")
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		c := newListCommand()
+		c.cmd.SetOut(&out)
+		c.cmd.SetArgs([]string{"--remote", "tool", "--output", "json"})
+		require.NoError(t, c.cmd.Execute())
+		var got []remoteRefEntry
+		require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+		assert.Equal(t, []remoteRefEntry{
+			{RemoteRef: vcsutils.RemoteRef{Name: "feature", Kind: "branch", Hash: featureHash.String()}},
+			{RemoteRef: vcsutils.RemoteRef{Name: "master", Kind: "branch", Hash: builtHash}, Built: true},
+			{RemoteRef: vcsutils.RemoteRef{Name: "v1.0", Kind: "tag", Hash: builtHash}, Built: true},
+		}, got)
+	})
+}
+
+func TestListRemoteErrors(t *testing.T) {
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, filepath.Join(t.TempDir(), "unreachable"), "make", "")
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "unreachable remote", args: []string{"--remote", "tool"}, wantErr: "requires network access"},
+		{name: "missing target", args: []string{"--remote"}, wantErr: "requires exactly one target"},
+		{name: "unknown format", args: []string{"--remote", "tool", "--output", "yaml"}, wantErr: "unsupported output format"},
+		{name: "unknown format without remote", args: []string{"--output", "yaml"}, wantErr: "unsupported output format"},
+		{name: "json tree", args: []string{"--tree", "--output", "json"}, wantErr: "--tree cannot be combined with --output json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newListCommand()
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetErr(&bytes.Buffer{})
+			c.cmd.SetArgs(tt.args)
+			err := c.cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestListLabelFilter(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	builds := map[string]map[string]string{
+		"alpha/aaaaaaa": {"env": "staging", "ticket": "JIRA-123"},
+		"alpha/bbbbbbb": {"env": "prod"},
+		"beta/ccccccc":  {"env": "staging"},
+		"gamma/ddddddd": nil,
+	}
+	for dir, labels := range builds {
+		buildDir := filepath.Join(root, dir)
+		require.NoError(t, os.MkdirAll(buildDir, 0755))
+		require.NoError(t, targets.WriteBuildInfo(buildDir, &targets.BuildInfo{Status: targets.BuildStatusSuccess, Labels: labels}))
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "all targets",
+			args:    []string{"--label", "env=staging"},
+			want:    []string{"alpha (1 commits)", "beta (1 commits)"},
+			notWant: []string{"gamma"},
+		},
+		{
+			name:    "tree",
+			args:    []string{"--tree", "--label", "env=staging"},
+			want:    []string{"aaaaaaa", "[env=staging,ticket=JIRA-123]", "ccccccc  "},
+			notWant: []string{"bbbbbbb", "gamma"},
+		},
+		{
+			name:    "single target with several labels",
+			args:    []string{"alpha", "--label", "env=staging", "--label", "ticket=JIRA-123"},
+			want:    []string{"1. + aaaaaaa", "[env=staging,ticket=JIRA-123]"},
+			notWant: []string{"bbbbbbb"},
+		},
+		{
+			name: "no matching builds",
+			args: []string{"--label", "env=dev"},
+			want: []string{"No builds labeled env=dev."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := newListCommand()
+			c.cmd.SetOut(&out)
+			c.cmd.SetArgs(tt.args)
+			require.NoError(t, c.cmd.Execute())
+			for _, s := range tt.want {
+				assert.Contains(t, out.String(), s)
+			}
+			for _, s := range tt.notWant {
+				assert.NotContains(t, out.String(), s)
+			}
+		})
+	}
+
+	c := newListCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetErr(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"--label", "staging"})
+	assert.Error(t, c.cmd.Execute())
+}
+
+func TestListJSON(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	for _, dir := range []string{"alpha/aaaaaaa", "alpha/bbbbbbb", "alpha/.aaaaaaa.old-1", "beta/ccccccc", ".hidden/ddddddd"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(root, "alpha", "bbbbbbb", "bin"), []byte("binary"), 0755))
+	require.NoError(t, targets.WriteBuildInfo(filepath.Join(root, "beta", "ccccccc"), &targets.BuildInfo{
+		Status: targets.BuildStatusArchived,
+		Labels: map[string]string{"env": "ci"},
+	}))
+	old := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(root, "alpha", "aaaaaaa"), old, old))
+
+	run := func(args ...string) []byte {
+		var out bytes.Buffer
+		c := newListCommand()
+		c.cmd.SetOut(&out)
+		c.cmd.SetArgs(args)
+		require.NoError(t, c.cmd.Execute())
+		return out.Bytes()
+	}
+
+	t.Run("all targets", func(t *testing.T) {
+		var got []listedTarget
+		require.NoError(t, json.Unmarshal(run("-o", "json"), &got))
+		require.Len(t, got, 2)
+		assert.Equal(t, "alpha", got[0].Name)
+		require.Len(t, got[0].Commits, 2)
+		assert.Equal(t, "bbbbbbb", got[0].Commits[0].ShortHash)
+		assert.True(t, got[0].Commits[0].HasBinary)
+		assert.Equal(t, int64(len("binary")), got[0].Commits[0].Size)
+		assert.Equal(t, "aaaaaaa", got[0].Commits[1].ShortHash)
+		assert.False(t, got[0].Commits[1].HasBinary)
+		assert.WithinDuration(t, old, got[0].Commits[1].BuildTime, time.Second)
+		assert.Equal(t, "beta", got[1].Name)
+		assert.True(t, got[1].Commits[0].Archived)
+		assert.Equal(t, map[string]string{"env": "ci"}, got[1].Commits[0].Labels)
+	})
+
+	t.Run("labels", func(t *testing.T) {
+		var got []listedTarget
+		require.NoError(t, json.Unmarshal(run("-o", "json", "--label", "env=ci"), &got))
+		require.Len(t, got, 1)
+		assert.Equal(t, "beta", got[0].Name)
+	})
+
+	t.Run("single target", func(t *testing.T) {
+		var got []listedCommit
+		require.NoError(t, json.Unmarshal(run("alpha", "--output", "json"), &got))
+		require.Len(t, got, 2)
+		assert.Equal(t, "bbbbbbb", got[0].ShortHash)
+	})
+
+	t.Run("no builds", func(t *testing.T) {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "empty"), 0755))
+		assert.Equal(t, "[]\n", string(run("empty", "-o", "json")))
+	})
+}
+
+func TestListTargetStatusAndSize(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	builds := map[string]string{
+		"aaaaaaa": targets.BuildStatusSuccess,
+		"bbbbbbb": targets.BuildStatusFailed,
+		"ccccccc": "",
+	}
+	for name, status := range builds {
+		dir := filepath.Join(root, "tool", name)
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "bin"), make([]byte, 1<<20), 0755))
+		if status != "" {
+			require.NoError(t, targets.WriteBuildInfo(dir, &targets.BuildInfo{Commit: name, Status: status}))
+		}
+	}
+
+	var out bytes.Buffer
+	c := newListCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool"})
+	require.NoError(t, c.cmd.Execute())
+
+	got := out.String()
+	assert.Regexp(t, `\+ aaaaaaa \(built on [0-9: -]+, 1\.00 MB\)`, got)
+	assert.Regexp(t, `x bbbbbbb \(build failed on [0-9: -]+, 1\.00 MB\)`, got)
+	assert.Regexp(t, `\? ccccccc \(built on [0-9: -]+, 1\.00 MB, status unknown\)`, got)
+}
+
+func TestGatherSizesOnlyWhenRequested(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	dir := filepath.Join(root, "tool", "aaaaaaa")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bin"), make([]byte, 1024), 0755))
+
+	summaries, err := gatherTargets(root, nil, false)
+	require.NoError(t, err)
+	require.Len(t, summaries, 1)
+	assert.Zero(t, summaries[0].builds[0].size)
+	summaries, err = gatherTargets(root, nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), summaries[0].builds[0].size)
+
+	// Directories of a build being swapped into place are not builds
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", ".aaaaaaa.new-1"), 0755))
+	commits, err := gatherCommits(filepath.Join(root, "tool"), nil, false)
+	require.NoError(t, err)
+	require.Len(t, commits, 1)
+	assert.Zero(t, commits[0].size)
+	commits, err = gatherCommits(filepath.Join(root, "tool"), nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), commits[0].size)
+}
@@ -0,0 +1,186 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/storage"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/fsutils"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+// logsPollInterval is how often --follow checks the build log for new output
+const logsPollInterval = 500 * time.Millisecond
+
+// logsCommand represents the structure for the logs command
+type logsCommand struct {
+	cmd *cobra.Command
+	// follow keeps printing the log while a build of the target is in progress
+	follow bool
+	// tail limits the output to the last lines of the log (0 prints all of it)
+	tail int
+	// pollInterval is how often --follow checks the log for new output
+	pollInterval time.Duration
+}
+
+// newLogsCommand creates a new logs command instance which prints the build
+// log of a build.
+//
+// Returns:
+//   - *logsCommand: A configured logs command instance
+func newLogsCommand() *logsCommand {
+	c := &logsCommand{pollInterval: logsPollInterval}
+	cmd := &cobra.Command{
+		Use:   "logs target [commit]",
+		Short: "Show the build log of a build",
+		Long: `Print the build log of a build, or of the latest build of the target when no
+commit is given. The commit can be a unique prefix of at least 7 characters.
+With --follow, new output is printed as it is written until the build of the
+target finishes.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if c.tail < 0 {
+				return fmt.Errorf("--tail must not be negative")
+			}
+			commitHash := ""
+			if len(args) > 1 {
+				commitHash = args[1]
+			}
+			return c.executeLogs(args[0], commitHash)
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return getInstalledTargets(toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			if len(args) == 1 {
+				return getTargetCommits(args[0], toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+	cmd.Flags().BoolVarP(&c.follow, "follow", "f", false, "Keep printing new output while a build of the target is in progress")
+	cmd.Flags().IntVar(&c.tail, "tail", 0, "Only print the last N lines of the log (0 prints all of it)")
+	c.cmd = cmd
+	return c
+}
+
+// findLatestBuildDir returns the commit directory of the most recent build
+// of a target
+//
+// Parameters:
+//   - target: The name of the target
+//
+// Returns:
+//   - string: The commit directory of the latest build
+//   - error: An error if the target does not exist or has no builds
+func findLatestBuildDir(target string) (string, error) {
+	if nigiriRootMissing() {
+		return "", errNoTargets
+	}
+	store := storage.NewLocal(nigiriRoot)
+	builds, err := store.List(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", logger.CreateErrorf("target '%s' not found", target)
+		}
+		return "", logger.CreateErrorf("failed to read target directory: %w", err)
+	}
+	if len(builds) == 0 {
+		return "", noBuildsYetError(target)
+	}
+	return store.Dir(target, builds[0].Name), nil
+}
+
+// executeLogs prints the build log of the build of target at commitHash
+//
+// Parameters:
+//   - target: The name of the target
+//   - commitHash: The commit hash (or unique prefix of at least 7 characters) of the build, or empty for the latest build
+//
+// Returns:
+//   - error: Any error encountered while finding the build or reading its log
+func (c *logsCommand) executeLogs(target, commitHash string) error {
+	var commitDir string
+	var err error
+	if commitHash == "" {
+		commitDir, err = findLatestBuildDir(target)
+	} else {
+		commitDir, err = findBuildDir(target, commitHash)
+	}
+	if err != nil {
+		return err
+	}
+
+	logPath := filepath.Join(commitDir, "logs", "build.log")
+	file, err := os.Open(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return logger.CreateErrorf("build %s of target '%s' has no build log", filepath.Base(commitDir), target)
+		}
+		return logger.CreateErrorf("failed to open build log: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	out := c.cmd.OutOrStdout()
+	if c.tail > 0 {
+		// The file is positioned at its end first, so that --follow only
+		// prints what is written after the tail
+		if _, err := file.Seek(0, io.SeekEnd); err != nil {
+			return logger.CreateErrorf("failed to read build log: %w", err)
+		}
+		lines, err := fsutils.TailLines(logPath, c.tail)
+		if err != nil {
+			return logger.CreateErrorf("failed to read build log: %w", err)
+		}
+		for _, line := range lines {
+			fmt.Fprintln(out, line)
+		}
+	} else if _, err := io.Copy(out, file); err != nil {
+		return logger.CreateErrorf("failed to read build log: %w", err)
+	}
+
+	if !c.follow {
+		return nil
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return c.followLog(ctx, file, filepath.Dir(commitDir))
+}
+
+// followLog copies new output of a build log to the command output until no
+// build of the target is in progress anymore or ctx is done
+//
+// Parameters:
+//   - ctx: The context whose cancellation stops following
+//   - file: The build log, positioned after the output already printed
+//   - targetRootDir: The root directory of the target, which holds the build lock
+//
+// Returns:
+//   - error: Any error encountered while reading the log
+func (c *logsCommand) followLog(ctx context.Context, file *os.File, targetRootDir string) error {
+	out := c.cmd.OutOrStdout()
+	for {
+		// The lock is checked before reading, so that the output written
+		// just before the build finished is not missed
+		building := targets.IsBuildLocked(targetRootDir)
+		if _, err := io.Copy(out, file); err != nil {
+			return logger.CreateErrorf("failed to read build log: %w", err)
+		}
+		if !building {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestBuildLog writes the build log of a build and sets the build's
+// modification time
+func writeTestBuildLog(t *testing.T, root, target, build, log string, modTime time.Time) string {
+	t.Helper()
+	commitDir := filepath.Join(root, target, build)
+	require.NoError(t, os.MkdirAll(filepath.Join(commitDir, "logs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(commitDir, "logs", "build.log"), []byte(log), 0644))
+	require.NoError(t, os.Chtimes(commitDir, modTime, modTime))
+	return commitDir
+}
+
+func TestLogs(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	now := time.Now()
+	writeTestBuildLog(t, root, "tool", "abc1234", "old build\n", now.Add(-time.Hour))
+	writeTestBuildLog(t, root, "tool", "def5678", "line 1\nline 2\nline 3\n", now)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", "fff0000"), 0755))
+	require.NoError(t, os.Chtimes(filepath.Join(root, "tool", "fff0000"), now.Add(-2*time.Hour), now.Add(-2*time.Hour)))
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{name: "latest build", args: []string{"tool"}, want: "line 1\nline 2\nline 3\n"},
+		{name: "commit prefix", args: []string{"tool", "abc1234"}, want: "old build\n"},
+		{name: "tail", args: []string{"tool", "--tail", "2"}, want: "line 2\nline 3\n"},
+		{name: "no log", args: []string{"tool", "fff0000"}, wantErr: "build fff0000 of target 'tool' has no build log"},
+		{name: "unknown commit", args: []string{"tool", "1234567"}, wantErr: "no build found for commit 1234567"},
+		{name: "unknown target", args: []string{"other"}, wantErr: "target 'other' not found"},
+		{name: "negative tail", args: []string{"tool", "--tail", "-1"}, wantErr: "--tail must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			c := newLogsCommand()
+			c.cmd.SetOut(&out)
+			c.cmd.SetErr(&bytes.Buffer{})
+			c.cmd.SetArgs(tt.args)
+			err := c.cmd.Execute()
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestLogsFollow(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	commitDir := writeTestBuildLog(t, root, "tool", "abc1234", "cloning\n", time.Now())
+	release, err := targets.AcquireBuildLock(filepath.Join(root, "tool"))
+	require.NoError(t, err)
+
+	file, err := os.Open(filepath.Join(commitDir, "logs", "build.log"))
+	require.NoError(t, err)
+	defer file.Close()
+	_, err = file.Seek(0, 2)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	c := newLogsCommand()
+	c.cmd.SetOut(&out)
+	c.pollInterval = 10 * time.Millisecond
+	done := make(chan error, 1)
+	go func() {
+		done <- c.followLog(context.Background(), file, filepath.Join(root, "tool"))
+	}()
+
+	// Output written while the build is in progress, including right before
+	// it finishes, is printed
+	log, err := os.OpenFile(filepath.Join(commitDir, "logs", "build.log"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = log.WriteString("building\n")
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	_, err = log.WriteString("done\n")
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+	release()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("--follow did not stop when the build finished")
+	}
+	assert.Equal(t, "building\ndone\n", out.String())
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"path/filepath"
+
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+// pinCommand represents the structure for the pin and unpin commands
+type pinCommand struct {
+	cmd *cobra.Command
+	// unpin removes the pin instead of adding it
+	unpin bool
+}
+
+// newPinCommand creates a new pin command instance which protects a build
+// from being removed by cleanup.
+//
+// Returns:
+//   - *pinCommand: A configured pin command instance
+func newPinCommand() *pinCommand {
+	c := &pinCommand{}
+	c.cmd = c.command("pin", "Protect a build from cleanup",
+		`Pin a build so that 'nigiri cleanup', 'nigiri gc' and 'nigiri build --prune-after'
+never remove it, however old it is. Pinned builds still count towards
+--max-builds. The commit can be a unique prefix of at least 7 characters.`)
+	return c
+}
+
+// newUnpinCommand creates a new unpin command instance which lets cleanup
+// remove a pinned build again.
+//
+// Returns:
+//   - *pinCommand: A configured unpin command instance
+func newUnpinCommand() *pinCommand {
+	c := &pinCommand{unpin: true}
+	c.cmd = c.command("unpin", "Let cleanup remove a pinned build again",
+		`Remove the pin of a build, so that cleanup treats it like any other build.
+The commit can be a unique prefix of at least 7 characters.`)
+	return c
+}
+
+// command creates the cobra command of c
+//
+// Parameters:
+//   - name: The name of the command
+//   - short: The short description of the command
+//   - long: The long description of the command
+//
+// Returns:
+//   - *cobra.Command: The configured command
+func (c *pinCommand) command(name, short, long string) *cobra.Command {
+	return &cobra.Command{
+		Use:   name + " target commit",
+		Short: short,
+		Long:  long,
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executePin(args[0], args[1])
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return getInstalledTargets(toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			if len(args) == 1 {
+				return getTargetCommits(args[0], toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+}
+
+// executePin pins or unpins the build of target at commitHash
+//
+// Parameters:
+//   - target: The name of the target
+//   - commitHash: The commit hash (or unique prefix of at least 7 characters) of the build
+//
+// Returns:
+//   - error: Any error encountered while finding the build or updating its pin
+func (c *pinCommand) executePin(target, commitHash string) error {
+	commitDir, err := findBuildDir(target, commitHash)
+	if err != nil {
+		return err
+	}
+	build := filepath.Base(commitDir)
+
+	if c.unpin {
+		changed, err := targets.Unpin(commitDir)
+		if err != nil {
+			return logger.CreateErrorf("failed to unpin build %s of target '%s': %w", build, target, err)
+		}
+		if !changed {
+			c.cmd.Printf("Build %s of target '%s' is not pinned\n", build, target)
+			return nil
+		}
+		c.cmd.Printf("Unpinned build %s of target '%s'\n", build, target)
+		return nil
+	}
+
+	changed, err := targets.Pin(commitDir)
+	if err != nil {
+		return logger.CreateErrorf("failed to pin build %s of target '%s': %w", build, target, err)
+	}
+	if !changed {
+		c.cmd.Printf("Build %s of target '%s' is already pinned\n", build, target)
+		return nil
+	}
+	c.cmd.Printf("Pinned build %s of target '%s'\n", build, target)
+	return nil
+}
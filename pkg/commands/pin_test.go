@@ -0,0 +1,39 @@
+package commands
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPinCommand(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	const commit = "abc1234def5678abc1234def5678abc1234def56"
+	commitDir := createTestCommitDir(t, root, "tool", commit[:7], "")
+
+	run := func(c *pinCommand, args ...string) string {
+		var out bytes.Buffer
+		c.cmd.SetOut(&out)
+		c.cmd.SetArgs(args)
+		require.NoError(t, c.cmd.Execute())
+		return out.String()
+	}
+
+	assert.Contains(t, run(newPinCommand(), "tool", commit[:7]), "Pinned build abc1234 of target 'tool'")
+	assert.True(t, dirutils.IsPinned(commitDir))
+	assert.Contains(t, run(newPinCommand(), "tool", commit[:7]), "already pinned")
+
+	assert.Contains(t, run(newUnpinCommand(), "tool", commit[:7]), "Unpinned build abc1234 of target 'tool'")
+	assert.False(t, dirutils.IsPinned(commitDir))
+	assert.Contains(t, run(newUnpinCommand(), "tool", commit[:7]), "is not pinned")
+
+	c := newPinCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "fff9999"})
+	assert.Error(t, c.cmd.Execute())
+	assert.NoFileExists(t, filepath.Join(root, "tool", "fff9999", dirutils.PinnedFileName))
+}
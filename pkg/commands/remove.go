@@ -1,19 +1,24 @@
 package commands
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/oota-sushikuitee/nigiri/internal/dirutils"
 	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/commits"
 	"github.com/oota-sushikuitee/nigiri/pkg/logger"
 	"github.com/spf13/cobra"
 )
 
 // removeCommand represents the structure for the remove command
 type removeCommand struct {
-	cmd *cobra.Command
-	all bool
+	cmd         *cobra.Command
+	all         bool
+	dryRun      bool
+	skipConfirm bool
 }
 
 // newRemoveCommand creates a new remove command instance which allows users
@@ -29,7 +34,8 @@ func newRemoveCommand() *removeCommand {
 		Long: `Remove a target or a specific commit build of a target.
 If commit is specified, only that commit build is removed.
 If --all flag is provided, all targets will be removed.
-If no commit is specified, the entire target and all its builds will be removed.`,
+If no commit is specified, the entire target and all its builds will be removed.
+Use --dry-run to show what would be removed without removing anything.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if c.all {
 				// If --all flag is provided, remove all targets
@@ -71,6 +77,8 @@ If no commit is specified, the entire target and all its builds will be removed.
 
 	flags := cmd.Flags()
 	flags.BoolVar(&c.all, "all", false, "Remove all targets")
+	flags.BoolVarP(&c.dryRun, "dry-run", "d", false, "Show what would be removed without actually removing anything")
+	flags.BoolVarP(&c.skipConfirm, "yes", "y", false, "Skip confirmation prompts")
 
 	c.cmd = cmd
 	return c
@@ -105,13 +113,13 @@ func (c *removeCommand) getCompletionCommits(target, prefix string) []string {
 		return nil
 	}
 
-	var commits []string
+	var commitList []string
 	for _, dir := range dirs {
-		if dir.IsDir() && strings.HasPrefix(dir.Name(), prefix) {
-			commits = append(commits, dir.Name())
+		if dir.IsDir() && commits.HasHashPrefix(dir.Name(), prefix) {
+			commitList = append(commitList, dir.Name())
 		}
 	}
-	return commits
+	return commitList
 }
 
 // executeRemove handles the removal of the specified target from the nigiri root directory.
@@ -123,22 +131,30 @@ func (c *removeCommand) getCompletionCommits(target, prefix string) []string {
 // Returns:
 //   - error: Any error encountered during the removal process
 func (c *removeCommand) executeRemove(target string) error {
+	if nigiriRootMissing() {
+		return errNoTargets
+	}
 	t := targets.Target{Target: target}
 	targetRootDir, err := t.GetTargetRootDir(nigiriRoot)
 	if err != nil {
 		return logger.CreateErrorf("target '%s' not found", target)
 	}
 
-	// Ask for confirmation before removing the entire target
-	c.cmd.Printf("This will remove the target '%s' and all its builds. Continue? (y/n): ", target)
-	var confirm string
-	if err := logger.ReadInput(&confirm); err != nil {
-		return logger.CreateErrorf("failed to read confirmation: %w", err)
+	if c.dryRun {
+		c.previewRemoval([]string{targetRootDir})
+		return nil
 	}
 
-	if strings.ToLower(confirm) != "y" {
-		c.cmd.Println("Operation cancelled.")
-		return nil
+	// Ask for confirmation before removing the entire target
+	if !c.skipConfirm {
+		ok, err := confirm(c.cmd, fmt.Sprintf("This will remove the target '%s' and all its builds. Continue?", target))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			c.cmd.Println("Operation cancelled.")
+			return nil
+		}
 	}
 
 	if err := os.RemoveAll(targetRootDir); err != nil {
@@ -158,6 +174,9 @@ func (c *removeCommand) executeRemove(target string) error {
 // Returns:
 //   - error: Any error encountered during the removal process
 func (c *removeCommand) executeRemoveCommit(target, commitHash string) error {
+	if nigiriRootMissing() {
+		return errNoTargets
+	}
 	t := targets.Target{Target: target}
 	targetRootDir, err := t.GetTargetRootDir(nigiriRoot)
 	if err != nil {
@@ -165,8 +184,8 @@ func (c *removeCommand) executeRemoveCommit(target, commitHash string) error {
 	}
 
 	// Check if commit hash is valid
-	if len(commitHash) < 7 {
-		return logger.CreateErrorf("commit hash is too short: %s (minimum 7 characters)", commitHash)
+	if err := checkCommitPrefix(target, commitHash); err != nil {
+		return err
 	}
 
 	// Find directories that match the commit hash prefix
@@ -177,7 +196,7 @@ func (c *removeCommand) executeRemoveCommit(target, commitHash string) error {
 
 	var matchingDirs []string
 	for _, dir := range dirs {
-		if dir.IsDir() && strings.HasPrefix(dir.Name(), commitHash) {
+		if dir.IsDir() && commits.HasHashPrefix(dir.Name(), commitHash) {
 			matchingDirs = append(matchingDirs, dir.Name())
 		}
 	}
@@ -198,16 +217,21 @@ func (c *removeCommand) executeRemoveCommit(target, commitHash string) error {
 	fullCommitHash := matchingDirs[0]
 	commitDir := filepath.Join(targetRootDir, fullCommitHash)
 
-	// Ask for confirmation
-	c.cmd.Printf("Remove build for commit %s? (y/n): ", fullCommitHash)
-	var confirm string
-	if err := logger.ReadInput(&confirm); err != nil {
-		return logger.CreateErrorf("failed to read confirmation: %w", err)
+	if c.dryRun {
+		c.previewRemoval([]string{commitDir})
+		return nil
 	}
 
-	if strings.ToLower(confirm) != "y" {
-		c.cmd.Println("Operation cancelled.")
-		return nil
+	// Ask for confirmation
+	if !c.skipConfirm {
+		ok, err := confirm(c.cmd, fmt.Sprintf("Remove build for commit %s?", fullCommitHash))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			c.cmd.Println("Operation cancelled.")
+			return nil
+		}
 	}
 
 	if err := os.RemoveAll(commitDir); err != nil {
@@ -223,40 +247,78 @@ func (c *removeCommand) executeRemoveCommit(target, commitHash string) error {
 // Returns:
 //   - error: Any error encountered during the removal process
 func (c *removeCommand) executeRemoveAll() error {
-	// Ask for confirmation before removing all targets
-	c.cmd.Print("This will remove ALL targets and ALL builds. This cannot be undone. Continue? (y/n): ")
-	var confirm string
-	if err := logger.ReadInput(&confirm); err != nil {
-		return logger.CreateErrorf("failed to read confirmation: %w", err)
-	}
-
-	if strings.ToLower(confirm) != "y" {
-		c.cmd.Println("Operation cancelled.")
-		return nil
-	}
-
 	// List all directories in nigiri root
 	entries, err := os.ReadDir(nigiriRoot)
 	if err != nil {
 		if os.IsNotExist(err) {
-			c.cmd.Println("No targets to remove.")
+			c.cmd.Println(noTargetsMessage)
 			return nil
 		}
 		return logger.CreateErrorf("failed to read nigiri root directory: %w", err)
 	}
 
-	removedCount := 0
+	var targetPaths []string
 	for _, entry := range entries {
 		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
-			targetPath := filepath.Join(nigiriRoot, entry.Name())
-			if err := os.RemoveAll(targetPath); err != nil {
-				c.cmd.Printf("Warning: Failed to remove target '%s': %v\n", entry.Name(), err)
-				continue
-			}
-			removedCount++
+			targetPaths = append(targetPaths, filepath.Join(nigiriRoot, entry.Name()))
 		}
 	}
 
+	if c.dryRun {
+		c.previewRemoval(targetPaths)
+		return nil
+	}
+
+	// Ask the user to type the confirmation phrase before removing all
+	// targets. This is only ever skipped when --yes, --assume-yes or
+	// NIGIRI_ASSUME_YES is set explicitly.
+	if !c.skipConfirm {
+		ok, err := confirmTyped(c.cmd, fmt.Sprintf("This will remove ALL %d targets and ALL builds. This cannot be undone.", len(targetPaths)), deleteConfirmPhrase)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			c.cmd.Println("Operation cancelled.")
+			return nil
+		}
+	}
+
+	removedCount := 0
+	for _, targetPath := range targetPaths {
+		if err := os.RemoveAll(targetPath); err != nil {
+			c.cmd.Printf("Warning: Failed to remove target '%s': %v\n", filepath.Base(targetPath), err)
+			continue
+		}
+		removedCount++
+	}
+
 	c.cmd.Printf("%d targets removed successfully.\n", removedCount)
 	return nil
 }
+
+// previewRemoval prints the directories that would be removed with their
+// sizes and the total disk space they use, without removing anything
+//
+// Parameters:
+//   - paths: The directories that would be removed
+func (c *removeCommand) previewRemoval(paths []string) {
+	if len(paths) == 0 {
+		c.cmd.Println("No targets to remove.")
+		c.cmd.Println("\nDry run: Nothing was removed.")
+		return
+	}
+
+	var total int64
+	c.cmd.Printf("Would remove %d directories:\n", len(paths))
+	for _, path := range paths {
+		size, err := dirutils.GetDirSize(path)
+		if err != nil {
+			c.cmd.Printf("  %s (size unknown: %v)\n", path, err)
+			continue
+		}
+		total += size
+		c.cmd.Printf("  %s (%.2f MB)\n", path, float64(size)/(1024*1024))
+	}
+	c.cmd.Printf("This would free approximately %.2f MB of disk space.\n", float64(total)/(1024*1024))
+	c.cmd.Println("\nDry run: Nothing was removed.")
+}
@@ -1,9 +1,13 @@
 package commands
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewRemoveCommand(t *testing.T) {
@@ -17,3 +21,88 @@ func TestExecuteRemove(t *testing.T) {
 	err := cmd.executeRemove("nigiri")
 	assert.Error(t, err) // Expecting error due to missing target directory
 }
+
+func TestRemoveDryRun(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		// want lists the paths relative to the nigiri root that are previewed
+		want    []string
+		notWant []string
+		total   string
+	}{
+		{
+			name:    "single target",
+			args:    []string{"tool"},
+			want:    []string{"tool"},
+			notWant: []string{"other"},
+			total:   "2.00 MB",
+		},
+		{
+			name:    "single commit",
+			args:    []string{"tool", "abcdef1"},
+			want:    []string{filepath.Join("tool", "abcdef1")},
+			notWant: []string{filepath.Join("tool", "1234567"), "other"},
+			total:   "1.00 MB",
+		},
+		{
+			name:  "all targets",
+			args:  []string{"--all"},
+			want:  []string{"other", "tool"},
+			total: "3.00 MB",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			builds := []string{
+				filepath.Join("tool", "abcdef1"),
+				filepath.Join("tool", "1234567"),
+				filepath.Join("other", "fedcba9"),
+			}
+			for _, build := range builds {
+				dir := filepath.Join(root, build)
+				require.NoError(t, os.MkdirAll(dir, 0755))
+				require.NoError(t, os.WriteFile(filepath.Join(dir, "bin"), make([]byte, 1024*1024), 0755))
+			}
+
+			var out bytes.Buffer
+			c := newRemoveCommand()
+			c.cmd.SetOut(&out)
+			c.cmd.SetArgs(append([]string{"--dry-run"}, tt.args...))
+			require.NoError(t, c.cmd.Execute())
+
+			for _, path := range tt.want {
+				assert.Contains(t, out.String(), "  "+filepath.Join(root, path)+" (")
+			}
+			for _, path := range tt.notWant {
+				assert.NotContains(t, out.String(), filepath.Join(root, path)+" (")
+			}
+			assert.Contains(t, out.String(), "This would free approximately "+tt.total+" of disk space.")
+			assert.Contains(t, out.String(), "Dry run: Nothing was removed.")
+			assert.NotContains(t, out.String(), "(y/n)")
+
+			for _, build := range builds {
+				assert.DirExists(t, filepath.Join(root, build))
+			}
+		})
+	}
+}
+
+func TestRemoveMatchesUpperCaseCommit(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	t.Setenv(assumeYesEnv, "1")
+	commitDir := filepath.Join(root, "tool", "abcdef1")
+	otherDir := filepath.Join(root, "tool", "1234567")
+	require.NoError(t, os.MkdirAll(commitDir, 0755))
+	require.NoError(t, os.MkdirAll(otherDir, 0755))
+
+	c := newRemoveCommand()
+	assert.Equal(t, []string{"abcdef1"}, c.getCompletionCommits("tool", "ABC"))
+	assert.Equal(t, []string{"abcdef1"}, getTargetCommits("tool", "ABC"))
+
+	c.cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, c.executeRemoveCommit("tool", "ABCDEF1"))
+	assert.NoDirExists(t, commitDir)
+	assert.DirExists(t, otherDir)
+}
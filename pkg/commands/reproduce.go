@@ -0,0 +1,192 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/commits"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
+	"github.com/spf13/cobra"
+)
+
+// reproduceCommand represents the structure for the reproduce command
+type reproduceCommand struct {
+	cmd *cobra.Command
+	// runner runs the build command of the rebuild
+	runner exec.Runner
+	// keep leaves the rebuild in its temporary directory for inspection
+	keep bool
+	// verbose shows the output of the build command
+	verbose bool
+	// useToken enables GitHub token authentication
+	useToken bool
+	// tokens resolves the GitHub token once for the rebuild
+	tokens *vcsutils.TokenCache
+}
+
+// newReproduceCommand creates a new reproduce command instance which rebuilds
+// a commit in a temporary directory and checks that the result matches the
+// stored build.
+//
+// Returns:
+//   - *reproduceCommand: A configured reproduce command instance
+func newReproduceCommand() *reproduceCommand {
+	c := &reproduceCommand{runner: exec.NewOSRunner(), tokens: &vcsutils.TokenCache{}}
+	cmd := &cobra.Command{
+		Use:   "reproduce target commit",
+		Short: "Check that a build can be reproduced",
+		Long: `Rebuild the commit of a stored build in a clean temporary directory with the
+reproducible-build settings of --reproducible, and compare the checksums of the
+resulting artifacts with those recorded for the stored build.
+The stored build is not modified. The command fails if the rebuild differs,
+naming the first artifact that does.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeReproduce(args[0], args[1])
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return getInstalledTargets(toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			if len(args) == 1 {
+				return getTargetCommits(args[0], toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&c.keep, "keep", false, "Keep the rebuild in its temporary directory for inspection")
+	flags.BoolVarP(&c.verbose, "verbose", "v", false, "Show the output of the build command")
+	flags.BoolVarP(&c.useToken, "use-token", "t", false, "Use GitHub token for authentication (required for private repositories)")
+
+	c.cmd = cmd
+	return c
+}
+
+// artifactChecksum is the recorded checksum of one artifact of a build
+type artifactChecksum struct {
+	// name describes the artifact, e.g. "binary"
+	name string
+	// sum is the hex-encoded SHA-256 checksum (empty if the artifact is missing)
+	sum string
+}
+
+// buildArtifacts lists the checksummed artifacts of a build in the order they
+// are compared: the binary, followed by the recorded dependency files
+//
+// Parameters:
+//   - info: The metadata of the build
+//
+// Returns:
+//   - []artifactChecksum: The artifacts and their checksums
+func buildArtifacts(info *targets.BuildInfo) []artifactChecksum {
+	artifacts := []artifactChecksum{{name: "binary", sum: info.BinarySHA256}}
+	for _, dep := range info.DependencyFiles {
+		artifacts = append(artifacts, artifactChecksum{name: "dependency file " + dep.Path, sum: dep.SHA256})
+	}
+	return artifacts
+}
+
+// firstDifference returns the first artifact of stored whose checksum differs
+// in rebuilt
+//
+// Parameters:
+//   - stored: The artifacts of the stored build
+//   - rebuilt: The artifacts of the rebuild
+//
+// Returns:
+//   - artifactChecksum: The differing artifact of the stored build
+//   - string: The checksum of the artifact in the rebuild (empty if missing)
+//   - bool: True if an artifact differs, false if all of them match
+func firstDifference(stored, rebuilt []artifactChecksum) (artifactChecksum, string, bool) {
+	sums := make(map[string]string, len(rebuilt))
+	for _, artifact := range rebuilt {
+		sums[artifact.name] = artifact.sum
+	}
+	for _, artifact := range stored {
+		if sums[artifact.name] != artifact.sum {
+			return artifact, sums[artifact.name], true
+		}
+	}
+	return artifactChecksum{}, "", false
+}
+
+// executeReproduce rebuilds the build of target at commitHash and compares
+// its artifacts with the stored build
+//
+// Parameters:
+//   - target: The name of the target
+//   - commitHash: The commit hash (or unique prefix of at least 7 characters) of the build
+//
+// Returns:
+//   - error: Any error encountered while rebuilding, or an error naming the
+//     first differing artifact if the build is not reproducible
+func (c *reproduceCommand) executeReproduce(target, commitHash string) error {
+	commitDir, err := findBuildDir(target, commitHash)
+	if err != nil {
+		return err
+	}
+	stored, err := targets.ReadBuildInfo(commitDir)
+	if err != nil {
+		return logger.CreateErrorf("failed to read build metadata of %s: %w", filepath.Base(commitDir), err)
+	}
+	if stored.BinarySHA256 == "" {
+		return logger.CreateErrorf("build %s of target '%s' has no recorded binary checksum to compare against (it needs full metadata and a binary-path)",
+			filepath.Base(commitDir), target)
+	}
+	if !commits.IsFullHash(stored.Commit) {
+		return logger.CreateErrorf("build %s of target '%s' does not record its full commit hash", filepath.Base(commitDir), target)
+	}
+
+	tempRoot, err := os.MkdirTemp("", "nigiri-reproduce-")
+	if err != nil {
+		return logger.CreateErrorf("failed to create temporary directory: %w", err)
+	}
+	if c.keep {
+		c.cmd.Printf("Keeping the rebuild in %s\n", tempRoot)
+	} else {
+		defer func() {
+			if err := os.RemoveAll(tempRoot); err != nil {
+				logger.Warnf("Failed to remove temporary directory %s: %v", tempRoot, err)
+			}
+		}()
+	}
+
+	c.cmd.Printf("Rebuilding target '%s' at commit %s...\n", target, stored.Commit)
+	b := newBuildCommand()
+	b.runner = c.runner
+	b.root = tempRoot
+	b.commit = stored.Commit
+	b.reproducible = true
+	b.recordDeps = len(stored.DependencyFiles) > 0
+	b.verbose = c.verbose
+	b.useToken = c.useToken
+	b.tokens = c.tokens
+	b.cmd.SetOut(c.cmd.OutOrStdout())
+	b.cmd.SetErr(c.cmd.ErrOrStderr())
+	if err := b.executeBuild(target); err != nil {
+		return logger.CreateErrorf("failed to rebuild: %w", err)
+	}
+
+	// The rebuild is named by the short hash length configured now, which
+	// need not be the one the stored build was named by
+	rebuilt, err := targets.ReadBuildInfo(b.builtDir)
+	if err != nil {
+		return logger.CreateErrorf("failed to read build metadata of the rebuild: %w", err)
+	}
+	if artifact, sum, differs := firstDifference(buildArtifacts(stored), buildArtifacts(rebuilt)); differs {
+		if sum == "" {
+			sum = "missing"
+		} else {
+			sum = "sha256:" + sum
+		}
+		return logger.CreateErrorf("build %s of target '%s' is not reproducible: %s differs (stored sha256:%s, rebuilt %s)",
+			filepath.Base(commitDir), target, artifact.name, artifact.sum, sum)
+	}
+	c.cmd.Printf("Build %s of target '%s' is reproducible (binary sha256:%s)\n", filepath.Base(commitDir), target, stored.BinarySHA256)
+	return nil
+}
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReproduceCommand(t *testing.T) {
+	cmd := newReproduceCommand()
+	assert.NotNil(t, cmd)
+	assert.NotNil(t, cmd.cmd)
+}
+
+func TestFirstDifference(t *testing.T) {
+	stored := []artifactChecksum{{name: "binary", sum: "aa"}, {name: "dependency file go.sum", sum: "bb"}}
+	tests := []struct {
+		name      string
+		rebuilt   []artifactChecksum
+		want      string
+		wantSum   string
+		wantDiffs bool
+	}{
+		{name: "identical", rebuilt: []artifactChecksum{{name: "binary", sum: "aa"}, {name: "dependency file go.sum", sum: "bb"}}},
+		{name: "binary differs", rebuilt: []artifactChecksum{{name: "binary", sum: "cc"}, {name: "dependency file go.sum", sum: "dd"}}, want: "binary", wantSum: "cc", wantDiffs: true},
+		{name: "dependency file missing", rebuilt: []artifactChecksum{{name: "binary", sum: "aa"}}, want: "dependency file go.sum", wantDiffs: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			artifact, sum, differs := firstDifference(stored, tt.rebuilt)
+			assert.Equal(t, tt.wantDiffs, differs)
+			assert.Equal(t, tt.want, artifact.name)
+			assert.Equal(t, tt.wantSum, sum)
+		})
+	}
+}
+
+func TestReproduce(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the timestamp fixture needs nanosecond resolution from date")
+	}
+	repoDir, hash := createTestSourceRepo(t)
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr string
+	}{
+		{
+			name:    "deterministic build is reproducible",
+			command: `mkdir -p bin && echo "built at $SOURCE_DATE_EPOCH" > bin/app`,
+		},
+		{
+			name:    "build embedding a timestamp is not reproducible",
+			command: "mkdir -p bin && date +%s%N > bin/app",
+			wantErr: "is not reproducible: binary differs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTestNigiriRoot(t)
+			t.Setenv("TMPDIR", t.TempDir())
+			useTestBuildConfig(t, repoDir, tt.command, "")
+
+			b := newBuildCommand()
+			b.reproducible = true
+			b.cmd.SetOut(&bytes.Buffer{})
+			require.NoError(t, b.executeBuild("tool"))
+			commitDir := filepath.Join(root, "tool", hash[:7])
+			before, err := os.ReadFile(filepath.Join(commitDir, "bin"))
+			require.NoError(t, err)
+
+			var out bytes.Buffer
+			c := newReproduceCommand()
+			c.cmd.SetOut(&out)
+			c.cmd.SetArgs([]string{"tool", hash[:7]})
+			err = c.cmd.Execute()
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Contains(t, out.String(), "Build "+hash[:7]+" of target 'tool' is reproducible")
+			}
+
+			// The stored build is left untouched and the rebuild is removed
+			after, err := os.ReadFile(filepath.Join(commitDir, "bin"))
+			require.NoError(t, err)
+			assert.Equal(t, before, after)
+			leftovers, err := os.ReadDir(os.Getenv("TMPDIR"))
+			require.NoError(t, err)
+			assert.Empty(t, leftovers)
+		})
+	}
+}
+
+func TestReproduceWithChangedShortHashLength(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	repoDir, hash := createTestSourceRepo(t)
+	command := `mkdir -p bin && echo "built at $SOURCE_DATE_EPOCH" > bin/app`
+	useTestBuildConfig(t, repoDir, command, "short-hash-length: 10")
+
+	b := newBuildCommand()
+	b.reproducible = true
+	b.cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, b.executeBuild("tool"))
+	require.DirExists(t, filepath.Join(root, "tool", hash[:10]))
+
+	// The rebuild is named by the default length, unlike the stored build
+	useTestBuildConfig(t, repoDir, command, "")
+	var out bytes.Buffer
+	c := newReproduceCommand()
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{"tool", hash[:10]})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "Build "+hash[:10]+" of target 'tool' is reproducible")
+}
+
+func TestReproduceRequiresChecksum(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	commitDir := filepath.Join(root, "tool", "abcdef1")
+	require.NoError(t, os.MkdirAll(commitDir, 0755))
+	require.NoError(t, targets.WriteBuildInfo(commitDir, &targets.BuildInfo{
+		Commit:    "abcdef1234567890abcdef1234567890abcdef12",
+		ShortHash: "abcdef1",
+		Status:    targets.BuildStatusSuccess,
+	}))
+
+	c := newReproduceCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"tool", "abcdef1"})
+	err := c.cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no recorded binary checksum")
+}
@@ -1,25 +1,74 @@
 package commands
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 
 	"github.com/oota-sushikuitee/nigiri/pkg/config"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
 	"github.com/spf13/cobra"
 )
 
-// nigiriRoot is the default path for nigiri's data directory
+// nigiriRoot is the path of nigiri's data directory. It starts at the
+// default location and is replaced by the global --root flag when set.
 var nigiriRoot = defaultNigiriRoot()
 
+// rootEnv is the environment variable that relocates the nigiri root
+const rootEnv = "NIGIRI_ROOT"
+
+// rootFlag holds the value of the global --root flag
+var rootFlag string
+
+// noTargetsMessage is shown by commands that read builds when the nigiri root
+// does not exist yet, e.g. right after installing nigiri
+const noTargetsMessage = "No targets yet. Run 'nigiri init' and 'nigiri build'."
+
+// errNoTargets is returned instead of a target lookup error by commands that
+// need an existing build when the nigiri root does not exist yet
+var errNoTargets = errors.New(noTargetsMessage)
+
+// nigiriRootMissing reports whether the nigiri root directory does not exist.
+// Commands check this before looking up targets so that a fresh install gets
+// the same friendly message everywhere.
+//
+// Returns:
+//   - bool: True if the nigiri root does not exist, false otherwise
+func nigiriRootMissing() bool {
+	_, err := os.Stat(nigiriRoot)
+	return os.IsNotExist(err)
+}
+
 // cfgFileFlag holds the value of the global --config flag. When non-empty it
 // overrides the default configuration file location.
 var cfgFileFlag string
 
-// defaultNigiriRoot resolves the nigiri data directory using the same home
-// directory resolution as the config loader, so both agree across platforms
-// (os.UserHomeDir works on Windows, where HOME is usually unset).
+// strictEnvFlag holds the value of the global --strict-env flag, which makes
+// undefined environment variables referenced by the configuration an error
+var strictEnvFlag bool
+
+// logLevelFlag holds the value of the global --log-level flag
+var logLevelFlag string
+
+// verboseFlag holds the value of the global --verbose flag, a shortcut for
+// --log-level debug
+var verboseFlag bool
+
+// logTimestampsFlag holds the value of the global --log-timestamps flag, which
+// starts log messages with a timestamp
+var logTimestampsFlag bool
+
+// defaultNigiriRoot resolves the nigiri data directory from NIGIRI_ROOT, or
+// otherwise using the same home directory resolution as the config loader, so
+// both agree across platforms (os.UserHomeDir works on Windows, where HOME is
+// usually unset).
 func defaultNigiriRoot() string {
+	if root := os.Getenv(rootEnv); root != "" {
+		return root
+	}
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return ".nigiri"
@@ -27,13 +76,54 @@ func defaultNigiriRoot() string {
 	return filepath.Join(homeDir, ".nigiri")
 }
 
+// applyRootFlag points nigiriRoot at the global --root flag when it is set,
+// which takes precedence over NIGIRI_ROOT and the default location. Commands
+// that parse their own flags call it again once the flag has been parsed.
+// The credentials file tokens are looked up in follows the root.
+func applyRootFlag() {
+	if rootFlag != "" {
+		nigiriRoot = rootFlag
+	}
+	vcsutils.CredentialsFile = filepath.Join(nigiriRoot, vcsutils.CredentialsFileName)
+}
+
+// applyLogFlags sets the level of the logger from the global --log-level
+// flag, or to debug when --verbose is set. Commands with a --verbose flag of
+// their own, such as build, raise the level the same way. An explicit
+// --log-level takes precedence over --verbose.
+//
+// Parameters:
+//   - cmd: The command being executed
+//
+// Returns:
+//   - error: An error if --log-level is not a known level
+func applyLogFlags(cmd *cobra.Command) error {
+	logger.SetShowTimestamp(logTimestampsFlag)
+	if cmd.Flags().Changed("log-level") {
+		level, err := logger.ParseLevel(logLevelFlag)
+		if err != nil {
+			return fmt.Errorf("invalid --log-level: %w", err)
+		}
+		logger.SetLevel(level)
+		return nil
+	}
+	if verbose := cmd.Flags().Lookup("verbose"); verbose != nil && verbose.Value.String() == "true" {
+		logger.SetLevel(logger.DebugLevel)
+		return nil
+	}
+	logger.SetLevel(logger.InfoLevel)
+	return nil
+}
+
 // newConfigManager builds a ConfigManager, applying the global --config flag
-// as an explicit configuration file path when it is set.
+// when it is set. The flag names either the configuration file itself or a
+// directory holding .nigiri.yml. The global --strict-env flag is applied too.
 func newConfigManager() *config.ConfigManager {
 	cm := config.NewConfigManager()
 	if cfgFileFlag != "" {
-		cm.Config.SetCfgFile(cfgFileFlag)
+		cm.SetCfgPath(cfgFileFlag)
 	}
+	cm.SetStrictEnv(strictEnvFlag)
 	return cm
 }
 
@@ -58,6 +148,10 @@ It allows you to easily build, run, and manage different versions of upstream pr
 `,
 		// Enable the --version flag on the root command
 		Version: Version,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			applyRootFlag()
+			return applyLogFlags(cmd)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return cmd.Help()
 		},
@@ -66,6 +160,12 @@ It allows you to easily build, run, and manage different versions of upstream pr
 	// Add global flags
 	fs := rootCmd.PersistentFlags()
 	fs.StringVarP(&cfgFileFlag, "config", "c", "", "config file (default is $HOME/.nigiri/.nigiri.yml)")
+	fs.StringVar(&rootFlag, "root", "", "nigiri data directory (default is $"+rootEnv+" or $HOME/.nigiri)")
+	fs.BoolVar(&strictEnvFlag, "strict-env", false, "Fail when the configuration references an undefined environment variable instead of expanding it to an empty string")
+	fs.StringVar(&logLevelFlag, "log-level", "info", "Minimum level of log messages to show: debug, info, warn or error")
+	fs.BoolVarP(&verboseFlag, "verbose", "v", false, "Show debug log messages (shortcut for --log-level debug)")
+	fs.BoolVar(&logTimestampsFlag, "log-timestamps", false, "Start log messages with an RFC3339 timestamp")
+	fs.BoolVar(&assumeYesFlag, "assume-yes", false, "Automatically accept all confirmation prompts (also enabled by "+assumeYesEnv+"=1)")
 
 	// Add subcommands
 	rootCmd.AddCommand(newInitCommand().cmd)
@@ -75,6 +175,19 @@ It allows you to easily build, run, and manage different versions of upstream pr
 	rootCmd.AddCommand(newCleanupCommand().cmd) // Add cleanup command
 	rootCmd.AddCommand(newVersionCommand().cmd)
 	rootCmd.AddCommand(newListCommand().cmd)
+	rootCmd.AddCommand(newBisectCommand().cmd)
+	rootCmd.AddCommand(newConfigCommand().cmd)
+	rootCmd.AddCommand(newGCCommand().cmd)
+	rootCmd.AddCommand(newStatusCommand().cmd)
+	rootCmd.AddCommand(newUpdateCommand().cmd)
+	rootCmd.AddCommand(newCacheCommand().cmd)
+	rootCmd.AddCommand(newExportDirCommand().cmd)
+	rootCmd.AddCommand(newInfoCommand().cmd)
+	rootCmd.AddCommand(newReproduceCommand().cmd)
+	rootCmd.AddCommand(newPinCommand().cmd)
+	rootCmd.AddCommand(newUnpinCommand().cmd)
+	rootCmd.AddCommand(newLogsCommand().cmd)
+	rootCmd.AddCommand(newDoctorCommand().cmd)
 
 	c.cmd = rootCmd
 	c.log = log.New(log.Writer(), "nigiri: ", log.LstdFlags)
@@ -2,10 +2,15 @@ package commands
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewRootCommand(t *testing.T) {
@@ -29,3 +34,167 @@ func TestExecuteVersionFlag(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, out.String(), Version)
 }
+
+func TestCommandsWithMissingNigiriRoot(t *testing.T) {
+	originalNigiriRoot := nigiriRoot
+	t.Cleanup(func() { nigiriRoot = originalNigiriRoot })
+	nigiriRoot = filepath.Join(t.TempDir(), "missing")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "list", args: []string{"list"}},
+		{name: "list tree", args: []string{"list", "--tree"}},
+		{name: "list target", args: []string{"list", "tool"}, wantErr: true},
+		{name: "run", args: []string{"run", "tool"}, wantErr: true},
+		{name: "remove target", args: []string{"remove", "tool"}, wantErr: true},
+		{name: "remove commit", args: []string{"remove", "tool", "abc1234"}, wantErr: true},
+		{name: "remove all", args: []string{"remove", "--all"}},
+		{name: "cleanup", args: []string{"cleanup"}},
+		{name: "cleanup target", args: []string{"cleanup", "tool"}, wantErr: true},
+		{name: "cleanup all", args: []string{"cleanup", "--all", "--yes"}},
+		{name: "cleanup empty targets", args: []string{"cleanup", "--empty-targets", "--yes"}},
+		{name: "gc", args: []string{"gc", "--yes"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := NewRootCommand()
+			var out bytes.Buffer
+			cmd.cmd.SetOut(&out)
+			cmd.cmd.SetErr(&out)
+			cmd.cmd.SetArgs(tt.args)
+
+			err := cmd.Execute()
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errNoTargets)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Contains(t, out.String(), noTargetsMessage)
+			assert.NoDirExists(t, nigiriRoot)
+		})
+	}
+}
+
+func TestNewConfigManagerConfigFlag(t *testing.T) {
+	cfgPath := useTestBuildConfig(t, "https://example.com/tool.git", "make", "")
+
+	tests := []struct {
+		name    string
+		flag    string
+		wantErr string
+	}{
+		{name: "file", flag: cfgPath},
+		{name: "directory", flag: filepath.Dir(cfgPath)},
+		{name: "missing", flag: filepath.Join(t.TempDir(), "missing.yml"), wantErr: "does not exist"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgFileFlag = tt.flag
+			cm := newConfigManager()
+			err := cm.LoadCfgFile()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Contains(t, cm.Config.Targets, "tool")
+		})
+	}
+}
+
+func TestDefaultNigiriRootFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(rootEnv, dir)
+	assert.Equal(t, dir, defaultNigiriRoot())
+}
+
+func TestRootFlag(t *testing.T) {
+	useTestNigiriRoot(t)
+	cfgPath := useTestConfig(t, testRunConfig)
+	t.Cleanup(func() { rootFlag = "" })
+	other := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "ran")
+	createTestCommitDir(t, other, "tool", "abc1234", "touch "+marker)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "before the command", args: []string{"--config", cfgPath, "--root", other, "run", "tool"}},
+		{name: "after the command", args: []string{"--config", cfgPath, "run", "--root", other, "tool"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTestNigiriRoot(t)
+			cmd := NewRootCommand()
+			var out bytes.Buffer
+			cmd.cmd.SetOut(&out)
+			cmd.cmd.SetErr(&out)
+			cmd.cmd.SetArgs(tt.args)
+
+			require.NoError(t, cmd.Execute())
+			assert.Equal(t, other, nigiriRoot)
+			assert.FileExists(t, marker)
+			require.NoError(t, os.Remove(marker))
+		})
+	}
+}
+
+func TestLogFlags(t *testing.T) {
+	useTestNigiriRoot(t)
+	t.Cleanup(func() {
+		logger.SetOutput(os.Stderr)
+		logger.SetLevel(logger.InfoLevel)
+		logLevelFlag, verboseFlag = "info", false
+	})
+
+	tests := []struct {
+		name      string
+		args      []string
+		wantDebug bool
+		wantInfo  bool
+		// failing is set for commands that fail after the log flags were applied
+		failing bool
+		wantErr string
+	}{
+		{name: "default", args: []string{"list"}, wantInfo: true},
+		{name: "verbose", args: []string{"--verbose", "list"}, wantDebug: true, wantInfo: true},
+		{name: "verbose shorthand after the command", args: []string{"list", "-v"}, wantDebug: true, wantInfo: true},
+		{name: "log level", args: []string{"--log-level", "warn", "list"}},
+		{name: "log level wins over verbose", args: []string{"--log-level", "error", "--verbose", "list"}},
+		{name: "command verbose flag", args: []string{"build", "--verbose", "missing"}, wantDebug: true, wantInfo: true, failing: true},
+		{name: "run", args: []string{"run", "--log-level", "debug", "missing"}, wantDebug: true, wantInfo: true, failing: true},
+		{name: "invalid level", args: []string{"--log-level", "loud", "list"}, wantErr: `unknown log level "loud"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logLevelFlag, verboseFlag = "info", false
+			logger.SetLevel(logger.ErrorLevel)
+			cmd := NewRootCommand()
+			cmd.cmd.SetOut(&bytes.Buffer{})
+			cmd.cmd.SetErr(&bytes.Buffer{})
+			cmd.cmd.SetArgs(tt.args)
+			err := cmd.Execute()
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			if !tt.failing {
+				require.NoError(t, err)
+			}
+
+			var buf bytes.Buffer
+			logger.SetOutput(&buf)
+			logger.Debug("debug message")
+			logger.Info("info message")
+			assert.Equal(t, tt.wantDebug, strings.Contains(buf.String(), "debug message"))
+			assert.Equal(t, tt.wantInfo, strings.Contains(buf.String(), "info message"))
+		})
+	}
+}
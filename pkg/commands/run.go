@@ -1,33 +1,99 @@
 package commands
 
 import (
-	"archive/tar"
-	"compress/gzip"
+	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/internal/storage"
 	"github.com/oota-sushikuitee/nigiri/internal/targets"
 	"github.com/oota-sushikuitee/nigiri/pkg/commits"
+	"github.com/oota-sushikuitee/nigiri/pkg/config"
+	"github.com/oota-sushikuitee/nigiri/pkg/fsutils"
 	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 // runCommand represents the structure for the run command
 type runCommand struct {
 	cmd *cobra.Command
+	// runner runs the built binary
+	runner exec.Runner
+	// attachLogs prints the build metadata and log tail before running
+	attachLogs bool
+	// logTail is the number of build log lines shown with --attach-logs
+	logTail int
+	// capture saves the program output to files in the commit directory
+	capture bool
+	// captureDir saves the program output to files in this directory
+	captureDir string
+	// execMode replaces the nigiri process with the program where supported
+	execMode bool
+	// replaceSupported reports whether the process can be replaced on this platform
+	replaceSupported bool
+	// replaceProcess replaces the nigiri process with the program
+	replaceProcess func(argv []string, opts exec.Options) error
+	// selectBuild asks which build to run from a numbered list
+	selectBuild bool
+	// lastSuccess only considers builds whose recorded build succeeded
+	lastSuccess bool
+	// last reuses the arguments of the previous run when none are given
+	last bool
+	// interactive asks which build to run when a commit prefix matches several
+	interactive bool
+	// output is the output format of the run outcome ("text" or "json")
+	output string
+	// envOverrides are KEY=VALUE pairs that override the environment of the program
+	envOverrides []string
+	// printEnv prints the environment of the program before running it
+	printEnv bool
+	// dryRun resolves the build to run without running it
+	dryRun bool
+}
+
+// RunResult is the outcome of running a build of a target
+//
+// Fields:
+//   - Target: The name of the target
+//   - Commit: The commit directory name of the build that was run
+//   - Binary: The path of the binary that was run
+//   - Args: The arguments passed to the binary
+//   - ExitCode: The exit code of the program (0 when it succeeded)
+//   - StartTime: When the program was started
+//   - Duration: How long the program ran
+type RunResult struct {
+	Target    string        `json:"target"`
+	Commit    string        `json:"commit"`
+	Binary    string        `json:"binary"`
+	Args      []string      `json:"args"`
+	ExitCode  int           `json:"exit_code"`
+	StartTime time.Time     `json:"start_time"`
+	Duration  time.Duration `json:"duration"`
 }
 
 // newRunCommand creates a new run command instance which allows users
 // to execute previously built targets with optional arguments.
 // The command supports specifying a particular commit to run or defaults to the latest.
 func newRunCommand() *runCommand {
-	c := &runCommand{}
+	c := &runCommand{
+		runner:           exec.NewOSRunner(),
+		replaceSupported: exec.ReplaceSupported,
+		replaceProcess:   exec.Replace,
+	}
 	cmd := &cobra.Command{
 		Use:   "run target [commit] [args...]",
 		Short: "Run a built target",
@@ -54,17 +120,83 @@ Examples:
 
   # Explicitly separate nigiri arguments from target arguments
   nigiri run <target> <commit> -- -v --flag=value
+
+  # Show the build metadata and build log tail before running
+  nigiri run --attach-logs <target>
+
+  # Save the program output to capture/stdout.log and capture/stderr.log
+  nigiri run --capture <target>
+
+  # Replace nigiri with the program, passing signals and exit status through
+  nigiri run --exec <target>
+
+  # Choose the build to run from a numbered list
+  nigiri run --select <target>
+
+  # Run the most recent successful build
+  nigiri run --last-success <target>
+
+  # Run again with the arguments of the previous run of the build
+  nigiri run --last <target>
+
+  # Choose the build when a short commit prefix matches several builds
+  nigiri run --interactive <target> <commit>
+
+  # Run with an extra environment variable
+  nigiri run --env LOG_LEVEL=debug <target>
+
+  # Show the environment the program would get, with secrets redacted
+  nigiri run --print-env --dry-run <target>
+
+Flags for nigiri itself must be given before the target name.
 `,
 		DisableFlagParsing: true, // Let us handle the flags manually
 		RunE: func(cmd *cobra.Command, args []string) error {
+			args, err := c.parseLeadingFlags(args)
+			if err != nil {
+				if errors.Is(err, pflag.ErrHelp) {
+					return cmd.Help()
+				}
+				return err
+			}
+			// The root's pre-run saw the flags before they were parsed here
+			applyRootFlag()
+			if err := applyLogFlags(cmd); err != nil {
+				return err
+			}
 			if len(args) < 1 {
 				return cmd.Help()
 			}
+			if c.output != "text" && c.output != "json" {
+				return fmt.Errorf("unsupported output format '%s': expected text or json", c.output)
+			}
+			if c.output == "json" && c.execMode {
+				return fmt.Errorf("--output json cannot be combined with --exec")
+			}
+			for _, kv := range c.envOverrides {
+				if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+					return fmt.Errorf("invalid --env %q: expected KEY=VALUE", kv)
+				}
+			}
 
 			target := args[0]
 			var commitHash string
 			var targetArgs []string
 
+			// The build is chosen by --select or --last-success, so every
+			// argument after the target is for the target program
+			if c.selectBuild || c.lastSuccess {
+				targetArgs = args[1:]
+				if len(targetArgs) > 0 && targetArgs[0] == "--" {
+					targetArgs = targetArgs[1:]
+				}
+				chosen, err := c.chooseBuild(target)
+				if err != nil {
+					return err
+				}
+				return c.reportRun(c.executeRun(target, chosen, targetArgs))
+			}
+
 			// Parse arguments to separate commit and target args
 			if len(args) > 1 {
 				// Look for "--" in args to find target arguments explicitly
@@ -116,7 +248,7 @@ Examples:
 				cmd.Printf("Using HEAD (latest commit)\n")
 			}
 
-			return c.executeRun(target, commitHash, targetArgs)
+			return c.reportRun(c.executeRun(target, commitHash, targetArgs))
 		},
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 			// Offer tab completion for targets if no arguments provided yet
@@ -140,10 +272,264 @@ Examples:
 		},
 	}
 
+	flags := cmd.Flags()
+	flags.BoolVar(&c.attachLogs, "attach-logs", false, "Print the build metadata and the tail of the build log before running")
+	flags.IntVar(&c.logTail, "log-tail", 10, "Number of build log lines to show with --attach-logs (0 to omit the log)")
+	flags.BoolVar(&c.capture, "capture", false, "Also save the program's stdout and stderr to files in the commit directory")
+	flags.StringVar(&c.captureDir, "capture-dir", "", "Also save the program's stdout and stderr to files in this directory (implies --capture)")
+	flags.BoolVar(&c.execMode, "exec", false, "Replace the nigiri process with the program instead of running it as a child (Unix only)")
+	flags.BoolVar(&c.selectBuild, "select", false, "Choose the build to run from a numbered list (interactive terminals only)")
+	flags.BoolVar(&c.lastSuccess, "last-success", false, "Run the most recent successful build (with --select, list only successful builds)")
+	flags.BoolVar(&c.interactive, "interactive", false, "Ask which build to run when the commit prefix matches several builds (interactive terminals only)")
+	flags.BoolVar(&c.last, "last", false, "Reuse the arguments of the previous run of the build when no arguments are given")
+	flags.StringVarP(&c.output, "output", "o", "text", "Output format of the run outcome printed after the program exits: text (nothing) or json")
+	flags.StringArrayVar(&c.envOverrides, "env", nil, "Set an environment variable for the program as KEY=VALUE, overriding the configured env (repeatable)")
+	flags.BoolVar(&c.printEnv, "print-env", false, "Print the environment of the program, sorted and with secrets redacted, before running it")
+	flags.BoolVar(&c.dryRun, "dry-run", false, "Show the build that would be run without running it")
+
 	c.cmd = cmd
 	return c
 }
 
+// parseLeadingFlags parses nigiri's own run flags, which must precede the
+// target name since flag parsing is otherwise disabled to pass arguments
+// through to the target program untouched.
+//
+// Parameters:
+//   - args: The raw command line arguments
+//
+// Returns:
+//   - []string: The remaining arguments starting at the target name
+//   - error: Any error encountered while parsing the flags
+func (c *runCommand) parseLeadingFlags(args []string) ([]string, error) {
+	flags := c.cmd.Flags()
+	var flagArgs []string
+	i := 0
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || !strings.HasPrefix(arg, "-") || arg == "-" {
+			break
+		}
+		if arg == "-h" || arg == "--help" {
+			return nil, pflag.ErrHelp
+		}
+
+		var flag *pflag.Flag
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "--") {
+			flag = flags.Lookup(name)
+		} else if len(name) == 1 {
+			flag = flags.ShorthandLookup(name)
+		}
+		if flag == nil {
+			return nil, logger.CreateErrorf("unknown flag: %s", arg)
+		}
+
+		flagArgs = append(flagArgs, arg)
+		// Flags that take a value may have it as the following argument
+		if !hasValue && flag.NoOptDefVal == "" {
+			if i+1 >= len(args) {
+				return nil, logger.CreateErrorf("flag needs an argument: %s", arg)
+			}
+			i++
+			flagArgs = append(flagArgs, args[i])
+		}
+	}
+
+	if err := flags.Parse(flagArgs); err != nil {
+		return nil, err
+	}
+	return args[i:], nil
+}
+
+// chooseBuild picks the build of target to run for --select and
+// --last-success. With --select, the builds are listed newest first and the
+// user is asked for the number of the build to run.
+//
+// Parameters:
+//   - target: The name of the built target
+//
+// Returns:
+//   - string: The directory name (short hash) of the chosen build
+//   - error: An error if there is no build to choose or the selection is invalid
+func (c *runCommand) chooseBuild(target string) (string, error) {
+	if nigiriRootMissing() {
+		return "", errNoTargets
+	}
+	if _, err := lookupRunTarget(target); err != nil {
+		return "", err
+	}
+	summaries, err := gatherTargets(nigiriRoot, nil, false)
+	if err != nil {
+		return "", err
+	}
+	var builds []buildSummary
+	for _, summary := range summaries {
+		if summary.name == target {
+			builds = summary.builds
+			break
+		}
+	}
+	if c.lastSuccess {
+		var succeeded []buildSummary
+		for _, build := range builds {
+			if build.status == targets.BuildStatusSuccess {
+				succeeded = append(succeeded, build)
+			}
+		}
+		if len(succeeded) == 0 {
+			return "", logger.CreateErrorf("no successful builds found for target %s", target)
+		}
+		builds = succeeded
+	}
+	if len(builds) == 0 {
+		return "", noBuildsYetError(target)
+	}
+
+	if !c.selectBuild {
+		c.cmd.Printf("Using latest successful build: %s\n", builds[0].hash)
+		return builds[0].hash, nil
+	}
+	if !isInteractiveInput(c.cmd.InOrStdin()) {
+		return "", logger.CreateErrorf("--select requires an interactive terminal")
+	}
+
+	c.cmd.Printf("Builds of target '%s':\n", target)
+	for i, build := range builds {
+		ref := build.ref
+		if ref == "" {
+			ref = "-"
+		}
+		status := build.status
+		if status == "" {
+			status = "unknown"
+		}
+		c.cmd.Printf("  %2d) %s  %-20s  %s  %s\n", i+1, build.hash, ref, build.modTime.Format("2006-01-02 15:04"), status)
+	}
+	index, err := c.readSelection(len(builds))
+	if err != nil {
+		return "", err
+	}
+	return builds[index].hash, nil
+}
+
+// readSelection asks for the number of one of count listed builds
+//
+// Parameters:
+//   - count: The number of builds listed
+//
+// Returns:
+//   - int: The zero-based index of the selected build
+//   - error: An error if the answer cannot be read or is not a listed number
+func (c *runCommand) readSelection(count int) (int, error) {
+	c.cmd.Printf("Select a build [1-%d]: ", count)
+
+	line, err := bufio.NewReader(c.cmd.InOrStdin()).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, logger.CreateErrorf("failed to read selection: %w", err)
+	}
+	answer := strings.TrimSpace(line)
+	index, err := strconv.Atoi(answer)
+	if err != nil || index < 1 || index > count {
+		return 0, logger.CreateErrorf("invalid selection %q: expected a number from 1 to %d", answer, count)
+	}
+	return index - 1, nil
+}
+
+// resolveBuild finds the build of target to run: the latest build when no
+// commit is given, or the build matching a commit prefix
+//
+// Parameters:
+//   - store: The storage holding the builds
+//   - target: The name of the target
+//   - commitHash: The commit hash or prefix (can be empty for the latest build)
+//
+// Returns:
+//   - string: The name of the build
+//   - error: An error if no build, or no single build, matches
+func (c *runCommand) resolveBuild(store storage.Storage, target, commitHash string) (string, error) {
+	if commitHash != "" {
+		if err := checkCommitPrefix(target, commitHash); err != nil {
+			return "", err
+		}
+	}
+	builds, err := store.List(target)
+	if err != nil {
+		return "", logger.CreateErrorf("failed to read target directory: %w", err)
+	}
+
+	if commitHash == "" {
+		if len(builds) == 0 {
+			return "", noBuildsYetError(target)
+		}
+		c.cmd.Printf("Using latest commit: %s\n", builds[0].Name)
+		return builds[0].Name, nil
+	}
+
+	var matchingDirs []string
+	for _, build := range builds {
+		if commits.HasHashPrefix(build.Name, commitHash) {
+			matchingDirs = append(matchingDirs, build.Name)
+		}
+	}
+	if len(matchingDirs) == 0 {
+		return "", logger.CreateErrorf("no build found for commit %s", commitHash)
+	}
+	if len(matchingDirs) == 1 {
+		return matchingDirs[0], nil
+	}
+	sort.Strings(matchingDirs)
+	return c.chooseMatchingBuild(commitHash, matchingDirs)
+}
+
+// chooseMatchingBuild resolves a commit prefix that matches several builds.
+// Without --interactive, the ambiguity is an error listing the matches;
+// with it, the user is asked which build to run.
+//
+// Parameters:
+//   - commitHash: The commit prefix given on the command line
+//   - matchingDirs: The names of the commit directories matching the prefix
+//
+// Returns:
+//   - string: The name of the chosen commit directory
+//   - error: An error if the prefix is ambiguous and no build was chosen
+func (c *runCommand) chooseMatchingBuild(commitHash string, matchingDirs []string) (string, error) {
+	if !c.interactive {
+		return "", logger.CreateErrorf("multiple builds match commit %s: %s (use a longer prefix, or --interactive to choose)",
+			commitHash, strings.Join(matchingDirs, ", "))
+	}
+	if !isInteractiveInput(c.cmd.InOrStdin()) {
+		return "", logger.CreateErrorf("multiple builds match commit %s: %s (--interactive requires an interactive terminal)",
+			commitHash, strings.Join(matchingDirs, ", "))
+	}
+
+	c.cmd.Printf("Multiple builds match commit %s:\n", commitHash)
+	for i, dir := range matchingDirs {
+		c.cmd.Printf("  %2d) %s\n", i+1, dir)
+	}
+	index, err := c.readSelection(len(matchingDirs))
+	if err != nil {
+		return "", err
+	}
+	return matchingDirs[index], nil
+}
+
+// isInteractiveInput reports whether r is an interactive terminal. Readers
+// that are not files, such as input injected with SetIn, count as interactive.
+//
+// Parameters:
+//   - r: The input reader
+//
+// Returns:
+//   - bool: True if r is a terminal or not a file, false otherwise
+func isInteractiveInput(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return true
+	}
+	return logger.IsTerminal(f)
+}
+
 // getCompletionTargets returns a list of available targets for command completion
 func (c *runCommand) getCompletionTargets(prefix string) []string {
 	return getConfiguredTargets(prefix)
@@ -154,6 +540,38 @@ func (c *runCommand) getCompletionCommits(target, prefix string) []string {
 	return getTargetCommits(target, prefix)
 }
 
+// lookupRunTarget returns the configuration of a target to run
+//
+// Parameters:
+//   - target: The name of the target
+//
+// Returns:
+//   - internalconfig.Target: The configuration of the target
+//   - error: An error if the configuration cannot be loaded or does not define the target
+func lookupRunTarget(target string) (internalconfig.Target, error) {
+	cm := newConfigManager()
+	if err := cm.LoadCfgFile(); err != nil {
+		return internalconfig.Target{}, logger.CreateErrorf("failed to load config: %w", err)
+	}
+	targetCfg, exists := cm.Config.Targets[target]
+	if !exists {
+		return internalconfig.Target{}, logger.CreateErrorf("unknown target %s (not in config)", target)
+	}
+	return targetCfg, nil
+}
+
+// noBuildsYetError returns the error for a configured target that has no
+// build to run, pointing at the command that builds it
+//
+// Parameters:
+//   - target: The name of the target
+//
+// Returns:
+//   - error: The error to report
+func noBuildsYetError(target string) error {
+	return logger.CreateErrorf("target %s has no builds yet; run 'nigiri build %s'", target, target)
+}
+
 // executeRun executes the specified target with the given commit hash and arguments.
 // If commitHash is empty, it uses the most recently built version of the target.
 // It handles locating the binary, setting up the execution environment, and running the process.
@@ -164,312 +582,356 @@ func (c *runCommand) getCompletionCommits(target, prefix string) []string {
 //   - args: Additional arguments to pass to the target binary when executing
 //
 // Returns:
-//   - error: Any error encountered during the execution process
-func (c *runCommand) executeRun(target, commitHash string, args []string) error {
+//   - *RunResult: The outcome of the run, once the program was started (nil otherwise)
+//   - error: Any error encountered during the execution process, including the
+//     exit error of a program that did not succeed
+func (c *runCommand) executeRun(target, commitHash string, args []string) (*RunResult, error) {
+	if nigiriRootMissing() {
+		return nil, errNoTargets
+	}
+	// The configuration tells a target that was never built apart from one
+	// that does not exist
+	targetCfg, err := lookupRunTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(nigiriRoot, target)); os.IsNotExist(err) {
+		return nil, noBuildsYetError(target)
+	}
 	fsTarget := targets.Target{
 		Target:  target,
 		Commits: commits.Commits{},
 	}
 	targetRootDir, err := fsTarget.GetTargetRootDir(nigiriRoot)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Use latest commit if none specified
-	var runDir string
-	if commitHash == "" {
-		// Find the most recent commit directory
-		dirs, err := os.ReadDir(targetRootDir)
-		if err != nil {
-			return logger.CreateErrorf("failed to read target directory: %w", err)
-		}
-
-		var latestDir string
-		var latestInfo os.FileInfo
-		for _, dir := range dirs {
-			if dir.IsDir() {
-				info, err := os.Stat(filepath.Join(targetRootDir, dir.Name()))
-				if err != nil {
-					continue
-				}
-				if latestInfo == nil || info.ModTime().After(latestInfo.ModTime()) {
-					latestInfo = info
-					latestDir = dir.Name()
-				}
+	store := storage.NewLocal(nigiriRoot)
+	build, err := c.resolveBuild(store, target, commitHash)
+	if err != nil {
+		return nil, err
+	}
+	runDir := filepath.Join(targetRootDir, build)
+
+	if info, err := storage.ReadBuildInfo(store, target, build); err == nil {
+		switch info.Status {
+		case targets.BuildStatusArchived:
+			return nil, logger.CreateErrorf("build %s of target %s is a source archive made with --archive-only and has nothing to run; build it with 'nigiri build %s %s'",
+				build, target, target, build)
+		case targets.BuildStatusFailed:
+			commit := info.Commit
+			if commit == "" {
+				commit = build
 			}
+			return nil, logger.CreateErrorf("this commit's build failed; rebuild with --force: 'nigiri build %s %s --force' (build log: %s)",
+				target, commit, filepath.Join(runDir, "logs", "build.log"))
 		}
+	}
 
-		if latestDir == "" {
-			return logger.CreateErrorf("no builds found for target %s", target)
-		}
-
-		runDir = filepath.Join(targetRootDir, latestDir)
-		c.cmd.Printf("Using latest commit: %s\n", latestDir)
-	} else {
-		// For specified commit
-		if len(commitHash) < 7 {
-			return logger.CreateErrorf("commit hash is too short: %s (minimum 7 characters)", commitHash)
-		}
-
-		// Find directory matching the commit hash
-		dirs, err := os.ReadDir(targetRootDir)
+	if c.last && len(args) == 0 {
+		lastArgs, recorded, err := targets.ReadLastArgs(runDir)
 		if err != nil {
-			return logger.CreateErrorf("failed to read target directory: %w", err)
+			return nil, logger.CreateErrorf("failed to read the arguments of the previous run: %w", err)
 		}
-
-		var matchingDir string
-		for _, dir := range dirs {
-			if dir.IsDir() && strings.HasPrefix(dir.Name(), commitHash) {
-				matchingDir = dir.Name()
-				break
-			}
-		}
-
-		if matchingDir == "" {
-			return logger.CreateErrorf("no build found for commit %s", commitHash)
+		if !recorded {
+			return nil, logger.CreateErrorf("no previous arguments recorded for build %s of target %s; run it once with arguments first",
+				filepath.Base(runDir), target)
 		}
-
-		runDir = filepath.Join(targetRootDir, matchingDir)
+		args = lastArgs
+		c.cmd.Printf("Reusing the arguments of the previous run: %v\n", args)
 	}
 
-	// Get configuration for working directory setting
-	cm := newConfigManager()
-	if err := cm.LoadCfgFile(); err != nil {
-		return logger.CreateErrorf("failed to load config: %w", err)
+	if _, err := os.Stat(filepath.Join(runDir, "bin")); os.IsNotExist(err) {
+		c.cmd.Println("Binary not found in commit/bin directory, looking for alternative locations...")
+		if targets.SourceNeedsExtraction(runDir) {
+			c.cmd.Println("Extracting source archive...")
+		}
 	}
-	targetCfg, exists := cm.Config.Targets[target]
-	if !exists {
-		return logger.CreateErrorf("target '%s' not found in configuration", target)
+	binaryPath, err := targets.ResolveBinary(runDir, targetCfg, target)
+	if err != nil {
+		return nil, err
 	}
 
-	// Look for the binary in the commit directory first
-	binaryPath := filepath.Join(runDir, "bin")
-	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
-		c.cmd.Printf("Binary not found in commit/bin directory, looking for alternative locations...\n")
-
-		// Check for compressed source
-		srcArchive := filepath.Join(runDir, "source.tar.gz")
-		srcDir := filepath.Join(runDir, "src")
-
-		// If source archive exists but src directory doesn't, extract it
-		if _, err := os.Stat(srcArchive); err == nil {
-			if _, err := os.Stat(srcDir); os.IsNotExist(err) {
-				c.cmd.Printf("Extracting source archive...\n")
-				if err := extractTarGz(srcArchive, runDir); err != nil {
-					return logger.CreateErrorf("failed to extract source archive: %w", err)
-				}
-			}
+	if c.printEnv {
+		c.cmd.Println("Environment of the program:")
+		for _, kv := range printableEnv(runEnvironment(targetCfg.Env, c.envOverrides)) {
+			c.cmd.Println("  " + kv)
 		}
+	}
+	if c.dryRun {
+		c.cmd.Printf("Would run %s with args: %v\n", binaryPath, args)
+		return nil, nil
+	}
 
-		// At this point, we should have a src directory (either it was there or we extracted it)
-		if _, err := os.Stat(srcDir); os.IsNotExist(err) {
-			return logger.CreateErrorf("source directory not found: %s", srcDir)
+	stdout, stderr := c.cmd.OutOrStdout(), c.cmd.ErrOrStderr()
+	record := targets.RunRecord{LastRun: time.Now()}
+	if c.capture || c.captureDir != "" {
+		captureDir := c.captureDir
+		if captureDir == "" {
+			captureDir = filepath.Join(runDir, captureDirName)
 		}
-
-		// Apply working directory if specified
-		workDir := srcDir
-		if targetCfg.WorkingDirectory != "" {
-			workDir = filepath.Join(srcDir, targetCfg.WorkingDirectory)
-			if _, err := os.Stat(workDir); os.IsNotExist(err) {
-				return logger.CreateErrorf("working directory '%s' not found in source", targetCfg.WorkingDirectory)
-			}
+		stdoutFile, stderrFile, err := openCaptureFiles(captureDir)
+		if err != nil {
+			return nil, logger.CreateErrorf("failed to create capture files: %w", err)
 		}
-
-		// Get binary path from config
-		if binPath, ok := targetCfg.BuildCommand.BinaryPath(); ok {
-			binaryPath = filepath.Join(workDir, binPath)
-		} else {
-			// Try common locations for the binary
-			binaryPath = filepath.Join(workDir, target)
-			// If binary not found directly, try common locations
-			if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
-				// Try bin/ directory
-				altPath := filepath.Join(workDir, "bin", target)
-				if _, err := os.Stat(altPath); err == nil {
-					binaryPath = altPath
-				} else {
-					// Try build/ directory
-					altPath = filepath.Join(workDir, "build", target)
-					if _, err := os.Stat(altPath); err == nil {
-						binaryPath = altPath
-					}
+		defer func() {
+			for _, f := range []*os.File{stdoutFile, stderrFile} {
+				if err := f.Close(); err != nil {
+					logger.Warnf("Failed to close capture file: %v", err)
 				}
 			}
-		}
+		}()
+		stdout = io.MultiWriter(stdout, stdoutFile)
+		stderr = io.MultiWriter(stderr, stderrFile)
+		record.Stdout = stdoutFile.Name()
+		record.Stderr = stderrFile.Name()
 	}
 
-	if _, err := os.Stat(binaryPath); os.IsNotExist(err) {
-		return logger.CreateErrorf("binary not found at %s", binaryPath)
+	// Remember when this build was used so cleanup can keep it
+	if err := targets.RecordRun(targetRootDir, filepath.Base(runDir), record); err != nil {
+		logger.Warnf("Failed to record run history: %v", err)
+	}
+	if err := targets.WriteLastArgs(runDir, args); err != nil {
+		logger.Warnf("Failed to record the arguments of the run: %v", err)
+	}
+
+	// Let cleanup see that this build is in use while it runs
+	releaseRunning, err := targets.MarkRunning(runDir, os.Getpid())
+	if err != nil {
+		logger.Warnf("Failed to mark build as running: %v", err)
+	} else {
+		defer releaseRunning()
+	}
+
+	if c.attachLogs {
+		c.printBuildContext(runDir)
 	}
 
 	// Make sure binary is executable (not needed on Windows)
 	if runtime.GOOS != "windows" {
 		if err := os.Chmod(binaryPath, 0755); err != nil {
-			return logger.CreateErrorf("failed to make binary executable: %w", err)
+			return nil, logger.CreateErrorf("failed to make binary executable: %w", err)
 		}
 	}
 
 	// Setup command execution with proper argument handling
-	cmd := exec.CommandContext(context.Background(), binaryPath, args...)
-	cmd.Stdout = c.cmd.OutOrStdout()
-	cmd.Stderr = c.cmd.ErrOrStderr()
-	cmd.Stdin = os.Stdin
-
-	// Set working directory to binary's directory
-	cmd.Dir = filepath.Dir(binaryPath)
+	runOpts := exec.Options{
+		Stdout: stdout,
+		Stderr: stderr,
+		Stdin:  c.cmd.InOrStdin(),
+		// Set working directory to binary's directory
+		Dir: filepath.Dir(binaryPath),
+	}
+	// Ending nigiri ends the program too. Run from a terminal, the program
+	// keeps the terminal and its interrupts, and nigiri waits for it; otherwise
+	// it runs in its own process group, which the signals are forwarded to.
+	if stdin, ok := runOpts.Stdin.(*os.File); ok && logger.IsTerminal(stdin) {
+		runOpts.ForwardSignals = true
+	} else {
+		runOpts.ProcessGroup = true
+	}
 
-	// Add any environment variables from config
-	if len(targetCfg.Env) > 0 {
-		cmd.Env = append(os.Environ(), targetCfg.Env...)
+	// Add any environment variables from config and --env
+	if len(targetCfg.Env) > 0 || len(c.envOverrides) > 0 {
+		runOpts.Env = runEnvironment(targetCfg.Env, c.envOverrides)
 	}
 
 	c.cmd.Printf("Running %s with args: %v\n", binaryPath, args)
-	return cmd.Run()
+	result := &RunResult{
+		Target:    target,
+		Commit:    filepath.Base(runDir),
+		Binary:    binaryPath,
+		Args:      args,
+		StartTime: time.Now(),
+	}
+	if c.useReplaceProcess() {
+		// The program keeps nigiri's PID, so the build stays marked as running
+		return nil, c.replaceProcess(append([]string{binaryPath}, args...), runOpts)
+	}
+	_, _, err = c.runner.Run(context.Background(), append([]string{binaryPath}, args...), runOpts)
+	result.Duration = time.Since(result.StartTime)
+	if code, exited := exec.ExitCode(err); exited {
+		result.ExitCode = code
+	}
+	return result, err
 }
 
-// maxFileSizeForExtract is the maximum file size allowed when extracting archives (1GB)
-const maxFileSizeForExtract = 1 << 30
+// runEnvironment builds the environment of a run from the environment of
+// nigiri, the env of the target configuration and the --env overrides, where
+// later entries take precedence
+//
+// Parameters:
+//   - configEnv: The env entries of the target configuration
+//   - overrides: The KEY=VALUE pairs given with --env
+//
+// Returns:
+//   - []string: The environment as KEY=value pairs, possibly with repeated keys
+func runEnvironment(configEnv, overrides []string) []string {
+	env := append(os.Environ(), configEnv...)
+	return append(env, overrides...)
+}
 
-// extractTarGz extracts a tar.gz file to the specified directory
-func extractTarGz(tarGzPath, destDir string) error {
-	// Open the tar.gz file
-	file, err := os.Open(tarGzPath)
-	if err != nil {
-		return fmt.Errorf("failed to open archive: %w", err)
+// printableEnv resolves repeated keys of env to their effective values, sorts
+// the result by key and redacts secrets: the values of variables whose names
+// look like they hold one, and credentials embedded in URLs
+//
+// Parameters:
+//   - env: The environment as KEY=value pairs, where the last entry of a key wins
+//
+// Returns:
+//   - []string: The effective KEY=value pairs, safe to print
+func printableEnv(env []string) []string {
+	values := make(map[string]string, len(env))
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		values[key] = value
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			logger.Warnf("failed to close archive file: %v", err)
-		}
-	}()
-
-	// Create gzip reader
-	gzipReader, err := gzip.NewReader(file)
-	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
 	}
-	defer func() {
-		if err := gzipReader.Close(); err != nil {
-			logger.Warnf("failed to close gzip reader: %v", err)
-		}
-	}()
-
-	// Create tar reader
-	tarReader := tar.NewReader(gzipReader)
+	sort.Strings(keys)
 
-	// Extract each file
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
+	printable := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := vcsutils.ScrubCredentials(values[key])
+		if config.IsSecretEnvName(key) && value != "" {
+			value = "***"
 		}
-		if err != nil {
-			return fmt.Errorf("tar reading error: %w", err)
+		printable = append(printable, key+"="+value)
+	}
+	return printable
+}
+
+// reportRun adapts the outcome of executeRun for the command: with --output
+// json, the run result is printed once the program has exited, whether it
+// succeeded or not
+//
+// Parameters:
+//   - result: The outcome of the run (nil if the program was not started)
+//   - err: The error returned by executeRun
+//
+// Returns:
+//   - error: The error of the run, or any error encountered printing the result
+func (c *runCommand) reportRun(result *RunResult, err error) error {
+	if c.output == "json" && result != nil {
+		enc := json.NewEncoder(c.cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		if encErr := enc.Encode(result); encErr != nil {
+			return errors.Join(err, encErr)
 		}
+	}
+	return err
+}
 
-		// Resolve the target path and ensure it stays within destDir. Using
-		// filepath.Rel-based containment avoids the separator-unsafe prefix
-		// pitfall (e.g. "/root-evil" is not contained by "/root").
-		filePath := filepath.Join(destDir, filepath.Clean(header.Name))
-		if !isWithinDir(destDir, filePath) {
-			return fmt.Errorf("attempted path traversal in archive: %s", header.Name)
+// printBuildContext prints the metadata of the build in runDir followed by
+// the tail of its build log, so the program output can be read in context.
+//
+// Parameters:
+//   - runDir: The commit directory of the build being run
+func (c *runCommand) printBuildContext(runDir string) {
+	c.cmd.Println("=== Build information ===")
+	info, err := targets.ReadBuildInfo(runDir)
+	switch {
+	case err == nil:
+		c.cmd.Printf("Commit:     %s\n", info.Commit)
+		if info.Ref != "" {
+			c.cmd.Printf("Ref:        %s\n", info.Ref)
+		}
+		if info.BuildDate.IsZero() {
+			// Minimal metadata does not record the build time
+			c.printBuildDirTime(runDir)
+		} else {
+			c.cmd.Printf("Build time: %s\n", info.BuildDate.Format(time.RFC3339))
 		}
+		c.cmd.Printf("Status:     %s\n", info.Status)
+	case os.IsNotExist(err):
+		// Builds made without metadata only have the commit directory to go on
+		c.cmd.Printf("Commit:     %s\n", filepath.Base(runDir))
+		c.printBuildDirTime(runDir)
+		c.cmd.Println("Status:     unknown (no build metadata recorded)")
+	default:
+		c.cmd.Printf("Build metadata not available: %v\n", err)
+	}
 
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(filePath, 0755); err != nil {
-				return fmt.Errorf("failed to create directory: %w", err)
-			}
-		case tar.TypeSymlink:
-			if err := extractSymlink(destDir, filePath, header.Linkname); err != nil {
-				return err
-			}
-		case tar.TypeLink:
-			// Hard link: the target is relative to the extraction root.
-			target := filepath.Join(destDir, filepath.Clean(header.Linkname))
-			if !isWithinDir(destDir, target) {
-				return fmt.Errorf("hard link target escapes extraction root: %s -> %s", header.Name, header.Linkname)
-			}
-			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-				return fmt.Errorf("failed to create parent directory: %w", err)
-			}
-			if err := os.Link(target, filePath); err != nil {
-				return fmt.Errorf("failed to create hard link: %w", err)
-			}
-		default:
-			// Make sure parent directory exists
-			if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-				return fmt.Errorf("failed to create parent directory: %w", err)
-			}
-			// Extract file using helper function for proper resource management
-			if err := extractFileFromTar(tarReader, filePath, header.Mode); err != nil {
-				return err
+	if c.logTail > 0 {
+		buildLogPath := filepath.Join(runDir, "logs", "build.log")
+		lines, err := fsutils.TailLines(buildLogPath, c.logTail)
+		if err != nil {
+			c.cmd.Printf("Build log not available: %v\n", err)
+		} else {
+			c.cmd.Printf("--- Last %d lines of %s ---\n", len(lines), buildLogPath)
+			for _, line := range lines {
+				c.cmd.Println(line)
 			}
 		}
 	}
-
-	return nil
+	c.cmd.Println("=== Program output ===")
 }
 
-// isWithinDir reports whether target is contained within root (or equal to it),
-// using path-component-aware comparison rather than a raw string prefix.
-func isWithinDir(root, target string) bool {
-	rel, err := filepath.Rel(filepath.Clean(root), filepath.Clean(target))
-	if err != nil {
-		return false
+// printBuildDirTime prints the modification time of the commit directory as
+// the build time, for builds whose metadata does not record it
+//
+// Parameters:
+//   - runDir: The commit directory of the build being run
+func (c *runCommand) printBuildDirTime(runDir string) {
+	if dirInfo, err := os.Stat(runDir); err == nil {
+		c.cmd.Printf("Build time: %s (from the build directory)\n", dirInfo.ModTime().Format(time.RFC3339))
 	}
-	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
 }
 
-// extractSymlink writes a symlink at linkPath pointing to linkname, rejecting
-// any link whose resolved target would escape the extraction root.
-func extractSymlink(destDir, linkPath, linkname string) error {
-	var resolved string
-	if filepath.IsAbs(linkname) {
-		resolved = filepath.Clean(linkname)
-	} else {
-		resolved = filepath.Clean(filepath.Join(filepath.Dir(linkPath), linkname))
-	}
-	if !isWithinDir(destDir, resolved) {
-		return fmt.Errorf("symlink target escapes extraction root: %s -> %s", linkPath, linkname)
-	}
-
-	if err := os.MkdirAll(filepath.Dir(linkPath), 0755); err != nil {
-		return fmt.Errorf("failed to create parent directory: %w", err)
+// useReplaceProcess reports whether --exec can replace the nigiri process
+// with the program. Capturing output needs nigiri to stay in between, so it
+// falls back to running the program as a child, as do unsupported platforms.
+//
+// Returns:
+//   - bool: True if the process should be replaced
+func (c *runCommand) useReplaceProcess() bool {
+	if !c.execMode {
+		return false
 	}
-	// Remove any pre-existing entry so a stale target cannot be followed.
-	if err := os.Remove(linkPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to replace existing path: %w", err)
+	if !c.replaceSupported {
+		logger.Warnf("--exec is not supported on %s; running the program as a child process", runtime.GOOS)
+		return false
 	}
-	if err := os.Symlink(linkname, linkPath); err != nil {
-		return fmt.Errorf("failed to create symlink: %w", err)
+	if c.capture || c.captureDir != "" {
+		logger.Warnf("--exec cannot be combined with capturing output; running the program as a child process")
+		return false
 	}
-	return nil
+	return true
 }
 
-// extractFileFromTar extracts a single file from the tar reader with proper resource cleanup
-// and size limits to prevent resource exhaustion
-func extractFileFromTar(tarReader *tar.Reader, filePath string, mode int64) error {
-	file, err := os.Create(filePath)
+// captureDirName is the directory in a commit directory that --capture saves
+// the program output to
+const captureDirName = "capture"
+
+// openCaptureFiles creates the files the program output is captured to,
+// truncating the output of a previous run
+//
+// Parameters:
+//   - dir: The directory to create stdout.log and stderr.log in
+//
+// Returns:
+//   - *os.File: The file capturing standard output
+//   - *os.File: The file capturing standard error
+//   - error: Any error encountered while creating the files
+func openCaptureFiles(dir string) (*os.File, *os.File, error) {
+	absDir, err := filepath.Abs(dir)
 	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
+		return nil, nil, err
 	}
-	defer func() {
-		if err := file.Close(); err != nil {
-			logger.Warnf("failed to close file %s: %v", filePath, err)
-		}
-	}()
-
-	// Use LimitReader to prevent extracting extremely large files
-	limitedReader := io.LimitReader(tarReader, maxFileSizeForExtract)
-	if _, err := io.Copy(file, limitedReader); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
+	if err := os.MkdirAll(absDir, 0755); err != nil {
+		return nil, nil, err
 	}
-
-	// Set file permissions
-	if err := os.Chmod(filePath, os.FileMode(mode)); err != nil {
-		return fmt.Errorf("failed to set file permissions: %w", err)
+	stdoutFile, err := os.Create(filepath.Join(absDir, "stdout.log"))
+	if err != nil {
+		return nil, nil, err
 	}
-
-	return nil
+	stderrFile, err := os.Create(filepath.Join(absDir, "stderr.log"))
+	if err != nil {
+		_ = stdoutFile.Close()
+		return nil, nil, err
+	}
+	return stdoutFile, stderrFile, nil
 }
@@ -0,0 +1,76 @@
+//go:build unix
+
+package commands
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunExecReplacesProcess(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	useTestConfig(t, testRunConfig)
+	commitDir := createTestCommitDir(t, root, "tool", "abc1234", "exit 0")
+
+	tests := []struct {
+		name        string
+		args        []string
+		wantReplace bool
+	}{
+		{name: "exec replaces the process", args: []string{"--exec"}, wantReplace: true},
+		{name: "without exec", args: nil},
+		{name: "capture needs a child process", args: []string{"--exec", "--capture"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, exec.ReplaceSupported)
+
+			fake := &exec.Fake{}
+			var replaced [][]string
+			c := newRunCommand()
+			c.runner = fake
+			c.replaceProcess = func(argv []string, opts exec.Options) error {
+				replaced = append(replaced, argv)
+				assert.Equal(t, commitDir, opts.Dir)
+				return nil
+			}
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs(append(tt.args, "tool", "abc1234", "arg"))
+			require.NoError(t, c.cmd.Execute())
+
+			want := []string{filepath.Join(commitDir, "bin"), "arg"}
+			if tt.wantReplace {
+				assert.Equal(t, [][]string{want}, replaced)
+				assert.Empty(t, fake.Calls())
+			} else {
+				assert.Empty(t, replaced)
+				require.Len(t, fake.Calls(), 1)
+				assert.Equal(t, want, fake.Calls()[0].Argv)
+			}
+		})
+	}
+}
+
+func TestRunExecFallsBackWhenUnsupported(t *testing.T) {
+	root := useTestNigiriRoot(t)
+	useTestConfig(t, testRunConfig)
+	createTestCommitDir(t, root, "tool", "abc1234", "exit 0")
+
+	fake := &exec.Fake{}
+	c := newRunCommand()
+	c.runner = fake
+	c.replaceSupported = false
+	c.replaceProcess = func([]string, exec.Options) error {
+		t.Fatal("process must not be replaced when unsupported")
+		return nil
+	}
+	c.cmd.SetOut(&bytes.Buffer{})
+	c.cmd.SetArgs([]string{"--exec", "tool", "abc1234"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Len(t, fake.Calls(), 1)
+}
@@ -0,0 +1,250 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/commits"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
+	"github.com/spf13/cobra"
+)
+
+// upstreamResolver returns the commit hash at the HEAD of a target's default branch
+type upstreamResolver func(targetCfg internalconfig.Target) (string, error)
+
+// targetDrift is the state of a target's builds compared to its upstream
+type targetDrift struct {
+	// latest is the short hash of the most recent build (empty if never built)
+	latest string
+	// upstream is the short hash of the upstream HEAD (empty if it could not be resolved)
+	upstream string
+	// err is the error encountered while resolving the upstream HEAD
+	err error
+}
+
+// behind reports whether the target has builds but none of the upstream HEAD
+func (d targetDrift) behind(targetRootDir string) bool {
+	if d.err != nil || d.latest == "" {
+		return false
+	}
+	return !targets.IsExistTargetCommitDir(targetRootDir, commits.Commit{ShortHash: d.upstream})
+}
+
+// statusCommand represents the structure for the status command
+type statusCommand struct {
+	cmd *cobra.Command
+	// runner runs the builds triggered by --fix-drift
+	runner exec.Runner
+	// resolve looks up the upstream HEAD of a target
+	resolve upstreamResolver
+	// fixDrift rebuilds targets that are behind upstream
+	fixDrift    bool
+	dryRun      bool
+	skipConfirm bool
+	// useToken enables GitHub token authentication
+	useToken bool
+	// tokens resolves the GitHub token once for every target
+	tokens *vcsutils.TokenCache
+}
+
+// newStatusCommand creates a new status command instance which compares the
+// latest build of each configured target with the HEAD of its upstream
+// default branch, and optionally rebuilds the targets that are behind.
+//
+// Returns:
+//   - *statusCommand: A configured status command instance
+func newStatusCommand() *statusCommand {
+	c := &statusCommand{runner: exec.NewOSRunner(), tokens: &vcsutils.TokenCache{}}
+	c.resolve = remoteHeadResolver(c.tokens)
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show which targets are behind upstream",
+		Long: `Compare the latest build of each configured target with the HEAD of its
+upstream default branch (requires network access).
+With --fix-drift, every target that is behind is rebuilt at the new HEAD.
+Targets that have never been built are reported but not built.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return c.executeStatus()
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVar(&c.fixDrift, "fix-drift", false, "Rebuild every target that is behind upstream at the new HEAD")
+	flags.BoolVarP(&c.dryRun, "dry-run", "d", false, "With --fix-drift, show what would be rebuilt without building anything")
+	flags.BoolVarP(&c.skipConfirm, "yes", "y", false, "Skip confirmation prompt")
+	flags.BoolVarP(&c.useToken, "use-token", "t", false, "Use GitHub token for authentication (required for private repositories)")
+
+	c.cmd = cmd
+	return c
+}
+
+// remoteHeadResolver returns an upstreamResolver that resolves the upstream
+// HEAD of a target the same way the build command does when no commit is given
+//
+// Parameters:
+//   - tokens: The GitHub token cache shared by every target
+//
+// Returns:
+//   - upstreamResolver: The resolver querying the remote repository
+func remoteHeadResolver(tokens *vcsutils.TokenCache) upstreamResolver {
+	return func(targetCfg internalconfig.Target) (string, error) {
+		git := vcsutils.Git{Source: targetCfg.Sources, Tokens: tokens}
+		defaultBranch := targetCfg.DefaultBranch
+		if defaultBranch == "" {
+			detected, err := git.DetectDefaultBranch()
+			if err != nil {
+				return "", logger.CreateErrorf("failed to detect default branch: %w", err)
+			}
+			defaultBranch = detected
+		}
+		if err := git.GetDefaultBranchRemoteHead(defaultBranch); err != nil {
+			return "", logger.CreateErrorf("failed to get HEAD of branch '%s': %w", defaultBranch, err)
+		}
+		return git.HEAD, nil
+	}
+}
+
+// executeStatus reports the drift of every configured target and, with
+// --fix-drift, rebuilds the targets that are behind
+//
+// Returns:
+//   - error: Any error encountered loading the configuration or rebuilding
+func (c *statusCommand) executeStatus() error {
+	cm := newConfigManager()
+	if err := cm.LoadCfgFile(); err != nil {
+		return logger.CreateErrorf("failed to load config: %w", err)
+	}
+	if len(cm.Config.Targets) == 0 {
+		c.cmd.Println("No targets configured.")
+		return nil
+	}
+
+	names := make([]string, 0, len(cm.Config.Targets))
+	for name := range cm.Config.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var stale []string
+	for _, name := range names {
+		drift := checkDrift(name, cm.Config.Targets[name], c.resolve)
+		targetRootDir := filepath.Join(nigiriRoot, name)
+		switch {
+		case drift.err != nil:
+			c.cmd.Printf("%s: failed to check upstream: %v\n", name, drift.err)
+		case drift.latest == "":
+			c.cmd.Printf("%s: not built (upstream %s)\n", name, drift.upstream)
+		case drift.behind(targetRootDir):
+			c.cmd.Printf("%s: behind upstream (latest build %s, upstream %s)\n", name, drift.latest, drift.upstream)
+			stale = append(stale, name)
+		default:
+			c.cmd.Printf("%s: up to date (%s)\n", name, drift.upstream)
+		}
+	}
+
+	if !c.fixDrift {
+		return nil
+	}
+	if len(stale) == 0 {
+		c.cmd.Println("\nAll built targets are up to date.")
+		return nil
+	}
+	if c.dryRun {
+		c.cmd.Printf("\nWould rebuild %d targets: %s\n", len(stale), strings.Join(stale, ", "))
+		c.cmd.Println("\nDry run: Nothing was built.")
+		return nil
+	}
+	if !c.skipConfirm {
+		ok, err := confirm(c.cmd, "\nRebuild "+strings.Join(stale, ", ")+" at the upstream HEAD?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			c.cmd.Println("Rebuild cancelled.")
+			return nil
+		}
+	}
+
+	var failed []string
+	for _, name := range stale {
+		c.cmd.Printf("\nRebuilding target '%s'...\n", name)
+		b := newBuildCommand()
+		b.runner = c.runner
+		b.useToken = c.useToken
+		b.tokens = c.tokens
+		b.cmd.SetOut(c.cmd.OutOrStdout())
+		if err := b.executeBuild(name); err != nil {
+			c.cmd.Printf("Failed to rebuild target '%s': %v\n", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		c.cmd.Printf("Rebuilt target '%s'\n", name)
+	}
+	if len(failed) > 0 {
+		return logger.CreateErrorf("failed to rebuild %d of %d targets: %s", len(failed), len(stale), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// checkDrift compares the latest build of a target with its upstream HEAD
+//
+// Parameters:
+//   - name: The name of the target
+//   - targetCfg: The configuration of the target
+//   - resolve: Looks up the upstream HEAD of the target
+//
+// Returns:
+//   - targetDrift: The latest build and the upstream HEAD of the target
+func checkDrift(name string, targetCfg internalconfig.Target, resolve upstreamResolver) targetDrift {
+	drift := targetDrift{latest: latestBuild(filepath.Join(nigiriRoot, name))}
+	hash, err := resolve(targetCfg)
+	if err != nil {
+		drift.err = err
+		return drift
+	}
+	upstream := commits.Commit{Hash: hash}
+	if err := upstream.CalculateShortHashLength(targetCfg.ShortHashLength()); err != nil {
+		drift.err = err
+		return drift
+	}
+	drift.upstream = uniqueShortHash(filepath.Join(nigiriRoot, name), upstream, "")
+	return drift
+}
+
+// latestBuild returns the name of the most recently modified build directory
+// of a target, the build that run uses when no commit is given
+//
+// Parameters:
+//   - targetRootDir: The root directory of the target
+//
+// Returns:
+//   - string: The short hash of the latest build (empty if there are none)
+func latestBuild(targetRootDir string) string {
+	entries, err := os.ReadDir(targetRootDir)
+	if err != nil {
+		return ""
+	}
+	var latest string
+	var latestInfo os.FileInfo
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if latestInfo == nil || info.ModTime().After(latestInfo.ModTime()) {
+			latestInfo = info
+			latest = entry.Name()
+		}
+	}
+	return latest
+}
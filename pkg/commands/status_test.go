@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStatusCommand returns a status command whose upstream HEAD is hash
+// for every target and whose builds run on fake
+func newTestStatusCommand(hash string, fake *exec.Fake) (*statusCommand, *bytes.Buffer) {
+	c := newStatusCommand()
+	c.runner = fake
+	c.resolve = func(internalconfig.Target) (string, error) { return hash, nil }
+	var out bytes.Buffer
+	c.cmd.SetOut(&out)
+	return c, &out
+}
+
+func TestStatusFixDrift(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	content := "targets:\n"
+	for _, name := range []string{"fresh", "stale", "unbuilt"} {
+		content += fmt.Sprintf(`  %s:
+    source: %s
+    default-branch: master
+    build-command:
+      linux: make
+      darwin: make
+`, name, repoDir)
+	}
+	useTestConfig(t, content)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "fresh", hash[:7]), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "stale", "1111111"), 0755))
+
+	fake := &exec.Fake{}
+	c, out := newTestStatusCommand(hash, fake)
+	c.cmd.SetArgs([]string{"--fix-drift", "--dry-run"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "fresh: up to date ("+hash[:7]+")")
+	assert.Contains(t, out.String(), "stale: behind upstream (latest build 1111111, upstream "+hash[:7]+")")
+	assert.Contains(t, out.String(), "unbuilt: not built (upstream "+hash[:7]+")")
+	assert.Contains(t, out.String(), "Would rebuild 1 targets: stale")
+	assert.Empty(t, fake.Calls())
+	assert.NoDirExists(t, filepath.Join(root, "stale", hash[:7]))
+
+	c, out = newTestStatusCommand(hash, fake)
+	c.cmd.SetArgs([]string{"--fix-drift", "--yes"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "Rebuilt target 'stale'")
+	require.Len(t, fake.Calls(), 1)
+	assert.Contains(t, fake.Calls()[0].Opts.Dir, filepath.Join(root, "stale", hash[:7]))
+	assert.DirExists(t, filepath.Join(root, "stale", hash[:7]))
+	assert.NoDirExists(t, filepath.Join(root, "unbuilt"))
+
+	// Once rebuilt, nothing is behind
+	c, out = newTestStatusCommand(hash, fake)
+	c.cmd.SetArgs([]string{"--fix-drift", "--yes"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "stale: up to date ("+hash[:7]+")")
+	assert.Contains(t, out.String(), "All built targets are up to date.")
+	assert.Len(t, fake.Calls(), 1)
+}
+
+func TestStatusReportsResolveErrors(t *testing.T) {
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, "https://example.com/repo.git", "make", "")
+
+	c := newStatusCommand()
+	c.resolve = func(internalconfig.Target) (string, error) { return "", fmt.Errorf("network unreachable") }
+	var out bytes.Buffer
+	c.cmd.SetOut(&out)
+	c.cmd.SetArgs([]string{})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "tool: failed to check upstream: network unreachable")
+}
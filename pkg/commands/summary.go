@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ANSI escape sequences used to color the statuses of a build summary
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+// statusColors maps the statuses of a build summary to their color
+var statusColors = map[string]string{
+	BuildResultSuccess:  colorGreen,
+	BuildResultFailed:   colorRed,
+	BuildResultUpToDate: colorYellow,
+}
+
+// renderBuildSummary writes the results of a multi-target build as a table
+// with a row per target, followed by the errors of the failed builds
+//
+// Parameters:
+//   - w: The writer the summary is written to
+//   - results: The results of the builds, in the order they are listed
+//   - color: Whether the statuses are colored
+func renderBuildSummary(w io.Writer, results []BuildResult, color bool) {
+	rows := [][]string{{"TARGET", "STATUS", "COMMIT", "DURATION", "SIZE"}}
+	for _, result := range results {
+		commit, size := result.Commit, "-"
+		if commit == "" {
+			commit = "-"
+		}
+		if result.Size > 0 {
+			size = fmt.Sprintf("%.2f MB", float64(result.Size)/(1024*1024))
+		}
+		rows = append(rows, []string{result.Target, result.Status, commit, result.Duration.Round(time.Millisecond).String(), size})
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	for r, row := range rows {
+		var line strings.Builder
+		line.WriteString("  ")
+		for i, cell := range row {
+			padded := cell
+			if i < len(row)-1 {
+				padded += strings.Repeat(" ", widths[i]-len(cell)+2)
+			}
+			// Only the status text is colored, so the padding stays aligned
+			if code, ok := statusColors[cell]; color && ok && i == 1 && r > 0 {
+				padded = code + cell + colorReset + padded[len(cell):]
+			}
+			line.WriteString(padded)
+		}
+		fmt.Fprintln(w, line.String())
+	}
+
+	for _, result := range results {
+		if result.Status == BuildResultFailed {
+			fmt.Fprintf(w, "  %s: %s\n", result.Target, result.Error)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package commands
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderBuildSummary(t *testing.T) {
+	results := []BuildResult{
+		{Target: "tool", Status: BuildResultSuccess, Commit: "abc1234", Duration: 1500 * time.Millisecond, Size: 3 * 1024 * 1024},
+		{Target: "long-target", Status: BuildResultFailed, Duration: 20 * time.Millisecond, Error: "failed to clone repository"},
+		{Target: "other", Status: BuildResultUpToDate, Commit: "def5678", Duration: 5 * time.Millisecond},
+	}
+
+	var out bytes.Buffer
+	renderBuildSummary(&out, results, false)
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, "  TARGET       STATUS      COMMIT   DURATION  SIZE", lines[0])
+	assert.Equal(t, "  tool         success     abc1234  1.5s      3.00 MB", lines[1])
+	assert.Equal(t, "  long-target  failed      -        20ms      -", lines[2])
+	assert.Equal(t, "  other        up-to-date  def5678  5ms       -", lines[3])
+	assert.Equal(t, "  long-target: failed to clone repository", lines[4])
+	assert.NotContains(t, out.String(), "\033[")
+
+	out.Reset()
+	renderBuildSummary(&out, results, true)
+	lines = strings.Split(out.String(), "\n")
+	assert.Equal(t, "  tool         "+colorGreen+"success"+colorReset+"     abc1234  1.5s      3.00 MB", lines[1])
+	assert.Contains(t, lines[2], colorRed+"failed"+colorReset)
+	assert.Contains(t, lines[3], colorYellow+"up-to-date"+colorReset)
+	assert.NotContains(t, lines[0], "\033[")
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
 )
 
 // writeTarGz builds a tar.gz archive at path from the provided entries. A nil
@@ -52,8 +54,8 @@ func TestCompressExtract_RoundTripPreservesSymlink(t *testing.T) {
 	}
 
 	dstDir := t.TempDir()
-	if err := extractTarGz(archive, dstDir); err != nil {
-		t.Fatalf("extractTarGz: %v", err)
+	if err := targets.ExtractTarGz(archive, dstDir); err != nil {
+		t.Fatalf("ExtractTarGz: %v", err)
 	}
 
 	// The extracted link must still be a symlink pointing at the original target.
@@ -139,7 +141,7 @@ func TestExtractTarGz_MaliciousEntries(t *testing.T) {
 			writeTarGz(t, archive, []*tar.Header{tt.header}, bodies)
 
 			dstDir := t.TempDir()
-			err := extractTarGz(archive, dstDir)
+			err := targets.ExtractTarGz(archive, dstDir)
 			if tt.wantErr && err == nil {
 				t.Fatalf("expected error, got nil")
 			}
@@ -149,17 +151,3 @@ func TestExtractTarGz_MaliciousEntries(t *testing.T) {
 		})
 	}
 }
-
-// TestExtractTarGz_PrefixSiblingNotEscaped guards against the separator-unsafe
-// prefix check: a destination like ".../root" must not be considered to contain
-// a sibling like ".../root-evil".
-func TestIsWithinDir_PrefixSibling(t *testing.T) {
-	root := filepath.Join("tmp", "root")
-	sibling := filepath.Join("tmp", "root-evil", "x")
-	if isWithinDir(root, sibling) {
-		t.Errorf("isWithinDir(%q, %q) = true, want false", root, sibling)
-	}
-	if !isWithinDir(root, filepath.Join(root, "sub", "file")) {
-		t.Errorf("isWithinDir did not contain a genuine child path")
-	}
-}
@@ -0,0 +1,166 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+	"github.com/oota-sushikuitee/nigiri/pkg/vcsutils"
+	"github.com/spf13/cobra"
+)
+
+// updateCommand represents the structure for the update command
+type updateCommand struct {
+	cmd *cobra.Command
+	// runner runs the builds triggered by the update
+	runner exec.Runner
+	// resolve looks up the upstream HEAD of a target
+	resolve upstreamResolver
+	// all updates every configured target that has been built
+	all bool
+	// check only reports whether an update is available
+	check bool
+	// useToken enables GitHub token authentication
+	useToken bool
+	// tokens resolves the GitHub token once for every target
+	tokens *vcsutils.TokenCache
+}
+
+// newUpdateCommand creates a new update command instance which rebuilds
+// targets at the HEAD of their upstream default branch when it has changed.
+//
+// Returns:
+//   - *updateCommand: A configured update command instance
+func newUpdateCommand() *updateCommand {
+	c := &updateCommand{runner: exec.NewOSRunner(), tokens: &vcsutils.TokenCache{}}
+	c.resolve = remoteHeadResolver(c.tokens)
+	cmd := &cobra.Command{
+		Use:   "update [target]",
+		Short: "Rebuild a target at the newest upstream HEAD",
+		Long: `Look up the HEAD of the target's upstream default branch (requires network access)
+and build it unless a build of that commit already exists.
+With --all, every configured target that has been built before is updated.
+With --check, only report whether an update is available.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if c.all == (len(args) == 1) {
+				return fmt.Errorf("specify either a target or --all")
+			}
+			var target string
+			if len(args) == 1 {
+				target = args[0]
+			}
+			return c.executeUpdate(target)
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 0 {
+				return getConfiguredTargets(toComplete), cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	flags := cmd.Flags()
+	flags.BoolVarP(&c.all, "all", "A", false, "Update every configured target that has been built")
+	flags.BoolVar(&c.check, "check", false, "Report whether an update is available without building")
+	flags.BoolVarP(&c.useToken, "use-token", "t", false, "Use GitHub token for authentication (required for private repositories)")
+
+	c.cmd = cmd
+	return c
+}
+
+// executeUpdate updates a single target, or every built target when target
+// is empty
+//
+// Parameters:
+//   - target: The name of the target to update (empty with --all)
+//
+// Returns:
+//   - error: Any error encountered loading the configuration, checking or building
+func (c *updateCommand) executeUpdate(target string) error {
+	cm := newConfigManager()
+	if err := cm.LoadCfgFile(); err != nil {
+		return logger.CreateErrorf("failed to load config: %w", err)
+	}
+
+	if target != "" {
+		targetCfg, exists := cm.Config.Targets[target]
+		if !exists {
+			return logger.CreateErrorf("target '%s' not found in configuration", target)
+		}
+		return c.updateTarget(target, targetCfg, true)
+	}
+
+	if len(cm.Config.Targets) == 0 {
+		c.cmd.Println("No targets configured.")
+		return nil
+	}
+	names := make([]string, 0, len(cm.Config.Targets))
+	for name := range cm.Config.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var failed []string
+	for _, name := range names {
+		if err := c.updateTarget(name, cm.Config.Targets[name], false); err != nil {
+			c.cmd.Printf("%s: %v\n", name, err)
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		return logger.CreateErrorf("failed to update %d of %d targets: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// updateTarget builds a target at its upstream HEAD unless that commit has
+// already been built
+//
+// Parameters:
+//   - name: The name of the target
+//   - targetCfg: The configuration of the target
+//   - buildNew: Whether to build a target that has never been built
+//
+// Returns:
+//   - error: Any error encountered resolving the upstream HEAD or building
+func (c *updateCommand) updateTarget(name string, targetCfg internalconfig.Target, buildNew bool) error {
+	drift := checkDrift(name, targetCfg, c.resolve)
+	if drift.err != nil {
+		return logger.CreateErrorf("failed to check upstream: %w", drift.err)
+	}
+
+	switch {
+	case drift.latest == "" && !buildNew:
+		c.cmd.Printf("%s: not built, skipping (run 'nigiri build %s' first)\n", name, name)
+		return nil
+	case drift.latest != "" && !drift.behind(filepath.Join(nigiriRoot, name)):
+		c.cmd.Printf("%s: already up to date (%s)\n", name, drift.upstream)
+		return nil
+	}
+
+	if c.check {
+		latest := "not built"
+		if drift.latest != "" {
+			latest = "latest build " + drift.latest
+		}
+		c.cmd.Printf("%s: update available (%s, upstream %s)\n", name, latest, drift.upstream)
+		return nil
+	}
+
+	c.cmd.Printf("Updating target '%s' to %s...\n", name, drift.upstream)
+	b := newBuildCommand()
+	b.runner = c.runner
+	b.useToken = c.useToken
+	b.tokens = c.tokens
+	b.cmd.SetOut(c.cmd.OutOrStdout())
+	if err := b.executeBuild(name); err != nil {
+		return logger.CreateErrorf("failed to build: %w", err)
+	}
+	c.cmd.Printf("Updated target '%s' to %s\n", name, drift.upstream)
+	return nil
+}
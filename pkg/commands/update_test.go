@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oota-sushikuitee/nigiri/internal/exec"
+	internalconfig "github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestUpdateCommand returns an update command whose upstream HEAD is hash
+// for every target and whose builds run on fake
+func newTestUpdateCommand(hash string, fake *exec.Fake) (*updateCommand, *bytes.Buffer) {
+	c := newUpdateCommand()
+	c.runner = fake
+	c.resolve = func(internalconfig.Target) (string, error) { return hash, nil }
+	var out bytes.Buffer
+	c.cmd.SetOut(&out)
+	c.cmd.SetErr(&out)
+	return c, &out
+}
+
+func TestUpdate(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	useTestBuildConfig(t, repoDir, "make", "")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "tool", "1111111"), 0755))
+
+	fake := &exec.Fake{}
+	c, out := newTestUpdateCommand(hash, fake)
+	c.cmd.SetArgs([]string{"tool", "--check"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "tool: update available (latest build 1111111, upstream "+hash[:7]+")")
+	assert.Empty(t, fake.Calls())
+
+	c, out = newTestUpdateCommand(hash, fake)
+	c.cmd.SetArgs([]string{"tool"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "Updated target 'tool' to "+hash[:7])
+	require.Len(t, fake.Calls(), 1)
+	assert.DirExists(t, filepath.Join(root, "tool", hash[:7]))
+
+	c, out = newTestUpdateCommand(hash, fake)
+	c.cmd.SetArgs([]string{"tool"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "tool: already up to date ("+hash[:7]+")")
+	assert.Len(t, fake.Calls(), 1)
+}
+
+func TestUpdateAll(t *testing.T) {
+	repoDir, hash := createTestSourceRepo(t)
+	root := useTestNigiriRoot(t)
+	content := "targets:\n"
+	for _, name := range []string{"fresh", "stale", "unbuilt"} {
+		content += fmt.Sprintf(`  %s:
+    source: %s
+    default-branch: master
+    build-command:
+      linux: make
+      darwin: make
+`, name, repoDir)
+	}
+	useTestConfig(t, content)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "fresh", hash[:7]), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "stale", "1111111"), 0755))
+
+	fake := &exec.Fake{}
+	c, out := newTestUpdateCommand(hash, fake)
+	c.cmd.SetArgs([]string{"--all"})
+	require.NoError(t, c.cmd.Execute())
+	assert.Contains(t, out.String(), "fresh: already up to date ("+hash[:7]+")")
+	assert.Contains(t, out.String(), "Updated target 'stale' to "+hash[:7])
+	assert.Contains(t, out.String(), "unbuilt: not built, skipping")
+	require.Len(t, fake.Calls(), 1)
+	assert.NoDirExists(t, filepath.Join(root, "unbuilt"))
+}
+
+func TestUpdateArgs(t *testing.T) {
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, "https://example.com/repo.git", "make", "")
+
+	for _, args := range [][]string{{}, {"tool", "--all"}} {
+		c, _ := newTestUpdateCommand("", &exec.Fake{})
+		c.cmd.SetArgs(args)
+		assert.EqualError(t, c.cmd.Execute(), "specify either a target or --all")
+	}
+
+	c, _ := newTestUpdateCommand("", &exec.Fake{})
+	c.resolve = func(internalconfig.Target) (string, error) { return "", fmt.Errorf("network unreachable") }
+	c.cmd.SetArgs([]string{"tool"})
+	assert.ErrorContains(t, c.cmd.Execute(), "network unreachable")
+}
@@ -0,0 +1,289 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+)
+
+// watchDebounce is how long the source must stay unchanged before --watch
+// rebuilds, so that a burst of changes such as a commit triggers one build
+const watchDebounce = 500 * time.Millisecond
+
+// localSourceDir returns the directory of a target source that is a local
+// path or a file:// URL
+//
+// Parameters:
+//   - source: The source of the target
+//
+// Returns:
+//   - string: The absolute path of the source directory
+//   - bool: True if the source is an existing local directory, false otherwise
+func localSourceDir(source string) (string, bool) {
+	path := strings.TrimPrefix(source, "file://")
+	if path == "" {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return "", false
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", false
+	}
+	return abs, true
+}
+
+// sourceWatcher reports changes anywhere below a source directory
+type sourceWatcher struct {
+	watcher *fsnotify.Watcher
+}
+
+// newSourceWatcher starts watching dir and every directory below it
+//
+// Parameters:
+//   - dir: The source directory to watch
+//
+// Returns:
+//   - *sourceWatcher: The watcher, which must be closed by the caller
+//   - error: Any error encountered while setting up the watches
+func newSourceWatcher(dir string) (*sourceWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &sourceWatcher{watcher: watcher}
+	if err := w.addTree(dir); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// addTree watches dir and every directory below it, since changes are only
+// reported for the directories watched directly
+//
+// Parameters:
+//   - dir: The root of the directory tree to watch
+//
+// Returns:
+//   - error: Any error encountered while walking the tree or adding a watch
+func (w *sourceWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		// The repository metadata changes with every git command, and is
+		// large enough to use up the watches of real repositories
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		return w.watcher.Add(path)
+	})
+}
+
+// inGitDir reports whether path is the .git directory of a repository or
+// lies within it
+//
+// Parameters:
+//   - path: The path to check
+//
+// Returns:
+//   - bool: True if a component of path is .git, false otherwise
+func inGitDir(path string) bool {
+	return slices.Contains(strings.Split(filepath.ToSlash(path), "/"), ".git")
+}
+
+// copyWorkingTree replaces the checkout in cloneDir with the working tree of
+// the local repository in sourceDir, so that uncommitted changes are built.
+// The .git directories are left as they are.
+//
+// Parameters:
+//   - sourceDir: The local repository
+//   - cloneDir: The checkout to replace
+//
+// Returns:
+//   - error: Any error encountered while removing or copying files
+func copyWorkingTree(sourceDir, cloneDir string) error {
+	checkout, err := os.ReadDir(cloneDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range checkout {
+		if entry.Name() == ".git" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(cloneDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	working, err := os.ReadDir(sourceDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range working {
+		if entry.Name() == ".git" {
+			continue
+		}
+		src, dst := filepath.Join(sourceDir, entry.Name()), filepath.Join(cloneDir, entry.Name())
+		switch {
+		case entry.Type()&os.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(src)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink: %w", err)
+			}
+			if err := os.Symlink(linkTarget, dst); err != nil {
+				return err
+			}
+		case entry.IsDir():
+			if err := copyDir(src, dst); err != nil {
+				return err
+			}
+		default:
+			if err := copyFile(src, dst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops watching the source directory
+func (w *sourceWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+// run calls onChange once the source has stayed unchanged for debounce after
+// a change, until ctx is done
+//
+// Parameters:
+//   - ctx: The context whose cancellation stops watching
+//   - debounce: How long the source must be quiet before onChange is called
+//   - onChange: The function called for each settled burst of changes
+//
+// Returns:
+//   - error: Any error that stops the watcher from reporting changes
+func (w *sourceWatcher) run(ctx context.Context, debounce time.Duration, onChange func()) error {
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return nil
+			}
+			if inGitDir(event.Name) {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				// New directories have to be watched to see changes within them
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := w.addTree(event.Name); err != nil {
+						logger.Warnf("Failed to watch %s: %v", event.Name, err)
+					}
+				}
+			}
+			timer.Reset(debounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Warnf("Error watching source: %v", err)
+		case <-timer.C:
+			onChange()
+		}
+	}
+}
+
+// executeWatch builds a target with a local source and rebuilds it whenever
+// the source directory changes, until interrupted
+//
+// Parameters:
+//   - target: The name of the target to build
+//
+// Returns:
+//   - error: Any error encountered before watching starts
+func (c *buildCommand) executeWatch(target string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return c.watchTarget(ctx, target)
+}
+
+// watchTarget builds a target with a local source from its working tree, and
+// rebuilds it whenever the working tree changes until ctx is done. Every
+// build replaces the build of the checked out commit, since the working tree
+// may hold uncommitted changes. Unless --prune-after is given, only the
+// latest build is kept.
+//
+// Parameters:
+//   - ctx: The context whose cancellation stops watching
+//   - target: The name of the target to build
+//
+// Returns:
+//   - error: Any error encountered before watching starts
+func (c *buildCommand) watchTarget(ctx context.Context, target string) error {
+	cm, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	if c.stdinConfig {
+		// Stdin can only be read once, so every rebuild uses this configuration
+		c.loadedConfig = cm
+	}
+	targetCfg, exists := cm.Config.Targets[target]
+	if !exists {
+		return logger.CreateErrorf("target '%s' not found in configuration", target)
+	}
+	sourceDir, ok := localSourceDir(targetCfg.Sources)
+	if !ok {
+		return logger.CreateErrorf("--watch requires a target whose source is a local directory, but the source of '%s' is %s", target, targetCfg.Sources)
+	}
+	if !c.cmd.Flags().Changed("prune-after") {
+		c.pruneAfter = 1
+	}
+	c.workingTree = sourceDir
+	c.forceBuild = true
+
+	// Changes made during the first build are picked up by the watch
+	watcher, err := newSourceWatcher(sourceDir)
+	if err != nil {
+		return logger.CreateErrorf("failed to watch %s: %w", sourceDir, err)
+	}
+	defer func() {
+		if err := watcher.Close(); err != nil {
+			logger.Warnf("Failed to stop watching %s: %v", sourceDir, err)
+		}
+	}()
+
+	rebuild := func() {
+		if err := c.executeBuild(target); err != nil {
+			c.cmd.Printf("Build of target '%s' failed: %v\n", target, err)
+		}
+		c.cmd.Printf("Watching %s for changes (press Ctrl-C to stop)...\n", sourceDir)
+	}
+	rebuild()
+	if err := watcher.run(ctx, watchDebounce, rebuild); err != nil {
+		return logger.CreateErrorf("failed to watch %s: %w", sourceDir, err)
+	}
+	c.cmd.Println("Stopped watching")
+	return nil
+}
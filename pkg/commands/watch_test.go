@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalSourceDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+
+	tests := []struct {
+		name    string
+		source  string
+		wantDir string
+		wantOK  bool
+	}{
+		{name: "local path", source: dir, wantDir: dir, wantOK: true},
+		{name: "file URL", source: "file://" + dir, wantDir: dir, wantOK: true},
+		{name: "remote URL", source: "https://github.com/octocat/Hello-World"},
+		{name: "SSH source", source: "git@github.com:octocat/Hello-World.git"},
+		{name: "file", source: file},
+		{name: "missing directory", source: filepath.Join(dir, "missing")},
+		{name: "empty", source: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := localSourceDir(tt.source)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantDir, got)
+		})
+	}
+}
+
+func TestSourceWatcherDebounces(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "cmd"), 0755))
+	w, err := newSourceWatcher(dir)
+	require.NoError(t, err)
+	defer func() { _ = w.Close() }()
+
+	const debounce = 200 * time.Millisecond
+	var changes atomic.Int32
+	changed := make(chan struct{}, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- w.run(ctx, debounce, func() {
+			changes.Add(1)
+			changed <- struct{}{}
+		})
+	}()
+
+	// A burst of changes, including one in a new directory, is one rebuild
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cmd", "app.go"), []byte("package cmd\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "internal"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rebuild after the source changed")
+	}
+	time.Sleep(3 * debounce)
+	assert.Equal(t, int32(1), changes.Load())
+
+	// Directories created while watching are watched too
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "internal", "lib.go"), []byte("package internal\n"), 0644))
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rebuild after a file in a new directory changed")
+	}
+	assert.Equal(t, int32(2), changes.Load())
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher did not stop when its context was cancelled")
+	}
+}
+
+func TestBuildWatchRequiresLocalSource(t *testing.T) {
+	useTestNigiriRoot(t)
+	useTestBuildConfig(t, "https://github.com/octocat/Hello-World", "make", "")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "remote source", args: []string{"tool", "--watch"}, wantErr: "--watch requires a target whose source is a local directory"},
+		{name: "commit", args: []string{"tool", "abc1234", "--watch"}, wantErr: "--watch builds a single target"},
+		{name: "tag", args: []string{"tool", "--watch", "--tag", "v1.0"}, wantErr: "--watch cannot be combined with --from-pr, --tag or --print-plan"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newBuildCommand()
+			c.cmd.SetOut(&bytes.Buffer{})
+			c.cmd.SetArgs(tt.args)
+			err := c.cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestBuildWatchBuildsWorkingTree(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the build command uses a Unix shell")
+	}
+	root := useTestNigiriRoot(t)
+	repoDir, hash := createTestSourceRepo(t)
+	useTestBuildConfig(t, repoDir, "mkdir -p bin && cp README bin/app", "")
+	binary := filepath.Join(root, "tool", hash[:7], "bin")
+	waitForBinary := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if content, err := os.ReadFile(binary); err == nil && string(content) == want {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		content, _ := os.ReadFile(binary)
+		t.Fatalf("binary = %q, want %q", content, want)
+	}
+
+	c := newBuildCommand()
+	c.cmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, c.cmd.ParseFlags([]string{"--watch"}))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.watchTarget(ctx, "tool") }()
+
+	waitForBinary("test\n")
+	// An uncommitted edit is built
+	require.NoError(t, os.WriteFile(filepath.Join(repoDir, "README"), []byte("edited\n"), 0644))
+	waitForBinary("edited\n")
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("watch did not stop when its context was cancelled")
+	}
+}
+
+func TestSourceWatcherSkipsGitDir(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, ".git", "objects"), 0755))
+	w, err := newSourceWatcher(dir)
+	require.NoError(t, err)
+	defer func() { _ = w.Close() }()
+	assert.NotContains(t, w.watcher.WatchList(), filepath.Join(dir, ".git"))
+	assert.NotContains(t, w.watcher.WatchList(), filepath.Join(dir, ".git", "objects"))
+	assert.Contains(t, w.watcher.WatchList(), dir)
+
+	changed := make(chan struct{}, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = w.run(ctx, 50*time.Millisecond, func() { changed <- struct{}{} }) }()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "index"), nil, 0644))
+	select {
+	case <-changed:
+		t.Fatal("a change in .git triggered a rebuild")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
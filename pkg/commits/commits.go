@@ -1,6 +1,9 @@
 package commits
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Commit represents a git commit with its hash and short hash
 //
@@ -22,34 +25,148 @@ type Commits struct {
 	Commits []Commit
 }
 
-// Validate checks if the commit has valid hash and short hash values
+// Lengths of commit hashes
+const (
+	// MinHashLength is the length of the shortest accepted (abbreviated) hash
+	MinHashLength = 7
+	// SHA1HashLength is the length of a full SHA-1 commit hash
+	SHA1HashLength = 40
+	// SHA256HashLength is the length of a full SHA-256 commit hash, the
+	// longest accepted hash
+	SHA256HashLength = 64
+)
+
+// ObjectFormat is the hash algorithm of the objects of a repository, as in
+// git's extensions.objectFormat setting
+type ObjectFormat string
+
+// Object formats of git repositories
+const (
+	// ObjectFormatSHA1 is the format of repositories with 40-character hashes
+	ObjectFormatSHA1 ObjectFormat = "sha1"
+	// ObjectFormatSHA256 is the format of repositories with 64-character hashes
+	ObjectFormatSHA256 ObjectFormat = "sha256"
+)
+
+// DetectObjectFormat detects the object format of a full commit hash from
+// its length. Abbreviated hashes do not tell the format.
+//
+// Parameters:
+//   - hash: The commit hash
+//
+// Returns:
+//   - ObjectFormat: The object format of the hash
+//   - bool: True if hash is a full SHA-1 or SHA-256 hash, false otherwise
+func DetectObjectFormat(hash string) (ObjectFormat, bool) {
+	switch len(hash) {
+	case SHA1HashLength:
+		return ObjectFormatSHA1, true
+	case SHA256HashLength:
+		return ObjectFormatSHA256, true
+	}
+	return "", false
+}
+
+// IsFullHash reports whether hash is a full SHA-1 or SHA-256 commit hash
+// rather than an abbreviated one
+//
+// Parameters:
+//   - hash: The commit hash
+//
+// Returns:
+//   - bool: True if hash has the length of a full hash, false otherwise
+func IsFullHash(hash string) bool {
+	_, ok := DetectObjectFormat(hash)
+	return ok
+}
+
+// Validate checks if the commit has valid hash and short hash values: both
+// must be 7 to 64 lower-case hexadecimal digits, and the short hash must be a
+// prefix of the hash. Full hashes may be SHA-1 (40 characters) or SHA-256
+// (64 characters); hashes of other lengths are abbreviations.
 //
 // Returns:
 //   - error: Any error encountered during validation
 func (c *Commit) Validate() error {
-	if c.Hash == "" {
-		return fmt.Errorf("hash is empty")
+	if err := validateHash("hash", c.Hash); err != nil {
+		return err
 	}
-	if len(c.Hash) < 7 {
-		return fmt.Errorf("hash is too short: %s", c.Hash)
+	if err := validateHash("short hash", c.ShortHash); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(c.Hash, c.ShortHash) {
+		return fmt.Errorf("short hash %s is not a prefix of hash %s", c.ShortHash, c.Hash)
+	}
+	return nil
+}
+
+// validateHash checks that a commit hash is 7 to 64 lower-case hexadecimal
+// digits
+//
+// Parameters:
+//   - name: The name of the hash in error messages, e.g. "short hash"
+//   - hash: The hash to check
+//
+// Returns:
+//   - error: An error describing why the hash is invalid, or nil
+func validateHash(name, hash string) error {
+	if hash == "" {
+		return fmt.Errorf("%s is empty", name)
 	}
-	if c.ShortHash == "" {
-		return fmt.Errorf("short hash is empty")
+	if len(hash) < MinHashLength {
+		return fmt.Errorf("%s is too short: %s", name, hash)
 	}
-	if len(c.ShortHash) < 7 {
-		return fmt.Errorf("short hash is too short: %s", c.ShortHash)
+	if len(hash) > SHA256HashLength {
+		return fmt.Errorf("%s is too long (%d characters, at most %d): %s", name, len(hash), SHA256HashLength, hash)
+	}
+	for _, r := range hash {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return fmt.Errorf("%s contains %q, which is not a lower-case hexadecimal digit: %s", name, r, hash)
+		}
 	}
 	return nil
 }
 
-// CalculateShortHash calculates the short hash from the full hash
+// CalculateShortHash calculates the short hash from the full hash, using the
+// first 7 characters
 //
 // Returns:
 //   - error: Any error encountered during the calculation
 func (c *Commit) CalculateShortHash() error {
-	if len(c.Hash) < 7 {
+	return c.CalculateShortHashLength(MinHashLength)
+}
+
+// CalculateShortHashLength calculates the short hash from the full hash,
+// using its first length characters. Hashes shorter than length are used
+// whole.
+//
+// Parameters:
+//   - length: The number of characters of the short hash (at least 7)
+//
+// Returns:
+//   - error: Any error encountered during the calculation
+func (c *Commit) CalculateShortHashLength(length int) error {
+	if length < MinHashLength {
+		return fmt.Errorf("short hash length must be at least %d, got %d", MinHashLength, length)
+	}
+	if len(c.Hash) < MinHashLength {
 		return fmt.Errorf("hash is too short: %s", c.Hash)
 	}
-	c.ShortHash = c.Hash[:7]
+	// Commit directories are named after the short hash, so it is always
+	// lower-case regardless of how the commit was given
+	c.ShortHash = strings.ToLower(c.Hash[:min(length, len(c.Hash))])
 	return nil
 }
+
+// HasHashPrefix reports whether hash starts with prefix. Commit hashes are
+// hexadecimal, so the comparison ignores case.
+//
+// Parameters:
+//   - hash: The commit hash or commit directory name to check
+//   - prefix: The (possibly abbreviated) commit hash to match
+//
+// Returns:
+//   - bool: True if hash starts with prefix, ignoring case
+func HasHashPrefix(hash, prefix string) bool {
+	return strings.HasPrefix(strings.ToLower(hash), strings.ToLower(prefix))
+}
@@ -50,6 +50,62 @@ func TestCommit_Validate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "SHA-256 hash",
+			commit: Commit{
+				Hash:      "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+				ShortHash: "1234567",
+			},
+			wantErr: false,
+		},
+		{
+			name: "abbreviated hash",
+			commit: Commit{
+				Hash:      "1234567",
+				ShortHash: "1234567",
+			},
+			wantErr: false,
+		},
+		{
+			name: "hash too long",
+			commit: Commit{
+				Hash:      "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef0",
+				ShortHash: "1234567",
+			},
+			wantErr: true,
+		},
+		{
+			name: "non-hex hash",
+			commit: Commit{
+				Hash:      "zzzzzzzz",
+				ShortHash: "zzzzzzz",
+			},
+			wantErr: true,
+		},
+		{
+			name: "upper-case hash",
+			commit: Commit{
+				Hash:      "ABCDEF1234567890ABCDEF1234567890ABCDEF12",
+				ShortHash: "abcdef1",
+			},
+			wantErr: true,
+		},
+		{
+			name: "non-hex short hash",
+			commit: Commit{
+				Hash:      "1234567890abcdef1234567890abcdef12345678",
+				ShortHash: "1234567-v1.0",
+			},
+			wantErr: true,
+		},
+		{
+			name: "short hash not a prefix",
+			commit: Commit{
+				Hash:      "1234567890abcdef1234567890abcdef12345678",
+				ShortHash: "abcdef1",
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -62,6 +118,51 @@ func TestCommit_Validate(t *testing.T) {
 	}
 }
 
+// Full hashes of the same length as those of SHA-1 and SHA-256 repositories
+const (
+	testSHA1Hash   = "1234567890abcdef1234567890abcdef12345678"
+	testSHA256Hash = "1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef"
+)
+
+func TestObjectFormats(t *testing.T) {
+	tests := []struct {
+		name       string
+		hash       string
+		wantFormat ObjectFormat
+		wantFull   bool
+		wantShort  string
+	}{
+		{name: "SHA-1", hash: testSHA1Hash, wantFormat: ObjectFormatSHA1, wantFull: true, wantShort: "1234567"},
+		{name: "SHA-256", hash: testSHA256Hash, wantFormat: ObjectFormatSHA256, wantFull: true, wantShort: "1234567"},
+		{name: "abbreviated", hash: "1234567890ab", wantShort: "1234567"},
+		{name: "abbreviated SHA-256", hash: testSHA256Hash[:50], wantShort: "1234567"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, full := DetectObjectFormat(tt.hash)
+			if format != tt.wantFormat || full != tt.wantFull {
+				t.Errorf("DetectObjectFormat() = %q, %v, want %q, %v", format, full, tt.wantFormat, tt.wantFull)
+			}
+			if got := IsFullHash(tt.hash); got != tt.wantFull {
+				t.Errorf("IsFullHash() = %v, want %v", got, tt.wantFull)
+			}
+
+			c := Commit{Hash: tt.hash}
+			if err := c.CalculateShortHash(); err != nil {
+				t.Fatalf("CalculateShortHash() error = %v", err)
+			}
+			if c.ShortHash != tt.wantShort {
+				t.Errorf("CalculateShortHash() = %v, want %v", c.ShortHash, tt.wantShort)
+			}
+			// The build directory stays named after the short hash
+			if err := c.Validate(); err != nil {
+				t.Errorf("Validate() error = %v", err)
+			}
+		})
+	}
+}
+
 func TestCommit_CalculateShortHash(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -75,6 +176,12 @@ func TestCommit_CalculateShortHash(t *testing.T) {
 			wantShort: "1234567",
 			wantErr:   false,
 		},
+		{
+			name:      "upper-case hash",
+			hash:      "ABCDEF1234567890ABCDEF1234567890ABCDEF12",
+			wantShort: "abcdef1",
+			wantErr:   false,
+		},
 		{
 			name:      "hash too short",
 			hash:      "123456",
@@ -99,3 +206,57 @@ func TestCommit_CalculateShortHash(t *testing.T) {
 		})
 	}
 }
+
+func TestCommit_CalculateShortHashLength(t *testing.T) {
+	tests := []struct {
+		name      string
+		hash      string
+		length    int
+		wantShort string
+		wantErr   bool
+	}{
+		{name: "default length", hash: "1234567890abcdef1234567890abcdef12345678", length: 7, wantShort: "1234567"},
+		{name: "longer", hash: "1234567890abcdef1234567890abcdef12345678", length: 12, wantShort: "1234567890ab"},
+		{name: "longer than the hash", hash: "1234567890", length: 12, wantShort: "1234567890"},
+		{name: "upper-case hash", hash: "ABCDEF1234567890", length: 10, wantShort: "abcdef1234"},
+		{name: "length too short", hash: "1234567890abcdef", length: 6, wantErr: true},
+		{name: "hash too short", hash: "123456", length: 7, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Commit{Hash: tt.hash}
+			err := c.CalculateShortHashLength(tt.length)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CalculateShortHashLength() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if c.ShortHash != tt.wantShort {
+				t.Errorf("CalculateShortHashLength() = %v, want %v", c.ShortHash, tt.wantShort)
+			}
+		})
+	}
+}
+
+func TestHasHashPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		hash   string
+		prefix string
+		want   bool
+	}{
+		{name: "same case", hash: "abc1234", prefix: "abc12", want: true},
+		{name: "upper-case prefix", hash: "abc1234", prefix: "ABC1234", want: true},
+		{name: "upper-case hash", hash: "ABC1234", prefix: "abc", want: true},
+		{name: "empty prefix", hash: "abc1234", prefix: "", want: true},
+		{name: "different hash", hash: "abc1234", prefix: "ABD", want: false},
+		{name: "prefix longer than hash", hash: "abc1234", prefix: "abc12345", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasHashPrefix(tt.hash, tt.prefix); got != tt.want {
+				t.Errorf("HasHashPrefix(%q, %q) = %v, want %v", tt.hash, tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,17 +2,53 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"maps"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
 
 	"github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+	"github.com/oota-sushikuitee/nigiri/pkg/commits"
 	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
 )
 
 // ConfigManager handles the reading and writing of configuration files
 type ConfigManager struct {
 	Config *config.Config
+	// strictEnv makes references to undefined environment variables an error
+	strictEnv bool
+	// keepEnvRefs leaves environment variable references unexpanded
+	keepEnvRefs bool
+}
+
+// SetStrictEnv makes references to undefined environment variables in the
+// configuration an error instead of expanding them to an empty string
+//
+// Parameters:
+//   - strict: Whether undefined variables are an error
+func (cm *ConfigManager) SetStrictEnv(strict bool) {
+	cm.strictEnv = strict
+}
+
+// SetKeepEnvReferences leaves environment variable references in the
+// configuration unexpanded, so a configuration that is saved again keeps
+// them instead of the values of the current environment
+//
+// Parameters:
+//   - keep: Whether references are kept as they are
+func (cm *ConfigManager) SetKeepEnvReferences(keep bool) {
+	cm.keepEnvRefs = keep
 }
 
 // NewConfigManager creates a new ConfigManager with default configuration
@@ -29,20 +65,51 @@ func NewConfigManager() *ConfigManager {
 	}
 }
 
+// SetCfgPath applies a user-supplied configuration path such as the --config
+// flag. A directory replaces the configuration directory, so .nigiri.yml is
+// looked up inside it; any other path is used as an explicit config file.
+//
+// Parameters:
+//   - path: The configuration file or directory
+func (cm *ConfigManager) SetCfgPath(path string) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		cm.Config.SetCfgDir(path)
+		cm.Config.SetCfgFile("")
+		return
+	}
+	cm.Config.SetCfgFile(path)
+}
+
 // LoadCfgFile loads the configuration file. When an explicit config file path
 // has been set (e.g. via the --config flag), that file is loaded directly;
 // otherwise the file is discovered in the configuration directory.
 func (cm *ConfigManager) LoadCfgFile() error {
+	v, err := cm.readCfgFile()
+	if err != nil {
+		return err
+	}
+	return cm.parseConfig(v, v.ConfigFileUsed())
+}
+
+// readCfgFile reads the configuration file without interpreting it
+//
+// Returns:
+//   - *viper.Viper: The configuration read from the file
+//   - error: Any error encountered while locating or reading the file
+func (cm *ConfigManager) readCfgFile() (*viper.Viper, error) {
 	v := viper.New()
 
 	if cfgFile := cm.Config.GetCfgFile(); cfgFile != "" {
+		if _, err := os.Stat(cfgFile); os.IsNotExist(err) {
+			return nil, fmt.Errorf("config file %s does not exist", cfgFile)
+		}
 		v.SetConfigFile(cfgFile)
 	} else {
 		cfgDir := cm.Config.GetCfgDir()
 		if cfgDir == "" {
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				return fmt.Errorf("could not determine home directory: %w", err)
+				return nil, fmt.Errorf("could not determine home directory: %w", err)
 			}
 			cfgDir = filepath.Join(homeDir, ".nigiri")
 			cm.Config.SetCfgDir(cfgDir)
@@ -54,9 +121,148 @@ func (cm *ConfigManager) LoadCfgFile() error {
 	}
 
 	if err := v.ReadInConfig(); err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	return v, nil
+}
+
+// targetKeys lists the keys a target may have in the configuration file
+var targetKeys = []string{
+	"binary-only", "build-command", "build-timeout", "default-branch", "deps-files", "env",
+	"expect-files", "keep-running", "metadata", "platforms", "post-process", "prune-failed",
+	"reproducible", "reproducible-exclude", "shell", "short-hash-length", "source", "sources",
+	"submodules", "toolchain", "warning-pattern", "working-directory",
+}
+
+// buildCommandKeys lists the keys of the build-command of a target
+var buildCommandKeys = []string{"linux", "windows", "darwin", "binary-path"}
+
+// ValidateCfgFile checks the configuration file more thoroughly than loading
+// it does: it reports keys that loading ignores, and validates every target
+// with its defaults applied, including that its source URL parses. The
+// configuration is loaded into cm as a side effect.
+//
+// Returns:
+//   - string: The path of the configuration file that was checked
+//   - []string: The problems found (empty if the configuration is valid)
+//   - error: Any error encountered while locating or reading the file
+func (cm *ConfigManager) ValidateCfgFile() (string, []string, error) {
+	v, err := cm.readCfgFile()
+	if err != nil {
+		return "", nil, err
+	}
+	path := v.ConfigFileUsed()
+	problems := unknownKeys(v.AllSettings())
+	if err := cm.parseConfig(v, path); err != nil {
+		return path, append(problems, err.Error()), nil
 	}
+	for _, name := range slices.Sorted(maps.Keys(cm.Config.Targets)) {
+		target := cm.Config.Targets[name]
+		target.BuildCommand = target.BuildCommand.WithDefaults(cm.Config.Defaults)
+		if err := ValidateTarget(name, target); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if err := validateSourceURL(target.Sources); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid source in target '%s': %v", name, err))
+		}
+	}
+	return path, problems, nil
+}
 
+// unknownKeys lists the keys of a configuration that nigiri does not know, and
+// would therefore silently ignore
+//
+// Parameters:
+//   - settings: The configuration as read from the file
+//
+// Returns:
+//   - []string: A problem for each unknown key, ordered by key
+func unknownKeys(settings map[string]interface{}) []string {
+	var problems []string
+	for _, key := range slices.Sorted(maps.Keys(settings)) {
+		if key != "targets" && key != "defaults" {
+			problems = append(problems, fmt.Sprintf("unknown top-level key '%s'", key))
+		}
+	}
+	if defaults, ok := settings["defaults"].(map[string]interface{}); ok {
+		for _, key := range slices.Sorted(maps.Keys(defaults)) {
+			if !IsSupportedPlatform(key) {
+				problems = append(problems, fmt.Sprintf("unknown key 'defaults.%s': expected one of %s", key, strings.Join(SupportedPlatforms, ", ")))
+			}
+		}
+	}
+	targetsCfg, _ := settings["targets"].(map[string]interface{})
+	for _, name := range slices.Sorted(maps.Keys(targetsCfg)) {
+		targetCfg, ok := targetsCfg[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range slices.Sorted(maps.Keys(targetCfg)) {
+			if !slices.Contains(targetKeys, key) {
+				problems = append(problems, fmt.Sprintf("unknown key '%s' in target '%s'", key, name))
+			}
+		}
+		buildCmd, exists := targetCfg["build-command"]
+		if !exists {
+			continue
+		}
+		buildCmdCfg, ok := buildCmd.(map[string]interface{})
+		if !ok {
+			problems = append(problems, fmt.Sprintf("invalid type for 'build-command' in target '%s': expected map", name))
+			continue
+		}
+		for _, key := range slices.Sorted(maps.Keys(buildCmdCfg)) {
+			if !slices.Contains(buildCommandKeys, key) {
+				problems = append(problems, fmt.Sprintf("unknown key 'build-command.%s' in target '%s'", key, name))
+			}
+		}
+	}
+	return problems
+}
+
+// validateSourceURL checks that a source given as a URL parses and names a
+// host. Sources without a scheme, such as local paths and SSH sources like
+// git@github.com:owner/repo.git, are not URLs and are accepted.
+//
+// Parameters:
+//   - source: The source of a target
+//
+// Returns:
+//   - error: An error describing why the URL is invalid, or nil
+func validateSourceURL(source string) error {
+	if !strings.Contains(source, "://") {
+		return nil
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		// The error of url.Parse quotes the URL, which may hold credentials
+		return fmt.Errorf("URL does not parse: %w", errors.Unwrap(err))
+	}
+	if u.Scheme != "file" && u.Host == "" {
+		return fmt.Errorf("URL %s has no host", u.Redacted())
+	}
+	return nil
+}
+
+// LoadCfgData loads the configuration from YAML data instead of a file
+//
+// Parameters:
+//   - data: The YAML configuration
+//   - source: A description of where the data came from, used in error messages
+//
+// Returns:
+//   - error: Any error encountered while parsing the configuration
+func (cm *ConfigManager) LoadCfgData(data []byte, source string) error {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	if err := v.ReadConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("failed to read config from %s: %w", source, err)
+	}
+	return cm.parseConfig(v, source)
+}
+
+// parseConfig converts the configuration read by v into cm.Config
+func (cm *ConfigManager) parseConfig(v *viper.Viper, source string) error {
 	// Create a map to store the intermediate configuration
 	var cfg struct {
 		Targets  map[string]map[string]interface{} `mapstructure:"targets"`
@@ -68,7 +274,7 @@ func (cm *ConfigManager) LoadCfgFile() error {
 	}
 
 	if len(cfg.Targets) == 0 {
-		return fmt.Errorf("no targets found in configuration file at %s", v.ConfigFileUsed())
+		return fmt.Errorf("no targets found in configuration file at %s", source)
 	}
 
 	// Convert the map to our config structure
@@ -106,6 +312,79 @@ func (cm *ConfigManager) LoadCfgFile() error {
 				return fmt.Errorf("invalid type for 'binary-only' in target '%s': expected bool", name)
 			}
 		}
+		if buildTimeout, ok := targetCfg["build-timeout"]; ok {
+			timeout, err := parseBuildTimeout(buildTimeout)
+			if err != nil {
+				return fmt.Errorf("invalid 'build-timeout' in target '%s': %w", name, err)
+			}
+			target.BuildTimeoutValue = timeout
+		}
+		if metadata, ok := targetCfg["metadata"]; ok {
+			m, ok := metadata.(string)
+			if !ok {
+				return fmt.Errorf("invalid type for 'metadata' in target '%s': expected string", name)
+			}
+			if !targets.IsMetadataLevel(m) {
+				return fmt.Errorf("invalid 'metadata' in target '%s': expected one of %s", name, strings.Join(targets.MetadataLevels, ", "))
+			}
+			target.Metadata = m
+		}
+		if keepRunning, ok := targetCfg["keep-running"]; ok {
+			if b, ok := keepRunning.(bool); ok {
+				target.KeepRunning = b
+			} else {
+				return fmt.Errorf("invalid type for 'keep-running' in target '%s': expected bool", name)
+			}
+		}
+		if reproducible, ok := targetCfg["reproducible"]; ok {
+			if b, ok := reproducible.(bool); ok {
+				target.Reproducible = b
+			} else {
+				return fmt.Errorf("invalid type for 'reproducible' in target '%s': expected bool", name)
+			}
+		}
+		if pruneFailed, ok := targetCfg["prune-failed"]; ok {
+			if b, ok := pruneFailed.(bool); ok {
+				target.PruneFailed = b
+			} else {
+				return fmt.Errorf("invalid type for 'prune-failed' in target '%s': expected bool", name)
+			}
+		}
+		if submodules, ok := targetCfg["submodules"]; ok {
+			if b, ok := submodules.(bool); ok {
+				target.Submodules = b
+			} else {
+				return fmt.Errorf("invalid type for 'submodules' in target '%s': expected bool", name)
+			}
+		}
+		if length, ok := targetCfg["short-hash-length"]; ok {
+			if n, ok := length.(int); ok {
+				target.ShortHashLengthValue = n
+			} else {
+				return fmt.Errorf("invalid type for 'short-hash-length' in target '%s': expected integer", name)
+			}
+		}
+		if pattern, ok := targetCfg["warning-pattern"]; ok {
+			if p, ok := pattern.(string); ok {
+				target.WarningPattern = p
+			} else {
+				return fmt.Errorf("invalid type for 'warning-pattern' in target '%s': expected string", name)
+			}
+		}
+		if shell, ok := targetCfg["shell"]; ok {
+			if sh, ok := shell.(string); ok {
+				target.Shell = sh
+			} else {
+				return fmt.Errorf("invalid type for 'shell' in target '%s': expected string", name)
+			}
+		}
+		if toolchain, ok := targetCfg["toolchain"]; ok {
+			if tc, ok := toolchain.(string); ok {
+				target.Toolchain = tc
+			} else {
+				return fmt.Errorf("invalid type for 'toolchain' in target '%s': expected string", name)
+			}
+		}
 		if workingDir, ok := targetCfg["working-directory"]; ok {
 			if w, ok := workingDir.(string); ok {
 				target.WorkingDirectory = w
@@ -127,6 +406,70 @@ func (cm *ConfigManager) LoadCfgFile() error {
 			}
 		}
 
+		if platforms, ok := targetCfg["platforms"]; ok {
+			if platformSlice, isSlice := platforms.([]interface{}); isSlice {
+				for i, p := range platformSlice {
+					if s, ok := p.(string); ok {
+						target.Platforms = append(target.Platforms, s)
+					} else {
+						return fmt.Errorf("invalid type for 'platforms[%d]' in target '%s': expected string", i, name)
+					}
+				}
+			} else {
+				return fmt.Errorf("invalid type for 'platforms' in target '%s': expected array", name)
+			}
+		}
+
+		if exclude, ok := targetCfg["reproducible-exclude"]; ok {
+			if excludeSlice, isSlice := exclude.([]interface{}); isSlice {
+				for i, e := range excludeSlice {
+					if s, ok := e.(string); ok {
+						target.ReproducibleExclude = append(target.ReproducibleExclude, s)
+					} else {
+						return fmt.Errorf("invalid type for 'reproducible-exclude[%d]' in target '%s': expected string", i, name)
+					}
+				}
+			} else {
+				return fmt.Errorf("invalid type for 'reproducible-exclude' in target '%s': expected array", name)
+			}
+		}
+
+		if depsFiles, ok := targetCfg["deps-files"]; ok {
+			if depsSlice, isSlice := depsFiles.([]interface{}); isSlice {
+				for i, d := range depsSlice {
+					if s, ok := d.(string); ok {
+						target.DepsFiles = append(target.DepsFiles, s)
+					} else {
+						return fmt.Errorf("invalid type for 'deps-files[%d]' in target '%s': expected string", i, name)
+					}
+				}
+			} else {
+				return fmt.Errorf("invalid type for 'deps-files' in target '%s': expected array", name)
+			}
+		}
+
+		if expectFiles, ok := targetCfg["expect-files"]; ok {
+			if expectSlice, isSlice := expectFiles.([]interface{}); isSlice {
+				for i, f := range expectSlice {
+					if s, ok := f.(string); ok {
+						target.ExpectFiles = append(target.ExpectFiles, s)
+					} else {
+						return fmt.Errorf("invalid type for 'expect-files[%d]' in target '%s': expected string", i, name)
+					}
+				}
+			} else {
+				return fmt.Errorf("invalid type for 'expect-files' in target '%s': expected array", name)
+			}
+		}
+
+		if postProcess, ok := targetCfg["post-process"]; ok {
+			parsed, err := parsePostProcess(postProcess)
+			if err != nil {
+				return fmt.Errorf("invalid 'post-process' in target '%s': %w", name, err)
+			}
+			target.PostProcess = parsed
+		}
+
 		// Handle build command with safe type assertions
 		if buildCmd, ok := targetCfg["build-command"].(map[string]interface{}); ok {
 			if linux, exists := buildCmd["linux"]; exists {
@@ -159,6 +502,11 @@ func (cm *ConfigManager) LoadCfgFile() error {
 			}
 		}
 
+		if !cm.keepEnvRefs {
+			if err := cm.expandTargetEnv(name, &target); err != nil {
+				return err
+			}
+		}
 		cm.Config.Targets[name] = target
 	}
 
@@ -174,57 +522,508 @@ func (cm *ConfigManager) LoadCfgFile() error {
 	return nil
 }
 
-// SaveCfgFile saves the configuration to the configuration file
+// envValue is a configuration value that may reference environment variables
+type envValue struct {
+	// key is the configuration key of the value, used in error messages
+	key string
+	// value points at the value, which is expanded in place
+	value *string
+}
+
+// expandTargetEnv expands the environment variable references in the source,
+// binary path, working directory, toolchain and env entries of a target.
+// Build commands are left as written: the shell they run through expands
+// them, along with the variables the commands set themselves.
+//
+// Parameters:
+//   - name: The name of the target
+//   - target: The target whose values are expanded in place
+//
+// Returns:
+//   - error: An error naming the undefined variables when strictEnv is set
+func (cm *ConfigManager) expandTargetEnv(name string, target *config.Target) error {
+	values := []envValue{
+		{"source", &target.Sources},
+		{"working-directory", &target.WorkingDirectory},
+		{"toolchain", &target.Toolchain},
+		{"build-command.binary-path", &target.BuildCommand.BinaryPathValue},
+	}
+	for i := range target.Env {
+		values = append(values, envValue{fmt.Sprintf("env[%d]", i), &target.Env[i]})
+	}
+	for _, v := range values {
+		if err := cm.expandValue(v.value, fmt.Sprintf("'%s' of target '%s'", v.key, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandValue expands the environment variable references in a configuration
+// value in place
+//
+// Parameters:
+//   - value: The value to expand
+//   - where: A description of the value used in error messages
+//
+// Returns:
+//   - error: An error naming the undefined variables when strictEnv is set
+func (cm *ConfigManager) expandValue(value *string, where string) error {
+	expanded, undefined := ExpandEnv(*value)
+	if len(undefined) > 0 && cm.strictEnv {
+		return fmt.Errorf("undefined environment variable %s in %s", strings.Join(undefined, ", "), where)
+	}
+	*value = expanded
+	return nil
+}
+
+// ExpandEnv expands ${VAR} and $VAR references to environment variables in s.
+// $$ stands for a literal $, and shell parameters such as $1, $@ or $? are left
+// as they are, so that build commands can still use them.
+//
+// Parameters:
+//   - s: The value to expand
+//
+// Returns:
+//   - string: The expanded value, with undefined variables expanded to ""
+//   - []string: The names of the undefined variables, in order of appearance
+func ExpandEnv(s string) (string, []string) {
+	var undefined []string
+	expanded := os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		if len(name) == 1 && strings.ContainsAny(name, "*#@!?-0123456789") {
+			return "$" + name
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok && !slices.Contains(undefined, name) {
+			undefined = append(undefined, name)
+		}
+		return value
+	})
+	return expanded, undefined
+}
+
+// SaveCfgFile saves the configuration to the configuration file. An explicit
+// config file path takes precedence over the configuration directory. An
+// existing file is updated in place: the comments, key order and formatting
+// of the values that did not change are kept, keys nigiri does not know are
+// left alone, and the file is not written at all if nothing changed.
 func (cm *ConfigManager) SaveCfgFile() error {
-	cfgDir := cm.Config.GetCfgDir()
-	v := viper.New()
-	v.SetConfigName(".nigiri")
-	v.SetConfigType("yaml")
-	v.AddConfigPath(cfgDir)
+	configFile := cm.Config.GetCfgFile()
+	if configFile == "" {
+		configFile = filepath.Join(cm.Config.GetCfgDir(), ".nigiri.yml")
+	}
+
+	var saved yaml.Node
+	if err := saved.Encode(cm.cfgDocument()); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	orderKeys(&saved)
 
-	// Create target configurations that properly include all fields
+	perm := os.FileMode(0644)
+	doc := &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{&saved}}
+	indent := 2
+	existing, err := os.ReadFile(configFile)
+	switch {
+	case err == nil:
+		var current yaml.Node
+		if err := yaml.Unmarshal(existing, &current); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+		}
+		if len(current.Content) == 1 && current.Content[0].Kind == yaml.MappingNode {
+			if !mergeMapping(current.Content[0], &saved, cfgSchema) {
+				return nil
+			}
+			doc = &current
+			indent = detectIndent(existing)
+		}
+		if info, statErr := os.Stat(configFile); statErr == nil {
+			perm = info.Mode().Perm()
+		}
+	case !os.IsNotExist(err):
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(indent)
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	return os.WriteFile(configFile, out.Bytes(), perm)
+}
+
+// cfgDocument returns the configuration as it is saved, leaving out the
+// fields that have their zero value
+//
+// Returns:
+//   - map[string]interface{}: The configuration keyed as in the file
+func (cm *ConfigManager) cfgDocument() map[string]interface{} {
 	targetConfigs := make(map[string]map[string]interface{})
 	for name, target := range cm.Config.Targets {
 		targetConfig := map[string]interface{}{
-			"source":            target.Sources,
-			"default-branch":    target.DefaultBranch,
-			"binary-only":       target.BinaryOnly,
-			"working-directory": target.WorkingDirectory,
+			"source": target.Sources,
 		}
 
+		if target.DefaultBranch != "" {
+			targetConfig["default-branch"] = target.DefaultBranch
+		}
+		if target.BinaryOnly {
+			targetConfig["binary-only"] = true
+		}
+		if target.WorkingDirectory != "" {
+			targetConfig["working-directory"] = target.WorkingDirectory
+		}
 		if len(target.Env) > 0 {
 			targetConfig["env"] = target.Env
 		}
-
-		buildCommand := map[string]interface{}{
-			"linux":   target.BuildCommand.Linux,
-			"windows": target.BuildCommand.Windows,
-			"darwin":  target.BuildCommand.Darwin,
+		if len(target.DepsFiles) > 0 {
+			targetConfig["deps-files"] = target.DepsFiles
+		}
+		if len(target.ExpectFiles) > 0 {
+			targetConfig["expect-files"] = target.ExpectFiles
+		}
+		if target.WarningPattern != "" {
+			targetConfig["warning-pattern"] = target.WarningPattern
+		}
+		if len(target.Platforms) > 0 {
+			targetConfig["platforms"] = target.Platforms
+		}
+		if target.KeepRunning {
+			targetConfig["keep-running"] = true
+		}
+		if target.Reproducible {
+			targetConfig["reproducible"] = true
+		}
+		if target.PruneFailed {
+			targetConfig["prune-failed"] = true
+		}
+		if target.Submodules {
+			targetConfig["submodules"] = true
+		}
+		if len(target.ReproducibleExclude) > 0 {
+			targetConfig["reproducible-exclude"] = target.ReproducibleExclude
+		}
+		if target.Metadata != "" {
+			targetConfig["metadata"] = target.Metadata
+		}
+		if target.Shell != "" {
+			targetConfig["shell"] = target.Shell
+		}
+		if target.Toolchain != "" {
+			targetConfig["toolchain"] = target.Toolchain
+		}
+		if target.BuildTimeoutValue != "" {
+			targetConfig["build-timeout"] = target.BuildTimeoutValue
+		}
+		if target.ShortHashLengthValue != 0 {
+			targetConfig["short-hash-length"] = target.ShortHashLengthValue
+		}
+		postProcess := map[string]interface{}{}
+		for _, platform := range SupportedPlatforms {
+			if commands := target.PostProcess.Commands(platform); len(commands) > 0 {
+				postProcess[platform] = commands
+			}
+		}
+		if len(postProcess) > 0 {
+			targetConfig["post-process"] = postProcess
 		}
 
+		buildCommand := nonEmptyPlatformCommands(target.BuildCommand)
 		if target.BuildCommand.BinaryPathValue != "" {
 			buildCommand["binary-path"] = target.BuildCommand.BinaryPathValue
 		}
-
-		targetConfig["build-command"] = buildCommand
+		if len(buildCommand) > 0 {
+			targetConfig["build-command"] = buildCommand
+		}
 		targetConfigs[name] = targetConfig
 	}
 
-	// Set values in viper
-	if err := v.MergeConfigMap(map[string]interface{}{
-		"targets": targetConfigs,
-		"defaults": map[string]interface{}{
-			"linux":   cm.Config.Defaults.Linux,
-			"windows": cm.Config.Defaults.Windows,
-			"darwin":  cm.Config.Defaults.Darwin,
-		},
-	}); err != nil {
-		return fmt.Errorf("failed to merge config: %w", err)
+	doc := map[string]interface{}{"targets": targetConfigs}
+	if defaults := nonEmptyPlatformCommands(cm.Config.Defaults); len(defaults) > 0 {
+		doc["defaults"] = defaults
 	}
+	return doc
+}
 
-	// Save to file
-	configFile := filepath.Join(cfgDir, ".nigiri.yml")
-	return v.WriteConfigAs(configFile)
+// nonEmptyPlatformCommands returns the OS-specific commands of bc that are set
+//
+// Parameters:
+//   - bc: The build command
+//
+// Returns:
+//   - map[string]interface{}: The commands keyed by OS
+func nonEmptyPlatformCommands(bc config.BuildCommand) map[string]interface{} {
+	commands := map[string]interface{}{}
+	for platform, command := range map[string]string{"linux": bc.Linux, "windows": bc.Windows, "darwin": bc.Darwin} {
+		if command != "" {
+			commands[platform] = command
+		}
+	}
+	return commands
+}
+
+// parseBuildTimeout validates a build-timeout value, which is a duration
+// string such as "45m" or 0 to disable the timeout
+//
+// Parameters:
+//   - value: The value read from the configuration
+//
+// Returns:
+//   - string: The timeout as a duration string
+//   - error: An error if the value is not a non-negative duration
+func parseBuildTimeout(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return "", fmt.Errorf("expected a duration such as '45m': %w", err)
+		}
+		if d < 0 {
+			return "", fmt.Errorf("duration %s is negative", v)
+		}
+		return v, nil
+	case int:
+		if v == 0 {
+			return "0", nil
+		}
+	}
+	return "", fmt.Errorf("expected a duration such as '45m', got %v", value)
+}
+
+// parsePostProcess converts a post-process value, a map from OS to a list of
+// commands, into its configuration model
+//
+// Parameters:
+//   - value: The value read from the configuration
+//
+// Returns:
+//   - config.PostProcess: The post-process commands per OS
+//   - error: An error if the value is not a map of known OSes to command lists
+func parsePostProcess(value interface{}) (config.PostProcess, error) {
+	var postProcess config.PostProcess
+	byOS, ok := value.(map[string]interface{})
+	if !ok {
+		return postProcess, fmt.Errorf("expected a map of OS to commands")
+	}
+	for goos, list := range byOS {
+		items, ok := list.([]interface{})
+		if !ok {
+			return postProcess, fmt.Errorf("expected a list of commands for '%s'", goos)
+		}
+		var commands []string
+		for i, item := range items {
+			command, ok := item.(string)
+			if !ok {
+				return postProcess, fmt.Errorf("invalid type for '%s[%d]': expected string", goos, i)
+			}
+			commands = append(commands, command)
+		}
+		switch goos {
+		case "linux":
+			postProcess.Linux = commands
+		case "windows":
+			postProcess.Windows = commands
+		case "darwin":
+			postProcess.Darwin = commands
+		default:
+			return postProcess, fmt.Errorf("unknown OS '%s': expected one of %s", goos, strings.Join(SupportedPlatforms, ", "))
+		}
+	}
+	return postProcess, nil
+}
+
+// SupportedPlatforms lists the operating systems a target can declare in its
+// platforms list, matching the OS keys of build-command
+var SupportedPlatforms = []string{"linux", "darwin", "windows"}
+
+// IsSupportedPlatform reports whether platform is one of SupportedPlatforms
+//
+// Parameters:
+//   - platform: The platform name to check
+//
+// Returns:
+//   - bool: True if the platform is known, false otherwise
+func IsSupportedPlatform(platform string) bool {
+	for _, p := range SupportedPlatforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
+
+// ReproducibleEnvVars lists the environment variables set for reproducible
+// builds, in the order they are added to the build environment
+var ReproducibleEnvVars = []string{"SOURCE_DATE_EPOCH", "GOFLAGS", "TZ", "LC_ALL"}
+
+// IsReproducibleEnvVar reports whether name is one of ReproducibleEnvVars
+//
+// Parameters:
+//   - name: The environment variable name to check
+//
+// Returns:
+//   - bool: True if the variable is set for reproducible builds, false otherwise
+func IsReproducibleEnvVar(name string) bool {
+	for _, v := range ReproducibleEnvVars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTarget checks that a target definition is complete enough to be built
+//
+// Parameters:
+//   - name: The name of the target
+//   - target: The target configuration
+//
+// Returns:
+//   - error: An error describing the first problem found, or nil if the target is valid
+func ValidateTarget(name string, target config.Target) error {
+	if err := targets.ValidateTargetName(name); err != nil {
+		return err
+	}
+	if target.Sources == "" {
+		return fmt.Errorf("target '%s' has no source", name)
+	}
+	bc := target.BuildCommand
+	if bc.Linux == "" && bc.Windows == "" && bc.Darwin == "" {
+		return fmt.Errorf("target '%s' has no build command", name)
+	}
+	for _, platform := range target.Platforms {
+		if !IsSupportedPlatform(platform) {
+			return fmt.Errorf("unknown platform '%s' in target '%s': expected one of %s", platform, name, strings.Join(SupportedPlatforms, ", "))
+		}
+	}
+	if target.Metadata != "" && !targets.IsMetadataLevel(target.Metadata) {
+		return fmt.Errorf("invalid metadata '%s' in target '%s': expected one of %s", target.Metadata, name, strings.Join(targets.MetadataLevels, ", "))
+	}
+	for _, variable := range target.ReproducibleExclude {
+		if !IsReproducibleEnvVar(variable) {
+			return fmt.Errorf("unknown reproducible-exclude variable '%s' in target '%s': expected one of %s", variable, name, strings.Join(ReproducibleEnvVars, ", "))
+		}
+	}
+	if n := target.ShortHashLengthValue; n != 0 && (n < commits.MinHashLength || n > commits.SHA256HashLength) {
+		return fmt.Errorf("invalid short-hash-length %d in target '%s': expected %d to %d", n, name, commits.MinHashLength, commits.SHA256HashLength)
+	}
+	if target.Shell != "" && strings.TrimSpace(target.Shell) == "" {
+		return fmt.Errorf("target '%s' has a blank shell", name)
+	}
+	if target.WarningPattern != "" {
+		if _, err := regexp.Compile(target.WarningPattern); err != nil {
+			return fmt.Errorf("invalid warning-pattern in target '%s': %w", name, err)
+		}
+	}
+	for _, platform := range SupportedPlatforms {
+		commands := target.PostProcess.Commands(platform)
+		if len(commands) > 0 && bc.BinaryPathValue == "" {
+			return fmt.Errorf("target '%s' has post-process commands but no build-command.binary-path", name)
+		}
+		for _, command := range commands {
+			if _, err := template.New("post-process").Option("missingkey=error").Parse(command); err != nil {
+				return fmt.Errorf("invalid post-process command in target '%s': %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// targetFields maps the target keys that can be set from the command line to
+// a function that sets them from a string value
+var targetFields = map[string]func(target *config.Target, value string) error{
+	"source":                    func(t *config.Target, v string) error { t.Sources = v; return nil },
+	"default-branch":            func(t *config.Target, v string) error { t.DefaultBranch = v; return nil },
+	"working-directory":         func(t *config.Target, v string) error { t.WorkingDirectory = v; return nil },
+	"metadata":                  func(t *config.Target, v string) error { t.Metadata = v; return nil },
+	"shell":                     func(t *config.Target, v string) error { t.Shell = v; return nil },
+	"toolchain":                 func(t *config.Target, v string) error { t.Toolchain = v; return nil },
+	"warning-pattern":           func(t *config.Target, v string) error { t.WarningPattern = v; return nil },
+	"build-command.linux":       func(t *config.Target, v string) error { t.BuildCommand.Linux = v; return nil },
+	"build-command.windows":     func(t *config.Target, v string) error { t.BuildCommand.Windows = v; return nil },
+	"build-command.darwin":      func(t *config.Target, v string) error { t.BuildCommand.Darwin = v; return nil },
+	"build-command.binary-path": func(t *config.Target, v string) error { t.BuildCommand.BinaryPathValue = v; return nil },
+	"build-timeout": func(t *config.Target, v string) error {
+		if v == "" {
+			t.BuildTimeoutValue = ""
+			return nil
+		}
+		timeout, err := parseBuildTimeout(v)
+		if err != nil {
+			return err
+		}
+		t.BuildTimeoutValue = timeout
+		return nil
+	},
+	"short-hash-length": func(t *config.Target, v string) error {
+		if v == "" {
+			t.ShortHashLengthValue = 0
+			return nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %q", v)
+		}
+		t.ShortHashLengthValue = n
+		return nil
+	},
+	"binary-only":  boolField(func(t *config.Target) *bool { return &t.BinaryOnly }),
+	"keep-running": boolField(func(t *config.Target) *bool { return &t.KeepRunning }),
+	"reproducible": boolField(func(t *config.Target) *bool { return &t.Reproducible }),
+	"prune-failed": boolField(func(t *config.Target) *bool { return &t.PruneFailed }),
+	"submodules":   boolField(func(t *config.Target) *bool { return &t.Submodules }),
+}
+
+// boolField returns a setter for the bool target field selected by field
+func boolField(field func(t *config.Target) *bool) func(target *config.Target, value string) error {
+	return func(target *config.Target, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %q", value)
+		}
+		*field(target) = b
+		return nil
+	}
+}
+
+// SettableTargetKeys returns the target keys that SetTargetField accepts
+//
+// Returns:
+//   - []string: The keys, sorted
+func SettableTargetKeys() []string {
+	return slices.Sorted(maps.Keys(targetFields))
+}
+
+// SetTargetField sets a single field of a target from its string form, as
+// given on the command line. The keys of build-command are addressed as
+// build-command.<key>; binary-path is accepted as a shorthand for
+// build-command.binary-path, and an empty value clears a string field.
+//
+// Parameters:
+//   - target: The target to change
+//   - key: The configuration key of the field
+//   - value: The new value
+//
+// Returns:
+//   - error: An error if the key cannot be set or the value is invalid for it
+func SetTargetField(target *config.Target, key, value string) error {
+	if key == "binary-path" {
+		key = "build-command.binary-path"
+	}
+	set, ok := targetFields[key]
+	if !ok {
+		return fmt.Errorf("unsupported key '%s': expected one of %s", key, strings.Join(SettableTargetKeys(), ", "))
+	}
+	if err := set(target, value); err != nil {
+		return fmt.Errorf("invalid value for '%s': %w", key, err)
+	}
+	return nil
 }
 
 // GetConfig returns the configuration
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/oota-sushikuitee/nigiri/internal/models/config"
+	"github.com/oota-sushikuitee/nigiri/internal/targets"
+)
+
+// Lint warning categories
+const (
+	// LintBinaryOnly warns about binary-only targets without a binary path
+	LintBinaryOnly = "binary-only"
+	// LintPortability warns about build commands that depend on the machine
+	LintPortability = "portability"
+	// LintDefaultBranch warns about targets without a default branch
+	LintDefaultBranch = "default-branch"
+	// LintSecret warns about secrets stored in plaintext
+	LintSecret = "secret"
+	// LintDuplicateSource warns about targets that build the same repository
+	LintDuplicateSource = "duplicate-source"
+)
+
+// LintWarning is an advisory finding about a target. Unlike the problems
+// reported by ValidateCfgFile, it does not stop the target from building.
+//
+// Fields:
+//   - Category: The kind of warning, one of the Lint* constants
+//   - Target: The name of the target the warning is about
+//   - Message: A description of the risk
+type LintWarning struct {
+	Category string
+	Target   string
+	Message  string
+}
+
+// secretEnvMarkers are the parts of environment variable names that mark
+// them as holding a secret
+var secretEnvMarkers = []string{"TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL", "API_KEY", "ACCESS_KEY", "PRIVATE_KEY"}
+
+// IsSecretEnvName reports whether the name of an environment variable looks
+// like it holds a secret
+//
+// Parameters:
+//   - name: The name of the environment variable
+//
+// Returns:
+//   - bool: True if the name contains a secret marker such as TOKEN, false otherwise
+func IsSecretEnvName(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, marker := range secretEnvMarkers {
+		if strings.Contains(upper, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// cdCommandPattern matches a cd command at the start of a build command or
+// after a command separator
+var cdCommandPattern = regexp.MustCompile(`(^|[;&|(]\s*)cd(\s|$)`)
+
+// absolutePathPattern matches a Unix or Windows absolute path
+var absolutePathPattern = regexp.MustCompile(`^(/[^/]|[A-Za-z]:[\\/]|\\\\)`)
+
+// LintConfig looks for risky settings in the targets of a configuration.
+// The configuration should be loaded with environment variable references
+// kept, so that secrets referenced from the environment are not reported.
+//
+// Parameters:
+//   - cfg: The configuration to check
+//
+// Returns:
+//   - []LintWarning: The warnings, sorted by target and category
+func LintConfig(cfg *config.Config) []LintWarning {
+	var warnings []LintWarning
+	sources := make(map[string][]string)
+	for name, target := range cfg.Targets {
+		warn := func(category, format string, v ...interface{}) {
+			warnings = append(warnings, LintWarning{Category: category, Target: name, Message: fmt.Sprintf(format, v...)})
+		}
+
+		bc := target.BuildCommand.WithDefaults(cfg.Defaults)
+		if target.BinaryOnly && bc.BinaryPathValue == "" {
+			warn(LintBinaryOnly, "binary-only is set without build-command.binary-path, so the binary may not be found and run will likely fail")
+		}
+		for _, platform := range SupportedPlatforms {
+			command := bc.Command(platform)
+			if cdCommandPattern.MatchString(command) {
+				warn(LintPortability, "the %s build command changes directory with cd; set working-directory instead", platform)
+			}
+			if path, ok := absolutePath(command); ok {
+				warn(LintPortability, "the %s build command uses the absolute path %s, which may not exist on other machines", platform, path)
+			}
+		}
+		if path, ok := absolutePath(bc.BinaryPathValue); ok {
+			warn(LintPortability, "build-command.binary-path %s is absolute; it should be relative to the source", path)
+		}
+		if target.DefaultBranch == "" {
+			warn(LintDefaultBranch, "no default-branch is set, so builds follow whatever branch the remote's HEAD points to")
+		}
+		for _, entry := range target.Env {
+			key, value, _ := strings.Cut(entry, "=")
+			if IsSecretEnvName(key) && value != "" && !strings.Contains(value, "$") {
+				warn(LintSecret, "env %s holds a secret in plaintext; reference an environment variable such as ${%s} instead", key, key)
+			}
+		}
+		if u, err := url.Parse(target.Sources); err == nil && u.User != nil && (u.Scheme == "http" || u.Scheme == "https") && !strings.Contains(u.User.String(), "$") {
+			warn(LintSecret, "the source URL contains credentials; use --use-token or a credential helper instead")
+		}
+		if target.Sources != "" {
+			key := targets.CloneCacheKey(target.Sources)
+			sources[key] = append(sources[key], name)
+		}
+	}
+
+	for _, names := range sources {
+		if len(names) < 2 {
+			continue
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			others := make([]string, 0, len(names)-1)
+			for _, other := range names {
+				if other != name {
+					others = append(others, other)
+				}
+			}
+			warnings = append(warnings, LintWarning{
+				Category: LintDuplicateSource,
+				Target:   name,
+				Message:  fmt.Sprintf("the source is also built by %s", strings.Join(others, ", ")),
+			})
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		if warnings[i].Target != warnings[j].Target {
+			return warnings[i].Target < warnings[j].Target
+		}
+		return warnings[i].Category < warnings[j].Category
+	})
+	return warnings
+}
+
+// absolutePath returns the first absolute path among the words of a command,
+// including the values of --flag=value words. /dev/null, which exists on
+// every Unix machine, is not reported.
+//
+// Parameters:
+//   - command: The command to check
+//
+// Returns:
+//   - string: The absolute path
+//   - bool: True if the command contains an absolute path, false otherwise
+func absolutePath(command string) (string, bool) {
+	for _, word := range strings.Fields(command) {
+		word = strings.Trim(word, `"'`)
+		if _, value, ok := strings.Cut(word, "="); ok {
+			word = strings.Trim(value, `"'`)
+		}
+		if absolutePathPattern.MatchString(word) && word != "/dev/null" {
+			return word, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,240 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// nodeSchema describes a mapping of the configuration file for saving it in
+// place: which of its keys nigiri manages, and how nested mappings are merged
+type nodeSchema struct {
+	// keys are the keys nigiri manages (nil manages every key); other keys
+	// are left as they are
+	keys []string
+	// aliases maps alternative spellings of a key to the key itself
+	aliases map[string]string
+	// fields are the schemas of nested mappings by key
+	fields map[string]*nodeSchema
+	// each is the schema of the value of every key, for mappings keyed by name
+	each *nodeSchema
+}
+
+// cfgSchema is the schema of the whole configuration file
+var cfgSchema = &nodeSchema{
+	keys: []string{"targets", "defaults"},
+	fields: map[string]*nodeSchema{
+		"targets": {each: &nodeSchema{
+			keys:    targetKeys,
+			aliases: map[string]string{"sources": "source"},
+			fields: map[string]*nodeSchema{
+				"build-command": {keys: buildCommandKeys},
+				"post-process":  {keys: SupportedPlatforms},
+			},
+		}},
+		"defaults": {keys: SupportedPlatforms},
+	},
+}
+
+// manages reports whether key is managed by nigiri
+func (s *nodeSchema) manages(key string) bool {
+	return s.keys == nil || slices.Contains(s.keys, key)
+}
+
+// child returns the schema of the nested mapping under key, or nil if its
+// value is replaced as a whole
+func (s *nodeSchema) child(key string) *nodeSchema {
+	if s.each != nil {
+		return s.each
+	}
+	return s.fields[key]
+}
+
+// leadingKeys lists the keys that come first, in this order, in the mappings
+// written for new entries; other keys follow alphabetically
+var leadingKeys = []string{"source", "default-branch", "build-command", "linux", "windows", "darwin", "binary-path"}
+
+// orderKeys reorders the mapping m and the mappings nested in it so that the
+// leading keys come first, which reads better than the alphabetical order
+// maps are encoded in
+//
+// Parameters:
+//   - m: The node to reorder in place
+func orderKeys(m *yaml.Node) {
+	for _, child := range m.Content {
+		orderKeys(child)
+	}
+	if m.Kind != yaml.MappingNode {
+		return
+	}
+	rank := func(key string) int {
+		if i := slices.Index(leadingKeys, key); i >= 0 {
+			return i
+		}
+		return len(leadingKeys)
+	}
+	pairs := make([][2]*yaml.Node, 0, len(m.Content)/2)
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		pairs = append(pairs, [2]*yaml.Node{m.Content[i], m.Content[i+1]})
+	}
+	slices.SortStableFunc(pairs, func(a, b [2]*yaml.Node) int {
+		return rank(a[0].Value) - rank(b[0].Value)
+	})
+	m.Content = m.Content[:0]
+	for _, pair := range pairs {
+		m.Content = append(m.Content, pair[0], pair[1])
+	}
+}
+
+// mergeMapping updates the mapping dst to hold the values of the mapping src.
+// Entries whose values did not change are kept as they are, including their
+// comments and style; changed values are replaced, new keys are appended and
+// managed keys missing from src are removed unless their value is empty,
+// which is equivalent to leaving them out.
+//
+// Parameters:
+//   - dst: The mapping read from the configuration file, updated in place
+//   - src: The mapping to save
+//   - schema: The schema of the mapping
+//
+// Returns:
+//   - bool: True if dst was changed, false otherwise
+func mergeMapping(dst, src *yaml.Node, schema *nodeSchema) bool {
+	changed := false
+	for i := 0; i < len(dst.Content); i += 2 {
+		key, value := dst.Content[i].Value, dst.Content[i+1]
+		if canonical, ok := schema.aliases[key]; ok {
+			key = canonical
+		}
+		if !schema.manages(key) || mappingIndex(src, key) >= 0 || isZeroNode(value) {
+			continue
+		}
+		dst.Content = slices.Delete(dst.Content, i, i+2)
+		i -= 2
+		changed = true
+	}
+
+	for i := 0; i < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		j := mappingIndex(dst, key.Value)
+		for alias, canonical := range schema.aliases {
+			if j < 0 && canonical == key.Value {
+				j = mappingIndex(dst, alias)
+			}
+		}
+		if j < 0 {
+			dst.Content = append(dst.Content, key, value)
+			changed = true
+			continue
+		}
+
+		current := dst.Content[j+1]
+		if child := schema.child(key.Value); child != nil && current.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			if mergeMapping(current, value, child) {
+				changed = true
+			}
+			continue
+		}
+		if nodesEqual(current, value) {
+			continue
+		}
+		value.HeadComment, value.LineComment, value.FootComment = current.HeadComment, current.LineComment, current.FootComment
+		dst.Content[j+1] = value
+		changed = true
+	}
+	return changed
+}
+
+// mappingIndex returns the index of key in the content of the mapping m
+//
+// Parameters:
+//   - m: The mapping node
+//   - key: The key to look up
+//
+// Returns:
+//   - int: The index of the key node, or -1 if m has no such key
+func mappingIndex(m *yaml.Node, key string) int {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// nodesEqual reports whether two nodes hold the same values, regardless of
+// their style and comments. Scalars are compared by their text, so that e.g.
+// 0 and "0" are equal.
+//
+// Parameters:
+//   - a: The first node
+//   - b: The second node
+//
+// Returns:
+//   - bool: True if the nodes hold the same values, false otherwise
+func nodesEqual(a, b *yaml.Node) bool {
+	for a.Kind == yaml.AliasNode && a.Alias != nil {
+		a = a.Alias
+	}
+	for b.Kind == yaml.AliasNode && b.Alias != nil {
+		b = b.Alias
+	}
+	if a.Kind != b.Kind || len(a.Content) != len(b.Content) {
+		return false
+	}
+	if a.Kind == yaml.ScalarNode {
+		return a.Value == b.Value
+	}
+	for i := range a.Content {
+		if !nodesEqual(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isZeroNode reports whether a node holds an empty value: null, an empty
+// string, false, or an empty sequence or mapping
+//
+// Parameters:
+//   - n: The node to check
+//
+// Returns:
+//   - bool: True if the value is empty, false otherwise
+func isZeroNode(n *yaml.Node) bool {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		tag := n.ShortTag()
+		return tag == "!!null" || (tag == "!!str" && n.Value == "") || (tag == "!!bool" && strings.EqualFold(n.Value, "false"))
+	case yaml.SequenceNode, yaml.MappingNode:
+		return len(n.Content) == 0
+	}
+	return false
+}
+
+// detectIndent returns the indentation of the first indented line of a YAML
+// file, so that a rewritten file keeps it
+//
+// Parameters:
+//   - data: The contents of the file
+//
+// Returns:
+//   - int: The number of spaces per indentation level (2 if none is found)
+func detectIndent(data []byte) int {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == line || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if indent := len(line) - len(trimmed); indent >= 2 {
+			return indent
+		}
+		return 2
+	}
+	return 2
+}
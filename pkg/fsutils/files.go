@@ -1,6 +1,10 @@
 package fsutils
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
 	"os"
 	"path/filepath"
 )
@@ -58,3 +62,59 @@ func RemoveIfExists(path string) error {
 	}
 	return os.RemoveAll(path)
 }
+
+// TailLines returns the last n lines of a file
+//
+// Parameters:
+//   - path: The path to the file to read
+//   - n: The maximum number of lines to return
+//
+// Returns:
+//   - []string: The last n lines of the file, oldest first
+//   - error: Any error encountered during the process
+func TailLines(path string, n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := make([]string, 0, n)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(lines) == n {
+			lines = lines[1:]
+		}
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// SHA256File computes the SHA-256 checksum of a file
+//
+// Parameters:
+//   - path: The path to the file to hash
+//
+// Returns:
+//   - string: The hex-encoded SHA-256 checksum
+//   - error: Any error encountered during the process
+func SHA256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
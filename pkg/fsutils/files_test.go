@@ -122,3 +122,66 @@ func TestRemoveIfExists(t *testing.T) {
 		t.Errorf("Directory still exists")
 	}
 }
+
+func TestTailLines(t *testing.T) {
+	testDir := setupTestDir(t)
+	defer cleanupTestDir(t, testDir)
+
+	path := filepath.Join(testDir, "build.log")
+	if err := os.WriteFile(path, []byte("one\ntwo\nthree\nfour\n"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		n    int
+		want []string
+	}{
+		{name: "fewer lines than file", n: 2, want: []string{"three", "four"}},
+		{name: "more lines than file", n: 10, want: []string{"one", "two", "three", "four"}},
+		{name: "zero lines", n: 0, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TailLines(path, tt.n)
+			if err != nil {
+				t.Fatalf("TailLines() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("TailLines() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("TailLines()[%d] = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	if _, err := TailLines(filepath.Join(testDir, "missing.log"), 1); err == nil {
+		t.Errorf("TailLines() on missing file should fail")
+	}
+}
+
+func TestSHA256File(t *testing.T) {
+	testDir := setupTestDir(t)
+	defer cleanupTestDir(t, testDir)
+
+	path := filepath.Join(testDir, "go.sum")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	got, err := SHA256File(path)
+	if err != nil {
+		t.Fatalf("SHA256File() error = %v", err)
+	}
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if got != want {
+		t.Errorf("SHA256File() = %q, want %q", got, want)
+	}
+
+	if _, err := SHA256File(filepath.Join(testDir, "missing")); err == nil {
+		t.Errorf("SHA256File() on missing file should fail")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"time"
 )
 
 // LogLevel represents the severity of a log message
@@ -23,6 +25,32 @@ const (
 	FatalLevel
 )
 
+// levelNames maps the names accepted by ParseLevel to their levels
+var levelNames = map[string]LogLevel{
+	"debug":   DebugLevel,
+	"info":    InfoLevel,
+	"warn":    WarnLevel,
+	"warning": WarnLevel,
+	"error":   ErrorLevel,
+}
+
+// ParseLevel converts the name of a log level, such as "debug" or "warn",
+// into its LogLevel. Names are case-insensitive.
+//
+// Parameters:
+//   - name: The name of the level: debug, info, warn (or warning) or error
+//
+// Returns:
+//   - LogLevel: The parsed level
+//   - error: An error if the name is not a known level
+func ParseLevel(name string) (LogLevel, error) {
+	level, ok := levelNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return InfoLevel, fmt.Errorf("unknown log level %q: expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
 var (
 	// Default output is stderr
 	defaultOutput io.Writer = os.Stderr
@@ -30,8 +58,29 @@ var (
 	defaultLevel = InfoLevel
 	// Whether to include log level prefix in output
 	showPrefix = true
+	// Whether to start each message with an RFC3339 timestamp
+	showTimestamp = false
+	// now returns the time written in timestamps, replaced in tests
+	now = time.Now
+)
+
+// ANSI escape sequences used to color the level prefixes
+const (
+	colorReset   = "\033[0m"
+	colorRed     = "\033[31m"
+	colorYellow  = "\033[33m"
+	colorMagenta = "\033[35m"
+	colorGray    = "\033[90m"
 )
 
+// prefixColors maps the level prefixes to their color
+var prefixColors = map[string]string{
+	"DEBUG: ":   colorGray,
+	"WARNING: ": colorYellow,
+	"ERROR: ":   colorRed,
+	"FATAL: ":   colorMagenta,
+}
+
 // SetOutput changes the output destination for the logger
 func SetOutput(w io.Writer) {
 	defaultOutput = w
@@ -47,6 +96,11 @@ func SetShowPrefix(show bool) {
 	showPrefix = show
 }
 
+// SetShowTimestamp controls whether log messages start with an RFC3339 timestamp
+func SetShowTimestamp(show bool) {
+	showTimestamp = show
+}
+
 // Debug logs a debug message
 func Debug(v ...interface{}) {
 	if defaultLevel <= DebugLevel {
@@ -129,18 +183,72 @@ func Fatalf(format string, v ...interface{}) {
 
 // logWithPrefix logs a message with an optional prefix
 func logWithPrefix(prefix string, v ...interface{}) {
-	if showPrefix {
-		fmt.Fprint(defaultOutput, prefix)
-	}
-	fmt.Fprintln(defaultOutput, v...)
+	// Each line is written at once so that lines logged concurrently, e.g.
+	// by parallel builds, do not interleave
+	_, _ = io.WriteString(defaultOutput, header(prefix)+fmt.Sprintln(v...))
 }
 
 // logfWithPrefix logs a formatted message with an optional prefix
 func logfWithPrefix(prefix string, format string, v ...interface{}) {
-	if showPrefix {
-		fmt.Fprint(defaultOutput, prefix)
+	_, _ = io.WriteString(defaultOutput, header(prefix)+fmt.Sprintf(format+"\n", v...))
+}
+
+// header returns what is written before a message: the timestamp when it is
+// enabled, followed by the level prefix when prefixes are shown. The prefix
+// is colored when the output is a terminal and NO_COLOR is empty or unset.
+//
+// Parameters:
+//   - prefix: The level prefix of the message, e.g. "WARNING: "
+//
+// Returns:
+//   - string: The header of the message
+func header(prefix string) string {
+	h := ""
+	if showTimestamp {
+		h = now().Format(time.RFC3339) + " "
+	}
+	if !showPrefix {
+		return h
+	}
+	if code, ok := prefixColors[prefix]; ok && UseColor(defaultOutput) {
+		return h + code + prefix[:len(prefix)-1] + colorReset + " "
+	}
+	return h + prefix
+}
+
+// UseColor reports whether output written to w may be colored: w must be a
+// terminal and the NO_COLOR environment variable must be empty or unset, as
+// the NO_COLOR convention only disables color for a non-empty value
+//
+// Parameters:
+//   - w: The writer the output goes to
+//
+// Returns:
+//   - bool: True if the output may be colored, false otherwise
+func UseColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return IsTerminal(w)
+}
+
+// IsTerminal reports whether w is a character device such as a terminal
+//
+// Parameters:
+//   - w: The writer to check
+//
+// Returns:
+//   - bool: True if w is a terminal, false otherwise
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
 	}
-	fmt.Fprintf(defaultOutput, format+"\n", v...)
+	return info.Mode()&os.ModeCharDevice != 0
 }
 
 // CreateErrorf creates an error with a formatted message
@@ -2,8 +2,11 @@ package logger
 
 import (
 	"bytes"
+	"fmt"
+	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestSetOutput verifies that SetOutput correctly changes where logs are written
@@ -88,14 +91,17 @@ func TestSetLevel(t *testing.T) {
 	}
 }
 
-// TestLogFunctions verifies formatted log functions work correctly
+// TestLogFunctions verifies formatted log functions work correctly, with
+// and without timestamps
 func TestLogFunctions(t *testing.T) {
 	// Save original settings
 	originalLevel := defaultLevel
 	originalOutput := defaultOutput
+	originalTimestamp := showTimestamp
 	defer func() {
 		defaultLevel = originalLevel
 		defaultOutput = originalOutput
+		showTimestamp = originalTimestamp
 	}()
 
 	SetLevel(DebugLevel)
@@ -129,12 +135,175 @@ func TestLogFunctions(t *testing.T) {
 		},
 	}
 
+	for _, timestamp := range []bool{false, true} {
+		SetShowTimestamp(timestamp)
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s/timestamp=%t", tt.name, timestamp), func(t *testing.T) {
+				buf.Reset()
+				tt.logFunc()
+				// The buffer is not a terminal, so the output is never colored
+				if !strings.HasSuffix(buf.String(), tt.expected+"\n") {
+					t.Errorf("Expected log to end with %q, got %q", tt.expected, buf.String())
+				}
+				if strings.Contains(buf.String(), "\033[") {
+					t.Errorf("Expected plain output, got %q", buf.String())
+				}
+			})
+		}
+	}
+}
+
+// TestSetShowTimestamp verifies that messages start with an RFC3339
+// timestamp only when timestamps are enabled
+func TestSetShowTimestamp(t *testing.T) {
+	originalOutput := defaultOutput
+	originalTimestamp := showTimestamp
+	originalNow := now
+	defer func() {
+		defaultOutput = originalOutput
+		showTimestamp = originalTimestamp
+		now = originalNow
+	}()
+	now = func() time.Time { return time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC) }
+
+	var buf bytes.Buffer
+	SetOutput(&buf)
+
+	SetShowTimestamp(true)
+	Warn("disk almost full")
+	if got, want := buf.String(), "2024-05-01T12:30:00Z WARNING: disk almost full\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	buf.Reset()
+	SetShowTimestamp(false)
+	Warn("disk almost full")
+	if got, want := buf.String(), "WARNING: disk almost full\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestHeaderColor verifies that level prefixes are colored on a character
+// device unless NO_COLOR is set
+func TestHeaderColor(t *testing.T) {
+	originalOutput := defaultOutput
+	defer func() { defaultOutput = originalOutput }()
+
+	// /dev/null is a character device, like a terminal
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("cannot open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+	SetOutput(devNull)
+	// t.Setenv restores NO_COLOR when the test ends
+	t.Setenv("NO_COLOR", "")
+	os.Unsetenv("NO_COLOR")
+
+	if got, want := header("ERROR: "), colorRed+"ERROR:"+colorReset+" "; got != want {
+		t.Errorf("Expected colored prefix %q, got %q", want, got)
+	}
+	if got := header(""); got != "" {
+		t.Errorf("Expected no header for info messages, got %q", got)
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if got := header("ERROR: "); got != "ERROR: " {
+		t.Errorf("Expected plain prefix with NO_COLOR set, got %q", got)
+	}
+}
+
+// TestUseColor verifies that only terminals without NO_COLOR are colored
+func TestUseColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	os.Unsetenv("NO_COLOR")
+	if UseColor(&bytes.Buffer{}) {
+		t.Error("Expected a buffer not to be colored")
+	}
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("cannot open %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+	if !IsTerminal(devNull) || !UseColor(devNull) {
+		t.Errorf("Expected %s to be a terminal that may be colored", os.DevNull)
+	}
+	// An empty NO_COLOR does not disable color
+	t.Setenv("NO_COLOR", "")
+	if !UseColor(devNull) {
+		t.Error("Expected color with NO_COLOR set to an empty value")
+	}
+	t.Setenv("NO_COLOR", "1")
+	if UseColor(devNull) {
+		t.Error("Expected no color with NO_COLOR set")
+	}
+}
+
+// countingWriter counts the writes made to it
+type countingWriter struct {
+	buf    bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.buf.Write(p)
+}
+
+// TestLogWritesLineOnce verifies that a log line and its header are written
+// in a single write, so that concurrent log lines do not interleave
+func TestLogWritesLineOnce(t *testing.T) {
+	originalOutput := defaultOutput
+	originalLevel := defaultLevel
+	defer func() {
+		defaultOutput = originalOutput
+		defaultLevel = originalLevel
+	}()
+	var out countingWriter
+	SetOutput(&out)
+	SetLevel(DebugLevel)
+
+	Warn("disk", "almost full")
+	Warnf("disk %d%% full", 95)
+	if out.writes != 2 {
+		t.Errorf("Expected 2 writes for 2 log lines, got %d", out.writes)
+	}
+	if got, want := out.buf.String(), "WARNING: disk almost full\nWARNING: disk 95% full\n"; got != want {
+		t.Errorf("Expected output %q, got %q", want, got)
+	}
+}
+
+// TestParseLevel verifies that level names are parsed case-insensitively
+// and that unknown names are rejected
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    LogLevel
+		wantErr bool
+	}{
+		{name: "debug", want: DebugLevel},
+		{name: "INFO", want: InfoLevel},
+		{name: "warn", want: WarnLevel},
+		{name: "warning", want: WarnLevel},
+		{name: " error ", want: ErrorLevel},
+		{name: "fatal", wantErr: true},
+		{name: "", wantErr: true},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			buf.Reset()
-			tt.logFunc()
-			if !strings.Contains(buf.String(), tt.expected) {
-				t.Errorf("Expected log to contain %q, got %q", tt.expected, buf.String())
+			got, err := ParseLevel(tt.name)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseLevel(%q) should fail", tt.name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLevel(%q) failed: %v", tt.name, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel(%q) = %s, want %s", tt.name, levelToString(got), levelToString(tt.want))
 			}
 		})
 	}
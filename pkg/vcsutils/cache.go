@@ -0,0 +1,176 @@
+package vcsutils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// cacheRefSpecs are the refs kept in a clone cache: every branch and tag of
+// the source, under the same names
+var cacheRefSpecs = []config.RefSpec{
+	"+refs/heads/*:refs/heads/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// cacheLocks serializes the use of each cache directory within the process,
+// so that concurrent builds of the same source do not fetch into one cache
+// at the same time
+var cacheLocks sync.Map
+
+// lockCache locks the cache directory cacheDir for the process
+//
+// Parameters:
+//   - cacheDir: The cache directory
+//
+// Returns:
+//   - func(): A function that unlocks the cache directory
+func lockCache(cacheDir string) func() {
+	mu, _ := cacheLocks.LoadOrStore(cacheDir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// CloneFromCache clones the repository to cloneDir through the bare mirror
+// in opts.CacheDir. The mirror is created on first use and fetched from the
+// source every time, so a stale mirror catches up before the clone; the
+// clone itself is then made locally from the mirror, with its full history.
+// Its origin remote points to the source, so later fetches, such as those of
+// Checkout, go to the source. opts.Depth does not apply to cached clones.
+// Submodules are cloned from their own remotes, once the origin remote
+// points to the source so that relative submodule URLs resolve against it.
+//
+// Parameters:
+//   - cloneDir: The directory to clone the repository into
+//   - opts: Additional options for cloning; CacheDir must be set
+//
+// Returns:
+//   - error: Any error encountered while updating the cache or cloning from it
+func (g *Git) CloneFromCache(cloneDir string, opts Options) error {
+	if opts.CacheDir == "" {
+		return fmt.Errorf("no cache directory given")
+	}
+	g.UseRetries(opts)
+	g.unshallow = opts.UnshallowIfNeeded
+	g.submodules = opts.RecurseSubmodules
+	unlock := lockCache(opts.CacheDir)
+	defer unlock()
+
+	if err := g.updateCache(opts); err != nil {
+		return err
+	}
+
+	cloneOpts := &git.CloneOptions{
+		URL:          opts.CacheDir,
+		SingleBranch: opts.SingleBranch,
+	}
+	if opts.Tag != "" {
+		cloneOpts.ReferenceName = plumbing.NewTagReferenceName(opts.Tag)
+	} else if opts.Branch != "" {
+		cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(opts.Branch)
+	}
+	if opts.Verbose {
+		progress := newScrubWriter(os.Stdout)
+		defer progress.Close()
+		cloneOpts.Progress = progress
+	}
+	r, err := git.PlainClone(cloneDir, false, cloneOpts)
+	if err != nil {
+		return scrubError(fmt.Errorf("git clone from cache failed: %w", err))
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read clone configuration: %w", err)
+	}
+	if remote, ok := cfg.Remotes[git.DefaultRemoteName]; ok {
+		remote.URLs = []string{g.Source}
+		if err := r.SetConfig(cfg); err != nil {
+			return fmt.Errorf("failed to point origin at the source: %w", err)
+		}
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := g.updateSubmodules(w); err != nil {
+		return err
+	}
+
+	ref, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD reference: %w", err)
+	}
+	g.HEAD = ref.Hash().String()
+	return nil
+}
+
+// updateCache creates the bare mirror in opts.CacheDir if it does not exist
+// yet, and fetches every branch and tag of the source into it
+//
+// Parameters:
+//   - opts: The clone options holding the cache directory and authentication
+//
+// Returns:
+//   - error: Any error encountered while creating or fetching the mirror
+func (g *Git) updateCache(opts Options) error {
+	if err := g.setupAuth(opts); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(opts.CacheDir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory %s: %w", opts.CacheDir, err)
+	}
+
+	r, err := git.PlainOpen(opts.CacheDir)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		r, err = git.PlainInit(opts.CacheDir, true)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open cache %s: %w", opts.CacheDir, err)
+	}
+
+	// The source may be spelled differently than when the cache was created,
+	// e.g. over SSH instead of HTTPS, so the remote is always updated
+	cfg, err := r.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read cache configuration: %w", err)
+	}
+	cfg.Remotes[git.DefaultRemoteName] = &config.RemoteConfig{
+		Name:  git.DefaultRemoteName,
+		URLs:  []string{g.Source},
+		Fetch: cacheRefSpecs,
+	}
+	if err := r.SetConfig(cfg); err != nil {
+		return fmt.Errorf("failed to write cache configuration: %w", err)
+	}
+
+	fetchOpts := &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   cacheRefSpecs,
+		Tags:       git.NoTags,
+		Force:      true,
+	}
+	if opts.Verbose {
+		progress := newScrubWriter(os.Stdout)
+		defer progress.Close()
+		fetchOpts.Progress = progress
+	}
+	if err := g.withRetries("updating the clone cache", func() error { return g.fetch(r, fetchOpts) }); err != nil {
+		return fmt.Errorf("failed to update cache: %w", err)
+	}
+
+	// A bare mirror's HEAD follows the default branch of the source, so that
+	// clones from it check out the same branch as clones from the source
+	if refs, err := g.listRemoteRefs(); err == nil {
+		if branch, ok := defaultBranchFromRefs(refs); ok {
+			_ = r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package vcsutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestCloneFromCache(t *testing.T) {
+	repoDir, _, second := initTestRepo(t)
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+
+	clone := func(opts Options) (*Git, string) {
+		t.Helper()
+		g := &Git{Source: repoDir}
+		cloneDir := filepath.Join(t.TempDir(), "clone")
+		opts.CacheDir = cacheDir
+		if err := g.Clone(cloneDir, opts); err != nil {
+			t.Fatalf("Clone() through the cache failed: %v", err)
+		}
+		return g, cloneDir
+	}
+
+	g, cloneDir := clone(Options{})
+	if g.HEAD != second {
+		t.Errorf("HEAD = %s, want %s", g.HEAD, second)
+	}
+	if _, err := git.PlainOpen(cacheDir); err != nil {
+		t.Fatalf("cache was not created: %v", err)
+	}
+	r, err := git.PlainOpen(cloneDir)
+	if err != nil {
+		t.Fatalf("failed to open clone: %v", err)
+	}
+	remote, err := r.Remote(git.DefaultRemoteName)
+	if err != nil {
+		t.Fatalf("clone has no origin remote: %v", err)
+	}
+	if urls := remote.Config().URLs; len(urls) != 1 || urls[0] != repoDir {
+		t.Errorf("origin URLs = %v, want [%s]", urls, repoDir)
+	}
+
+	// A commit pushed after the cache was created is fetched into the stale
+	// cache before the next clone
+	source, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open source: %v", err)
+	}
+	w, err := source.Worktree()
+	if err != nil {
+		t.Fatalf("failed to get worktree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "file.txt"), []byte("third"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if _, err := w.Add("file.txt"); err != nil {
+		t.Fatalf("failed to add file: %v", err)
+	}
+	third, err := w.Commit("third", &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	g, cloneDir = clone(Options{Branch: "master", SingleBranch: true})
+	if g.HEAD != third.String() {
+		t.Errorf("HEAD after the source changed = %s, want %s", g.HEAD, third)
+	}
+	content, err := os.ReadFile(filepath.Join(cloneDir, "file.txt"))
+	if err != nil {
+		t.Fatalf("failed to read checked out file: %v", err)
+	}
+	if string(content) != "third" {
+		t.Errorf("checked out content = %q, want %q", content, "third")
+	}
+}
+
+func TestCloneFromCacheRequiresCacheDir(t *testing.T) {
+	g := &Git{Source: t.TempDir()}
+	if err := g.CloneFromCache(filepath.Join(t.TempDir(), "clone"), Options{}); err == nil {
+		t.Errorf("CloneFromCache() without a cache directory should fail")
+	}
+}
@@ -0,0 +1,265 @@
+package vcsutils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// defaultTokenHost is the host tokens are looked up for when a source has no
+// host, such as a local path
+const defaultTokenHost = "github.com"
+
+// CredentialsFileName is the name of the credentials file in the nigiri root
+const CredentialsFileName = "credentials"
+
+// CredentialsFile is the credentials file managed by nigiri that tokens are
+// looked up in (empty skips it). Commands point it into the nigiri root.
+//
+// Each line holds a host and the token for it, separated by whitespace;
+// empty lines and lines starting with # are ignored:
+//
+//	github.com ghp_xxxxxxxxxxxx
+var CredentialsFile string
+
+// tokenUsername is the user name a token is sent with when its source names
+// none; GitHub accepts any user name along with a token
+const tokenUsername = "x-access-token"
+
+// ghAuthToken asks the gh CLI for its token for host; tests replace it so
+// that they do not depend on the gh CLI being installed and logged in
+var ghAuthToken = func(host string) (string, error) {
+	output, err := exec.CommandContext(context.Background(), "gh", "auth", "token", "--hostname", host).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// TokenHost returns the host a token is looked up for to access source
+//
+// Parameters:
+//   - source: The source repository URL
+//
+// Returns:
+//   - string: The lower-case host, or github.com if the source has none
+func TokenHost(source string) string {
+	endpoint, err := transport.NewEndpoint(source)
+	if err != nil || endpoint.Protocol == "file" || endpoint.Host == "" {
+		return defaultTokenHost
+	}
+	return strings.ToLower(endpoint.Host)
+}
+
+// netrcPath returns the path of the netrc file: $NETRC if set, otherwise
+// ~/.netrc (~/_netrc on Windows), as git and the go command use
+//
+// Returns:
+//   - string: The path of the netrc file, or empty if it cannot be determined
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(home, name)
+}
+
+// netrcCredentials looks up the login and password of host in a netrc file.
+// The entry of the first "machine" matching host is used, or the "default"
+// entry if no machine matches; macro definitions are skipped.
+//
+// Parameters:
+//   - path: The path of the netrc file
+//   - host: The host to look up
+//
+// Returns:
+//   - string: The login of the host, or empty if the entry has none
+//   - string: The password of the host, or empty if the file has none
+func netrcCredentials(path, host string) (string, string) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", ""
+	}
+	defer func() { _ = file.Close() }()
+
+	var words []string
+	inMacro := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// A macro definition ends at the first empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if field == "macdef" {
+				fields = fields[:i]
+				inMacro = true
+				break
+			}
+		}
+		words = append(words, fields...)
+	}
+
+	// Each entry starts at a machine or default keyword
+	type entry struct {
+		machine   string
+		isDefault bool
+		login     string
+		password  string
+	}
+	var entries []*entry
+	for i := 0; i < len(words); i++ {
+		switch words[i] {
+		case "machine":
+			entries = append(entries, &entry{})
+			if i+1 < len(words) {
+				i++
+				entries[len(entries)-1].machine = words[i]
+			}
+		case "default":
+			entries = append(entries, &entry{isDefault: true})
+		case "password", "login", "account":
+			if i+1 < len(words) {
+				i++
+				if len(entries) == 0 {
+					continue
+				}
+				switch words[i-1] {
+				case "password":
+					entries[len(entries)-1].password = words[i]
+				case "login":
+					entries[len(entries)-1].login = words[i]
+				}
+			}
+		}
+	}
+
+	var fallback *entry
+	for _, e := range entries {
+		if !e.isDefault && strings.EqualFold(e.machine, host) {
+			return e.login, e.password
+		}
+		if e.isDefault && fallback == nil {
+			fallback = e
+		}
+	}
+	if fallback == nil {
+		return "", ""
+	}
+	return fallback.login, fallback.password
+}
+
+// credentialsFileToken looks up the token of host in a credentials file
+// written in the format described at CredentialsFile
+//
+// Parameters:
+//   - path: The path of the credentials file
+//   - host: The host to look up
+//
+// Returns:
+//   - string: The token of the host, or empty if the file has none
+func credentialsFileToken(path, host string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if strings.EqualFold(fields[0], host) {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+// ResolveGitHubToken looks up the token for host like ResolveCredentials,
+// without the user name
+//
+// Parameters:
+//   - host: The host of the repository the token is for
+//
+// Returns:
+//   - string: The token
+//   - error: An error listing the sources that were tried if none has a token
+func ResolveGitHubToken(host string) (string, error) {
+	_, token, err := ResolveCredentials(host)
+	return token, err
+}
+
+// ResolveCredentials looks up the token for host in the GITHUB_TOKEN
+// environment variable (for github.com only), then in the netrc file, then
+// in the nigiri credentials file and finally with the gh CLI. Only a netrc
+// entry names the user name the token is sent with; the others are sent
+// with x-access-token.
+//
+// Parameters:
+//   - host: The host of the repository the token is for
+//
+// Returns:
+//   - string: The user name to send the token with
+//   - string: The token
+//   - error: An error listing the sources that were tried if none has a token
+func ResolveCredentials(host string) (string, string, error) {
+	var tried []string
+	if host == defaultTokenHost {
+		if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+			return tokenUsername, token, nil
+		}
+		tried = append(tried, "the GITHUB_TOKEN environment variable")
+	}
+
+	if path := netrcPath(); path != "" {
+		if login, token := netrcCredentials(path, host); token != "" {
+			if login == "" {
+				login = tokenUsername
+			}
+			return login, token, nil
+		}
+		tried = append(tried, path)
+	}
+	if CredentialsFile != "" {
+		if token := credentialsFileToken(CredentialsFile, host); token != "" {
+			return tokenUsername, token, nil
+		}
+		tried = append(tried, CredentialsFile)
+	}
+	if token, err := ghAuthToken(host); err == nil && token != "" {
+		return tokenUsername, token, nil
+	}
+	tried = append(tried, fmt.Sprintf("'gh auth token --hostname %s'", host))
+
+	hint := "Set GITHUB_TOKEN, add"
+	if host != defaultTokenHost {
+		hint = "Add"
+	}
+	return "", "", fmt.Errorf("no token found for %s; tried %s. %s %s to your netrc file or log in with 'gh auth login --hostname %s'",
+		host, joinTried(tried), hint, host, host)
+}
+
+// joinTried joins the sources a token was looked up in as an English list
+func joinTried(tried []string) string {
+	if len(tried) < 2 {
+		return strings.Join(tried, "")
+	}
+	return strings.Join(tried[:len(tried)-1], ", ") + " and " + tried[len(tried)-1]
+}
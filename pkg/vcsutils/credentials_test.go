@@ -0,0 +1,189 @@
+package vcsutils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokenHost(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{source: "https://github.com/owner/repo", want: "github.com"},
+		{source: "https://GitHub.example.com/owner/repo.git", want: "github.example.com"},
+		{source: "git@gitlab.com:owner/repo.git", want: "gitlab.com"},
+		{source: "/path/to/repo", want: "github.com"},
+	}
+	for _, tt := range tests {
+		if got := TokenHost(tt.source); got != tt.want {
+			t.Errorf("TokenHost(%q) = %q, want %q", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestNetrcCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netrc")
+	netrc := `machine placeholder.invalid password unused
+machine example.com login me password other
+machine GitHub.com
+  login x-access-token
+  password ghp_netrc
+
+macdef init
+machine github.com password macro
+
+default login anonymous password fallback
+`
+	if err := os.WriteFile(path, []byte(netrc), 0600); err != nil {
+		t.Fatalf("failed to write netrc: %v", err)
+	}
+
+	tests := []struct {
+		host      string
+		wantLogin string
+		want      string
+	}{
+		{host: "github.com", wantLogin: "x-access-token", want: "ghp_netrc"},
+		{host: "example.com", wantLogin: "me", want: "other"},
+		{host: "gitlab.com", wantLogin: "anonymous", want: "fallback"},
+	}
+	for _, tt := range tests {
+		if login, got := netrcCredentials(path, tt.host); login != tt.wantLogin || got != tt.want {
+			t.Errorf("netrcCredentials(%q) = %q, %q, want %q, %q", tt.host, login, got, tt.wantLogin, tt.want)
+		}
+	}
+	if login, got := netrcCredentials(filepath.Join(t.TempDir(), "missing"), "github.com"); login != "" || got != "" {
+		t.Errorf("netrcCredentials() of a missing file = %q, %q, want empty", login, got)
+	}
+}
+
+func TestCredentialsFileToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), CredentialsFileName)
+	content := "# nigiri credentials\n\ngithub.com ghp_file\ngitlab.com glpat_file\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write credentials: %v", err)
+	}
+	if got := credentialsFileToken(path, "GitHub.com"); got != "ghp_file" {
+		t.Errorf("credentialsFileToken(github.com) = %q, want %q", got, "ghp_file")
+	}
+	if got := credentialsFileToken(path, "example.com"); got != "" {
+		t.Errorf("credentialsFileToken(example.com) = %q, want empty", got)
+	}
+}
+
+func TestResolveGitHubToken(t *testing.T) {
+	dir := t.TempDir()
+	netrc := filepath.Join(dir, "netrc")
+	credentials := filepath.Join(dir, CredentialsFileName)
+	if err := os.WriteFile(netrc, []byte("machine github.com password from-netrc\nmachine git.example.com login me password from-netrc\n"), 0600); err != nil {
+		t.Fatalf("failed to write netrc: %v", err)
+	}
+	if err := os.WriteFile(credentials, []byte("github.com from-file\ngitlab.com from-file\n"), 0600); err != nil {
+		t.Fatalf("failed to write credentials: %v", err)
+	}
+
+	origFile, origGh := CredentialsFile, ghAuthToken
+	defer func() { CredentialsFile, ghAuthToken = origFile, origGh }()
+	CredentialsFile = credentials
+	t.Setenv("NETRC", netrc)
+
+	tests := []struct {
+		name      string
+		env       string
+		host      string
+		gh        string
+		wantLogin string
+		want      string
+		wantErr   bool
+	}{
+		{name: "environment first", env: "from-env", host: "github.com", gh: "from-gh", wantLogin: "x-access-token", want: "from-env"},
+		{name: "netrc before the credentials file", host: "github.com", gh: "from-gh", wantLogin: "x-access-token", want: "from-netrc"},
+		{name: "netrc login", env: "from-env", host: "git.example.com", gh: "from-gh", wantLogin: "me", want: "from-netrc"},
+		{name: "credentials file before gh", env: "from-env", host: "gitlab.com", gh: "from-gh", wantLogin: "x-access-token", want: "from-file"},
+		{name: "gh last", env: "from-env", host: "example.com", gh: "from-gh", wantLogin: "x-access-token", want: "from-gh"},
+		{name: "nothing found", env: "from-env", host: "example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GITHUB_TOKEN", tt.env)
+			var ghHost string
+			ghAuthToken = func(host string) (string, error) {
+				ghHost = host
+				if tt.gh == "" {
+					return "", errors.New("gh not found")
+				}
+				return tt.gh, nil
+			}
+			login, got, err := ResolveCredentials(tt.host)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ResolveCredentials() = %q, want an error", got)
+				}
+				for _, tried := range []string{netrc, credentials, "gh auth token --hostname example.com"} {
+					if !strings.Contains(err.Error(), tried) {
+						t.Errorf("error %q does not mention %s", err, tried)
+					}
+				}
+				// GITHUB_TOKEN is a github.com token, so it is not tried for other hosts
+				if strings.Contains(err.Error(), "GITHUB_TOKEN") {
+					t.Errorf("error %q mentions GITHUB_TOKEN for %s", err, tt.host)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveCredentials() error = %v", err)
+			}
+			if login != tt.wantLogin || got != tt.want {
+				t.Errorf("ResolveCredentials() = %q, %q, want %q, %q", login, got, tt.wantLogin, tt.want)
+			}
+			if ghHost != "" && ghHost != tt.host {
+				t.Errorf("gh asked for the token of %q, want %q", ghHost, tt.host)
+			}
+		})
+	}
+
+	// GITHUB_TOKEN is suggested when no token is found for github.com
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("NETRC", filepath.Join(dir, "missing"))
+	CredentialsFile = ""
+	ghAuthToken = func(string) (string, error) { return "", errors.New("gh not found") }
+	if _, err := ResolveGitHubToken("github.com"); err == nil || !strings.Contains(err.Error(), "GITHUB_TOKEN") {
+		t.Errorf("ResolveGitHubToken(github.com) error = %v, want it to mention GITHUB_TOKEN", err)
+	}
+}
+
+func TestTokenCachePerHost(t *testing.T) {
+	origFile, origGh := CredentialsFile, ghAuthToken
+	defer func() { CredentialsFile, ghAuthToken = origFile, origGh }()
+	CredentialsFile = filepath.Join(t.TempDir(), CredentialsFileName)
+	if err := os.WriteFile(CredentialsFile, []byte("github.com ghp\ngitlab.com glpat\n"), 0600); err != nil {
+		t.Fatalf("failed to write credentials: %v", err)
+	}
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("NETRC", filepath.Join(t.TempDir(), "missing"))
+	ghCalls := 0
+	ghAuthToken = func(string) (string, error) {
+		ghCalls++
+		return "", errors.New("gh not found")
+	}
+
+	tokens := &TokenCache{}
+	for i := 0; i < 2; i++ {
+		if got, err := (&Git{Source: "https://github.com/owner/repo", Tokens: tokens}).tokenAuth(); err != nil || got.Password != "ghp" {
+			t.Errorf("tokenAuth() for github.com = %v, %v, want %q", got, err, "ghp")
+		}
+		if got, err := (&Git{Source: "https://gitlab.com/owner/repo", Tokens: tokens}).tokenAuth(); err != nil || got.Password != "glpat" {
+			t.Errorf("tokenAuth() for gitlab.com = %v, %v, want %q", got, err, "glpat")
+		}
+		if _, err := (&Git{Source: "https://example.com/owner/repo", Tokens: tokens}).tokenAuth(); err == nil {
+			t.Errorf("tokenAuth() for example.com should fail")
+		}
+	}
+	if ghCalls != 1 {
+		t.Errorf("gh CLI asked %d times, want 1", ghCalls)
+	}
+}
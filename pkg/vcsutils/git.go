@@ -1,18 +1,22 @@
 package vcsutils
 
 import (
-	"context"
 	"errors"
 	"fmt"
+	"math"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 )
 
 // Git represents a git repository with its source URL and HEAD commit hash
@@ -20,9 +24,116 @@ import (
 // Fields:
 //   - Source: The source repository URL
 //   - HEAD: The HEAD commit hash
+//   - Tokens: The GitHub token cache shared by the operations of one command
+//     invocation (nil gives the Git value a cache of its own)
 type Git struct {
 	Source string
 	HEAD   string
+	Tokens *TokenCache
+	// auth is the authentication Clone used, reused by later fetches
+	auth transport.AuthMethod
+	// retries is the number of times transient failures of remote
+	// operations are retried
+	retries int
+	// retryBackoff is the delay before the first retry
+	retryBackoff time.Duration
+	// unshallow lets Checkout fetch the full history of a shallow clone
+	unshallow bool
+	// submodules makes Checkout update the submodules of the clone
+	submodules bool
+}
+
+// TokenCache resolves the GitHub token of each host at most once, so that
+// every git operation of a command invocation uses the same token and the gh
+// CLI is run at most once. The zero value resolves tokens with
+// ResolveGitHubToken.
+//
+// Fields:
+//   - Resolve: Looks up the token for every host (nil uses ResolveGitHubToken)
+type TokenCache struct {
+	Resolve func() (string, error)
+	mu      sync.Mutex
+	tokens  map[string]resolvedToken
+}
+
+// resolvedToken is the outcome of resolving the token of one host
+type resolvedToken struct {
+	username string
+	token    string
+	err      error
+}
+
+// Token returns the GitHub token for github.com, resolving it on the first
+// call only
+//
+// Returns:
+//   - string: The GitHub token
+//   - error: The error of the first resolution, returned on every call
+func (c *TokenCache) Token() (string, error) {
+	return c.TokenFor(defaultTokenHost)
+}
+
+// TokenFor returns the token for host, resolving it on the first call for
+// the host only. With a Resolve function, one token is shared by every host.
+//
+// Parameters:
+//   - host: The host of the repository the token is for
+//
+// Returns:
+//   - string: The token
+//   - error: The error of the first resolution, returned on every call
+func (c *TokenCache) TokenFor(host string) (string, error) {
+	_, token, err := c.CredentialsFor(host)
+	return token, err
+}
+
+// CredentialsFor returns the user name and token for host like TokenFor. A
+// token from a Resolve function is sent with x-access-token.
+//
+// Parameters:
+//   - host: The host of the repository the token is for
+//
+// Returns:
+//   - string: The user name to send the token with
+//   - string: The token
+//   - error: The error of the first resolution, returned on every call
+func (c *TokenCache) CredentialsFor(host string) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resolve := func() (string, string, error) { return ResolveCredentials(host) }
+	if c.Resolve != nil {
+		host = ""
+		resolve = func() (string, string, error) {
+			token, err := c.Resolve()
+			return tokenUsername, token, err
+		}
+	}
+	if resolved, ok := c.tokens[host]; ok {
+		return resolved.username, resolved.token, resolved.err
+	}
+	username, token, err := resolve()
+	if c.tokens == nil {
+		c.tokens = make(map[string]resolvedToken)
+	}
+	c.tokens[host] = resolvedToken{username: username, token: token, err: err}
+	return username, token, err
+}
+
+// tokenAuth returns the credentials for the host of the source from the
+// token cache of the Git value
+//
+// Returns:
+//   - *githttp.BasicAuth: The user name and token to authenticate with
+//   - error: The error of resolving the token
+func (g *Git) tokenAuth() (*githttp.BasicAuth, error) {
+	if g.Tokens == nil {
+		g.Tokens = &TokenCache{}
+	}
+	username, token, err := g.Tokens.CredentialsFor(TokenHost(g.Source))
+	if err != nil {
+		return nil, err
+	}
+	return &githttp.BasicAuth{Username: username, Password: token}, nil
 }
 
 // AuthMethod represents the authentication method
@@ -47,30 +158,140 @@ type Options struct {
 	Depth int
 	// Verbose enables verbose output
 	Verbose bool
-	// UnshallowIfNeeded specifies whether to unshallow if needed
+	// UnshallowIfNeeded lets Checkout fetch the full history of a shallow
+	// clone when deepening it step by step does not reach the requested
+	// commit
 	UnshallowIfNeeded bool
+	// RefSpecs restricts the clone to the given refspecs (e.g.
+	// "+refs/heads/main:refs/remotes/origin/main"). When set, the refs are
+	// fetched into an empty repository and nothing is checked out.
+	RefSpecs []string
+	// Branch is the branch to clone and check out (empty uses the branch the
+	// remote HEAD points to)
+	Branch string
+	// Tag is the tag to clone and check out instead of a branch; it takes
+	// precedence over Branch
+	Tag string
+	// SingleBranch fetches only Branch or Tag, or the remote HEAD's branch,
+	// instead of every branch
+	SingleBranch bool
+	// SSHKeyPath is the private key used with AuthSSH (default ~/.ssh/id_rsa)
+	SSHKeyPath string
+	// SSHKeyPassphrase decrypts the private key used with AuthSSH
+	SSHKeyPassphrase string
+	// CacheDir is the directory of a bare mirror of the source. When set,
+	// Clone updates the mirror and clones from it instead of from the
+	// source, unless RefSpecs are given.
+	CacheDir string
+	// Retries is the number of times a clone is retried after a transient
+	// network failure, such as a timeout or a reset connection
+	Retries int
+	// RetryBackoff is the delay before the first retry, doubled for every
+	// further retry (0 uses DefaultRetryBackoff)
+	RetryBackoff time.Duration
+	// RecurseSubmodules clones the submodules of the repository, and their
+	// submodules in turn. Submodules of a shallow clone are shallow too.
+	RecurseSubmodules bool
 }
 
-// getGitHubToken tries to get a GitHub token from various sources
-func getGitHubToken() (string, error) {
-	// First check environment variable
-	token := os.Getenv("GITHUB_TOKEN")
-	if token != "" {
-		return token, nil
+// IsSSHSource reports whether source is reached over SSH, either as an
+// ssh:// URL or in the scp-like form git@host:owner/repo
+//
+// Parameters:
+//   - source: The source repository URL
+//
+// Returns:
+//   - bool: True if the source uses the SSH transport, false otherwise
+func IsSSHSource(source string) bool {
+	endpoint, err := transport.NewEndpoint(source)
+	return err == nil && endpoint.Protocol == "ssh"
+}
+
+// sshAuth builds the SSH authentication for source. The private key at
+// opts.SSHKeyPath is used when given; otherwise ~/.ssh/id_rsa is used if it
+// exists, and the SSH agent if it does not.
+//
+// Parameters:
+//   - source: The SSH source repository URL
+//   - opts: The options holding the key path and passphrase
+//
+// Returns:
+//   - transport.AuthMethod: The SSH authentication
+//   - error: An error if the source is not an SSH URL or the key cannot be loaded
+func sshAuth(source string, opts Options) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(source)
+	if err != nil || endpoint.Protocol != "ssh" {
+		return nil, fmt.Errorf("SSH authentication requires an SSH source such as ssh://host/owner/repo or git@host:owner/repo, got %s", ScrubCredentials(source))
+	}
+	user := endpoint.User
+	if user == "" {
+		user = "git"
 	}
 
-	// Then try gh cli
-	cmd := exec.CommandContext(context.Background(), "gh", "auth", "token")
-	output, err := cmd.Output()
-	if err == nil {
-		token = strings.TrimSpace(string(output))
-		if token != "" {
-			return token, nil
+	keyPath := opts.SSHKeyPath
+	if keyPath == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if defaultKey := filepath.Join(home, ".ssh", "id_rsa"); fileExists(defaultKey) {
+				keyPath = defaultKey
+			}
 		}
 	}
+	if keyPath == "" {
+		auth, err := gitssh.DefaultAuthBuilder(user)
+		if err != nil {
+			return nil, fmt.Errorf("no SSH key found at ~/.ssh/id_rsa and the SSH agent is unavailable: %w", err)
+		}
+		return auth, nil
+	}
+	auth, err := gitssh.NewPublicKeysFromFile(user, keyPath, opts.SSHKeyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load SSH key %s: %w", keyPath, err)
+	}
+	return auth, nil
+}
 
-	// Could add more methods here (like reading from ~/.netrc or other sources)
-	return "", fmt.Errorf("no GitHub token found, set GITHUB_TOKEN environment variable or login with 'gh auth login'")
+// UseSSH makes the remote operations of the Git value that run before Clone,
+// such as resolving the HEAD of a branch, authenticate with SSH as Clone does
+// with AuthSSH
+//
+// Parameters:
+//   - opts: The options holding the SSH key path and passphrase
+//
+// Returns:
+//   - error: An error if the source is not an SSH URL or the key cannot be loaded
+func (g *Git) UseSSH(opts Options) error {
+	auth, err := sshAuth(g.Source, opts)
+	if err != nil {
+		return err
+	}
+	g.auth = auth
+	return nil
+}
+
+// fileExists reports whether path exists and is a regular file
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// ParseRefSpecs parses and validates refspecs in git's <src>:<dst> syntax
+//
+// Parameters:
+//   - specs: The refspecs to parse
+//
+// Returns:
+//   - []config.RefSpec: The parsed refspecs
+//   - error: An error naming the first invalid refspec
+func ParseRefSpecs(specs []string) ([]config.RefSpec, error) {
+	refSpecs := make([]config.RefSpec, 0, len(specs))
+	for _, spec := range specs {
+		refSpec := config.RefSpec(spec)
+		if err := refSpec.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid refspec '%s': %w", spec, err)
+		}
+		refSpecs = append(refSpecs, refSpec)
+	}
+	return refSpecs, nil
 }
 
 // normalizeCloneDepth maps a requested clone depth to the value passed to go-git.
@@ -83,7 +304,10 @@ func normalizeCloneDepth(depth int) int {
 	return depth
 }
 
-// Clone clones the repository to the specified directory
+// Clone clones the repository to the specified directory. When opts.RefSpecs is
+// set only those refs are fetched and the worktree is left empty, so callers
+// must check out the commit they want to build. Otherwise, when opts.CacheDir
+// is set, the clone is made through the cache with CloneFromCache.
 //
 // Parameters:
 //   - cloneDir: The directory to clone the repository into
@@ -92,46 +316,42 @@ func normalizeCloneDepth(depth int) int {
 // Returns:
 //   - error: Any error encountered during the cloning process
 func (g *Git) Clone(cloneDir string, opts Options) error {
+	g.UseRetries(opts)
+	g.unshallow = opts.UnshallowIfNeeded
+	g.submodules = opts.RecurseSubmodules
+	if opts.CacheDir != "" && len(opts.RefSpecs) == 0 {
+		return g.CloneFromCache(cloneDir, opts)
+	}
+
 	// Default options
 	depth := normalizeCloneDepth(opts.Depth)
 	verbose := opts.Verbose
-	authMethod := AuthNone
-
-	// Apply provided options
-	if opts.AuthMethod != "" {
-		authMethod = opts.AuthMethod
-	}
 
 	// Prepare clone options
 	cloneOpts := &git.CloneOptions{
 		URL:               g.Source,
 		ShallowSubmodules: depth == 1,
 		Depth:             depth,
+		SingleBranch:      opts.SingleBranch,
+	}
+	if opts.RecurseSubmodules {
+		cloneOpts.RecurseSubmodules = git.DefaultSubmoduleRecursionDepth
+	}
+	if opts.Tag != "" {
+		cloneOpts.ReferenceName = plumbing.NewTagReferenceName(opts.Tag)
+	} else if opts.Branch != "" {
+		cloneOpts.ReferenceName = plumbing.NewBranchReferenceName(opts.Branch)
 	}
 
-	// For explicit token authentication, attach credentials up front.
-	// Anonymous clones (AuthNone) are attempted without credentials first and
-	// only retried with a token if the server requires authentication; this
-	// keeps token-less clones of public repositories working.
-	if authMethod == AuthToken {
-		token := opts.Token
-		if token == "" {
-			var err error
-			token, err = getGitHubToken()
-			if err != nil {
-				return err
-			}
-		}
-
-		cloneOpts.Auth = &githttp.BasicAuth{
-			Username: "x-access-token", // This is what GitHub expects for token auth
-			Password: token,
-		}
+	if err := g.setupAuth(opts); err != nil {
+		return err
 	}
 
 	// Add progress reporting if verbose
 	if verbose {
-		cloneOpts.Progress = os.Stdout
+		progress := newScrubWriter(os.Stdout)
+		defer progress.Close()
+		cloneOpts.Progress = progress
 	}
 
 	// Create destination directory if it doesn't exist
@@ -141,30 +361,44 @@ func (g *Git) Clone(cloneDir string, opts Options) error {
 		}
 	}
 
-	// Perform clone
-	r, err := git.PlainClone(cloneDir, false, cloneOpts)
-
-	// If an anonymous clone failed because the server requires authentication,
-	// retry with a token when one is available (e.g. private repositories).
-	if err != nil && authMethod == AuthNone && cloneOpts.Auth == nil && isAuthRequiredError(err) {
-		if token, tokenErr := getGitHubToken(); tokenErr == nil {
-			cloneOpts.Auth = &githttp.BasicAuth{
-				Username: "x-access-token",
-				Password: token,
-			}
-			// A failed clone may leave a partially initialized directory;
-			// clear it so the retry starts from a clean state.
-			_ = os.RemoveAll(cloneDir)
-			r, err = git.PlainClone(cloneDir, false, cloneOpts)
+	if len(opts.RefSpecs) > 0 {
+		refSpecs, err := ParseRefSpecs(opts.RefSpecs)
+		if err != nil {
+			return err
 		}
+		return g.fetchRefSpecs(cloneDir, &git.FetchOptions{
+			RemoteName: git.DefaultRemoteName,
+			RefSpecs:   refSpecs,
+			Depth:      depth,
+			Auth:       g.auth,
+			Progress:   cloneOpts.Progress,
+		})
 	}
 
+	// Perform clone
+	var r *git.Repository
+	attempted := false
+	err := g.withRetries("git clone", func() error {
+		return g.withTokenRetry(g.auth, func(auth transport.AuthMethod) error {
+			// A failed clone may leave a partially initialized directory;
+			// clear it so each attempt starts from a clean state.
+			if attempted {
+				_ = os.RemoveAll(cloneDir)
+			}
+			attempted = true
+			cloneOpts.Auth = auth
+			var cloneErr error
+			r, cloneErr = git.PlainClone(cloneDir, false, cloneOpts)
+			return cloneErr
+		})
+	})
+
 	if err != nil {
 		// Handle specific errors more gracefully
 		if strings.Contains(err.Error(), "already exists") {
 			return fmt.Errorf("destination path already exists and is not empty: %s", cloneDir)
 		}
-		return fmt.Errorf("git clone failed: %w", err)
+		return scrubError(fmt.Errorf("git clone failed: %w", err))
 	}
 
 	// Get HEAD reference
@@ -176,10 +410,114 @@ func (g *Git) Clone(cloneDir string, opts Options) error {
 	return nil
 }
 
-// isAuthRequiredError reports whether err indicates that the remote requires
+// setupAuth sets up the authentication of the remote operations of the Git
+// value as selected by opts.AuthMethod. For explicit token authentication,
+// credentials are attached up front. Anonymous operations (AuthNone) are
+// attempted without credentials first and only retried with a token if the
+// server requires authentication (see withTokenRetry); this keeps token-less
+// clones of public repositories working.
+//
+// Parameters:
+//   - opts: The options selecting the authentication method
+//
+// Returns:
+//   - error: An error if the token cannot be resolved or the SSH key cannot be loaded
+func (g *Git) setupAuth(opts Options) error {
+	authMethod := AuthNone
+	if opts.AuthMethod != "" {
+		authMethod = opts.AuthMethod
+	}
+
+	g.auth = nil
+	if authMethod == AuthToken {
+		if opts.Token != "" {
+			g.auth = &githttp.BasicAuth{
+				Username: tokenUsername, // This is what GitHub expects for token auth
+				Password: opts.Token,
+			}
+		} else {
+			auth, err := g.tokenAuth()
+			if err != nil {
+				return err
+			}
+			g.auth = auth
+		}
+	} else if authMethod == AuthSSH {
+		auth, err := sshAuth(g.Source, opts)
+		if err != nil {
+			return err
+		}
+		g.auth = auth
+	}
+	return nil
+}
+
+// withTokenRetry runs op with auth. If op ran anonymously (auth is nil) and
+// failed because the remote requires authentication, e.g. for a private
+// repository, op runs once more with the token for the source when one is
+// available, and later operations of the Git value use the token too. A
+// token cannot authenticate over SSH, so SSH sources are never retried.
+//
+// Parameters:
+//   - auth: The authentication to run op with first
+//   - op: The remote operation, run with the authentication to use
+//
+// Returns:
+//   - error: The error of the last run of op
+func (g *Git) withTokenRetry(auth transport.AuthMethod, op func(auth transport.AuthMethod) error) error {
+	err := op(auth)
+	if err == nil || auth != nil || IsSSHSource(g.Source) || !IsAuthRequiredError(err) {
+		return err
+	}
+	tokenAuth, tokenErr := g.tokenAuth()
+	if tokenErr != nil {
+		return err
+	}
+	g.auth = tokenAuth
+	return op(tokenAuth)
+}
+
+// fetchRefSpecs initializes a repository in cloneDir and fetches only the
+// refspecs in fetchOpts from the source
+//
+// Parameters:
+//   - cloneDir: The directory to initialize the repository in
+//   - fetchOpts: The fetch options, including the refspecs to fetch
+//
+// Returns:
+//   - error: Any error encountered while initializing or fetching
+func (g *Git) fetchRefSpecs(cloneDir string, fetchOpts *git.FetchOptions) error {
+	r, err := git.PlainInit(cloneDir, false)
+	if err != nil {
+		return fmt.Errorf("failed to initialize repository: %w", err)
+	}
+	if _, err := r.CreateRemote(&config.RemoteConfig{
+		Name:  fetchOpts.RemoteName,
+		URLs:  []string{g.Source},
+		Fetch: fetchOpts.RefSpecs,
+	}); err != nil {
+		return fmt.Errorf("failed to create remote: %w", err)
+	}
+
+	err = g.withRetries("git fetch", func() error {
+		return g.withTokenRetry(fetchOpts.Auth, func(auth transport.AuthMethod) error {
+			fetchOpts.Auth = auth
+			return r.Fetch(fetchOpts)
+		})
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return scrubError(fmt.Errorf("git fetch failed: %w", err))
+	}
+
+	// Nothing is checked out, so there is no HEAD commit yet
+	g.HEAD = ""
+	return nil
+}
+
+// IsAuthRequiredError reports whether err indicates that the remote requires
 // authentication (or that the provided credentials were rejected). It is used
 // to decide whether an anonymous operation should be retried with a token.
-func isAuthRequiredError(err error) bool {
+func IsAuthRequiredError(err error) bool {
 	if err == nil {
 		return false
 	}
@@ -193,40 +531,150 @@ func isAuthRequiredError(err error) bool {
 		strings.Contains(msg, "authentication")
 }
 
-// GetDefaultBranchRemoteHead retrieves the HEAD commit hash of the default branch from the remote repository
-//
-// Parameters:
-//   - defaultBranch: The name of the default branch
+// listRemoteRefs lists the references advertised by the remote repository,
+// retrying with a token when the remote requires authentication
 //
 // Returns:
-//   - error: Any error encountered during the process
-func (g *Git) GetDefaultBranchRemoteHead(defaultBranch string) error {
+//   - []*plumbing.Reference: The references advertised by the remote
+//   - error: Any error encountered while listing the references
+func (g *Git) listRemoteRefs() ([]*plumbing.Reference, error) {
 	// When dealing with potentially private repos, it's better to use go-git's
 	// authentication mechanisms rather than the RemoteConfig directly
 
-	// First try without authentication
+	// First try without authentication, unless SSH was selected with UseSSH
 	remote := git.NewRemote(nil, &config.RemoteConfig{
 		URLs: []string{g.Source},
 	})
-	refs, err := remote.List(&git.ListOptions{})
-
-	// If we failed, try with token (might be a private repo)
-	if err != nil && isAuthRequiredError(err) {
-		token, tokenErr := getGitHubToken()
-		if tokenErr == nil {
-			auth := &githttp.BasicAuth{
-				Username: "x-access-token",
-				Password: token,
+	var refs []*plumbing.Reference
+	err := g.withTokenRetry(g.auth, func(auth transport.AuthMethod) error {
+		var listErr error
+		// Peeled tags let annotated tags be resolved to their commits
+		refs, listErr = remote.List(&git.ListOptions{Auth: auth, PeelingOption: git.AppendPeeled})
+		return listErr
+	})
+
+	if err != nil {
+		if strings.Contains(err.Error(), "authentication") {
+			return nil, scrubError(fmt.Errorf("authentication failed: %w", err))
+		}
+		return nil, scrubError(fmt.Errorf("failed to list remote references: %w", err))
+	}
+	return refs, nil
+}
+
+// RemoteRef represents a branch or tag advertised by a remote repository
+//
+// Fields:
+//   - Name: The short name of the branch or tag
+//   - Kind: Either "branch" or "tag"
+//   - Hash: The commit hash the reference points to (peeled for annotated tags)
+type RemoteRef struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	Hash string `json:"hash"`
+}
+
+// LsRemote lists the branches and tags of the remote repository, like
+// `git ls-remote --heads --tags`
+//
+// Returns:
+//   - []RemoteRef: The branches sorted by name, followed by the tags sorted by name
+//   - error: Any error encountered while listing the references
+func (g *Git) LsRemote() ([]RemoteRef, error) {
+	refs, err := g.listRemoteRefs()
+	if err != nil {
+		return nil, err
+	}
+	return remoteRefsFromRefs(refs), nil
+}
+
+// remoteRefsFromRefs converts a remote reference listing into branches and
+// tags, resolving annotated tags to the commits they point to
+func remoteRefsFromRefs(refs []*plumbing.Reference) []RemoteRef {
+	const peeledSuffix = "^{}"
+	peeled := make(map[string]string)
+	for _, ref := range refs {
+		if name := ref.Name().String(); strings.HasSuffix(name, peeledSuffix) {
+			peeled[strings.TrimSuffix(name, peeledSuffix)] = ref.Hash().String()
+		}
+	}
+
+	var branches, tags []RemoteRef
+	for _, ref := range refs {
+		if ref.Type() != plumbing.HashReference || strings.HasSuffix(ref.Name().String(), peeledSuffix) {
+			continue
+		}
+		switch {
+		case ref.Name().IsBranch():
+			branches = append(branches, RemoteRef{Name: ref.Name().Short(), Kind: "branch", Hash: ref.Hash().String()})
+		case ref.Name().IsTag():
+			hash := ref.Hash().String()
+			if commit, ok := peeled[ref.Name().String()]; ok {
+				hash = commit
 			}
-			refs, err = remote.List(&git.ListOptions{Auth: auth})
+			tags = append(tags, RemoteRef{Name: ref.Name().Short(), Kind: "tag", Hash: hash})
 		}
 	}
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Name < branches[j].Name })
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return append(branches, tags...)
+}
 
+// DetectDefaultBranch determines the default branch of the remote repository.
+// The remote's HEAD symref is used when advertised; otherwise the branch falls
+// back to "main" and then "master" if either exists on the remote.
+//
+// Returns:
+//   - string: The name of the default branch
+//   - error: Any error encountered during the process
+func (g *Git) DetectDefaultBranch() (string, error) {
+	refs, err := g.listRemoteRefs()
 	if err != nil {
-		if strings.Contains(err.Error(), "authentication") {
-			return fmt.Errorf("authentication failed: %w", err)
+		return "", err
+	}
+	branch, ok := defaultBranchFromRefs(refs)
+	if !ok {
+		return "", fmt.Errorf("could not detect the default branch of %s", ScrubCredentials(g.Source))
+	}
+	return branch, nil
+}
+
+// defaultBranchFromRefs picks the default branch from a remote reference listing
+func defaultBranchFromRefs(refs []*plumbing.Reference) (string, bool) {
+	branches := make(map[string]bool)
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference && ref.Target().IsBranch() {
+			return ref.Target().Short(), true
+		}
+		if ref.Name().IsBranch() {
+			branches[ref.Name().Short()] = true
 		}
-		return fmt.Errorf("failed to list remote references: %w", err)
+	}
+	for _, fallback := range []string{"main", "master"} {
+		if branches[fallback] {
+			return fallback, true
+		}
+	}
+	return "", false
+}
+
+// GetDefaultBranchRemoteHead retrieves the HEAD commit hash of the default branch from the remote repository.
+// Transient failures are retried as set up with UseRetries.
+//
+// Parameters:
+//   - defaultBranch: The name of the default branch
+//
+// Returns:
+//   - error: Any error encountered during the process
+func (g *Git) GetDefaultBranchRemoteHead(defaultBranch string) error {
+	var refs []*plumbing.Reference
+	err := g.withRetries("listing remote references", func() error {
+		var listErr error
+		refs, listErr = g.listRemoteRefs()
+		return listErr
+	})
+	if err != nil {
+		return err
 	}
 
 	// Try finding the exact match first
@@ -257,14 +705,152 @@ func (g *Git) GetDefaultBranchRemoteHead(defaultBranch string) error {
 	return fmt.Errorf("branch '%s' not found in remote repository", defaultBranch)
 }
 
-// Checkout checkouts the specified commit or branch in the repository
+// GetTagRemoteHead retrieves the commit hash a tag of the remote repository
+// points to and stores it in g.HEAD. Annotated tags are dereferenced to the
+// commit they tag.
+//
+// Parameters:
+//   - tag: The name of the tag, with or without the refs/tags/ prefix
+//
+// Returns:
+//   - error: An error if the tag does not exist in the remote repository
+func (g *Git) GetTagRemoteHead(tag string) error {
+	refs, err := g.listRemoteRefs()
+	if err != nil {
+		return err
+	}
+	hash, ok := tagCommitFromRefs(refs, tag)
+	if !ok {
+		return fmt.Errorf("tag '%s' not found in remote repository", tag)
+	}
+	g.HEAD = hash
+	return nil
+}
+
+// tagCommitFromRefs finds the commit a tag points to in a remote reference
+// listing, preferring the peeled entry advertised for annotated tags
+func tagCommitFromRefs(refs []*plumbing.Reference, tag string) (string, bool) {
+	name := plumbing.NewTagReferenceName(strings.TrimPrefix(tag, "refs/tags/"))
+	peeled := plumbing.ReferenceName(name.String() + "^{}")
+	var hash string
+	for _, ref := range refs {
+		switch ref.Name() {
+		case peeled:
+			return ref.Hash().String(), true
+		case name:
+			hash = ref.Hash().String()
+		}
+	}
+	return hash, hash != ""
+}
+
+// IsGitHubSource reports whether source is a repository hosted on github.com,
+// in any of the HTTPS, ssh:// or scp-like forms
+//
+// Parameters:
+//   - source: The source repository URL
+//
+// Returns:
+//   - bool: True if the source is hosted on GitHub, false otherwise
+func IsGitHubSource(source string) bool {
+	endpoint, err := transport.NewEndpoint(source)
+	return err == nil && endpoint.Protocol != "file" && strings.EqualFold(endpoint.Host, "github.com")
+}
+
+// PullRequestRef returns the reference GitHub publishes the head of a pull
+// request under
+//
+// Parameters:
+//   - number: The number of the pull request
+//
+// Returns:
+//   - string: The reference, e.g. "refs/pull/123/head"
+func PullRequestRef(number int) string {
+	return fmt.Sprintf("refs/pull/%d/head", number)
+}
+
+// PullRequestRefSpec returns the refspec that fetches the head of a pull
+// request, for use in Options.RefSpecs
+//
+// Parameters:
+//   - number: The number of the pull request
+//
+// Returns:
+//   - string: The refspec, e.g. "+refs/pull/123/head:refs/remotes/origin/pr/123"
+func PullRequestRefSpec(number int) string {
+	return fmt.Sprintf("+%s:refs/remotes/origin/pr/%d", PullRequestRef(number), number)
+}
+
+// GetPullRequestHead retrieves the head commit hash of a GitHub pull request
+// from the remote repository and stores it in g.HEAD
+//
+// Parameters:
+//   - number: The number of the pull request
+//
+// Returns:
+//   - error: An error if the source is not on GitHub or the pull request does not exist
+func (g *Git) GetPullRequestHead(number int) error {
+	if number <= 0 {
+		return fmt.Errorf("invalid pull request number %d", number)
+	}
+	if !IsGitHubSource(g.Source) {
+		return fmt.Errorf("pull requests can only be built from GitHub sources, got %s", ScrubCredentials(g.Source))
+	}
+	refs, err := g.listRemoteRefs()
+	if err != nil {
+		return err
+	}
+	hash, ok := pullRequestHeadFromRefs(refs, number)
+	if !ok {
+		return fmt.Errorf("pull request #%d not found in %s", number, ScrubCredentials(g.Source))
+	}
+	g.HEAD = hash
+	return nil
+}
+
+// pullRequestHeadFromRefs finds the head commit of a pull request in a remote
+// reference listing
+func pullRequestHeadFromRefs(refs []*plumbing.Reference, number int) (string, bool) {
+	name := plumbing.ReferenceName(PullRequestRef(number))
+	for _, ref := range refs {
+		if ref.Name() == name {
+			return ref.Hash().String(), true
+		}
+	}
+	return "", false
+}
+
+// Errors returned by Checkout, distinguishing a reference that cannot be
+// found from a worktree that cannot be switched
+var (
+	// ErrRefNotFound means the reference is not a branch, tag or commit of the repository
+	ErrRefNotFound = errors.New("reference not found")
+	// ErrCheckoutConflict means local changes in the worktree prevent the checkout
+	ErrCheckoutConflict = errors.New("checkout conflict")
+)
+
+// fetchedCommitRef is the reference a commit fetched by its hash is stored under
+const fetchedCommitRef = "refs/nigiri/checkout"
+
+// deepenSteps are the depths a shallow clone is deepened to, one after the
+// other, while a reference to check out is missing from it
+var deepenSteps = []int{10, 100, 1000, 10000}
+
+// Checkout checkouts the specified commit or branch in the repository. When a
+// shallow clone does not contain the reference, the clone is deepened from
+// the origin remote until it does; the full history is only fetched when
+// the clone was made with Options.UnshallowIfNeeded. When the clone was made
+// with Options.RecurseSubmodules, the submodules are then updated to the
+// commits recorded by the checked out commit. If the checkout fails part
+// way, the worktree is reset to the commit it was on before.
 //
 // Parameters:
 //   - repoDir: The directory containing the repository
 //   - ref: The reference (commit hash or branch name) to checkout
 //
 // Returns:
-//   - error: Any error encountered during the checkout process
+//   - error: Any error encountered during the checkout process; ErrRefNotFound
+//     and ErrCheckoutConflict identify the two common causes
 func (g *Git) Checkout(repoDir string, ref string) error {
 	r, err := git.PlainOpen(repoDir)
 	if err != nil {
@@ -276,20 +862,307 @@ func (g *Git) Checkout(repoDir string, ref string) error {
 		return fmt.Errorf("failed to get worktree: %w", err)
 	}
 
+	// go-git moves HEAD before updating the worktree, so a failed checkout
+	// must put HEAD back to where it was
+	previousHead, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD reference: %w", err)
+	}
+	checkoutFailed := func(err error) error {
+		_ = r.Storer.SetReference(previousHead)
+		if errors.Is(err, git.ErrUnstagedChanges) {
+			// Keep the local changes; only HEAD had moved
+			return fmt.Errorf("%w: local changes in %s would be overwritten by checking out '%s'", ErrCheckoutConflict, repoDir, ref)
+		}
+		if head, headErr := r.Head(); headErr == nil {
+			// Do not leave a half checked out worktree behind
+			_ = w.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset})
+		}
+		return fmt.Errorf("failed to checkout reference '%s': %w", ref, err)
+	}
+
 	// Try checkout as branch first
-	err = w.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewBranchReferenceName(ref),
+	branch := plumbing.NewBranchReferenceName(ref)
+	if _, refErr := r.Reference(branch, false); refErr == nil {
+		if err := w.Checkout(&git.CheckoutOptions{Branch: branch}); err != nil {
+			return checkoutFailed(err)
+		}
+		return g.updateSubmodules(w)
+	}
+
+	// If not a branch, resolve the revision (full/short commit hash or tag)
+	hash, err := g.resolveRevision(r, ref)
+	if err != nil {
+		return err
+	}
+	if err := w.Checkout(&git.CheckoutOptions{Hash: *hash}); err != nil {
+		return checkoutFailed(err)
+	}
+
+	return g.updateSubmodules(w)
+}
+
+// updateSubmodules initializes the submodules of a worktree and updates them,
+// recursively, to the commits recorded in it, if the clone was made with
+// Options.RecurseSubmodules. The submodules are fetched with their full
+// history, since the recorded commits need not be the tips of their branches.
+//
+// Parameters:
+//   - w: The worktree
+//
+// Returns:
+//   - error: Any error encountered while updating the submodules
+func (g *Git) updateSubmodules(w *git.Worktree) error {
+	if !g.submodules {
+		return nil
+	}
+	subs, err := w.Submodules()
+	if err != nil {
+		return fmt.Errorf("failed to read submodules: %w", err)
+	}
+	err = g.withRetries("updating submodules", func() error {
+		return subs.Update(&git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+			Auth:              g.auth,
+		})
 	})
 	if err != nil {
-		// If not a branch, resolve the revision (full/short commit hash or tag)
-		hash, resolveErr := r.ResolveRevision(plumbing.Revision(ref))
-		if resolveErr != nil {
-			return fmt.Errorf("failed to resolve reference '%s': %w", ref, resolveErr)
+		return scrubError(fmt.Errorf("failed to update submodules: %w", err))
+	}
+	return nil
+}
+
+// resolveRevision resolves ref to a commit hash. A shallow clone may not
+// contain the commit yet, in which case it is fetched and resolved again.
+//
+// Parameters:
+//   - r: The repository
+//   - ref: The reference (commit hash, branch or tag) to resolve
+//
+// Returns:
+//   - *plumbing.Hash: The resolved commit hash
+//   - error: ErrRefNotFound if the reference does not exist, or a fetch error
+func (g *Git) resolveRevision(r *git.Repository, ref string) (*plumbing.Hash, error) {
+	hash, err := r.ResolveRevision(plumbing.Revision(ref))
+	if err == nil {
+		return hash, nil
+	}
+
+	shallow, shallowErr := r.Storer.Shallow()
+	if shallowErr != nil || len(shallow) == 0 {
+		// The full history is already present, so the reference does not exist
+		return nil, fmt.Errorf("%w: '%s' is not a branch, tag or commit in the repository", ErrRefNotFound, ref)
+	}
+
+	if fetchErr := g.fetchMissing(r, ref); fetchErr != nil {
+		return nil, fmt.Errorf("'%s' is not in the shallow clone and fetching it failed: %w", ref, fetchErr)
+	}
+	hash, err = r.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("%w: '%s' is not a branch, tag or commit in the repository, even after fetching its full history", ErrRefNotFound, ref)
+	}
+	return hash, nil
+}
+
+// fetchMissing fetches a reference that a shallow clone does not contain.
+// A full commit hash is first fetched on its own, which servers such as
+// GitHub allow; otherwise the clone is deepened step by step until the
+// reference resolves or the history is complete. Fetching the rest of the
+// history after the last step requires g.unshallow.
+//
+// Parameters:
+//   - r: The shallow repository
+//   - ref: The reference that could not be resolved
+//
+// Returns:
+//   - error: Any error encountered while fetching, or an error if the
+//     reference is deeper than the last step and unshallowing is disabled
+func (g *Git) fetchMissing(r *git.Repository, ref string) error {
+	if plumbing.IsHash(ref) {
+		err := g.fetch(r, &git.FetchOptions{
+			RemoteName: git.DefaultRemoteName,
+			RefSpecs:   []config.RefSpec{config.RefSpec(strings.ToLower(ref) + ":" + fetchedCommitRef)},
+			Depth:      1,
+		})
+		if err == nil {
+			return nil
+		}
+	}
+
+	for _, depth := range deepenSteps {
+		if err := g.fetch(r, &git.FetchOptions{
+			RemoteName: git.DefaultRemoteName,
+			Depth:      depth,
+			Tags:       git.AllTags,
+		}); err != nil {
+			return err
+		}
+		if _, err := r.ResolveRevision(plumbing.Revision(ref)); err == nil {
+			return nil
+		}
+		if historyComplete(r) {
+			// Deepening further cannot help
+			return nil
+		}
+	}
+
+	if !g.unshallow {
+		return fmt.Errorf("'%s' is not within the last %d commits; clone the full history to reach it", ref, deepenSteps[len(deepenSteps)-1])
+	}
+	// Deepening by the largest depth fetches the rest of the history, as
+	// git fetch --unshallow does
+	return g.fetch(r, &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		Depth:      math.MaxInt32,
+		Tags:       git.AllTags,
+	})
+}
+
+// historyComplete reports whether a clone holds the whole history of its
+// commits. The shallow boundary recorded by go-git is not cleared when a
+// deepening fetch reaches the root commits, so the parents of the boundary
+// commits are checked instead.
+//
+// Parameters:
+//   - r: The repository
+//
+// Returns:
+//   - bool: True if no parent of a boundary commit is missing, false otherwise
+func historyComplete(r *git.Repository) bool {
+	shallow, err := r.Storer.Shallow()
+	if err != nil {
+		return false
+	}
+	for _, hash := range shallow {
+		commit, err := r.CommitObject(hash)
+		if err != nil {
+			return false
 		}
-		if err := w.Checkout(&git.CheckoutOptions{Hash: *hash}); err != nil {
-			return fmt.Errorf("failed to checkout reference '%s': %w", ref, err)
+		for _, parent := range commit.ParentHashes {
+			if _, err := r.CommitObject(parent); err != nil {
+				return false
+			}
 		}
 	}
+	return true
+}
 
+// fetch fetches from the origin remote, retrying with a token when the
+// remote requires authentication
+//
+// Parameters:
+//   - r: The repository to fetch into
+//   - fetchOpts: The fetch options
+//
+// Returns:
+//   - error: Any error encountered while fetching; being up to date is not an error
+func (g *Git) fetch(r *git.Repository, fetchOpts *git.FetchOptions) error {
+	if fetchOpts.Auth == nil {
+		fetchOpts.Auth = g.auth
+	}
+	err := g.withTokenRetry(fetchOpts.Auth, func(auth transport.AuthMethod) error {
+		fetchOpts.Auth = auth
+		return r.Fetch(fetchOpts)
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return scrubError(fmt.Errorf("git fetch failed: %w", err))
+	}
 	return nil
 }
+
+// CurrentCommitHash returns the commit hash that HEAD points to in a checked-out repository
+//
+// Parameters:
+//   - repoDir: The directory containing the repository
+//
+// Returns:
+//   - string: The full commit hash of the worktree HEAD
+//   - error: Any error encountered during the process
+func (g *Git) CurrentCommitHash(repoDir string) (string, error) {
+	r, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+	ref, err := r.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD reference: %w", err)
+	}
+	return ref.Hash().String(), nil
+}
+
+// CommitTime returns the committer date of the commit that HEAD points to in
+// a checked-out repository
+//
+// Parameters:
+//   - repoDir: The directory containing the repository
+//
+// Returns:
+//   - time.Time: The committer date of the worktree HEAD
+//   - error: Any error encountered during the process
+func (g *Git) CommitTime(repoDir string) (time.Time, error) {
+	r, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to open repository: %w", err)
+	}
+	ref, err := r.Head()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get HEAD reference: %w", err)
+	}
+	commit, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	return commit.Committer.When, nil
+}
+
+// CommitRange returns the commits after good up to and including bad, oldest
+// first, following the first-parent history of bad. Both revisions may be
+// full or abbreviated hashes, branches or tags.
+//
+// Parameters:
+//   - repoDir: The directory containing a clone with the full history
+//   - good: The last known good revision (excluded from the result)
+//   - bad: The known bad revision (included in the result)
+//
+// Returns:
+//   - []string: The full commit hashes in the range, oldest first
+//   - error: Any error encountered, including when good is not an ancestor of bad
+func (g *Git) CommitRange(repoDir, good, bad string) ([]string, error) {
+	r, err := git.PlainOpen(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	goodHash, err := r.ResolveRevision(plumbing.Revision(good))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve good revision '%s': %w", good, err)
+	}
+	badHash, err := r.ResolveRevision(plumbing.Revision(bad))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve bad revision '%s': %w", bad, err)
+	}
+
+	commit, err := r.CommitObject(*badHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", badHash, err)
+	}
+	var hashes []string
+	for commit.Hash != *goodHash {
+		hashes = append(hashes, commit.Hash.String())
+		if commit.NumParents() == 0 {
+			return nil, fmt.Errorf("good revision '%s' is not a first-parent ancestor of bad revision '%s'", good, bad)
+		}
+		if commit, err = commit.Parent(0); err != nil {
+			return nil, fmt.Errorf("failed to read parent of %s: %w", hashes[len(hashes)-1], err)
+		}
+	}
+	if len(hashes) == 0 {
+		return nil, fmt.Errorf("good and bad revisions both resolve to %s", goodHash)
+	}
+
+	// Reverse so that the oldest commit comes first
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	return hashes, nil
+}
@@ -1,16 +1,28 @@
 package vcsutils
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 )
 
 func TestIsAuthRequiredError(t *testing.T) {
@@ -31,8 +43,8 @@ func TestIsAuthRequiredError(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			if got := isAuthRequiredError(tt.err); got != tt.want {
-				t.Errorf("isAuthRequiredError(%v) = %v, want %v", tt.err, got, tt.want)
+			if got := IsAuthRequiredError(tt.err); got != tt.want {
+				t.Errorf("IsAuthRequiredError(%v) = %v, want %v", tt.err, got, tt.want)
 			}
 		})
 	}
@@ -130,6 +142,448 @@ func TestCheckout(t *testing.T) {
 	}
 }
 
+func TestCheckoutFetchesMissingCommit(t *testing.T) {
+	tests := []struct {
+		name string
+		// allowSHA1InWant lets the fixture serve commits fetched by hash
+		allowSHA1InWant bool
+		ref             func(first string) string
+		wantFetchedRef  bool
+	}{
+		{name: "full hash fetched on its own", allowSHA1InWant: true, ref: func(first string) string { return first }, wantFetchedRef: true},
+		{name: "full hash by deepening", ref: func(first string) string { return first }},
+		{name: "short hash by deepening", ref: func(first string) string { return first[:7] }},
+		{name: "tag by deepening", ref: func(string) string { return "v1" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repoDir, first, _ := initTestRepo(t)
+			source, err := git.PlainOpen(repoDir)
+			if err != nil {
+				t.Fatalf("failed to open fixture: %v", err)
+			}
+			if tt.allowSHA1InWant {
+				cfg, err := source.Config()
+				if err != nil {
+					t.Fatalf("failed to read fixture config: %v", err)
+				}
+				cfg.Raw.Section("uploadpack").SetOption("allowReachableSHA1InWant", "true")
+				if err := source.SetConfig(cfg); err != nil {
+					t.Fatalf("failed to write fixture config: %v", err)
+				}
+			}
+
+			cloneDir := t.TempDir()
+			clone, err := git.PlainClone(cloneDir, false, &git.CloneOptions{URL: repoDir, Depth: 1})
+			if err != nil {
+				t.Fatalf("failed to clone fixture: %v", err)
+			}
+			if _, err := clone.CommitObject(plumbing.NewHash(first)); err == nil {
+				t.Fatalf("shallow clone unexpectedly contains the first commit")
+			}
+			// Tagged after cloning, as if the tag had been pushed since
+			if _, err := source.CreateTag("v1", plumbing.NewHash(first), nil); err != nil {
+				t.Fatalf("failed to create tag: %v", err)
+			}
+
+			g := &Git{}
+			if err := g.Checkout(cloneDir, tt.ref(first)); err != nil {
+				t.Fatalf("Checkout() failed: %v", err)
+			}
+			got, err := g.CurrentCommitHash(cloneDir)
+			if err != nil {
+				t.Fatalf("CurrentCommitHash() failed: %v", err)
+			}
+			if got != first {
+				t.Errorf("checked out %s, want %s", got, first)
+			}
+			content, err := os.ReadFile(filepath.Join(cloneDir, "file.txt"))
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+			if string(content) != "first" {
+				t.Errorf("file content = %q, want %q", content, "first")
+			}
+			_, refErr := clone.Reference(fetchedCommitRef, false)
+			if (refErr == nil) != tt.wantFetchedRef {
+				t.Errorf("fetched commit reference present = %v, want %v", refErr == nil, tt.wantFetchedRef)
+			}
+		})
+	}
+}
+
+func TestCheckoutDeepensStepByStep(t *testing.T) {
+	repoDir, first, _ := initTestRepo(t)
+	source, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open fixture: %v", err)
+	}
+	w, err := source.Worktree()
+	if err != nil {
+		t.Fatalf("failed to get worktree: %v", err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	for i := 0; i < 3; i++ {
+		if _, err := w.Commit(fmt.Sprintf("empty %d", i), &git.CommitOptions{Author: sig, AllowEmptyCommits: true}); err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+	}
+
+	origSteps := deepenSteps
+	deepenSteps = []int{2, 3}
+	defer func() { deepenSteps = origSteps }()
+
+	tests := []struct {
+		name      string
+		unshallow bool
+		wantErr   bool
+	}{
+		{name: "first commit is deeper than the last step", wantErr: true},
+		{name: "unshallowed after the last step", unshallow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloneDir := filepath.Join(t.TempDir(), "clone")
+			g := &Git{Source: repoDir}
+			if err := g.Clone(cloneDir, Options{Depth: 1, UnshallowIfNeeded: tt.unshallow}); err != nil {
+				t.Fatalf("Clone() failed: %v", err)
+			}
+			err := g.Checkout(cloneDir, first[:7])
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "not within the last 3 commits") {
+					t.Fatalf("Checkout() error = %v, want the commit to be out of reach", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Checkout() failed: %v", err)
+			}
+			if got, _ := g.CurrentCommitHash(cloneDir); got != first {
+				t.Errorf("checked out %s, want %s", got, first)
+			}
+		})
+	}
+}
+
+func TestCheckoutErrors(t *testing.T) {
+	t.Run("reference not found", func(t *testing.T) {
+		repoDir, _, _ := initTestRepo(t)
+		err := (&Git{}).Checkout(repoDir, "no-such-branch")
+		if !errors.Is(err, ErrRefNotFound) {
+			t.Fatalf("Checkout() error = %v, want ErrRefNotFound", err)
+		}
+	})
+
+	t.Run("reference not found after fetching", func(t *testing.T) {
+		repoDir, _, _ := initTestRepo(t)
+		cloneDir := t.TempDir()
+		if _, err := git.PlainClone(cloneDir, false, &git.CloneOptions{URL: repoDir, Depth: 1}); err != nil {
+			t.Fatalf("failed to clone fixture: %v", err)
+		}
+		err := (&Git{}).Checkout(cloneDir, "0000000000000000000000000000000000000000")
+		if !errors.Is(err, ErrRefNotFound) {
+			t.Fatalf("Checkout() error = %v, want ErrRefNotFound", err)
+		}
+	})
+
+	t.Run("checkout conflict", func(t *testing.T) {
+		repoDir, first, second := initTestRepo(t)
+		if err := os.WriteFile(filepath.Join(repoDir, "file.txt"), []byte("local change"), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+		g := &Git{}
+		err := g.Checkout(repoDir, first)
+		if !errors.Is(err, ErrCheckoutConflict) {
+			t.Fatalf("Checkout() error = %v, want ErrCheckoutConflict", err)
+		}
+		if errors.Is(err, ErrRefNotFound) {
+			t.Errorf("Checkout() conflict should not be reported as a missing reference")
+		}
+		// The worktree is left untouched
+		got, err := g.CurrentCommitHash(repoDir)
+		if err != nil {
+			t.Fatalf("CurrentCommitHash() failed: %v", err)
+		}
+		if got != second {
+			t.Errorf("HEAD = %s after failed checkout, want %s", got, second)
+		}
+		content, err := os.ReadFile(filepath.Join(repoDir, "file.txt"))
+		if err != nil {
+			t.Fatalf("failed to read file: %v", err)
+		}
+		if string(content) != "local change" {
+			t.Errorf("file content = %q, want the local change", content)
+		}
+	})
+}
+
+func TestDefaultBranchFromRefs(t *testing.T) {
+	t.Parallel()
+	hash := plumbing.NewHash("1234567890abcdef1234567890abcdef12345678")
+	tests := []struct {
+		name   string
+		refs   []*plumbing.Reference
+		want   string
+		wantOK bool
+	}{
+		{
+			name: "symref takes precedence",
+			refs: []*plumbing.Reference{
+				plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("develop")),
+				plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), hash),
+				plumbing.NewHashReference(plumbing.NewBranchReferenceName("develop"), hash),
+			},
+			want:   "develop",
+			wantOK: true,
+		},
+		{
+			name: "falls back to main",
+			refs: []*plumbing.Reference{
+				plumbing.NewHashReference(plumbing.NewBranchReferenceName("master"), hash),
+				plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), hash),
+			},
+			want:   "main",
+			wantOK: true,
+		},
+		{
+			name: "falls back to master",
+			refs: []*plumbing.Reference{
+				plumbing.NewHashReference(plumbing.NewBranchReferenceName("master"), hash),
+			},
+			want:   "master",
+			wantOK: true,
+		},
+		{
+			name: "no candidate branch",
+			refs: []*plumbing.Reference{
+				plumbing.NewHashReference(plumbing.NewBranchReferenceName("trunk"), hash),
+			},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, ok := defaultBranchFromRefs(tt.refs)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("defaultBranchFromRefs() = (%q, %v), want (%q, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestDetectDefaultBranch(t *testing.T) {
+	// The fixture repository is initialized with master as its default branch
+	repoDir, _, _ := initTestRepo(t)
+	g := &Git{Source: repoDir}
+
+	branch, err := g.DetectDefaultBranch()
+	if err != nil {
+		t.Fatalf("DetectDefaultBranch() failed: %v", err)
+	}
+	if branch != "master" {
+		t.Errorf("DetectDefaultBranch() = %q, want %q", branch, "master")
+	}
+}
+
+func TestCurrentCommitHash(t *testing.T) {
+	repoDir, first, second := initTestRepo(t)
+	g := &Git{}
+
+	got, err := g.CurrentCommitHash(repoDir)
+	if err != nil {
+		t.Fatalf("CurrentCommitHash() failed: %v", err)
+	}
+	if got != second {
+		t.Errorf("CurrentCommitHash() = %q, want %q", got, second)
+	}
+
+	// After checking out an older commit, HEAD reflects the worktree state
+	if err := g.Checkout(repoDir, first[:7]); err != nil {
+		t.Fatalf("Checkout() failed: %v", err)
+	}
+	got, err = g.CurrentCommitHash(repoDir)
+	if err != nil {
+		t.Fatalf("CurrentCommitHash() failed: %v", err)
+	}
+	if got != first {
+		t.Errorf("CurrentCommitHash() after checkout = %q, want %q", got, first)
+	}
+
+	if _, err := g.CurrentCommitHash(t.TempDir()); err == nil {
+		t.Errorf("CurrentCommitHash() on a non-repository should fail")
+	}
+}
+
+func TestCommitTime(t *testing.T) {
+	repoDir, _, second := initTestRepo(t)
+	g := &Git{}
+
+	r, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+	commit, err := r.CommitObject(plumbing.NewHash(second))
+	if err != nil {
+		t.Fatalf("Failed to read commit: %v", err)
+	}
+
+	got, err := g.CommitTime(repoDir)
+	if err != nil {
+		t.Fatalf("CommitTime() failed: %v", err)
+	}
+	if !got.Equal(commit.Committer.When) {
+		t.Errorf("CommitTime() = %v, want %v", got, commit.Committer.When)
+	}
+
+	if _, err := g.CommitTime(t.TempDir()); err == nil {
+		t.Errorf("CommitTime() on a non-repository should fail")
+	}
+}
+
+func TestCommitRange(t *testing.T) {
+	repoDir, first, second := initTestRepo(t)
+	g := &Git{}
+
+	got, err := g.CommitRange(repoDir, first[:7], "master")
+	if err != nil {
+		t.Fatalf("CommitRange() failed: %v", err)
+	}
+	if len(got) != 1 || got[0] != second {
+		t.Errorf("CommitRange() = %v, want [%s]", got, second)
+	}
+
+	if _, err := g.CommitRange(repoDir, second, first); err == nil {
+		t.Errorf("CommitRange() with good after bad should fail")
+	}
+	if _, err := g.CommitRange(repoDir, second, second); err == nil {
+		t.Errorf("CommitRange() with identical revisions should fail")
+	}
+	if _, err := g.CommitRange(repoDir, "unknown", second); err == nil {
+		t.Errorf("CommitRange() with an unknown revision should fail")
+	}
+}
+
+func TestParseRefSpecs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name    string
+		specs   []string
+		wantErr bool
+	}{
+		{name: "none", specs: nil},
+		{name: "branch", specs: []string{"+refs/heads/main:refs/remotes/origin/main"}},
+		{name: "wildcard", specs: []string{"refs/heads/release/*:refs/remotes/origin/release/*"}},
+		{name: "missing separator", specs: []string{"refs/heads/main"}, wantErr: true},
+		{name: "mismatched wildcard", specs: []string{"refs/heads/*:refs/remotes/origin/main"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := ParseRefSpecs(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRefSpecs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(got) != len(tt.specs) {
+				t.Errorf("ParseRefSpecs() returned %d refspecs, want %d", len(got), len(tt.specs))
+			}
+		})
+	}
+}
+
+func TestCloneWithRefSpecs(t *testing.T) {
+	repoDir, _, second := initTestRepo(t)
+	g := &Git{Source: repoDir}
+	cloneDir := filepath.Join(t.TempDir(), "clone")
+
+	err := g.Clone(cloneDir, Options{RefSpecs: []string{"+refs/heads/master:refs/remotes/origin/master"}})
+	if err != nil {
+		t.Fatalf("Clone() with refspecs failed: %v", err)
+	}
+	r, err := git.PlainOpen(cloneDir)
+	if err != nil {
+		t.Fatalf("failed to open clone: %v", err)
+	}
+	ref, err := r.Reference(plumbing.NewRemoteReferenceName("origin", "master"), true)
+	if err != nil {
+		t.Fatalf("fetched reference not found: %v", err)
+	}
+	if ref.Hash().String() != second {
+		t.Errorf("fetched reference = %s, want %s", ref.Hash(), second)
+	}
+	if err := g.Checkout(cloneDir, second); err != nil {
+		t.Errorf("Checkout() of fetched commit failed: %v", err)
+	}
+
+	if err := g.Clone(filepath.Join(t.TempDir(), "bad"), Options{RefSpecs: []string{"refs/heads/master"}}); err == nil {
+		t.Errorf("Clone() with an invalid refspec should fail")
+	}
+}
+
+func TestCloneSingleBranch(t *testing.T) {
+	repoDir, first, second := initTestRepo(t)
+	r, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repository: %v", err)
+	}
+	feature := plumbing.NewHashReference(plumbing.NewBranchReferenceName("feature"), plumbing.NewHash(first))
+	if err := r.Storer.SetReference(feature); err != nil {
+		t.Fatalf("failed to create branch: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		opts         Options
+		wantHead     string
+		wantBranches []string
+	}{
+		{
+			name:         "single branch",
+			opts:         Options{Branch: "feature", SingleBranch: true},
+			wantHead:     first,
+			wantBranches: []string{"feature"},
+		},
+		{
+			name:         "all branches",
+			opts:         Options{},
+			wantHead:     second,
+			wantBranches: []string{"feature", "master"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Git{Source: repoDir}
+			cloneDir := filepath.Join(t.TempDir(), "clone")
+			if err := g.Clone(cloneDir, tt.opts); err != nil {
+				t.Fatalf("Clone() failed: %v", err)
+			}
+			if g.HEAD != tt.wantHead {
+				t.Errorf("HEAD = %s, want %s", g.HEAD, tt.wantHead)
+			}
+
+			clone, err := git.PlainOpen(cloneDir)
+			if err != nil {
+				t.Fatalf("failed to open clone: %v", err)
+			}
+			refs, err := clone.References()
+			if err != nil {
+				t.Fatalf("failed to list references: %v", err)
+			}
+			var branches []string
+			_ = refs.ForEach(func(ref *plumbing.Reference) error {
+				if ref.Name().IsRemote() && ref.Type() == plumbing.HashReference {
+					branches = append(branches, strings.TrimPrefix(ref.Name().Short(), "origin/"))
+				}
+				return nil
+			})
+			sort.Strings(branches)
+			if !reflect.DeepEqual(branches, tt.wantBranches) {
+				t.Errorf("fetched branches = %v, want %v", branches, tt.wantBranches)
+			}
+		})
+	}
+}
+
 func TestClone(t *testing.T) {
 	testDir := t.TempDir()
 
@@ -192,3 +646,343 @@ func TestGetRemoteHead(t *testing.T) {
 		t.Errorf("HEAD does not match: %v != %v", head1, head2)
 	}
 }
+
+func TestLsRemote(t *testing.T) {
+	repoDir, first, second := initTestRepo(t)
+	r, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repository: %v", err)
+	}
+	if err := r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("feature"), plumbing.NewHash(first))); err != nil {
+		t.Fatalf("failed to create branch: %v", err)
+	}
+	if _, err := r.CreateTag("v1.0", plumbing.NewHash(first), nil); err != nil {
+		t.Fatalf("failed to create lightweight tag: %v", err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	if _, err := r.CreateTag("v2.0", plumbing.NewHash(second), &git.CreateTagOptions{Tagger: sig, Message: "v2.0"}); err != nil {
+		t.Fatalf("failed to create annotated tag: %v", err)
+	}
+
+	g := &Git{Source: repoDir}
+	got, err := g.LsRemote()
+	if err != nil {
+		t.Fatalf("LsRemote() failed: %v", err)
+	}
+	want := []RemoteRef{
+		{Name: "feature", Kind: "branch", Hash: first},
+		{Name: "master", Kind: "branch", Hash: second},
+		{Name: "v1.0", Kind: "tag", Hash: first},
+		{Name: "v2.0", Kind: "tag", Hash: second},
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("LsRemote() = %v, want %v", got, want)
+	}
+
+	missing := &Git{Source: filepath.Join(t.TempDir(), "missing")}
+	if _, err := missing.LsRemote(); err == nil {
+		t.Errorf("LsRemote() of a missing repository should fail")
+	}
+}
+
+func TestTokenResolvedOnce(t *testing.T) {
+	// A server that requires authentication and rejects every token makes
+	// each operation retry with the token
+	var authorized []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, password, ok := r.BasicAuth(); ok {
+			authorized = append(authorized, password)
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	calls := 0
+	tokens := &TokenCache{Resolve: func() (string, error) {
+		calls++
+		return "secret", nil
+	}}
+	g := &Git{Source: server.URL + "/repo.git", Tokens: tokens}
+	if _, err := g.LsRemote(); err == nil {
+		t.Errorf("LsRemote() should fail against a server rejecting the token")
+	}
+	if err := g.GetDefaultBranchRemoteHead("main"); err == nil {
+		t.Errorf("GetDefaultBranchRemoteHead() should fail against a server rejecting the token")
+	}
+	if err := g.Clone(filepath.Join(t.TempDir(), "src"), Options{}); err == nil {
+		t.Errorf("Clone() should fail against a server rejecting the token")
+	}
+	if err := g.Clone(filepath.Join(t.TempDir(), "src"), Options{AuthMethod: AuthToken}); err == nil {
+		t.Errorf("Clone() with a token should fail against a server rejecting the token")
+	}
+	// Another Git value of the same invocation shares the cache
+	other := &Git{Source: g.Source, Tokens: tokens}
+	if _, err := other.DetectDefaultBranch(); err == nil {
+		t.Errorf("DetectDefaultBranch() should fail against a server rejecting the token")
+	}
+
+	if calls != 1 {
+		t.Errorf("token resolver called %d times, want 1", calls)
+	}
+	if len(authorized) < 5 {
+		t.Errorf("server saw %d authenticated requests, want at least 5", len(authorized))
+	}
+	for _, password := range authorized {
+		if password != "secret" {
+			t.Errorf("server saw token %q, want %q", password, "secret")
+		}
+	}
+}
+
+func TestWithTokenRetry(t *testing.T) {
+	authErr := transport.ErrAuthenticationRequired
+	tests := []struct {
+		name      string
+		source    string
+		auth      transport.AuthMethod
+		err       error
+		tokenErr  error
+		wantCalls int
+		wantAuth  bool
+	}{
+		{name: "anonymous success", source: "https://example.com/repo.git", wantCalls: 1},
+		{name: "anonymous auth required", source: "https://example.com/repo.git", err: authErr, wantCalls: 2, wantAuth: true},
+		{name: "other error", source: "https://example.com/repo.git", err: errors.New("not found"), wantCalls: 1},
+		{name: "no token", source: "https://example.com/repo.git", err: authErr, tokenErr: errors.New("no token"), wantCalls: 1},
+		{name: "ssh source", source: "git@example.com:repo.git", err: authErr, wantCalls: 1},
+		{name: "explicit auth", source: "https://example.com/repo.git", auth: &githttp.BasicAuth{Password: "given"}, err: authErr, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Git{Source: tt.source, Tokens: &TokenCache{Resolve: func() (string, error) { return "secret", tt.tokenErr }}}
+			calls := 0
+			err := g.withTokenRetry(tt.auth, func(auth transport.AuthMethod) error {
+				calls++
+				if calls == 2 {
+					if basic, ok := auth.(*githttp.BasicAuth); !ok || basic.Password != "secret" {
+						t.Errorf("retry ran with %v, want the token", auth)
+					}
+					return nil
+				}
+				return tt.err
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("op ran %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantCalls == 1 && !errors.Is(err, tt.err) {
+				t.Errorf("withTokenRetry() error = %v, want %v", err, tt.err)
+			}
+			if (g.auth != nil) != tt.wantAuth {
+				t.Errorf("later operations use the token = %v, want %v", g.auth != nil, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestTokenCacheKeepsError(t *testing.T) {
+	calls := 0
+	tokens := &TokenCache{Resolve: func() (string, error) {
+		calls++
+		return "", errors.New("no token")
+	}}
+	for i := 0; i < 2; i++ {
+		if _, err := tokens.Token(); err == nil || err.Error() != "no token" {
+			t.Errorf("Token() error = %v, want %q", err, "no token")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("token resolver called %d times, want 1", calls)
+	}
+}
+
+func TestIsSSHSource(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{source: "git@github.com:owner/repo.git", want: true},
+		{source: "ssh://git@github.com/owner/repo.git", want: true},
+		{source: "https://github.com/owner/repo.git", want: false},
+		{source: "/tmp/repo", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsSSHSource(tt.source); got != tt.want {
+			t.Errorf("IsSSHSource(%q) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestSSHAuth(t *testing.T) {
+	t.Parallel()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_rsa")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	for source, wantUser := range map[string]string{
+		"git@github.com:owner/repo.git":         "git",
+		"ssh://alice@example.com/owner/repo":    "alice",
+		"ssh://example.com:2222/owner/repo.git": "git",
+	} {
+		auth, err := sshAuth(source, Options{SSHKeyPath: keyPath})
+		if err != nil {
+			t.Fatalf("sshAuth(%q) error = %v", source, err)
+		}
+		keys, ok := auth.(*gitssh.PublicKeys)
+		if !ok {
+			t.Fatalf("sshAuth(%q) = %T, want *ssh.PublicKeys", source, auth)
+		}
+		if keys.User != wantUser {
+			t.Errorf("sshAuth(%q) user = %q, want %q", source, keys.User, wantUser)
+		}
+	}
+
+	if _, err := sshAuth("git@github.com:owner/repo.git", Options{SSHKeyPath: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("sshAuth() expected error for a missing key")
+	}
+	if _, err := sshAuth("https://github.com/owner/repo.git", Options{SSHKeyPath: keyPath}); err == nil {
+		t.Error("sshAuth() expected error for an HTTPS source")
+	}
+
+	// Selecting SSH for an HTTPS source fails before anything is cloned
+	g := &Git{Source: "https://github.com/owner/repo.git"}
+	if err := g.Clone(filepath.Join(t.TempDir(), "clone"), Options{AuthMethod: AuthSSH, SSHKeyPath: keyPath}); err == nil {
+		t.Error("Clone() expected error for SSH authentication with an HTTPS source")
+	}
+}
+
+func TestIsGitHubSource(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		source string
+		want   bool
+	}{
+		{source: "https://github.com/owner/repo.git", want: true},
+		{source: "https://GitHub.com/owner/repo", want: true},
+		{source: "git@github.com:owner/repo.git", want: true},
+		{source: "ssh://git@github.com/owner/repo.git", want: true},
+		{source: "https://gitlab.com/owner/repo.git", want: false},
+		{source: "https://github.com.example.org/owner/repo.git", want: false},
+		{source: "/tmp/github.com/repo", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsGitHubSource(tt.source); got != tt.want {
+			t.Errorf("IsGitHubSource(%q) = %v, want %v", tt.source, got, tt.want)
+		}
+	}
+}
+
+func TestPullRequestRef(t *testing.T) {
+	t.Parallel()
+	if got := PullRequestRef(123); got != "refs/pull/123/head" {
+		t.Errorf("PullRequestRef(123) = %q, want refs/pull/123/head", got)
+	}
+	spec := PullRequestRefSpec(123)
+	if spec != "+refs/pull/123/head:refs/remotes/origin/pr/123" {
+		t.Errorf("PullRequestRefSpec(123) = %q", spec)
+	}
+	if _, err := ParseRefSpecs([]string{spec}); err != nil {
+		t.Errorf("PullRequestRefSpec(123) is not a valid refspec: %v", err)
+	}
+}
+
+func TestPullRequestHeadFromRefs(t *testing.T) {
+	t.Parallel()
+	prHash := plumbing.NewHash("1234567890abcdef1234567890abcdef12345678")
+	otherHash := plumbing.NewHash("abcdefabcdefabcdefabcdefabcdefabcdefabcd")
+	refs := []*plumbing.Reference{
+		plumbing.NewHashReference(plumbing.NewBranchReferenceName("main"), otherHash),
+		plumbing.NewHashReference(plumbing.ReferenceName("refs/pull/12/head"), otherHash),
+		plumbing.NewHashReference(plumbing.ReferenceName("refs/pull/123/merge"), otherHash),
+		plumbing.NewHashReference(plumbing.ReferenceName("refs/pull/123/head"), prHash),
+	}
+
+	got, ok := pullRequestHeadFromRefs(refs, 123)
+	if !ok || got != prHash.String() {
+		t.Errorf("pullRequestHeadFromRefs(123) = %q, %v, want %q, true", got, ok, prHash.String())
+	}
+	if _, ok := pullRequestHeadFromRefs(refs, 7); ok {
+		t.Error("pullRequestHeadFromRefs(7) found a pull request that does not exist")
+	}
+}
+
+func TestGetPullRequestHeadRejectsNonGitHubSource(t *testing.T) {
+	t.Parallel()
+	g := &Git{Source: "https://gitlab.com/owner/repo.git"}
+	err := g.GetPullRequestHead(123)
+	if err == nil || !strings.Contains(err.Error(), "only be built from GitHub sources") {
+		t.Errorf("GetPullRequestHead() error = %v, want an error about GitHub sources", err)
+	}
+	g = &Git{Source: "https://github.com/owner/repo.git"}
+	if err := g.GetPullRequestHead(0); err == nil {
+		t.Error("GetPullRequestHead(0) should fail")
+	}
+}
+
+func TestGetTagRemoteHead(t *testing.T) {
+	repoDir, first, second := initTestRepo(t)
+	r, err := git.PlainOpen(repoDir)
+	if err != nil {
+		t.Fatalf("failed to open repository: %v", err)
+	}
+	if _, err := r.CreateTag("v1.0", plumbing.NewHash(first), nil); err != nil {
+		t.Fatalf("failed to create lightweight tag: %v", err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	if _, err := r.CreateTag("v2.0", plumbing.NewHash(second), &git.CreateTagOptions{Tagger: sig, Message: "v2.0"}); err != nil {
+		t.Fatalf("failed to create annotated tag: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		tag     string
+		want    string
+		wantErr bool
+	}{
+		{name: "lightweight tag", tag: "v1.0", want: first},
+		{name: "annotated tag is dereferenced", tag: "v2.0", want: second},
+		{name: "full reference name", tag: "refs/tags/v1.0", want: first},
+		{name: "branch is not a tag", tag: "master", wantErr: true},
+		{name: "missing tag", tag: "v3.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Git{Source: repoDir}
+			err := g.GetTagRemoteHead(tt.tag)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("GetTagRemoteHead(%q) should fail", tt.tag)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetTagRemoteHead(%q) error = %v", tt.tag, err)
+			}
+			if g.HEAD != tt.want {
+				t.Errorf("GetTagRemoteHead(%q) HEAD = %s, want %s", tt.tag, g.HEAD, tt.want)
+			}
+		})
+	}
+
+	t.Run("clone checks out the tagged commit", func(t *testing.T) {
+		g := &Git{Source: repoDir}
+		cloneDir := filepath.Join(t.TempDir(), "clone")
+		if err := g.Clone(cloneDir, Options{Tag: "v2.0", SingleBranch: true}); err != nil {
+			t.Fatalf("Clone() of a tag failed: %v", err)
+		}
+		hash, err := g.CurrentCommitHash(cloneDir)
+		if err != nil {
+			t.Fatalf("CurrentCommitHash() error = %v", err)
+		}
+		if hash != second {
+			t.Errorf("checked out %s, want %s", hash, second)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package vcsutils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+)
+
+// DefaultRetryBackoff is the delay before the first retry when
+// Options.RetryBackoff is not set
+const DefaultRetryBackoff = time.Second
+
+// sleep waits between retries; tests replace it to avoid waiting
+var sleep = time.Sleep
+
+// transientErrorMarkers are the parts of error messages that mark network
+// failures worth retrying, for errors that only carry their message
+var transientErrorMarkers = []string{
+	"i/o timeout",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"unexpected eof",
+	"tls handshake timeout",
+	"temporary failure in name resolution",
+	"server misbehaving",
+}
+
+// isTransientError reports whether err is a network failure that may succeed
+// when retried: a timeout, a reset or refused connection, or a temporary DNS
+// failure. Authentication errors and missing repositories or refs are never
+// transient.
+//
+// Parameters:
+//   - err: The error to check
+//
+// Returns:
+//   - bool: True if the operation should be retried, false otherwise
+func isTransientError(err error) bool {
+	if err == nil || IsAuthRequiredError(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound && (dnsErr.IsTemporary || dnsErr.IsTimeout)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	if strings.Contains(msg, "not found") {
+		return false
+	}
+	for _, marker := range transientErrorMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// UseRetries makes the remote operations of the Git value made outside of
+// Clone, such as resolving the HEAD of a branch, retry transient failures as
+// Clone does with opts.Retries and opts.RetryBackoff
+//
+// Parameters:
+//   - opts: The options holding the number of retries and the backoff
+func (g *Git) UseRetries(opts Options) {
+	g.retries = opts.Retries
+	g.retryBackoff = opts.RetryBackoff
+}
+
+// withRetries runs a remote operation, retrying it up to g.retries times
+// while it fails with a transient error. The delay before each retry starts
+// at g.retryBackoff and doubles with every retry.
+//
+// Parameters:
+//   - operation: A description of the operation for the retry warnings
+//   - fn: The operation to run
+//
+// Returns:
+//   - error: The error of the last attempt, or nil if an attempt succeeded
+func (g *Git) withRetries(operation string, fn func() error) error {
+	backoff := g.retryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	err := fn()
+	for attempt := 1; attempt <= g.retries && isTransientError(err); attempt++ {
+		logger.Warnf("%s failed: %v; retrying in %s (%d/%d)", operation, scrubError(err), backoff, attempt, g.retries)
+		sleep(backoff)
+		backoff *= 2
+		err = fn()
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package vcsutils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/oota-sushikuitee/nigiri/pkg/logger"
+)
+
+// timeoutError is a net.Error that reports a timeout
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransientError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "net timeout", err: fmt.Errorf("clone: %w", timeoutError{}), want: true},
+		{name: "connection reset", err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}, want: true},
+		{name: "unexpected EOF", err: fmt.Errorf("fetch: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "temporary DNS failure", err: &net.DNSError{Err: "server misbehaving", Name: "example.com", IsTemporary: true}, want: true},
+		{name: "unknown host", err: &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}, want: false},
+		{name: "timeout message", err: errors.New("dial tcp 10.0.0.1:443: i/o timeout"), want: true},
+		{name: "reset message", err: errors.New("read: connection reset by peer"), want: true},
+		{name: "authentication required", err: transport.ErrAuthenticationRequired, want: false},
+		{name: "repository not found", err: transport.ErrRepositoryNotFound, want: false},
+		{name: "scrubbed", err: scrubError(fmt.Errorf("git clone failed: %w", timeoutError{})), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientError(tt.err); got != tt.want {
+				t.Errorf("isTransientError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	var slept []time.Duration
+	origSleep := sleep
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	defer func() { sleep = origSleep }()
+
+	var logs strings.Builder
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stderr)
+
+	transient := errors.New("read: connection reset by peer")
+	tests := []struct {
+		name         string
+		retries      int
+		backoff      time.Duration
+		errs         []error
+		wantErr      error
+		wantAttempts int
+		wantSlept    []time.Duration
+	}{
+		{
+			name:         "succeeds after transient failures",
+			retries:      3,
+			backoff:      time.Millisecond,
+			errs:         []error{transient, transient, nil},
+			wantAttempts: 3,
+			wantSlept:    []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name:         "gives up after the retries",
+			retries:      2,
+			backoff:      time.Millisecond,
+			errs:         []error{transient, transient, transient},
+			wantErr:      transient,
+			wantAttempts: 3,
+			wantSlept:    []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		},
+		{
+			name:         "does not retry authentication errors",
+			retries:      3,
+			errs:         []error{transport.ErrAuthenticationRequired},
+			wantErr:      transport.ErrAuthenticationRequired,
+			wantAttempts: 1,
+		},
+		{
+			name:         "no retries",
+			errs:         []error{transient},
+			wantErr:      transient,
+			wantAttempts: 1,
+		},
+		{
+			name:         "default backoff",
+			retries:      1,
+			errs:         []error{transient, nil},
+			wantAttempts: 2,
+			wantSlept:    []time.Duration{DefaultRetryBackoff},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slept = nil
+			logs.Reset()
+			g := &Git{}
+			g.UseRetries(Options{Retries: tt.retries, RetryBackoff: tt.backoff})
+			attempts := 0
+			err := g.withRetries("git clone", func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("withRetries() error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if fmt.Sprint(slept) != fmt.Sprint(tt.wantSlept) {
+				t.Errorf("slept = %v, want %v", slept, tt.wantSlept)
+			}
+			if got := strings.Count(logs.String(), "retrying in"); got != len(tt.wantSlept) {
+				t.Errorf("logged %d retry warnings, want %d:\n%s", got, len(tt.wantSlept), logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package vcsutils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// initTestSuperRepo creates a repository with the repository subDir as its
+// submodule "sub", recording one commit per entry of subCommits, and returns
+// the repository directory and its commits in order
+func initTestSuperRepo(t *testing.T, subDir string, subCommits ...string) (repoDir string, commits []string) {
+	t.Helper()
+	repoDir = t.TempDir()
+	r, err := git.PlainInit(repoDir, false)
+	if err != nil {
+		t.Fatalf("failed to init repository: %v", err)
+	}
+	w, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("failed to get worktree: %v", err)
+	}
+	gitmodules := "[submodule \"sub\"]\n\tpath = sub\n\turl = " + subDir + "\n"
+	if err := os.WriteFile(filepath.Join(repoDir, ".gitmodules"), []byte(gitmodules), 0644); err != nil {
+		t.Fatalf("failed to write .gitmodules: %v", err)
+	}
+	if _, err := w.Add(".gitmodules"); err != nil {
+		t.Fatalf("failed to add .gitmodules: %v", err)
+	}
+	sig := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+	for _, subCommit := range subCommits {
+		// go-git cannot add a submodule, so its gitlink is written to the
+		// index directly
+		idx, err := r.Storer.Index()
+		if err != nil {
+			t.Fatalf("failed to read index: %v", err)
+		}
+		if entry, err := idx.Entry("sub"); err == nil {
+			entry.Hash = plumbing.NewHash(subCommit)
+		} else {
+			idx.Entries = append(idx.Entries, &index.Entry{Name: "sub", Hash: plumbing.NewHash(subCommit), Mode: filemode.Submodule})
+		}
+		if err := r.Storer.SetIndex(idx); err != nil {
+			t.Fatalf("failed to write index: %v", err)
+		}
+		hash, err := w.Commit("sub at "+subCommit, &git.CommitOptions{Author: sig})
+		if err != nil {
+			t.Fatalf("failed to commit: %v", err)
+		}
+		commits = append(commits, hash.String())
+	}
+	return repoDir, commits
+}
+
+// readSubFile returns the content of file.txt in the submodule of a clone
+func readSubFile(t *testing.T, cloneDir string) string {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join(cloneDir, "sub", "file.txt"))
+	if err != nil {
+		t.Fatalf("failed to read submodule file: %v", err)
+	}
+	return string(content)
+}
+
+func TestCloneRecurseSubmodules(t *testing.T) {
+	subDir, first, second := initTestRepo(t)
+	repoDir, commits := initTestSuperRepo(t, subDir, first, second)
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "direct", opts: Options{RecurseSubmodules: true}},
+		{name: "through the cache", opts: Options{RecurseSubmodules: true, CacheDir: filepath.Join(t.TempDir(), "cache")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &Git{Source: repoDir}
+			cloneDir := filepath.Join(t.TempDir(), "clone")
+			if err := g.Clone(cloneDir, tt.opts); err != nil {
+				t.Fatalf("Clone() failed: %v", err)
+			}
+			if got := readSubFile(t, cloneDir); got != "second" {
+				t.Errorf("submodule content = %q, want %q", got, "second")
+			}
+
+			// Checking out an older commit moves the submodule along
+			if err := g.Checkout(cloneDir, commits[0]); err != nil {
+				t.Fatalf("Checkout() failed: %v", err)
+			}
+			if got := readSubFile(t, cloneDir); got != "first" {
+				t.Errorf("submodule content after checkout = %q, want %q", got, "first")
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		g := &Git{Source: repoDir}
+		cloneDir := filepath.Join(t.TempDir(), "clone")
+		if err := g.Clone(cloneDir, Options{}); err != nil {
+			t.Fatalf("Clone() failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(cloneDir, "sub", "file.txt")); !os.IsNotExist(err) {
+			t.Errorf("submodule was cloned without RecurseSubmodules: %v", err)
+		}
+	})
+}
@@ -6,4 +6,6 @@ type VCS interface {
 	Clone(cloneDir string, opts Options) error
 	// GetDefaultBranchRemoteHead retrieves the HEAD commit hash of the default branch
 	GetDefaultBranchRemoteHead(defaultBranch string) error
+	// DetectDefaultBranch determines the default branch of the remote repository
+	DetectDefaultBranch() (string, error)
 }